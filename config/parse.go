@@ -17,6 +17,8 @@ var dbSource = flag.String("db-source", "", "source of the database photo-db-fs
 
 var logLevelFlag = flag.String("log-level", "", "debugging logging level")
 
+var writableFlag = flag.Bool("writable", false, "allow tagging and rating photos through the file system")
+
 func Parse() (types.Config, error) {
 	flag.Parse()
 
@@ -45,6 +47,9 @@ func Parse() (types.Config, error) {
 	if *logLevelFlag != "" {
 		config.LogLevel = *logLevelFlag
 	}
+	if *writableFlag {
+		config.Writable = true
+	}
 
 	return config, nil
 }
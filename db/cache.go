@@ -0,0 +1,546 @@
+package db
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/anitschke/photo-db-fs/types"
+	"go.uber.org/zap"
+)
+
+// CacheOptions control how a CachingDB caches the results of queries.
+type CacheOptions struct {
+	// TTL is how long a result is cached for. If it is zero then nothing is
+	// cached, otherwise changes that the DB doesn't report to Watch would never
+	// show up.
+	TTL time.Duration
+
+	// MaxBytes is roughly how much memory the cached results can take up, the
+	// least recently used results are evicted past this. If it is zero then
+	// nothing is cached.
+	MaxBytes int64
+}
+
+// CacheStats are statistics about how well a CachingDB is working.
+type CacheStats struct {
+	// Hits is the number of queries that were answered from the cache.
+	Hits int64
+
+	// Misses is the number of queries that were passed on to the wrapped DB.
+	Misses int64
+
+	// Shared is the number of queries that weren't cached but waited for an
+	// identical query that was already running rather than also being passed
+	// on to the wrapped DB.
+	Shared int64
+
+	// Entries and Bytes are how many results are currently cached and roughly
+	// how much memory they take up.
+	Entries int
+	Bytes   int64
+}
+
+// CachingDB is a DB that caches the results of queries, since the same photos
+// are selected over and over by different parts of the file system. For
+// example the photos of a tag are selected by both the photos directory of the
+// tag and the photos directories of the ratings within it.
+//
+// Results are cached by the method and the CanonicalKey of the selector of the
+// query, so queries that are written differently but select the same photos
+// share a result. If the same query is made while it is already running then
+// it waits for the running query rather than running it again.
+//
+// The whole cache is invalidated whenever the DB is modified through the
+// CachingDB or the wrapped DB reports that it changed, see Watch. Methods that
+// don't select photos based on a query are passed straight through to the
+// wrapped DB.
+type CachingDB struct {
+	DB
+	opts CacheOptions
+
+	mu sync.Mutex
+
+	// order is the cached results from most to least recently used, entries
+	// maps the key of each result to its element in order.
+	order   *list.List
+	entries map[string]*list.Element
+	bytes   int64
+
+	// running are the queries that are currently running.
+	running map[string]*cacheCall
+
+	// generation is incremented whenever the cache is invalidated, so results
+	// of queries that were started before then aren't cached.
+	generation int64
+
+	stats CacheStats
+}
+
+var _ = (DB)((*CachingDB)(nil))
+var _ = (Watcher)((*CachingDB)(nil))
+var _ = (Counter)((*CachingDB)(nil))
+var _ = (PhotoStreamer)((*CachingDB)(nil))
+var _ = (Grouper)((*CachingDB)(nil))
+
+type cacheEntry struct {
+	key     string
+	value   interface{}
+	size    int64
+	expires time.Time
+}
+
+// cacheCall is a query that is running, done is closed once the result is in.
+type cacheCall struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// NewCachingDB wraps d so that the results of queries are cached. If
+// opts.TTL or opts.MaxBytes is zero then d is returned as is.
+func NewCachingDB(d DB, opts CacheOptions) DB {
+	if opts.TTL <= 0 || opts.MaxBytes <= 0 {
+		return d
+	}
+	return &CachingDB{
+		DB:      d,
+		opts:    opts,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		running: make(map[string]*cacheCall),
+	}
+}
+
+// Stats gets statistics about how well the cache is working.
+func (c *CachingDB) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.order.Len()
+	stats.Bytes = c.bytes
+	return stats
+}
+
+// Invalidate drops all of the cached results.
+func (c *CachingDB) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+	c.bytes = 0
+
+	// Anyone waiting on a query that is already running still gets its
+	// result, but new queries shouldn't wait for what might be a stale result.
+	c.running = make(map[string]*cacheCall)
+}
+
+// get gets the result of a query from the cache, or runs the query with fetch
+// if it isn't cached. fetch also returns roughly how much memory the result
+// takes up.
+func (c *CachingDB) get(ctx context.Context, method string, q types.Query, fetch func() (interface{}, int64, error)) (interface{}, error) {
+	key, err := cacheKey(method, q)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		c.mu.Lock()
+		if value, ok := c.lookup(key); ok {
+			c.stats.Hits++
+			c.mu.Unlock()
+			return value, nil
+		}
+		call, shared := c.running[key]
+		if !shared {
+			c.stats.Misses++
+			call = &cacheCall{done: make(chan struct{})}
+			c.running[key] = call
+			generation := c.generation
+			c.mu.Unlock()
+
+			c.run(key, call, generation, fetch)
+			return call.value, call.err
+		}
+		c.stats.Shared++
+		c.mu.Unlock()
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		// The query we were waiting on runs with the context of whoever started
+		// it, if they gave up on it then we run it ourselves. The same goes for
+		// StreamPhotos that didn't collect all of the photos.
+		if (isContextError(call.err) && ctx.Err() == nil) || errors.Is(call.err, errNotCollected) {
+			continue
+		}
+		return call.value, call.err
+	}
+}
+
+// cacheKey gets the key that the result of calling method with q is cached
+// under.
+func cacheKey(method string, q types.Query) (string, error) {
+	selectorKey, err := types.CanonicalKey(q.Selector)
+	if err != nil {
+		return "", err
+	}
+	return method + "\x00" + selectorKey, nil
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// run runs a query and caches the result, unless the cache was invalidated
+// since the query was started.
+func (c *CachingDB) run(key string, call *cacheCall, generation int64, fetch func() (interface{}, int64, error)) {
+	value, size, err := fetch()
+	call.value = value
+	call.err = err
+
+	c.mu.Lock()
+	if c.running[key] == call {
+		delete(c.running, key)
+	}
+	if err == nil && generation == c.generation {
+		c.store(key, value, size)
+	}
+	c.mu.Unlock()
+
+	close(call.done)
+}
+
+// lookup gets a result that hasn't expired from the cache, the caller must hold
+// mu.
+func (c *CachingDB) lookup(key string) (interface{}, bool) {
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*cacheEntry)
+	if !time.Now().Before(entry.expires) {
+		c.remove(e)
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return entry.value, true
+}
+
+// store adds a result to the cache, evicting the least recently used results
+// if needed to make room for it. The caller must hold mu.
+func (c *CachingDB) store(key string, value interface{}, size int64) {
+	if size > c.opts.MaxBytes {
+		zap.L().Debug("query result is too big to cache", zap.String("key", key), zap.Int64("size", size))
+		return
+	}
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+	entry := &cacheEntry{key: key, value: value, size: size, expires: time.Now().Add(c.opts.TTL)}
+	c.entries[key] = c.order.PushFront(entry)
+	c.bytes += size
+	for c.bytes > c.opts.MaxBytes {
+		c.remove(c.order.Back())
+	}
+}
+
+// remove removes a result from the cache, the caller must hold mu.
+func (c *CachingDB) remove(e *list.Element) {
+	entry := c.order.Remove(e).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= entry.size
+}
+
+// The sizes of results are rough estimates of how much memory they take up on
+// a 64 bit platform, they only need to be good enough to keep the cache from
+// growing without bound.
+const (
+	stringSize = 16
+	sliceSize  = 24
+)
+
+func pathSize(path []string) int64 {
+	size := int64(sliceSize)
+	for _, p := range path {
+		size += stringSize + int64(len(p))
+	}
+	return size
+}
+
+func photoSize(p types.Photo) int64 {
+	return 2*stringSize + int64(len(p.Path)+len(p.ID))
+}
+
+func photosSize(photos []types.Photo) int64 {
+	size := int64(sliceSize)
+	for _, p := range photos {
+		size += photoSize(p)
+	}
+	return size
+}
+
+func (c *CachingDB) Photos(ctx context.Context, q types.Query) ([]types.Photo, error) {
+	value, err := c.get(ctx, "Photos", q, func() (interface{}, int64, error) {
+		photos, err := c.DB.Photos(ctx, q)
+		return photos, photosSize(photos), err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Callers are free to modify what they get back, such as sorting it, so
+	// they each get their own copy of the cached result.
+	photos := value.([]types.Photo)
+	return append(make([]types.Photo, 0, len(photos)), photos...), nil
+}
+
+// StreamPhotos shares its cached result with Photos, but if the result isn't
+// cached yet the photos are streamed straight from the wrapped DB rather than
+// waiting for all of them to be collected. The streamed photos are only
+// collected to be cached for as long as they fit in the cache, so streaming a
+// result that is too big to cache never holds all of it in memory.
+//
+// Like Photos, if the same query is already running then it waits for the
+// running query and goes through its result. If the running query didn't
+// collect all of the photos then they are streamed from the wrapped DB again.
+func (c *CachingDB) StreamPhotos(ctx context.Context, q types.Query, fn func(types.Photo) error) error {
+	key, err := cacheKey("Photos", q)
+	if err != nil {
+		return err
+	}
+
+	for {
+		c.mu.Lock()
+		if value, ok := c.lookup(key); ok {
+			c.stats.Hits++
+			c.mu.Unlock()
+			return streamSlice(value.([]types.Photo), fn)
+		}
+		call, shared := c.running[key]
+		if !shared {
+			c.stats.Misses++
+			call = &cacheCall{done: make(chan struct{})}
+			c.running[key] = call
+			generation := c.generation
+			c.mu.Unlock()
+
+			return c.stream(ctx, key, call, generation, q, fn)
+		}
+		c.stats.Shared++
+		c.mu.Unlock()
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		switch {
+		case errors.Is(call.err, errNotCollected):
+			return StreamPhotos(ctx, c.DB, q, fn)
+		case isContextError(call.err) && ctx.Err() == nil:
+			continue
+		case call.err != nil:
+			return call.err
+		}
+		return streamSlice(call.value.([]types.Photo), fn)
+	}
+}
+
+// errNotCollected is the result of a streamed query that didn't collect all of
+// the photos, either because they didn't fit in the cache or because fn
+// stopped streaming early.
+var errNotCollected = errors.New("streamed photos weren't collected")
+
+// stream streams the photos of a query that isn't running yet from the wrapped
+// DB, collecting them so they can be cached and shared with anyone that waits
+// on call.
+func (c *CachingDB) stream(ctx context.Context, key string, call *cacheCall, generation int64, q types.Query, fn func(types.Photo) error) error {
+	var fnErr error
+	c.run(key, call, generation, func() (interface{}, int64, error) {
+		var photos []types.Photo
+		size := int64(sliceSize)
+		fits := true
+		err := StreamPhotos(ctx, c.DB, q, func(p types.Photo) error {
+			if fits {
+				size += photoSize(p)
+				if size > c.opts.MaxBytes {
+					fits = false
+					photos = nil
+				} else {
+					photos = append(photos, p)
+				}
+			}
+			fnErr = fn(p)
+			return fnErr
+		})
+		if fnErr != nil || (err == nil && !fits) {
+			return nil, 0, errNotCollected
+		}
+		return photos, size, err
+	})
+
+	if fnErr != nil {
+		return fnErr
+	}
+	if errors.Is(call.err, errNotCollected) {
+		return nil
+	}
+	return call.err
+}
+
+func streamSlice(photos []types.Photo, fn func(types.Photo) error) error {
+	for _, p := range photos {
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *CachingDB) PhotoTags(ctx context.Context, q types.Query) ([]types.Tag, error) {
+	value, err := c.get(ctx, "PhotoTags", q, func() (interface{}, int64, error) {
+		tags, err := c.DB.PhotoTags(ctx, q)
+		size := int64(sliceSize)
+		for _, t := range tags {
+			size += pathSize(t.Path)
+		}
+		return tags, size, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	tags := value.([]types.Tag)
+	return append(make([]types.Tag, 0, len(tags)), tags...), nil
+}
+
+func (c *CachingDB) TakenYears(ctx context.Context, q types.Query) ([]int, error) {
+	value, err := c.get(ctx, "TakenYears", q, func() (interface{}, int64, error) {
+		years, err := c.DB.TakenYears(ctx, q)
+		return years, sliceSize + 8*int64(len(years)), err
+	})
+	if err != nil {
+		return nil, err
+	}
+	years := value.([]int)
+	return append(make([]int, 0, len(years)), years...), nil
+}
+
+func (c *CachingDB) TagCounts(ctx context.Context, q types.Query) ([]types.TagCount, error) {
+	value, err := c.get(ctx, "TagCounts", q, func() (interface{}, int64, error) {
+		counts, err := TagCounts(ctx, c.DB, q)
+		size := int64(sliceSize)
+		for _, tc := range counts {
+			size += pathSize(tc.Tag.Path) + 8
+		}
+		return counts, size, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	counts := value.([]types.TagCount)
+	return append(make([]types.TagCount, 0, len(counts)), counts...), nil
+}
+
+func (c *CachingDB) RatingCounts(ctx context.Context, q types.Query) ([]types.RatingCount, error) {
+	value, err := c.get(ctx, "RatingCounts", q, func() (interface{}, int64, error) {
+		counts, err := RatingCounts(ctx, c.DB, q)
+		return counts, sliceSize + 16*int64(len(counts)), err
+	})
+	if err != nil {
+		return nil, err
+	}
+	counts := value.([]types.RatingCount)
+	return append(make([]types.RatingCount, 0, len(counts)), counts...), nil
+}
+
+func (c *CachingDB) PhotoGroups(ctx context.Context, q types.Query) (types.PhotoGroups, error) {
+	value, err := c.get(ctx, "PhotoGroups", q, func() (interface{}, int64, error) {
+		groups, err := PhotoGroups(ctx, c.DB, q)
+		size := int64(3 * sliceSize)
+		for _, a := range groups.Albums {
+			size += pathSize(a.Path)
+		}
+		for _, camera := range groups.Cameras {
+			size += 2*stringSize + int64(len(camera.Make)+len(camera.Model))
+		}
+		for _, l := range groups.Lenses {
+			size += stringSize + int64(len(l))
+		}
+		return groups, size, err
+	})
+	if err != nil {
+		return types.PhotoGroups{}, err
+	}
+	groups := value.(types.PhotoGroups)
+	return types.PhotoGroups{
+		Albums:  append(make([]types.Album, 0, len(groups.Albums)), groups.Albums...),
+		Cameras: append(make([]types.Camera, 0, len(groups.Cameras)), groups.Cameras...),
+		Lenses:  append(make([]string, 0, len(groups.Lenses)), groups.Lenses...),
+	}, nil
+}
+
+// AddTag, RemoveTag, CreateTag and SetRating invalidate the cache even if they
+// fail, since a failure doesn't guarantee nothing was modified.
+
+func (c *CachingDB) AddTag(ctx context.Context, photo types.Photo, tag types.Tag) error {
+	defer c.Invalidate()
+	return c.DB.AddTag(ctx, photo, tag)
+}
+
+func (c *CachingDB) RemoveTag(ctx context.Context, photo types.Photo, tag types.Tag) error {
+	defer c.Invalidate()
+	return c.DB.RemoveTag(ctx, photo, tag)
+}
+
+func (c *CachingDB) CreateTag(ctx context.Context, tag types.Tag) error {
+	defer c.Invalidate()
+	return c.DB.CreateTag(ctx, tag)
+}
+
+func (c *CachingDB) SetRating(ctx context.Context, photo types.Photo, rating float64) error {
+	defer c.Invalidate()
+	return c.DB.SetRating(ctx, photo, rating)
+}
+
+// Watch watches the wrapped DB and invalidates the cache whenever it changes.
+// The cache is invalidated before the change is passed on so that anything
+// refreshing because of the change doesn't get stale results.
+//
+// If nothing watches the CachingDB then changes made outside of it are only
+// picked up once the cached results expire.
+func (c *CachingDB) Watch(ctx context.Context, interval time.Duration) (<-chan ChangeEvent, error) {
+	changes, err := Watch(ctx, c.DB, interval)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan ChangeEvent)
+	go func() {
+		defer close(out)
+		for e := range changes {
+			stats := c.Stats()
+			zap.L().Debug("invalidating query cache",
+				zap.Int64("hits", stats.Hits),
+				zap.Int64("misses", stats.Misses),
+				zap.Int64("shared", stats.Shared),
+				zap.Int("entries", stats.Entries),
+				zap.Int64("bytes", stats.Bytes))
+			c.Invalidate()
+
+			select {
+			case out <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
@@ -0,0 +1,324 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anitschke/photo-db-fs/db"
+	"github.com/anitschke/photo-db-fs/db/mocks"
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// watchingDB is a mock DB that sends whatever is sent on changes when watched.
+type watchingDB struct {
+	*mocks.DB
+	changes chan db.ChangeEvent
+}
+
+var _ = (db.Watcher)(watchingDB{})
+
+func (w watchingDB) Watch(ctx context.Context, interval time.Duration) (<-chan db.ChangeEvent, error) {
+	return w.changes, nil
+}
+
+var (
+	cacheA = types.HasTag{Tag: types.Tag{Path: []string{"a"}}}
+	cacheB = types.HasTag{Tag: types.Tag{Path: []string{"b"}}}
+)
+
+func TestCachingDB(t *testing.T) {
+	assert := assert.New(t)
+
+	mockDB := mocks.NewDB(t)
+	assert.Same(mockDB, db.NewCachingDB(mockDB, db.CacheOptions{}))
+	assert.Same(mockDB, db.NewCachingDB(mockDB, db.CacheOptions{MaxBytes: 1 << 20}))
+
+	photo := types.Photo{Path: "/photos/foo.jpg", ID: "foo"}
+	both := types.Query{Selector: types.And{Operands: []types.Selector{cacheA, cacheB}}}
+	mockDB.On("Photos", mock.Anything, both).Return([]types.Photo{photo}, nil).Once()
+	mockDB.On("PhotoTags", mock.Anything, both).Return([]types.Tag{cacheA.Tag, cacheB.Tag}, nil).Once()
+	mockDB.On("TakenYears", mock.Anything, both).Return([]int{2022}, nil).Once()
+	mockDB.On("RootTags", mock.Anything).Return([]types.Tag{cacheA.Tag}, nil).Twice()
+
+	ctx := context.Background()
+	c := db.NewCachingDB(mockDB, db.CacheOptions{TTL: time.Minute, MaxBytes: 1 << 20}).(*db.CachingDB)
+
+	// Queries that select the same photos share a result, even if they are
+	// written differently.
+	reordered := types.Query{Selector: types.And{Operands: []types.Selector{cacheB, cacheA, cacheB}}}
+	for _, q := range []types.Query{both, reordered} {
+		photos, err := c.Photos(ctx, q)
+		assert.Nil(err)
+		assert.Equal([]types.Photo{photo}, photos)
+
+		tags, err := c.PhotoTags(ctx, q)
+		assert.Nil(err)
+		assert.Equal([]types.Tag{cacheA.Tag, cacheB.Tag}, tags)
+
+		years, err := c.TakenYears(ctx, q)
+		assert.Nil(err)
+		assert.Equal([]int{2022}, years)
+	}
+
+	// Modifying a result doesn't modify what is cached.
+	photos, err := c.Photos(ctx, both)
+	assert.Nil(err)
+	photos[0].ID = "modified"
+
+	// StreamPhotos shares its results with Photos.
+	var streamed []types.Photo
+	err = c.StreamPhotos(ctx, both, func(p types.Photo) error {
+		streamed = append(streamed, p)
+		return nil
+	})
+	assert.Nil(err)
+	assert.Equal([]types.Photo{photo}, streamed)
+
+	// Anything that doesn't select photos isn't cached.
+	for i := 0; i < 2; i++ {
+		tags, err := c.RootTags(ctx)
+		assert.Nil(err)
+		assert.Equal([]types.Tag{cacheA.Tag}, tags)
+	}
+
+	stats := c.Stats()
+	assert.Equal(int64(5), stats.Hits)
+	assert.Equal(int64(3), stats.Misses)
+	assert.Equal(3, stats.Entries)
+	assert.Greater(stats.Bytes, int64(0))
+}
+
+func TestCachingDB_Invalidate(t *testing.T) {
+	assert := assert.New(t)
+
+	mockDB := mocks.NewDB(t)
+	changes := make(chan db.ChangeEvent)
+	w := watchingDB{DB: mockDB, changes: changes}
+
+	photo := types.Photo{Path: "/photos/foo.jpg", ID: "foo"}
+	q := types.Query{Selector: cacheA}
+	mockDB.On("Photos", mock.Anything, q).Return([]types.Photo{photo}, nil).Times(3)
+	mockDB.On("SetRating", mock.Anything, photo, float64(5)).Return(nil).Once()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := db.NewCachingDB(w, db.CacheOptions{TTL: time.Minute, MaxBytes: 1 << 20})
+
+	_, err := c.Photos(ctx, q)
+	assert.Nil(err)
+	_, err = c.Photos(ctx, q)
+	assert.Nil(err)
+
+	// Modifying the DB through the cache invalidates it.
+	assert.Nil(c.SetRating(ctx, photo, 5))
+	_, err = c.Photos(ctx, q)
+	assert.Nil(err)
+
+	// So does the wrapped DB reporting a change, which is only passed on once
+	// the cache has been invalidated.
+	watched, err := db.Watch(ctx, c, time.Second)
+	assert.Nil(err)
+	changes <- db.ChangeEvent{Scope: db.TagsChanged}
+	assert.Equal(db.ChangeEvent{Scope: db.TagsChanged}, <-watched)
+	assert.Equal(0, c.(*db.CachingDB).Stats().Entries)
+	_, err = c.Photos(ctx, q)
+	assert.Nil(err)
+}
+
+func TestCachingDB_Errors(t *testing.T) {
+	assert := assert.New(t)
+
+	mockDB := mocks.NewDB(t)
+	q := types.Query{Selector: cacheA}
+	mockDB.On("Photos", mock.Anything, q).Return(nil, errors.New("oops")).Once()
+	mockDB.On("Photos", mock.Anything, q).Return([]types.Photo{}, nil).Once()
+
+	ctx := context.Background()
+	c := db.NewCachingDB(mockDB, db.CacheOptions{TTL: time.Minute, MaxBytes: 1 << 20})
+
+	// Errors aren't cached.
+	_, err := c.Photos(ctx, q)
+	assert.EqualError(err, "oops")
+	photos, err := c.Photos(ctx, q)
+	assert.Nil(err)
+	assert.Empty(photos)
+	_, err = c.Photos(ctx, q)
+	assert.Nil(err)
+
+	_, err = c.Photos(ctx, types.Query{})
+	assert.Error(err)
+}
+
+func TestCachingDB_Limits(t *testing.T) {
+	assert := assert.New(t)
+
+	mockDB := mocks.NewDB(t)
+	aQuery := types.Query{Selector: cacheA}
+	bQuery := types.Query{Selector: cacheB}
+	photo := types.Photo{Path: "/photos/foo.jpg", ID: "foo"}
+	mockDB.On("Photos", mock.Anything, aQuery).Return([]types.Photo{photo}, nil).Times(4)
+	mockDB.On("Photos", mock.Anything, bQuery).Return([]types.Photo{photo}, nil).Once()
+
+	ctx := context.Background()
+
+	// There is only room for one result, so caching b evicts a.
+	c := db.NewCachingDB(mockDB, db.CacheOptions{TTL: time.Minute, MaxBytes: 100})
+	for _, q := range []types.Query{aQuery, aQuery, bQuery, aQuery} {
+		_, err := c.Photos(ctx, q)
+		assert.Nil(err)
+	}
+
+	// Results expire after the TTL.
+	c = db.NewCachingDB(mockDB, db.CacheOptions{MaxBytes: 1 << 20, TTL: 10 * time.Millisecond})
+	_, err := c.Photos(ctx, aQuery)
+	assert.Nil(err)
+	time.Sleep(20 * time.Millisecond)
+	_, err = c.Photos(ctx, aQuery)
+	assert.Nil(err)
+}
+
+// streamingDB is a mock DB that streams photos, counting how many times it is
+// asked to. If release isn't nil then it waits for it to be closed before
+// streaming.
+type streamingDB struct {
+	*mocks.DB
+	photos  []types.Photo
+	streams int
+	release chan struct{}
+}
+
+var _ = (db.PhotoStreamer)((*streamingDB)(nil))
+
+func (s *streamingDB) StreamPhotos(ctx context.Context, q types.Query, fn func(types.Photo) error) error {
+	s.streams++
+	if s.release != nil {
+		<-s.release
+	}
+	for _, p := range s.photos {
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestCachingDB_StreamPhotos(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	q := types.Query{Selector: cacheA}
+	photos := []types.Photo{{Path: "/photos/foo.jpg", ID: "foo"}, {Path: "/photos/bar.jpg", ID: "bar"}}
+	stream := func(c db.DB) []types.Photo {
+		var streamed []types.Photo
+		err := db.StreamPhotos(ctx, c, q, func(p types.Photo) error {
+			streamed = append(streamed, p)
+			return nil
+		})
+		assert.Nil(err)
+		return streamed
+	}
+
+	// Photos that aren't cached yet are streamed from the wrapped DB and then
+	// cached.
+	s := &streamingDB{DB: mocks.NewDB(t), photos: photos}
+	c := db.NewCachingDB(s, db.CacheOptions{TTL: time.Minute, MaxBytes: 1 << 20})
+	assert.Equal(photos, stream(c))
+	assert.Equal(photos, stream(c))
+	assert.Equal(1, s.streams)
+	cached, err := c.Photos(ctx, q)
+	assert.Nil(err)
+	assert.Equal(photos, cached)
+
+	// Photos that don't fit in the cache are streamed every time.
+	s = &streamingDB{DB: mocks.NewDB(t), photos: photos}
+	c = db.NewCachingDB(s, db.CacheOptions{TTL: time.Minute, MaxBytes: 80})
+	assert.Equal(photos, stream(c))
+	assert.Equal(photos, stream(c))
+	assert.Equal(2, s.streams)
+	assert.Equal(0, c.(*db.CachingDB).Stats().Entries)
+}
+
+func TestCachingDB_Singleflight(t *testing.T) {
+	assert := assert.New(t)
+
+	mockDB := mocks.NewDB(t)
+	q := types.Query{Selector: cacheA}
+	photo := types.Photo{Path: "/photos/foo.jpg", ID: "foo"}
+	release := make(chan struct{})
+	mockDB.On("Photos", mock.Anything, q).Run(func(mock.Arguments) { <-release }).Return([]types.Photo{photo}, nil).Once()
+
+	ctx := context.Background()
+	c := db.NewCachingDB(mockDB, db.CacheOptions{TTL: time.Minute, MaxBytes: 1 << 20}).(*db.CachingDB)
+
+	const queries = 10
+	var wg sync.WaitGroup
+	for i := 0; i < queries; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			photos, err := c.Photos(ctx, q)
+			assert.Nil(err)
+			assert.Equal([]types.Photo{photo}, photos)
+		}()
+	}
+
+	// Every query but the first waits for the first one.
+	assert.Eventually(func() bool {
+		return c.Stats().Shared == queries-1
+	}, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(int64(1), c.Stats().Misses)
+}
+
+func TestCachingDB_SingleflightStreamPhotos(t *testing.T) {
+	assert := assert.New(t)
+
+	q := types.Query{Selector: cacheA}
+	photos := []types.Photo{{Path: "/photos/foo.jpg", ID: "foo"}, {Path: "/photos/bar.jpg", ID: "bar"}}
+	s := &streamingDB{DB: mocks.NewDB(t), photos: photos, release: make(chan struct{})}
+
+	ctx := context.Background()
+	c := db.NewCachingDB(s, db.CacheOptions{TTL: time.Minute, MaxBytes: 1 << 20}).(*db.CachingDB)
+
+	const queries = 10
+	var wg sync.WaitGroup
+	for i := 0; i < queries; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var streamed []types.Photo
+			err := c.StreamPhotos(ctx, q, func(p types.Photo) error {
+				streamed = append(streamed, p)
+				return nil
+			})
+			assert.Nil(err)
+			assert.Equal(photos, streamed)
+		}()
+	}
+
+	// Every stream but the first waits for the first one, and so does getting
+	// the same photos with Photos.
+	assert.Eventually(func() bool {
+		return c.Stats().Shared == queries-1
+	}, time.Second, time.Millisecond)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		shared, err := c.Photos(ctx, q)
+		assert.Nil(err)
+		assert.Equal(photos, shared)
+	}()
+	assert.Eventually(func() bool {
+		return c.Stats().Shared == queries
+	}, time.Second, time.Millisecond)
+	close(s.release)
+	wg.Wait()
+	assert.Equal(int64(1), c.Stats().Misses)
+	assert.Equal(1, s.streams)
+}
@@ -0,0 +1,41 @@
+package db
+
+import (
+	"context"
+
+	"github.com/anitschke/photo-db-fs/types"
+)
+
+// Counter can optionally be implemented by a DB that is able to count the
+// photos of every tag, or every rating, at once. This is used to show how many
+// photos are in a directory without having to query the photos of each
+// directory separately.
+type Counter interface {
+	// TagCounts should return the number of photos selected by the query that
+	// each tag is directly applied to. Tags that aren't applied to any of the
+	// photos may be left out.
+	TagCounts(ctx context.Context, q types.Query) ([]types.TagCount, error)
+
+	// RatingCounts should return the number of photos selected by the query
+	// that have each rating. Ratings that none of the photos have, and photos
+	// that haven't been rated, may be left out.
+	RatingCounts(ctx context.Context, q types.Query) ([]types.RatingCount, error)
+}
+
+// TagCounts counts the photos of every tag if the DB implements Counter,
+// otherwise it returns ErrNotSupported.
+func TagCounts(ctx context.Context, d DB, q types.Query) ([]types.TagCount, error) {
+	if c, ok := d.(Counter); ok {
+		return c.TagCounts(ctx, q)
+	}
+	return nil, ErrNotSupported
+}
+
+// RatingCounts counts the photos of every rating if the DB implements Counter,
+// otherwise it returns ErrNotSupported.
+func RatingCounts(ctx context.Context, d DB, q types.Query) ([]types.RatingCount, error) {
+	if c, ok := d.(Counter); ok {
+		return c.RatingCounts(ctx, q)
+	}
+	return nil, ErrNotSupported
+}
@@ -0,0 +1,62 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/anitschke/photo-db-fs/db"
+	"github.com/anitschke/photo-db-fs/db/mocks"
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// countingDB is a mock DB that can also count photos.
+type countingDB struct {
+	*mocks.DB
+}
+
+var _ = (db.Counter)(countingDB{})
+
+func (c countingDB) TagCounts(ctx context.Context, q types.Query) ([]types.TagCount, error) {
+	args := c.Called(ctx, q)
+	return args.Get(0).([]types.TagCount), args.Error(1)
+}
+
+func (c countingDB) RatingCounts(ctx context.Context, q types.Query) ([]types.RatingCount, error) {
+	args := c.Called(ctx, q)
+	return args.Get(0).([]types.RatingCount), args.Error(1)
+}
+
+func TestCounts(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	all := types.Query{Selector: types.And{}}
+
+	mockDB := mocks.NewDB(t)
+	_, err := db.TagCounts(ctx, mockDB, all)
+	assert.ErrorIs(err, db.ErrNotSupported)
+	_, err = db.RatingCounts(ctx, mockDB, all)
+	assert.ErrorIs(err, db.ErrNotSupported)
+
+	// Excluded photos aren't counted.
+	private := types.HasTag{Tag: types.Tag{Path: []string{"Private"}}}
+	skiing := types.Tag{Path: []string{"activity", "skiing"}}
+	excluded := types.Query{Selector: types.Difference{Starting: types.And{}, Excluding: private}}
+	counter := countingDB{DB: mockDB}
+	counter.On("TagCounts", mock.Anything, excluded).Return([]types.TagCount{{Tag: skiing, Photos: 3}}, nil).Once()
+	counter.On("RatingCounts", mock.Anything, excluded).Return([]types.RatingCount{{Rating: 4, Photos: 2}}, nil).Once()
+
+	e := db.NewExcludingDB(counter, private)
+	tagCounts, err := db.TagCounts(ctx, e, all)
+	assert.Nil(err)
+	assert.Equal([]types.TagCount{{Tag: skiing, Photos: 3}}, tagCounts)
+	ratingCounts, err := db.RatingCounts(ctx, e, all)
+	assert.Nil(err)
+	assert.Equal([]types.RatingCount{{Rating: 4, Photos: 2}}, ratingCounts)
+
+	// But the ExcludingDB can't count anything if the DB it wraps can't.
+	_, err = db.TagCounts(ctx, db.NewExcludingDB(mockDB, private), all)
+	assert.ErrorIs(err, db.ErrNotSupported)
+}
@@ -2,7 +2,9 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"sync"
 
 	"github.com/anitschke/photo-db-fs/types"
@@ -13,7 +15,36 @@ var (
 	dbFactory   = make(map[string]DBConstructor)
 )
 
-type DBConstructor func(dbSource string) (DB, error)
+type DBConstructor func(dbSource string, opts Options) (DB, error)
+
+// Options control how a DB is opened.
+type Options struct {
+	// Writable opens the DB so that it can be modified. If this is not set
+	// then all methods that modify the DB should return ErrReadOnly.
+	Writable bool
+}
+
+var (
+	// ErrReadOnly is returned when trying to modify a DB that was not opened
+	// as Writable.
+	ErrReadOnly = errors.New("database is read-only")
+
+	// ErrLocked is returned when trying to modify a DB that is locked by
+	// another program, such as the photo manager that owns the database.
+	ErrLocked = errors.New("database is locked by another program")
+
+	// ErrNotFound is returned when trying to modify something that doesn't
+	// exist in the DB.
+	ErrNotFound = errors.New("not found in database")
+
+	// ErrExists is returned when trying to create something that already
+	// exists in the DB.
+	ErrExists = errors.New("already exists in database")
+
+	// ErrNotSupported is returned when asking a DB for something that it
+	// can't do, such as counting photos with a DB that isn't a Counter.
+	ErrNotSupported = errors.New("not supported by database")
+)
 
 // DB is an interface for interacting with a photo database to query information
 // about photos within the database.
@@ -22,6 +53,30 @@ type DB interface {
 	RootTags(ctx context.Context) ([]types.Tag, error)
 	ChildrenTags(ctx context.Context, parent types.Tag) ([]types.Tag, error)
 
+	// PhotoTags should return all of the tags that are applied to at least one
+	// of the photos selected by the query.
+	PhotoTags(ctx context.Context, q types.Query) ([]types.Tag, error)
+
+	// PhotoMetadata should return the metadata of a single photo. The photo is
+	// identified in the same way as for AddTag, if there are multiple photos
+	// with the same ID then the metadata of any one of them may be returned.
+	PhotoMetadata(ctx context.Context, photo types.Photo) (types.PhotoMetadata, error)
+
+	// Albums should return the top level albums of the photo library. For
+	// databases that allow a library to be spread across multiple locations on
+	// disk there should be one top level album per location.
+	Albums(ctx context.Context) ([]types.Album, error)
+	ChildAlbums(ctx context.Context, parent types.Album) ([]types.Album, error)
+
+	// Cameras and Lenses should return all of the distinct cameras and lenses
+	// that were used to take photos in the database.
+	Cameras(ctx context.Context) ([]types.Camera, error)
+	Lenses(ctx context.Context) ([]string, error)
+
+	// TakenYears should return all of the distinct years in which the photos
+	// selected by the query were taken.
+	TakenYears(ctx context.Context, q types.Query) ([]int, error)
+
 	// Ratings should return a slice of ratings that will be used to render a
 	// directory of folders based on these ratings. In most cases all possible
 	// Ratings should be returned, if there is more than a "reasonable" number
@@ -30,9 +85,49 @@ type DB interface {
 	// acceding order.
 	Ratings() []float64
 
+	// PhotosWithContent should return all the photos in the database that have
+	// exactly the given content. This is used to figure out which photo a file
+	// is a copy of.
+	PhotosWithContent(ctx context.Context, content io.ReaderAt, size int64) ([]types.Photo, error)
+
+	// AddTag, RemoveTag and CreateTag modify the tags in the database. The
+	// photo is identified by its ID, or by its Path if it doesn't have an ID.
+	// If the ID is shared by multiple photos in the database then all of them
+	// are modified.
+	AddTag(ctx context.Context, photo types.Photo, tag types.Tag) error
+	RemoveTag(ctx context.Context, photo types.Photo, tag types.Tag) error
+	CreateTag(ctx context.Context, tag types.Tag) error
+
+	// SetRating sets the rating of a photo, the photo is identified the same
+	// way as for AddTag. The rating must be one of the ratings returned by
+	// Ratings.
+	SetRating(ctx context.Context, photo types.Photo, rating float64) error
+
 	Close() error
 }
 
+// Versioner can optionally be implemented by a DB that is able to cheaply tell
+// when the photo database has been modified, for example by the photo manager
+// that owns the database. This allows the DB to be polled for changes so
+// cached results can be refreshed.
+type Versioner interface {
+	// Version should return a value that changes every time the database is
+	// modified. The value itself has no meaning other than being compared to
+	// a previous version.
+	Version(ctx context.Context) (int64, error)
+}
+
+// CapabilityReporter can optionally be implemented by a DB that can't natively
+// select photos with every kind of selector. A DB that doesn't implement it is
+// assumed to support every kind of selector. See NewFallbackDB for how the
+// other kinds of selectors are handled.
+type CapabilityReporter interface {
+	// Capabilities should return the kinds of selectors that the DB is able to
+	// select photos with when they are passed to Photos, PhotoTags or
+	// TakenYears.
+	Capabilities() []types.SelectorKind
+}
+
 // Register handles registration of a new DB type.
 //
 // We handle registration of new DB types similar to how SQL database drivers
@@ -52,11 +147,11 @@ func Register(name string, dbCtor DBConstructor) {
 	dbFactory[name] = dbCtor
 }
 
-func New(name string, dbSource string) (DB, error) {
+func New(name string, dbSource string, opts Options) (DB, error) {
 	ctor, ok := dbFactory[name]
 	if !ok {
 		return nil, fmt.Errorf("dbCtor with name %q does not exist", name)
 	}
 
-	return ctor(dbSource)
+	return ctor(dbSource, opts)
 }
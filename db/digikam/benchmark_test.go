@@ -0,0 +1,358 @@
+package digikam
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	digikamtestresources "github.com/anitschke/photo-db-fs/test-resources/digikam"
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// The shape of the generated library used for benchmarks. It is meant to be
+// roughly the size of a large real world library, with a deep tag hierarchy
+// and a handful of tags on each photo.
+const (
+	benchmarkPhotos       = 100000
+	benchmarkAlbums       = 200
+	benchmarkRootTags     = 20
+	benchmarkChildTags    = 20
+	benchmarkLeafTags     = 10
+	benchmarkTagsPerPhoto = 5
+)
+
+// benchmarkTag gets one of the leaf tags of the generated library.
+func benchmarkTag(root, child, leaf int) types.Tag {
+	return types.Tag{Path: []string{
+		fmt.Sprintf("root%d", root),
+		fmt.Sprintf("child%d", child),
+		fmt.Sprintf("leaf%d", leaf),
+	}}
+}
+
+// generateLargeDB adds a large generated library to the basic test DB.
+func generateLargeDB(dbFile string) error {
+	sqlDB, err := sql.Open("sqlite3", "file:"+dbFile)
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	tx, err := sqlDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	exec := func(query string, args ...any) (int64, error) {
+		result, err := tx.Exec(query, args...)
+		if err != nil {
+			return 0, err
+		}
+		return result.LastInsertId()
+	}
+
+	albums := make([]int64, benchmarkAlbums)
+	for i := range albums {
+		if albums[i], err = exec("INSERT INTO Albums (albumRoot, relativePath) VALUES (1, ?)", fmt.Sprintf("/generated/album%d", i)); err != nil {
+			return err
+		}
+	}
+
+	var leaves []int64
+	for r := 0; r < benchmarkRootTags; r++ {
+		rootID, err := exec("INSERT INTO Tags (pid, name) VALUES (0, ?)", fmt.Sprintf("root%d", r))
+		if err != nil {
+			return err
+		}
+		for c := 0; c < benchmarkChildTags; c++ {
+			childID, err := exec("INSERT INTO Tags (pid, name) VALUES (?, ?)", rootID, fmt.Sprintf("child%d", c))
+			if err != nil {
+				return err
+			}
+			for l := 0; l < benchmarkLeafTags; l++ {
+				leafID, err := exec("INSERT INTO Tags (pid, name) VALUES (?, ?)", childID, fmt.Sprintf("leaf%d", l))
+				if err != nil {
+					return err
+				}
+				leaves = append(leaves, leafID)
+			}
+		}
+	}
+
+	// Use a fixed seed so every run of the benchmark uses the same library.
+	rnd := rand.New(rand.NewSource(1))
+	start := time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < benchmarkPhotos; i++ {
+		imageID, err := exec("INSERT INTO Images (album, name, status, category, fileSize, uniqueHash) VALUES (?, ?, 1, 1, 1000, ?)",
+			albums[i%len(albums)], fmt.Sprintf("IMG_%06d.jpg", i), fmt.Sprintf("%032x", i))
+		if err != nil {
+			return err
+		}
+
+		taken := start.Add(time.Duration(rnd.Int63n(int64(10 * 365 * 24 * time.Hour))))
+		if _, err := exec("INSERT INTO ImageInformation (imageid, rating, creationDate) VALUES (?, ?, ?)",
+			imageID, rnd.Intn(7)-1, taken.Format("2006-01-02T15:04:05")); err != nil {
+			return err
+		}
+		if _, err := exec("INSERT INTO ImageMetadata (imageid, make, model, lens) VALUES (?, 'NIKON CORPORATION', ?, ?)",
+			imageID, fmt.Sprintf("NIKON D%d", 3000+rnd.Intn(5)*100), fmt.Sprintf("Lens %d", rnd.Intn(10))); err != nil {
+			return err
+		}
+		for t := 0; t < benchmarkTagsPerPhoto; t++ {
+			if _, err := exec("INSERT OR IGNORE INTO ImageTags (imageid, tagid) VALUES (?, ?)", imageID, leaves[rnd.Intn(len(leaves))]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	_, err = sqlDB.Exec("ANALYZE")
+	return err
+}
+
+// setOperationPhotoInfoCTE, setOperationVisitor and buildSetOperationPhotoQuery
+// are how photo queries used to be built, before selectors were compiled into
+// conditions on each image by selectorVisitor. They are only kept around as a
+// baseline for the benchmarks to compare against.
+const setOperationPhotoInfoCTE = `
+WITH image_info as (
+SELECT i.id AS imageId, r.specificPath AS root, a.relativePath AS path, i.name AS name, i.uniqueHash AS uniqueHash, t.id AS tagId, ii.rating AS rating, i.album AS albumId, COALESCE(im.make, '') AS make, COALESCE(im.model, '') AS model, COALESCE(im.lens, '') AS lens, ii.creationDate AS taken
+FROM Images i
+LEFT JOIN ImageTags it ON it.imageid = i.id
+LEFT JOIN ImageInformation ii ON ii.imageid = i.id
+LEFT JOIN ImageMetadata im ON im.imageid = i.id
+LEFT JOIN Tags t ON it.tagid = t.id
+LEFT JOIN Albums a ON i.album = a.id
+LEFT JOIN AlbumRoots r ON albumRoot = r.id
+WHERE root != '' AND path != ''
+)
+`
+
+// setOperationVisitor builds a query that selects the photoProperties of the
+// images selected by each selector from the image_info CTE, and does set
+// operations on the results of those queries for And, Or and Difference.
+type setOperationVisitor struct{}
+
+var _ = (types.SelectorVisitor)(setOperationVisitor{})
+
+func (v setOperationVisitor) selectWhere(where string, parameters ...any) visitResult {
+	return visitResult{
+		query:      "SELECT " + photoProperties + " FROM image_info WHERE " + where,
+		parameters: parameters,
+	}
+}
+
+func (v setOperationVisitor) VisitHasTag(s types.HasTag) (interface{}, error) {
+	tagSubQuery, parameters, err := tagIDSubquery(s.Tag)
+	if err != nil {
+		return nil, err
+	}
+	return v.selectWhere("tagId = "+tagSubQuery, parameters...), nil
+}
+
+func (v setOperationVisitor) VisitHasRating(s types.HasRating) (interface{}, error) {
+	if err := s.Operator.Validate(); err != nil {
+		return nil, err
+	}
+	return v.selectWhere("rating >= 0 AND rating " + string(s.Operator) + " " + strconv.Itoa(int(s.Rating))), nil
+}
+
+func (v setOperationVisitor) VisitInAlbum(s types.InAlbum) (interface{}, error) {
+	albumSubQuery, parameters, err := albumIDSubquery(s.Album)
+	if err != nil {
+		return nil, err
+	}
+	return v.selectWhere("albumId = "+albumSubQuery, parameters...), nil
+}
+
+func (v setOperationVisitor) VisitHasCamera(s types.HasCamera) (interface{}, error) {
+	return v.selectWhere("make = ? AND model = ?", s.Camera.Make, s.Camera.Model), nil
+}
+
+func (v setOperationVisitor) VisitHasLens(s types.HasLens) (interface{}, error) {
+	return v.selectWhere("lens = ?", s.Lens), nil
+}
+
+func (v setOperationVisitor) VisitTakenOn(s types.TakenOn) (interface{}, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	conditions := []string{"taken IS NOT NULL"}
+	var parameters []any
+	if s.Year != 0 {
+		conditions = append(conditions, "CAST(strftime('%Y', taken) AS INTEGER) = ?")
+		parameters = append(parameters, s.Year)
+	}
+	if s.Month != 0 {
+		conditions = append(conditions, "CAST(strftime('%m', taken) AS INTEGER) = ?")
+		parameters = append(parameters, int(s.Month))
+	}
+	if s.Day != 0 {
+		conditions = append(conditions, "CAST(strftime('%d', taken) AS INTEGER) = ?")
+		parameters = append(parameters, s.Day)
+	}
+	return v.selectWhere(strings.Join(conditions, " AND "), parameters...), nil
+}
+
+func (v setOperationVisitor) VisitAnd(s types.And) (interface{}, error) {
+	// Originally And needed at least two operands, selecting everything here
+	// lets the baseline run every one of the benchmark queries.
+	if len(s.Operands) == 0 {
+		return v.selectWhere("1"), nil
+	}
+	return v.visitSetOperation("INTERSECT", s.Operands)
+}
+
+func (v setOperationVisitor) VisitOr(s types.Or) (interface{}, error) {
+	return v.visitSetOperation("UNION", s.Operands)
+}
+
+func (v setOperationVisitor) visitSetOperation(operator string, operands []types.Selector) (interface{}, error) {
+	queries := make([]string, 0, len(operands))
+	var parameters []any
+	for _, op := range operands {
+		subResult, err := selectorAccept(op, v)
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, subResult.query)
+		parameters = append(parameters, subResult.parameters...)
+	}
+
+	// sqlite doesn't allow brackets around set operations, so they are nested
+	// as sub queries instead.
+	return visitResult{
+		query:      "SELECT *\nFROM (\n" + strings.Join(queries, "\n"+operator+"\n") + "\n)",
+		parameters: parameters,
+	}, nil
+}
+
+func (v setOperationVisitor) VisitDifference(s types.Difference) (interface{}, error) {
+	startingResult, err := selectorAccept(s.Starting, v)
+	if err != nil {
+		return nil, err
+	}
+	excludingResult, err := selectorAccept(s.Excluding, v)
+	if err != nil {
+		return nil, err
+	}
+
+	return visitResult{
+		query:      "SELECT *\nFROM (\n" + startingResult.query + "\nEXCEPT\n" + excludingResult.query + "\n)",
+		parameters: append(append([]any{}, startingResult.parameters...), excludingResult.parameters...),
+	}, nil
+}
+
+func buildSetOperationPhotoQuery(q types.Query) (string, []any, error) {
+	visitResult, err := selectorAccept(q.Selector, setOperationVisitor{})
+	if err != nil {
+		return "", nil, err
+	}
+	return setOperationPhotoInfoCTE + "\nSELECT DISTINCT * FROM(\n" + visitResult.query + "\n)", visitResult.parameters, nil
+}
+
+// photoIDs gets the sorted IDs of photos so the photos selected by different
+// queries can be compared.
+func photoIDs(photos []types.Photo) []string {
+	ids := make([]string, 0, len(photos))
+	for _, p := range photos {
+		ids = append(ids, string(p.ID))
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func BenchmarkDigikamSqliteDatabase_LargeLibrary(b *testing.B) {
+	testDB, _, cleanup, err := digikamtestresources.PrepareBasicDB()
+	if !assert.Nil(b, err) {
+		b.FailNow()
+	}
+	defer cleanup()
+
+	if !assert.Nil(b, generateLargeDB(testDB)) {
+		b.FailNow()
+	}
+
+	photoDB, err := NewDigikamSqliteDatabase(testDB)
+	if !assert.Nil(b, err) {
+		b.FailNow()
+	}
+	defer func() {
+		assert.Nil(b, photoDB.Close())
+	}()
+
+	ctx := context.Background()
+	tag := types.HasTag{Tag: benchmarkTag(3, 7, 2)}
+	otherTag := types.HasTag{Tag: benchmarkTag(3, 7, 5)}
+	fourStars := types.HasRating{Operator: types.GreaterThanOrEqual, Rating: 4}
+
+	queries := []struct {
+		name     string
+		selector types.Selector
+	}{
+		{name: "All", selector: types.And{}},
+		{name: "Tag", selector: tag},
+		{name: "TagAndRating", selector: types.And{Operands: []types.Selector{tag, fourStars}}},
+		{name: "TwoTags", selector: types.Or{Operands: []types.Selector{tag, otherTag}}},
+		{name: "TagExcludingTag", selector: types.Difference{Starting: tag, Excluding: otherTag}},
+		{name: "TagAndYear", selector: types.And{Operands: []types.Selector{tag, types.TakenOn{Year: 2015}}}},
+	}
+
+	for _, q := range queries {
+		query := types.Query{Selector: q.selector}
+		b.Run("Photos"+q.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := photoDB.Photos(ctx, query); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		// The baseline is how the photos used to be queried, compare it with
+		// Photos to see how much faster the selectors are now.
+		baseline, parameters, err := buildSetOperationPhotoQuery(query)
+		if !assert.Nil(b, err) {
+			b.FailNow()
+		}
+		b.Run("PhotosBaseline"+q.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := photoDB.photos(ctx, baseline, parameters); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		// Make sure the baseline selects the same photos, otherwise comparing
+		// the two is meaningless.
+		photos, err := photoDB.Photos(ctx, query)
+		assert.Nil(b, err)
+		baselinePhotos, err := photoDB.photos(ctx, baseline, parameters)
+		assert.Nil(b, err)
+		assert.Equal(b, photoIDs(photos), photoIDs(baselinePhotos), q.name)
+		b.Run("PhotoTags"+q.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := photoDB.PhotoTags(ctx, query); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run("TagCounts"+q.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := photoDB.TagCounts(ctx, query); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
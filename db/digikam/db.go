@@ -3,8 +3,13 @@ package digikam
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/anitschke/photo-db-fs/db"
 	"github.com/anitschke/photo-db-fs/types"
@@ -14,65 +19,151 @@ import (
 )
 
 func init() {
-	db.Register("digikam-sqlite", func(dbSource string) (db.DB, error) {
+	db.Register("digikam-sqlite", func(dbSource string, opts db.Options) (db.DB, error) {
+		if opts.Writable {
+			return NewWritableDigikamSqliteDatabase(dbSource)
+		}
 		return NewDigikamSqliteDatabase(dbSource)
 	})
 }
 
 type DigikamSQLDatabase struct {
-	db *sql.DB
+	db       *sql.DB
+	writable bool
+
+	// versionConn is a connection that we hold on to for checking the
+	// data_version. SQLite only gives a meaningful data_version when it is
+	// checked on the same connection each time, so we can't use a connection
+	// from the pool. It is only opened the first time Version is called.
+	versionMu   sync.Mutex
+	versionConn *sql.Conn
+
+	// tags is the tag tree as of tagsVersion, see tagTree.
+	tagsMu      sync.Mutex
+	tags        *tagTree
+	tagsVersion int64
 }
 
 var _ = (db.DB)((*DigikamSQLDatabase)(nil))
+var _ = (db.Versioner)((*DigikamSQLDatabase)(nil))
+var _ = (db.Counter)((*DigikamSQLDatabase)(nil))
+var _ = (db.PhotoStreamer)((*DigikamSQLDatabase)(nil))
+var _ = (db.MetadataBatcher)((*DigikamSQLDatabase)(nil))
+var _ = (db.Grouper)((*DigikamSQLDatabase)(nil))
 
 func NewDigikamSqliteDatabase(filePath string) (*DigikamSQLDatabase, error) {
-	return NewDigikamSQLDatabase("sqlite3", filePath)
+	return NewDigikamSQLDatabase("sqlite3", filePath, false)
+}
+
+// NewWritableDigikamSqliteDatabase opens the database so that it can be
+// modified. digiKam doesn't expect anyone else to be modifying its database
+// while it is running, so this will refuse to open the database if digiKam
+// currently has it locked.
+func NewWritableDigikamSqliteDatabase(filePath string) (*DigikamSQLDatabase, error) {
+	return NewDigikamSQLDatabase("sqlite3", filePath, true)
 }
 
-func NewDigikamSQLDatabase(driver string, filePath string) (*DigikamSQLDatabase, error) {
+func NewDigikamSQLDatabase(driver string, filePath string, writable bool) (*DigikamSQLDatabase, error) {
 	connectionString := "file:" + filePath + "?mode=ro"
-	db, err := sql.Open(driver, connectionString)
+	if writable {
+		// Use immediate transactions so that we get the write lock at the
+		// start of every transaction, that way we find out right away if
+		// digiKam is holding the lock rather than half way through
+		// modifying the database. We only wait a short time for the lock
+		// since digiKam holding it likely means it is in the middle of a long
+		// running operation (scanning, ...).
+		connectionString = "file:" + filePath + "?mode=rw&_txlock=immediate&_busy_timeout=1000"
+	}
+	sqlDB, err := sql.Open(driver, connectionString)
 	if err != nil {
 		return nil, err
 	}
 
-	return &DigikamSQLDatabase{
-		db: db,
-	}, nil
+	d := &DigikamSQLDatabase{
+		db:       sqlDB,
+		writable: writable,
+	}
+
+	if writable {
+		if err := d.checkNotLocked(context.Background()); err != nil {
+			utils.CloseAndLogErrors(sqlDB)
+			return nil, err
+		}
+	}
+	return d, nil
 }
 
 func (db *DigikamSQLDatabase) Photos(ctx context.Context, q types.Query) ([]types.Photo, error) {
+	var photos []types.Photo
+	err := db.StreamPhotos(ctx, q, func(p types.Photo) error {
+		photos = append(photos, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return photos, nil
+}
+
+func (db *DigikamSQLDatabase) StreamPhotos(ctx context.Context, q types.Query, fn func(types.Photo) error) error {
 	zap.L().Debug("db query photos", zap.Any("query", q))
 
-	queryString, parameters, err := buildDigikamPhotoQuery(q)
+	tree, err := db.tagTree(ctx)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	queryString, parameters, err := buildDigikamPhotoQuery(q, tree)
+	if err != nil {
+		return err
 	}
 
-	queryString = addCountToQuery(queryString)
+	count, err := db.streamPhotos(ctx, queryString, parameters, fn)
+	if err != nil {
+		return err
+	}
 
+	zap.L().Debug("db query photos passed", zap.Any("query", q), zap.Int("resultCount", count))
+	return nil
+}
+
+// photos runs a query that selects the photoProperties of photos and converts
+// the results into photos.
+func (db *DigikamSQLDatabase) photos(ctx context.Context, queryString string, parameters []any) ([]types.Photo, error) {
+	var photos []types.Photo
+	_, err := db.streamPhotos(ctx, queryString, parameters, func(p types.Photo) error {
+		photos = append(photos, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return photos, nil
+}
+
+// streamPhotos runs a query that selects the photoProperties of photos and
+// calls fn with each of the photos as the rows are read. It returns the number
+// of photos.
+func (db *DigikamSQLDatabase) streamPhotos(ctx context.Context, queryString string, parameters []any, fn func(types.Photo) error) (int, error) {
 	zap.L().Debug("db query", zap.String("query", queryString), zap.Any("parameters", parameters))
 	rows, err := db.db.QueryContext(ctx, queryString, parameters...)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 	defer utils.CloseAndLogErrors(rows)
 
-	// don't make until we know how big to make our slice (increasing capacity
-	// of slices is expensive)
-	var photos []types.Photo
-
+	count := 0
 	for rows.Next() {
-		// root, path, name, uniqueHash
+		// imageId, root, path, name, uniqueHash
 
-		var nPhotos int
+		var imageID int64
 		var root string
 		var path string
 		var name string
 		var uniqueHash string
-		err = rows.Scan(&nPhotos, &root, &path, &name, &uniqueHash)
+		err = rows.Scan(&imageID, &root, &path, &name, &uniqueHash)
 		if err != nil {
-			return nil, err
+			return count, err
 		}
 
 		fullPath := filepath.Join(root, path, name)
@@ -81,50 +172,371 @@ func (db *DigikamSQLDatabase) Photos(ctx context.Context, q types.Query) ([]type
 			Path: fullPath,
 			ID:   uniqueHash,
 		}
-
-		// If the tags slice doesn't exist yet then make it with enough elements
-		// so we aren't constantly resizing on every append
-		if photos == nil {
-			photos = make([]types.Photo, 0, nPhotos)
+		if err := fn(p); err != nil {
+			return count, err
 		}
-
-		photos = append(photos, p)
+		count++
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return count, err
 	}
-
-	zap.L().Debug("db query photos passed", zap.Any("query", q), zap.Int("resultCount", len(photos)))
-	return photos, nil
+	return count, nil
 }
 
 func (db *DigikamSQLDatabase) RootTags(ctx context.Context) ([]types.Tag, error) {
 	zap.L().Debug("db query root tags")
 
-	where := "pid=0"
-	parentPath := []string{}
-	parameters := make([]any, 0)
-	return db.tags(ctx, parentPath, where, parameters)
+	tree, err := db.tagTree(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return tree.childTags(0), nil
 }
 
 func (db *DigikamSQLDatabase) ChildrenTags(ctx context.Context, p types.Tag) ([]types.Tag, error) {
 	zap.L().Debug("db query children tags", zap.Any("parent", p))
 
-	parentQuery, parameters, err := tagIDSubquery(p)
+	if len(p.Path) == 0 {
+		return nil, fmt.Errorf("can't query the children of a tag with an empty path")
+	}
+
+	tree, err := db.tagTree(ctx)
+	if err != nil {
+		return nil, err
+	}
+	id, ok := tree.id(p)
+	if !ok {
+		return nil, nil
+	}
+	return tree.childTags(id), nil
+}
+
+func (db *DigikamSQLDatabase) PhotoTags(ctx context.Context, q types.Query) ([]types.Tag, error) {
+	zap.L().Debug("db query photo tags", zap.Any("query", q))
+
+	tree, err := db.tagTree(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	queryString, parameters, err := buildDigikamPhotoTagsQuery(q, tree)
+	if err != nil {
+		return nil, err
+	}
+
+	zap.L().Debug("db query", zap.String("query", queryString), zap.Any("parameters", parameters))
+	tags, err := db.selectedTags(ctx, tree, queryString, parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	zap.L().Debug("db photo tags query passed", zap.Any("query", q), zap.Int("resultCount", len(tags)))
+	return tags, nil
+}
+
+// selectedTags runs a query that selects the IDs of tags and looks up each of
+// the tags in the tag tree.
+func (db *DigikamSQLDatabase) selectedTags(ctx context.Context, tree *tagTree, queryString string, parameters []any) ([]types.Tag, error) {
+	rows, err := db.db.QueryContext(ctx, queryString, parameters...)
+	if err != nil {
+		return nil, err
+	}
+	defer utils.CloseAndLogErrors(rows)
+
+	tags := make([]types.Tag, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		tag, ok := tree.tag(id)
+		if !ok {
+			return nil, fmt.Errorf("failed to find tag with id %d", id)
+		}
+		tags = append(tags, tag)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// digikamCaptionType is the type of comment in the ImageComments table that
+// digiKam shows as the caption of a photo.
+const digikamCaptionType = 1
+
+func (db *DigikamSQLDatabase) PhotoMetadata(ctx context.Context, photo types.Photo) (types.PhotoMetadata, error) {
+	zap.L().Debug("db query photo metadata", zap.Any("photo", photo))
+
+	ids, err := imageIDs(ctx, db.db, photo)
+	if err != nil {
+		return types.PhotoMetadata{}, err
+	}
+	metadata, err := db.imagesMetadata(ctx, ids[:1])
+	if err != nil {
+		return types.PhotoMetadata{}, err
+	}
+
+	zap.L().Debug("db photo metadata query passed", zap.Any("photo", photo))
+	return metadata[ids[0]], nil
+}
+
+// PhotosMetadata gets the metadata of all of the photos with a few queries,
+// rather than the handful of queries per photo that PhotoMetadata needs.
+func (db *DigikamSQLDatabase) PhotosMetadata(ctx context.Context, photos []types.Photo) ([]types.PhotoMetadata, error) {
+	zap.L().Debug("db query photos metadata", zap.Int("photoCount", len(photos)))
+
+	// Photos are nearly always found by their path. Just like imageIDs we find
+	// all the images with the right names and then check the full paths here.
+	names := make([]string, 0, len(photos))
+	seen := make(map[string]struct{}, len(photos))
+	for _, p := range photos {
+		name := filepath.Base(p.Path)
+		if _, ok := seen[name]; !ok {
+			seen[name] = struct{}{}
+			names = append(names, name)
+		}
+	}
+	byPath, err := db.imageIDsByPath(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, len(photos))
+	for i, p := range photos {
+		id, ok := byPath[filepath.Clean(p.Path)]
+		if !ok {
+			// The photo may only be identified by its ID.
+			found, err := imageIDs(ctx, db.db, p)
+			if err != nil {
+				return nil, err
+			}
+			id = found[0]
+		}
+		ids[i] = id
+	}
+
+	byID, err := db.imagesMetadata(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	metadata := make([]types.PhotoMetadata, len(photos))
+	for i, id := range ids {
+		metadata[i] = byID[id]
+	}
+
+	zap.L().Debug("db photos metadata query passed", zap.Int("photoCount", len(photos)))
+	return metadata, nil
+}
+
+// imageIDsByPath maps the full path of each of the images with one of the names
+// to the ID of the image.
+func (db *DigikamSQLDatabase) imageIDsByPath(ctx context.Context, names []string) (map[string]int64, error) {
+	// Rather than building a query with a parameter for each name, which
+	// could go over the limit on the number of parameters, the names are
+	// passed as a single JSON array.
+	namesJSON, err := json.Marshal(names)
 	if err != nil {
 		return nil, err
 	}
+	rows, err := db.db.QueryContext(ctx, `SELECT i.id, r.specificPath, a.relativePath, i.name
+		FROM Images i
+		JOIN Albums a ON i.album = a.id
+		JOIN AlbumRoots r ON a.albumRoot = r.id
+		WHERE i.name IN (SELECT value FROM json_each(?))`, string(namesJSON))
+	if err != nil {
+		return nil, err
+	}
+	defer utils.CloseAndLogErrors(rows)
+
+	byPath := make(map[string]int64, len(names))
+	for rows.Next() {
+		var id int64
+		var root, path, name string
+		if err := rows.Scan(&id, &root, &path, &name); err != nil {
+			return nil, err
+		}
+		byPath[filepath.Join(root, path, name)] = id
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return byPath, nil
+}
+
+// imagesMetadata gets the metadata of each of the images with the IDs.
+func (db *DigikamSQLDatabase) imagesMetadata(ctx context.Context, ids []int64) (map[int64]types.PhotoMetadata, error) {
+	idsJSON, err := json.Marshal(ids)
+	if err != nil {
+		return nil, err
+	}
+	metadata := make(map[int64]types.PhotoMetadata, len(ids))
+
+	rows, err := db.db.QueryContext(ctx, `SELECT i.id, r.label, a.relativePath, ii.rating, ii.creationDate, COALESCE(im.make, ''), COALESCE(im.model, ''), COALESCE(im.lens, '')
+		FROM Images i
+		JOIN Albums a ON i.album = a.id
+		JOIN AlbumRoots r ON a.albumRoot = r.id
+		LEFT JOIN ImageInformation ii ON ii.imageid = i.id
+		LEFT JOIN ImageMetadata im ON im.imageid = i.id
+		WHERE i.id IN (SELECT value FROM json_each(?))`, string(idsJSON))
+	if err != nil {
+		return nil, err
+	}
+	defer utils.CloseAndLogErrors(rows)
+	for rows.Next() {
+		var id int64
+		var label sql.NullString
+		var relativePath string
+		var rating sql.NullInt64
+		var taken sql.NullTime
+		var m types.PhotoMetadata
+		err := rows.Scan(&id, &label, &relativePath, &rating, &taken, &m.Camera.Make, &m.Camera.Model, &m.Lens)
+		if err != nil {
+			return nil, err
+		}
+
+		// digiKam uses a rating of -1 for photos that haven't been rated.
+		if rating.Valid && rating.Int64 >= 0 {
+			r := float64(rating.Int64)
+			m.Rating = &r
+		}
+		// digiKam stores dates as local time without a time zone, which the
+		// sqlite driver hands back to us as UTC.
+		if taken.Valid {
+			t := taken.Time
+			m.Taken = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+		}
+		m.Album = albumFromRelativePath(label, relativePath)
+		metadata[id] = m
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-	where := "pid=" + parentQuery
+	// A caption can be stored in multiple languages, we prefer the default
+	// language but will take any if there is no default.
+	captionRows, err := db.db.QueryContext(ctx, `SELECT imageid, comment FROM ImageComments
+		WHERE type = ? AND imageid IN (SELECT value FROM json_each(?))
+		ORDER BY imageid, language = 'x-default' DESC, id`, digikamCaptionType, string(idsJSON))
+	if err != nil {
+		return nil, err
+	}
+	defer utils.CloseAndLogErrors(captionRows)
+	captioned := make(map[int64]bool)
+	for captionRows.Next() {
+		var id int64
+		var caption sql.NullString
+		if err := captionRows.Scan(&id, &caption); err != nil {
+			return nil, err
+		}
+		if captioned[id] {
+			continue
+		}
+		captioned[id] = true
+		m := metadata[id]
+		m.Caption = caption.String
+		metadata[id] = m
+	}
+	if err := captionRows.Err(); err != nil {
+		return nil, err
+	}
 
-	return db.tags(ctx, p.Path, where, parameters)
+	tree, err := db.tagTree(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tagRows, err := db.db.QueryContext(ctx, "SELECT imageid, tagid FROM ImageTags WHERE imageid IN (SELECT value FROM json_each(?))", string(idsJSON))
+	if err != nil {
+		return nil, err
+	}
+	defer utils.CloseAndLogErrors(tagRows)
+	for tagRows.Next() {
+		var id, tagID int64
+		if err := tagRows.Scan(&id, &tagID); err != nil {
+			return nil, err
+		}
+		tag, ok := tree.tag(tagID)
+		if !ok {
+			return nil, fmt.Errorf("failed to find tag with id %d", tagID)
+		}
+		m := metadata[id]
+		m.Tags = append(m.Tags, tag)
+		metadata[id] = m
+	}
+	if err := tagRows.Err(); err != nil {
+		return nil, err
+	}
+	return metadata, nil
 }
 
-func (db *DigikamSQLDatabase) tags(ctx context.Context, parentPath []string, where string, parameters []any) ([]types.Tag, error) {
-	q := "SELECT name FROM Tags WHERE " + where
+func (db *DigikamSQLDatabase) Albums(ctx context.Context) ([]types.Album, error) {
+	zap.L().Debug("db query albums")
+
+	// In digiKam the top level albums are the album roots, each of which is
+	// a different location on disk where the library is stored.
+	q := "SELECT label FROM AlbumRoots"
 	q = addCountToQuery(q)
 
+	zap.L().Debug("db query", zap.String("query", q))
+	rows, err := db.db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer utils.CloseAndLogErrors(rows)
+
+	var albums []types.Album
+
+	for rows.Next() {
+		var nAlbums int
+		var label sql.NullString
+		err = rows.Scan(&nAlbums, &label)
+		if err != nil {
+			return nil, err
+		}
+
+		if albums == nil {
+			albums = make([]types.Album, 0, nAlbums)
+		}
+
+		// The label of an album root is optional, without one there is no
+		// name we can give the album so we leave it out.
+		if !label.Valid {
+			zap.L().Debug("skipping album root without a label")
+			continue
+		}
+
+		albums = append(albums, types.Album{Path: []string{label.String}})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	zap.L().Debug("db albums query passed", zap.Int("resultCount", len(albums)))
+	return albums, nil
+}
+
+func (db *DigikamSQLDatabase) ChildAlbums(ctx context.Context, p types.Album) ([]types.Album, error) {
+	zap.L().Debug("db query child albums", zap.Any("parent", p))
+
+	if len(p.Path) == 0 {
+		return nil, fmt.Errorf("can't query the children of an album with an empty path")
+	}
+
+	// digiKam stores the albums as a flat list of paths relative to the album
+	// root, so we ask for every album under the parent and then only keep the
+	// ones that are direct children of the parent.
+	relativePath := albumRelativePath(p)
+	prefix := relativePath
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	q := "SELECT a.relativePath FROM Albums a JOIN AlbumRoots r ON a.albumRoot = r.id WHERE r.label = ? AND substr(a.relativePath, 1, length(?)) = ?"
+	parameters := []any{p.Path[0], prefix, prefix}
+
 	zap.L().Debug("db query", zap.String("query", q), zap.Any("parameters", parameters))
 	rows, err := db.db.QueryContext(ctx, q, parameters...)
 	if err != nil {
@@ -132,47 +544,308 @@ func (db *DigikamSQLDatabase) tags(ctx context.Context, parentPath []string, whe
 	}
 	defer utils.CloseAndLogErrors(rows)
 
-	// don't make until we know how big to make our slice (increasing capacity
-	// of slices is expensive)
-	var tags []types.Tag
+	albums := make([]types.Album, 0)
 
 	for rows.Next() {
-		var nTags int
-		var name string
-		err = rows.Scan(&nTags, &name)
+		var childPath string
+		err = rows.Scan(&childPath)
 		if err != nil {
 			return nil, err
 		}
 
-		t := types.Tag{}
-		t.Path = make([]string, len(parentPath), len(parentPath)+1)
-		copy(t.Path, parentPath)
-		t.Path = append(t.Path, name)
+		name := strings.TrimPrefix(childPath, prefix)
+		if name == "" || strings.Contains(name, "/") {
+			continue
+		}
+
+		a := types.Album{}
+		a.Path = make([]string, len(p.Path), len(p.Path)+1)
+		copy(a.Path, p.Path)
+		a.Path = append(a.Path, name)
+		albums = append(albums, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	zap.L().Debug("db child albums query passed", zap.Any("parent", p), zap.Int("resultCount", len(albums)))
+	return albums, nil
+}
+
+func (db *DigikamSQLDatabase) Cameras(ctx context.Context) ([]types.Camera, error) {
+	zap.L().Debug("db query cameras")
+
+	q := "SELECT DISTINCT COALESCE(make, ''), COALESCE(model, '') FROM ImageMetadata WHERE COALESCE(make, '') != '' OR COALESCE(model, '') != ''"
+	q = addCountToQuery(q)
 
-		// If the tags slice doesn't exist yet then make it with enough elements
-		// so we aren't constantly resizing on every append
-		if tags == nil {
-			tags = make([]types.Tag, 0, nTags)
+	zap.L().Debug("db query", zap.String("query", q))
+	rows, err := db.db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer utils.CloseAndLogErrors(rows)
+
+	var cameras []types.Camera
+
+	for rows.Next() {
+		var nCameras int
+		var c types.Camera
+		err = rows.Scan(&nCameras, &c.Make, &c.Model)
+		if err != nil {
+			return nil, err
 		}
 
-		tags = append(tags, t)
+		if cameras == nil {
+			cameras = make([]types.Camera, 0, nCameras)
+		}
+
+		cameras = append(cameras, c)
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
-	zap.L().Debug("db tags query passed", zap.Any("parentPath", parentPath), zap.Int("resultCount", len(tags)))
-	return tags, nil
+	zap.L().Debug("db cameras query passed", zap.Int("resultCount", len(cameras)))
+	return cameras, nil
+}
+
+func (db *DigikamSQLDatabase) Lenses(ctx context.Context) ([]string, error) {
+	zap.L().Debug("db query lenses")
+
+	q := "SELECT DISTINCT lens FROM ImageMetadata WHERE COALESCE(lens, '') != ''"
+	q = addCountToQuery(q)
+
+	zap.L().Debug("db query", zap.String("query", q))
+	rows, err := db.db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer utils.CloseAndLogErrors(rows)
+
+	var lenses []string
+
+	for rows.Next() {
+		var nLenses int
+		var lens string
+		err = rows.Scan(&nLenses, &lens)
+		if err != nil {
+			return nil, err
+		}
+
+		if lenses == nil {
+			lenses = make([]string, 0, nLenses)
+		}
+
+		lenses = append(lenses, lens)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	zap.L().Debug("db lenses query passed", zap.Int("resultCount", len(lenses)))
+	return lenses, nil
+}
+
+func (db *DigikamSQLDatabase) TakenYears(ctx context.Context, q types.Query) ([]int, error) {
+	zap.L().Debug("db query taken years", zap.Any("query", q))
+
+	tree, err := db.tagTree(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	queryString, parameters, err := buildDigikamTakenYearsQuery(q, tree)
+	if err != nil {
+		return nil, err
+	}
+
+	zap.L().Debug("db query", zap.String("query", queryString), zap.Any("parameters", parameters))
+	rows, err := db.db.QueryContext(ctx, queryString, parameters...)
+	if err != nil {
+		return nil, err
+	}
+	defer utils.CloseAndLogErrors(rows)
+
+	years := make([]int, 0)
+	for rows.Next() {
+		var year int
+		err = rows.Scan(&year)
+		if err != nil {
+			return nil, err
+		}
+		years = append(years, year)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	zap.L().Debug("db taken years query passed", zap.Any("query", q), zap.Int("resultCount", len(years)))
+	return years, nil
+}
+
+func (db *DigikamSQLDatabase) TagCounts(ctx context.Context, q types.Query) ([]types.TagCount, error) {
+	zap.L().Debug("db query tag counts", zap.Any("query", q))
+
+	tree, err := db.tagTree(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	queryString, parameters, err := buildDigikamTagCountsQuery(q, tree)
+	if err != nil {
+		return nil, err
+	}
+
+	zap.L().Debug("db query", zap.String("query", queryString), zap.Any("parameters", parameters))
+	rows, err := db.db.QueryContext(ctx, queryString, parameters...)
+	if err != nil {
+		return nil, err
+	}
+	defer utils.CloseAndLogErrors(rows)
+
+	counts := make([]types.TagCount, 0)
+	for rows.Next() {
+		var id int64
+		var count int
+		if err := rows.Scan(&id, &count); err != nil {
+			return nil, err
+		}
+		tag, ok := tree.tag(id)
+		if !ok {
+			return nil, fmt.Errorf("failed to find tag with id %d", id)
+		}
+		counts = append(counts, types.TagCount{Tag: tag, Photos: count})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	zap.L().Debug("db tag counts query passed", zap.Any("query", q), zap.Int("resultCount", len(counts)))
+	return counts, nil
+}
+
+func (db *DigikamSQLDatabase) RatingCounts(ctx context.Context, q types.Query) ([]types.RatingCount, error) {
+	zap.L().Debug("db query rating counts", zap.Any("query", q))
+
+	tree, err := db.tagTree(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	queryString, parameters, err := buildDigikamRatingCountsQuery(q, tree)
+	if err != nil {
+		return nil, err
+	}
+
+	zap.L().Debug("db query", zap.String("query", queryString), zap.Any("parameters", parameters))
+	rows, err := db.db.QueryContext(ctx, queryString, parameters...)
+	if err != nil {
+		return nil, err
+	}
+	defer utils.CloseAndLogErrors(rows)
+
+	counts := make([]types.RatingCount, 0)
+	for rows.Next() {
+		var rating int64
+		var count int
+		if err := rows.Scan(&rating, &count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, types.RatingCount{Rating: float64(rating), Photos: count})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	zap.L().Debug("db rating counts query passed", zap.Any("query", q), zap.Int("resultCount", len(counts)))
+	return counts, nil
+}
+
+func (db *DigikamSQLDatabase) PhotoGroups(ctx context.Context, q types.Query) (types.PhotoGroups, error) {
+	zap.L().Debug("db query photo groups", zap.Any("query", q))
+
+	tree, err := db.tagTree(ctx)
+	if err != nil {
+		return types.PhotoGroups{}, err
+	}
+
+	queryString, parameters, err := buildDigikamPhotoGroupsQuery(q, tree)
+	if err != nil {
+		return types.PhotoGroups{}, err
+	}
+
+	zap.L().Debug("db query", zap.String("query", queryString), zap.Any("parameters", parameters))
+	rows, err := db.db.QueryContext(ctx, queryString, parameters...)
+	if err != nil {
+		return types.PhotoGroups{}, err
+	}
+	defer utils.CloseAndLogErrors(rows)
+
+	var groups types.PhotoGroupsBuilder
+	for rows.Next() {
+		var label sql.NullString
+		var relativePath string
+		var camera types.Camera
+		var lens string
+		if err := rows.Scan(&label, &relativePath, &camera.Make, &camera.Model, &lens); err != nil {
+			return types.PhotoGroups{}, err
+		}
+		groups.Add(albumFromRelativePath(label, relativePath), camera, lens)
+	}
+
+	if err := rows.Err(); err != nil {
+		return types.PhotoGroups{}, err
+	}
+
+	zap.L().Debug("db photo groups query passed", zap.Any("query", q))
+	return groups.Groups(), nil
 }
 
 func (db *DigikamSQLDatabase) Ratings() []float64 {
 	return []float64{0, 1, 2, 3, 4, 5}
 }
 
+// Version uses the SQLite data_version pragma to detect when another
+// connection, such as digiKam itself, has modified the database.
+func (db *DigikamSQLDatabase) Version(ctx context.Context) (int64, error) {
+	db.versionMu.Lock()
+	defer db.versionMu.Unlock()
+
+	if db.versionConn == nil {
+		conn, err := db.db.Conn(ctx)
+		if err != nil {
+			return 0, err
+		}
+		db.versionConn = conn
+	}
+
+	var version int64
+	if err := db.versionConn.QueryRowContext(ctx, "PRAGMA data_version").Scan(&version); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
 func (db *DigikamSQLDatabase) Close() error {
 	zap.L().Debug("db close")
-	return db.db.Close()
+
+	db.versionMu.Lock()
+	defer db.versionMu.Unlock()
+
+	// Close the DB even if closing the version connection fails so we don't
+	// leak it.
+	var versionErr error
+	if db.versionConn != nil {
+		versionErr = db.versionConn.Close()
+		db.versionConn = nil
+	}
+	return errors.Join(versionErr, db.db.Close())
 }
 
 // tagIDSubquery accepts a tag and produces a query and the parameters
@@ -195,6 +868,39 @@ func tagIDSubquery(t types.Tag) (string, []any, error) {
 	return q, parameters, nil
 }
 
+// albumRelativePath converts an album into the path digiKam stores for that
+// album relative to the album root.
+func albumRelativePath(a types.Album) string {
+	return "/" + strings.Join(a.Path[1:], "/")
+}
+
+// albumFromRelativePath converts the label of an album root and the path
+// digiKam stores for an album relative to the root into an album. Album roots
+// without a label aren't shown as albums, see Albums, so photos in them aren't
+// in any album.
+func albumFromRelativePath(label sql.NullString, relativePath string) types.Album {
+	if !label.Valid {
+		return types.Album{}
+	}
+	a := types.Album{Path: []string{label.String}}
+	if relativePath != "/" {
+		a.Path = append(a.Path, strings.Split(strings.TrimPrefix(relativePath, "/"), "/")...)
+	}
+	return a
+}
+
+// albumIDSubquery accepts an album and produces a query and the parameters
+// associated with that query in order to get the ID of the specified album
+func albumIDSubquery(a types.Album) (string, []any, error) {
+	if len(a.Path) == 0 {
+		return "", nil, fmt.Errorf("can't produce an album subquery for an album with an empty path")
+	}
+
+	q := "(SELECT a.id FROM Albums a JOIN AlbumRoots r ON a.albumRoot = r.id WHERE r.label = ? AND a.relativePath = ?)"
+	parameters := []any{a.Path[0], albumRelativePath(a)}
+	return q, parameters, nil
+}
+
 // addCountToQuery modifies the query so as to also return the number of results
 // in the query so we are able to create slices with the correct capacity so we
 // don't run into inefficient resizes as we add every new result to the slice.
@@ -2,7 +2,10 @@ package digikam
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/anitschke/photo-db-fs/db"
 	digikamtestresources "github.com/anitschke/photo-db-fs/test-resources/digikam"
@@ -32,7 +35,7 @@ func TestDigikamSqliteDatabase_Registered(t *testing.T) {
 	assert.Nil(err)
 	defer cleanup()
 
-	db, err := db.New("digikam-sqlite", testDB)
+	db, err := db.New("digikam-sqlite", testDB, db.Options{})
 	assert.NotNil(db)
 	assert.Nil(err)
 
@@ -140,6 +143,384 @@ func TestDigikamSqliteDatabase_ChildrenTags_ChildrenOfNonRoot(t *testing.T) {
 	assert.ElementsMatch(actTags, expTags)
 }
 
+func TestDigikamSqliteDatabase_PhotoTags(t *testing.T) {
+	assert := assert.New(t)
+
+	testDB, _, cleanup, err := digikamtestresources.PrepareBasicDB()
+	assert.Nil(err)
+	defer cleanup()
+
+	db, err := NewDigikamSqliteDatabase(testDB)
+	assert.Nil(err)
+	defer func() {
+		err = db.Close()
+		assert.Nil(err)
+	}()
+
+	q := types.Query{
+		Selector: types.HasTag{
+			Tag: types.Tag{
+				Path: []string{"activity", "skiing"},
+			},
+		},
+	}
+
+	ctx := context.Background()
+	actTags, err := db.PhotoTags(ctx, q)
+	assert.Nil(err)
+
+	expTags := []types.Tag{
+		{
+			Path: []string{"activity"},
+		},
+		{
+			Path: []string{"activity", "skiing"},
+		},
+		{
+			Path: []string{"_Digikam_Internal_Tags_", "Pick Label None"},
+		},
+		{
+			Path: []string{"_Digikam_Internal_Tags_", "Color Label None"},
+		},
+		{
+			Path: []string{"_Digikam_Internal_Tags_", "Color Label Red"},
+		},
+		{
+			Path: []string{"_Digikam_Internal_Tags_", "Color Label Green"},
+		},
+	}
+
+	assert.ElementsMatch(actTags, expTags)
+}
+
+func TestDigikamSqliteDatabase_Albums(t *testing.T) {
+	assert := assert.New(t)
+
+	testDB, _, cleanup, err := digikamtestresources.PrepareBasicDB()
+	assert.Nil(err)
+	defer cleanup()
+
+	db, err := NewDigikamSqliteDatabase(testDB)
+	assert.Nil(err)
+	defer func() {
+		err = db.Close()
+		assert.Nil(err)
+	}()
+
+	ctx := context.Background()
+	actAlbums, err := db.Albums(ctx)
+	assert.Nil(err)
+
+	expAlbums := []types.Album{
+		{
+			Path: []string{"photos"},
+		},
+	}
+
+	assert.ElementsMatch(actAlbums, expAlbums)
+}
+
+func TestDigikamSqliteDatabase_Albums_NullLabel(t *testing.T) {
+	assert := assert.New(t)
+
+	testDB, libraryRoot, cleanup, err := digikamtestresources.PrepareBasicDB()
+	assert.Nil(err)
+	defer cleanup()
+
+	// The label of an album root is optional in digiKam.
+	writer, err := sql.Open("sqlite3", "file:"+testDB)
+	assert.Nil(err)
+	_, err = writer.Exec(`UPDATE AlbumRoots SET label = NULL WHERE id = 1`)
+	assert.Nil(err)
+	assert.Nil(writer.Close())
+
+	photoDB, err := NewDigikamSqliteDatabase(testDB)
+	assert.Nil(err)
+	defer func() {
+		err = photoDB.Close()
+		assert.Nil(err)
+	}()
+
+	ctx := context.Background()
+	albums, err := photoDB.Albums(ctx)
+	assert.Nil(err)
+	assert.Empty(albums)
+
+	// The photos are still there, they just aren't in any album.
+	metadata, err := photoDB.PhotoMetadata(ctx, types.Photo{Path: libraryRoot + "/album2/DSC_0196.jpg"})
+	assert.Nil(err)
+	assert.Equal(types.Album{}, metadata.Album)
+
+	groups, err := photoDB.PhotoGroups(ctx, types.Query{Selector: types.And{}})
+	assert.Nil(err)
+	assert.Empty(groups.Albums)
+	assert.NotEmpty(groups.Cameras)
+}
+
+func TestDigikamSqliteDatabase_ChildAlbums(t *testing.T) {
+	assert := assert.New(t)
+
+	testDB, _, cleanup, err := digikamtestresources.PrepareBasicDB()
+	assert.Nil(err)
+	defer cleanup()
+
+	db, err := NewDigikamSqliteDatabase(testDB)
+	assert.Nil(err)
+	defer func() {
+		err = db.Close()
+		assert.Nil(err)
+	}()
+
+	ctx := context.Background()
+	actAlbums, err := db.ChildAlbums(ctx, types.Album{Path: []string{"photos"}})
+	assert.Nil(err)
+
+	expAlbums := []types.Album{
+		{
+			Path: []string{"photos", "album1"},
+		},
+		{
+			Path: []string{"photos", "album2"},
+		},
+	}
+
+	assert.ElementsMatch(actAlbums, expAlbums)
+
+	actAlbums, err = db.ChildAlbums(ctx, types.Album{Path: []string{"photos", "album1"}})
+	assert.Nil(err)
+	assert.Empty(actAlbums)
+}
+
+func TestDigikamSqliteDatabase_Photos_basic_album(t *testing.T) {
+	assert := assert.New(t)
+
+	testDB, libraryRoot, cleanup, err := digikamtestresources.PrepareBasicDB()
+	assert.Nil(err)
+	defer cleanup()
+
+	db, err := NewDigikamSqliteDatabase(testDB)
+	assert.Nil(err)
+	defer func() {
+		err = db.Close()
+		assert.Nil(err)
+	}()
+
+	selector := types.InAlbum{
+		Album: types.Album{
+			Path: []string{"photos", "album2"},
+		},
+	}
+
+	q := types.Query{
+		Selector: selector,
+	}
+
+	ctx := context.Background()
+	actPhotos, err := db.Photos(ctx, q)
+	assert.Nil(err)
+
+	expPhotos := []types.Photo{
+		{
+			Path: libraryRoot + "/album2/DSC_0196.jpg",
+			ID:   "d5b701b4043c51007430119971b17ae2",
+		},
+		{
+			Path: libraryRoot + "/album2/DSC_0340_BW.jpg",
+			ID:   "17db9d693f682a894fb0ff538dccb972",
+		},
+		{
+			Path: libraryRoot + "/album2/DSC_6603.jpg",
+			ID:   "0048360c4b329c9b14925fe2db2a7b34",
+		},
+	}
+
+	assert.ElementsMatch(actPhotos, expPhotos)
+
+	// The album root itself doesn't directly contain any photos
+	q = types.Query{
+		Selector: types.InAlbum{Album: types.Album{Path: []string{"photos"}}},
+	}
+	actPhotos, err = db.Photos(ctx, q)
+	assert.Nil(err)
+	assert.Empty(actPhotos)
+}
+
+func TestDigikamSqliteDatabase_Cameras(t *testing.T) {
+	assert := assert.New(t)
+
+	testDB, _, cleanup, err := digikamtestresources.PrepareBasicDB()
+	assert.Nil(err)
+	defer cleanup()
+
+	db, err := NewDigikamSqliteDatabase(testDB)
+	assert.Nil(err)
+	defer func() {
+		err = db.Close()
+		assert.Nil(err)
+	}()
+
+	ctx := context.Background()
+	actCameras, err := db.Cameras(ctx)
+	assert.Nil(err)
+
+	expCameras := []types.Camera{
+		{
+			Make:  "NIKON CORPORATION",
+			Model: "NIKON D5500",
+		},
+	}
+
+	assert.ElementsMatch(actCameras, expCameras)
+}
+
+func TestDigikamSqliteDatabase_Lenses(t *testing.T) {
+	assert := assert.New(t)
+
+	testDB, _, cleanup, err := digikamtestresources.PrepareBasicDB()
+	assert.Nil(err)
+	defer cleanup()
+
+	db, err := NewDigikamSqliteDatabase(testDB)
+	assert.Nil(err)
+	defer func() {
+		err = db.Close()
+		assert.Nil(err)
+	}()
+
+	ctx := context.Background()
+	actLenses, err := db.Lenses(ctx)
+	assert.Nil(err)
+
+	expLenses := []string{
+		"Sigma 18-250mm F3.5-6.3 DC OS Macro HSM",
+		"Tokina atx-i 11-16mm F2.8 CF",
+	}
+
+	assert.ElementsMatch(actLenses, expLenses)
+}
+
+func TestDigikamSqliteDatabase_Photos_basic_camera_and_lens(t *testing.T) {
+	assert := assert.New(t)
+
+	testDB, libraryRoot, cleanup, err := digikamtestresources.PrepareBasicDB()
+	assert.Nil(err)
+	defer cleanup()
+
+	db, err := NewDigikamSqliteDatabase(testDB)
+	assert.Nil(err)
+	defer func() {
+		err = db.Close()
+		assert.Nil(err)
+	}()
+
+	ctx := context.Background()
+
+	// The photos in album2 don't have any camera metadata so only the photos
+	// in album1 should be found.
+	q := types.Query{
+		Selector: types.HasCamera{
+			Camera: types.Camera{Make: "NIKON CORPORATION", Model: "NIKON D5500"},
+		},
+	}
+	actPhotos, err := db.Photos(ctx, q)
+	assert.Nil(err)
+
+	expPhotos := []types.Photo{
+		{Path: libraryRoot + "/album1/GRAND_00626.jpg", ID: "35f0ac735f2e0f585cac5b918bf98bf3"},
+		{Path: libraryRoot + "/album1/GRAND_00896.jpg", ID: "de7303f2c490dc1b3fe23b0e17277542"},
+		{Path: libraryRoot + "/album1/GRAND_01471.jpg", ID: "8c91175a9a7cac20d821835e92091154"},
+		{Path: libraryRoot + "/album1/GRAND_02763.jpg", ID: "3ca473635db0f321144be7fd8774deb4"},
+		{Path: libraryRoot + "/album1/GRAND_03331.jpg", ID: "fa1f19e1bc9216e68689acd11044b0ed"},
+		{Path: libraryRoot + "/album1/GRAND_03476.jpg", ID: "f5e76142783d0c7466b4bcc8fcc9afff"},
+	}
+	assert.ElementsMatch(actPhotos, expPhotos)
+
+	q = types.Query{
+		Selector: types.HasLens{Lens: "Tokina atx-i 11-16mm F2.8 CF"},
+	}
+	actPhotos, err = db.Photos(ctx, q)
+	assert.Nil(err)
+
+	expPhotos = []types.Photo{
+		{Path: libraryRoot + "/album1/GRAND_03331.jpg", ID: "fa1f19e1bc9216e68689acd11044b0ed"},
+		{Path: libraryRoot + "/album1/GRAND_03476.jpg", ID: "f5e76142783d0c7466b4bcc8fcc9afff"},
+	}
+	assert.ElementsMatch(actPhotos, expPhotos)
+}
+
+func TestDigikamSqliteDatabase_TakenYears(t *testing.T) {
+	assert := assert.New(t)
+
+	testDB, _, cleanup, err := digikamtestresources.PrepareBasicDB()
+	assert.Nil(err)
+	defer cleanup()
+
+	db, err := NewDigikamSqliteDatabase(testDB)
+	assert.Nil(err)
+	defer func() {
+		err = db.Close()
+		assert.Nil(err)
+	}()
+
+	ctx := context.Background()
+	actYears, err := db.TakenYears(ctx, types.Query{Selector: types.TakenOn{Month: time.July, Day: 10}})
+	assert.Nil(err)
+	assert.ElementsMatch(actYears, []int{2022})
+
+	actYears, err = db.TakenYears(ctx, types.Query{Selector: types.TakenOn{Month: time.July, Day: 9}})
+	assert.Nil(err)
+	assert.Empty(actYears)
+}
+
+func TestDigikamSqliteDatabase_Photos_basic_taken_on(t *testing.T) {
+	assert := assert.New(t)
+
+	testDB, libraryRoot, cleanup, err := digikamtestresources.PrepareBasicDB()
+	assert.Nil(err)
+	defer cleanup()
+
+	db, err := NewDigikamSqliteDatabase(testDB)
+	assert.Nil(err)
+	defer func() {
+		err = db.Close()
+		assert.Nil(err)
+	}()
+
+	ctx := context.Background()
+
+	q := types.Query{
+		Selector: types.TakenOn{Year: 2022, Month: time.July, Day: 10},
+	}
+	actPhotos, err := db.Photos(ctx, q)
+	assert.Nil(err)
+
+	expPhotos := []types.Photo{
+		{Path: libraryRoot + "/album1/GRAND_00626.jpg", ID: "35f0ac735f2e0f585cac5b918bf98bf3"},
+		{Path: libraryRoot + "/album1/GRAND_00896.jpg", ID: "de7303f2c490dc1b3fe23b0e17277542"},
+	}
+	assert.ElementsMatch(actPhotos, expPhotos)
+
+	// Zero parts of the date should match anything
+	q = types.Query{
+		Selector: types.TakenOn{Month: time.November},
+	}
+	actPhotos, err = db.Photos(ctx, q)
+	assert.Nil(err)
+
+	expPhotos = []types.Photo{
+		{Path: libraryRoot + "/album2/DSC_0196.jpg", ID: "d5b701b4043c51007430119971b17ae2"},
+		{Path: libraryRoot + "/album2/DSC_0340_BW.jpg", ID: "17db9d693f682a894fb0ff538dccb972"},
+		{Path: libraryRoot + "/album2/DSC_6603.jpg", ID: "0048360c4b329c9b14925fe2db2a7b34"},
+	}
+	assert.ElementsMatch(actPhotos, expPhotos)
+
+	q = types.Query{
+		Selector: types.TakenOn{Month: 13},
+	}
+	_, err = db.Photos(ctx, q)
+	assert.Error(err)
+}
+
 func TestDigikamSqliteDatabase_Photos_basic_tag(t *testing.T) {
 	assert := assert.New(t)
 
@@ -186,6 +567,44 @@ func TestDigikamSqliteDatabase_Photos_basic_tag(t *testing.T) {
 	assert.ElementsMatch(actPhotos, expPhotos)
 }
 
+func TestDigikamSqliteDatabase_StreamPhotos(t *testing.T) {
+	assert := assert.New(t)
+
+	testDB, _, cleanup, err := digikamtestresources.PrepareBasicDB()
+	assert.Nil(err)
+	defer cleanup()
+
+	db, err := NewDigikamSqliteDatabase(testDB)
+	assert.Nil(err)
+	defer func() {
+		err = db.Close()
+		assert.Nil(err)
+	}()
+
+	ctx := context.Background()
+	q := types.Query{Selector: types.HasTag{Tag: types.Tag{Path: []string{"activity", "skiing"}}}}
+	expPhotos, err := db.Photos(ctx, q)
+	assert.Nil(err)
+
+	var actPhotos []types.Photo
+	err = db.StreamPhotos(ctx, q, func(p types.Photo) error {
+		actPhotos = append(actPhotos, p)
+		return nil
+	})
+	assert.Nil(err)
+	assert.Equal(expPhotos, actPhotos)
+
+	// Streaming stops at the first error.
+	stop := errors.New("stop")
+	calls := 0
+	err = db.StreamPhotos(ctx, q, func(p types.Photo) error {
+		calls++
+		return stop
+	})
+	assert.ErrorIs(err, stop)
+	assert.Equal(1, calls)
+}
+
 func TestDigikamSqliteDatabase_Photos_basic_rating(t *testing.T) {
 	assert := assert.New(t)
 
@@ -374,3 +793,201 @@ func TestDigikamSqliteDatabase_Photos_set_operations(t *testing.T) {
 		watersportsNone,
 	})
 }
+
+func TestDigikamSqliteDatabase_Version(t *testing.T) {
+	assert := assert.New(t)
+
+	testDB, _, cleanup, err := digikamtestresources.PrepareBasicDB()
+	assert.Nil(err)
+	defer cleanup()
+
+	db, err := NewDigikamSqliteDatabase(testDB)
+	assert.Nil(err)
+	defer func() {
+		err = db.Close()
+		assert.Nil(err)
+	}()
+
+	ctx := context.Background()
+	before, err := db.Version(ctx)
+	assert.Nil(err)
+
+	again, err := db.Version(ctx)
+	assert.Nil(err)
+	assert.Equal(before, again)
+
+	// Simulate digiKam modifying the database by writing to it from another
+	// connection.
+	writer, err := sql.Open("sqlite3", "file:"+testDB)
+	assert.Nil(err)
+	_, err = writer.Exec(`UPDATE Tags SET name = "renamed" WHERE name = "kayaking"`)
+	assert.Nil(err)
+	assert.Nil(writer.Close())
+
+	after, err := db.Version(ctx)
+	assert.Nil(err)
+	assert.NotEqual(before, after)
+}
+
+func TestDigikamSqliteDatabase_CloseAfterVersionConnFails(t *testing.T) {
+	assert := assert.New(t)
+
+	testDB, _, cleanup, err := digikamtestresources.PrepareBasicDB()
+	assert.Nil(err)
+	defer cleanup()
+
+	photoDB, err := NewDigikamSqliteDatabase(testDB)
+	assert.Nil(err)
+
+	_, err = photoDB.Version(context.Background())
+	assert.Nil(err)
+
+	// Closing the version connection a second time fails, but the DB should
+	// still be closed.
+	assert.Nil(photoDB.versionConn.Close())
+	err = photoDB.Close()
+	assert.ErrorIs(err, sql.ErrConnDone)
+	assert.ErrorContains(photoDB.db.Ping(), "database is closed")
+}
+
+func TestDigikamSqliteDatabase_Watch(t *testing.T) {
+	assert := assert.New(t)
+
+	testDB, _, cleanup, err := digikamtestresources.PrepareBasicDB()
+	assert.Nil(err)
+	defer cleanup()
+
+	photoDB, err := NewDigikamSqliteDatabase(testDB)
+	assert.Nil(err)
+	defer func() {
+		err = photoDB.Close()
+		assert.Nil(err)
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes, err := photoDB.Watch(ctx, 10*time.Millisecond)
+	assert.Nil(err)
+
+	writer, err := sql.Open("sqlite3", "file:"+testDB)
+	assert.Nil(err)
+	defer func() {
+		assert.Nil(writer.Close())
+	}()
+
+	nextEvent := func() db.ChangeEvent {
+		select {
+		case e := <-changes:
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for change event")
+			return db.ChangeEvent{}
+		}
+	}
+
+	_, err = writer.Exec(`UPDATE Tags SET name = "renamed" WHERE name = "kayaking"`)
+	assert.Nil(err)
+	assert.Equal(db.ChangeEvent{Scope: db.TagsChanged}, nextEvent())
+
+	_, err = writer.Exec(`UPDATE ImageInformation SET rating = rating - 1 WHERE imageid = 1`)
+	assert.Nil(err)
+	assert.Equal(db.ChangeEvent{Scope: db.PhotoMetadataChanged}, nextEvent())
+
+	// Swapping the ratings of two photos doesn't change how many photos have
+	// each rating, but it is still a change.
+	var low, lowRating, high, highRating int64
+	err = writer.QueryRow(`SELECT l.imageid, l.rating, h.imageid, h.rating
+		FROM (SELECT imageid, rating FROM ImageInformation ORDER BY rating, imageid LIMIT 1) l,
+		(SELECT imageid, rating FROM ImageInformation ORDER BY rating DESC, imageid LIMIT 1) h`).Scan(&low, &lowRating, &high, &highRating)
+	assert.Nil(err)
+	assert.NotEqual(lowRating, highRating)
+	tx, err := writer.Begin()
+	assert.Nil(err)
+	_, err = tx.Exec(`UPDATE ImageInformation SET rating = ? WHERE imageid = ?`, highRating, low)
+	assert.Nil(err)
+	_, err = tx.Exec(`UPDATE ImageInformation SET rating = ? WHERE imageid = ?`, lowRating, high)
+	assert.Nil(err)
+	assert.Nil(tx.Commit())
+	assert.Equal(db.ChangeEvent{Scope: db.PhotoMetadataChanged}, nextEvent())
+
+	_, err = writer.Exec(`UPDATE Images SET album = 2 WHERE id = 9`)
+	assert.Nil(err)
+	assert.Equal(db.ChangeEvent{Scope: db.PhotosChanged}, nextEvent())
+
+	cancel()
+	for range changes {
+	}
+}
+
+func TestDigikamSqliteDatabase_PhotoMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	testDB, libraryRoot, cleanup, err := digikamtestresources.PrepareBasicDB()
+	assert.Nil(err)
+	defer cleanup()
+
+	photoDB, err := NewDigikamSqliteDatabase(testDB)
+	assert.Nil(err)
+	defer func() {
+		err = photoDB.Close()
+		assert.Nil(err)
+	}()
+
+	writer, err := sql.Open("sqlite3", "file:"+testDB)
+	assert.Nil(err)
+	defer func() {
+		assert.Nil(writer.Close())
+	}()
+	_, err = writer.Exec(`INSERT INTO ImageComments (imageid, type, language, comment) VALUES
+		(7, 1, 'de-DE', 'Skifahren'),
+		(7, 1, 'x-default', 'Skiing'),
+		(7, 3, 'x-default', 'A title')`)
+	assert.Nil(err)
+
+	ctx := context.Background()
+	metadata, err := photoDB.PhotoMetadata(ctx, types.Photo{Path: libraryRoot + "/album2/DSC_0196.jpg", ID: "d5b701b4043c51007430119971b17ae2"})
+	assert.Nil(err)
+
+	assert.ElementsMatch([]types.Tag{
+		{Path: []string{"activity"}},
+		{Path: []string{"activity", "skiing"}},
+		{Path: []string{"_Digikam_Internal_Tags_", "Pick Label None"}},
+		{Path: []string{"_Digikam_Internal_Tags_", "Color Label Red"}},
+	}, metadata.Tags)
+	assert.Nil(metadata.Rating)
+	assert.Equal("Skiing", metadata.Caption)
+	assert.Equal(time.Date(2022, 11, 12, 9, 53, 57, 326000000, time.Local), metadata.Taken)
+	assert.Equal(types.Album{Path: []string{"photos", "album2"}}, metadata.Album)
+	assert.Equal(types.Camera{}, metadata.Camera)
+	assert.Equal("", metadata.Lens)
+
+	grand := types.Photo{Path: libraryRoot + "/album1/GRAND_00626.jpg"}
+	metadata, err = photoDB.PhotoMetadata(ctx, grand)
+	assert.Nil(err)
+	assert.NotNil(metadata.Rating)
+	assert.Equal("", metadata.Caption)
+	assert.Equal(types.Album{Path: []string{"photos", "album1"}}, metadata.Album)
+	assert.Equal(types.Camera{Make: "NIKON CORPORATION", Model: "NIKON D5500"}, metadata.Camera)
+	assert.Equal("Sigma 18-250mm F3.5-6.3 DC OS Macro HSM", metadata.Lens)
+
+	_, err = photoDB.PhotoMetadata(ctx, types.Photo{Path: libraryRoot + "/album1/doesNotExist.jpg"})
+	assert.ErrorIs(err, db.ErrNotFound)
+
+	// Getting the metadata of many photos at once gets the same metadata as
+	// getting it one at a time.
+	photos, err := photoDB.Photos(ctx, types.Query{Selector: types.And{}})
+	assert.Nil(err)
+	photos = append(photos, grand, types.Photo{Path: "/moved/DSC_0196.jpg", ID: "d5b701b4043c51007430119971b17ae2"})
+	batch, err := photoDB.PhotosMetadata(ctx, photos)
+	assert.Nil(err)
+	assert.Len(batch, len(photos))
+	for i, p := range photos {
+		metadata, err := photoDB.PhotoMetadata(ctx, p)
+		assert.Nil(err)
+		assert.ElementsMatch(metadata.Tags, batch[i].Tags, p.Path)
+		metadata.Tags = batch[i].Tags
+		assert.Equal(metadata, batch[i], p.Path)
+	}
+
+	_, err = photoDB.PhotosMetadata(ctx, []types.Photo{grand, {Path: libraryRoot + "/album1/doesNotExist.jpg"}})
+	assert.ErrorIs(err, db.ErrNotFound)
+}
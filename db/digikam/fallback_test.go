@@ -0,0 +1,97 @@
+package digikam
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/anitschke/photo-db-fs/db"
+	digikamtestresources "github.com/anitschke/photo-db-fs/test-resources/digikam"
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// limitedDB is a digiKam DB that claims to only support some kinds of
+// selectors, so that a FallbackDB has to evaluate the rest in memory.
+type limitedDB struct {
+	*DigikamSQLDatabase
+	t     *testing.T
+	kinds []types.SelectorKind
+}
+
+var _ = (db.CapabilityReporter)(limitedDB{})
+
+func (l limitedDB) Capabilities() []types.SelectorKind {
+	return l.kinds
+}
+
+func (l limitedDB) PhotoMetadata(ctx context.Context, photo types.Photo) (types.PhotoMetadata, error) {
+	l.t.Errorf("metadata of %q should have been fetched in a batch", photo.Path)
+	return l.DigikamSQLDatabase.PhotoMetadata(ctx, photo)
+}
+
+// TestFallbackDB_MatchesDigikam checks that a FallbackDB around a digiKam DB
+// that only supports some kinds of selectors selects the same photos as the
+// digiKam DB does with all of them.
+func TestFallbackDB_MatchesDigikam(t *testing.T) {
+	testDB, _, cleanup, err := digikamtestresources.PrepareBasicDB()
+	assert.Nil(t, err)
+	defer cleanup()
+
+	photoDB, err := NewDigikamSqliteDatabase(testDB)
+	assert.Nil(t, err)
+	defer func() {
+		assert.Nil(t, photoDB.Close())
+	}()
+
+	ctx := context.Background()
+	selectors := testSelectors(t, ctx, photoDB)
+
+	capabilities := map[string][]types.SelectorKind{
+		"AlbumsOnly":   {types.InAlbumKind},
+		"NoAlbums":     {types.HasTagKind, types.HasCameraKind, types.AndKind, types.OrKind, types.DifferenceKind, types.HasRatingKind},
+		"NoCombinator": {types.HasTagKind, types.HasLensKind, types.InAlbumKind, types.TakenOnKind},
+	}
+	for name, kinds := range capabilities {
+		t.Run(name, func(t *testing.T) {
+			f := db.NewFallbackDB(limitedDB{DigikamSQLDatabase: photoDB, t: t, kinds: kinds})
+			assert.IsType(t, &db.FallbackDB{}, f)
+			counter := f.(db.Counter)
+
+			for _, s := range selectors {
+				q := types.Query{Selector: s}
+				name := fmt.Sprintf("%#v", s)
+
+				expPhotos, err := photoDB.Photos(ctx, q)
+				assert.Nil(t, err, name)
+				actPhotos, err := f.Photos(ctx, q)
+				assert.Nil(t, err, name)
+				assert.ElementsMatch(t, expPhotos, actPhotos, name)
+
+				expTags, err := photoDB.PhotoTags(ctx, q)
+				assert.Nil(t, err, name)
+				actTags, err := f.PhotoTags(ctx, q)
+				assert.Nil(t, err, name)
+				assert.ElementsMatch(t, expTags, actTags, name)
+
+				expYears, err := photoDB.TakenYears(ctx, q)
+				assert.Nil(t, err, name)
+				actYears, err := f.TakenYears(ctx, q)
+				assert.Nil(t, err, name)
+				assert.ElementsMatch(t, expYears, actYears, name)
+
+				expTagCounts, err := photoDB.TagCounts(ctx, q)
+				assert.Nil(t, err, name)
+				actTagCounts, err := counter.TagCounts(ctx, q)
+				assert.Nil(t, err, name)
+				assert.ElementsMatch(t, expTagCounts, actTagCounts, name)
+
+				expRatingCounts, err := photoDB.RatingCounts(ctx, q)
+				assert.Nil(t, err, name)
+				actRatingCounts, err := counter.RatingCounts(ctx, q)
+				assert.Nil(t, err, name)
+				assert.ElementsMatch(t, expRatingCounts, actRatingCounts, name)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package digikam
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/anitschke/photo-db-fs/db/memory"
+	digikamtestresources "github.com/anitschke/photo-db-fs/test-resources/digikam"
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryIndex loads every photo in the DB into a memory.Index.
+func memoryIndex(t *testing.T, ctx context.Context, photoDB *DigikamSQLDatabase) *memory.Index {
+	rows, err := photoDB.db.QueryContext(ctx, `
+SELECT r.specificPath, r.label, a.relativePath, i.name, i.uniqueHash, COALESCE(im.make, ''), COALESCE(im.model, ''), COALESCE(im.lens, '')
+FROM Images i
+JOIN Albums a ON i.album = a.id
+JOIN AlbumRoots r ON a.albumRoot = r.id
+LEFT JOIN ImageMetadata im ON im.imageid = i.id`)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer rows.Close()
+
+	var photos []memory.Photo
+	for rows.Next() {
+		var root, label, relativePath, name string
+		var p memory.Photo
+		err := rows.Scan(&root, &label, &relativePath, &name, &p.ID, &p.Camera.Make, &p.Camera.Model, &p.Lens)
+		assert.Nil(t, err)
+
+		p.Path = filepath.Join(root, relativePath, name)
+		p.Album.Path = []string{label}
+		if relativePath != "/" {
+			p.Album.Path = append(p.Album.Path, strings.Split(strings.TrimPrefix(relativePath, "/"), "/")...)
+		}
+		photos = append(photos, p)
+	}
+	assert.Nil(t, rows.Err())
+
+	for i := range photos {
+		metadata, err := photoDB.PhotoMetadata(ctx, photos[i].Photo)
+		assert.Nil(t, err)
+		photos[i].Tags = metadata.Tags
+		photos[i].Rating = metadata.Rating
+		photos[i].Taken = metadata.Taken
+	}
+	return memory.NewIndex(photos)
+}
+
+// allTags gets every tag in the DB.
+func allTags(t *testing.T, ctx context.Context, photoDB *DigikamSQLDatabase) []types.Tag {
+	tags, err := photoDB.RootTags(ctx)
+	assert.Nil(t, err)
+	for i := 0; i < len(tags); i++ {
+		children, err := photoDB.ChildrenTags(ctx, tags[i])
+		assert.Nil(t, err)
+		tags = append(tags, children...)
+	}
+	return tags
+}
+
+// allAlbums gets every album in the DB.
+func allAlbums(t *testing.T, ctx context.Context, photoDB *DigikamSQLDatabase) []types.Album {
+	albums, err := photoDB.Albums(ctx)
+	assert.Nil(t, err)
+	for i := 0; i < len(albums); i++ {
+		children, err := photoDB.ChildAlbums(ctx, albums[i])
+		assert.Nil(t, err)
+		albums = append(albums, children...)
+	}
+	return albums
+}
+
+// TestDigikamSqliteDatabase_MatchesMemory checks that the SQL we generate for
+// selectors selects the same photos as the in-memory evaluator, which serves as
+// the specification of what each selector means.
+func TestDigikamSqliteDatabase_MatchesMemory(t *testing.T) {
+	testDB, _, cleanup, err := digikamtestresources.PrepareBasicDB()
+	assert.Nil(t, err)
+	defer cleanup()
+
+	photoDB, err := NewDigikamSqliteDatabase(testDB)
+	assert.Nil(t, err)
+	defer func() {
+		assert.Nil(t, photoDB.Close())
+	}()
+
+	ctx := context.Background()
+	index := memoryIndex(t, ctx, photoDB)
+
+	for _, s := range testSelectors(t, ctx, photoDB) {
+		q := types.Query{Selector: s}
+		name := fmt.Sprintf("%#v", s)
+
+		expPhotos, err := index.Photos(q)
+		assert.Nil(t, err, name)
+		actPhotos, err := photoDB.Photos(ctx, q)
+		assert.Nil(t, err, name)
+		assert.ElementsMatch(t, expPhotos, actPhotos, name)
+
+		expTags, err := index.PhotoTags(q)
+		assert.Nil(t, err, name)
+		actTags, err := photoDB.PhotoTags(ctx, q)
+		assert.Nil(t, err, name)
+		assert.ElementsMatch(t, expTags, actTags, name)
+
+		expYears, err := index.TakenYears(q)
+		assert.Nil(t, err, name)
+		actYears, err := photoDB.TakenYears(ctx, q)
+		assert.Nil(t, err, name)
+		assert.ElementsMatch(t, expYears, actYears, name)
+
+		expTagCounts, err := index.TagCounts(q)
+		assert.Nil(t, err, name)
+		actTagCounts, err := photoDB.TagCounts(ctx, q)
+		assert.Nil(t, err, name)
+		assert.ElementsMatch(t, expTagCounts, actTagCounts, name)
+
+		expRatingCounts, err := index.RatingCounts(q)
+		assert.Nil(t, err, name)
+		actRatingCounts, err := photoDB.RatingCounts(ctx, q)
+		assert.Nil(t, err, name)
+		assert.ElementsMatch(t, expRatingCounts, actRatingCounts, name)
+
+		expGroups, err := index.PhotoGroups(q)
+		assert.Nil(t, err, name)
+		actGroups, err := photoDB.PhotoGroups(ctx, q)
+		assert.Nil(t, err, name)
+		assert.ElementsMatch(t, expGroups.Albums, actGroups.Albums, name)
+		assert.ElementsMatch(t, expGroups.Cameras, actGroups.Cameras, name)
+		assert.ElementsMatch(t, expGroups.Lenses, actGroups.Lenses, name)
+	}
+}
+
+// testSelectors gets a variety of selectors made up of every kind of leaf
+// selector that selects something in the DB.
+func testSelectors(t *testing.T, ctx context.Context, photoDB *DigikamSQLDatabase) []types.Selector {
+	var leaves []types.Selector
+	for _, tag := range allTags(t, ctx, photoDB) {
+		leaves = append(leaves, types.HasTag{Tag: tag})
+	}
+	for _, album := range allAlbums(t, ctx, photoDB) {
+		leaves = append(leaves, types.InAlbum{Album: album})
+	}
+	cameras, err := photoDB.Cameras(ctx)
+	assert.Nil(t, err)
+	for _, camera := range cameras {
+		leaves = append(leaves, types.HasCamera{Camera: camera})
+	}
+	lenses, err := photoDB.Lenses(ctx)
+	assert.Nil(t, err)
+	for _, lens := range lenses {
+		leaves = append(leaves, types.HasLens{Lens: lens})
+	}
+	for _, rating := range photoDB.Ratings() {
+		for _, operator := range []types.RelationalOperator{types.Equal, types.NotEqual, types.LessThan, types.LessThanOrEqual, types.GreaterThan, types.GreaterThanOrEqual} {
+			leaves = append(leaves, types.HasRating{Operator: operator, Rating: rating})
+		}
+	}
+	leaves = append(leaves,
+		types.TakenOn{Year: 2022},
+		types.TakenOn{Year: 2021},
+		types.TakenOn{Month: 7},
+		types.TakenOn{Month: 7, Day: 10},
+		types.TakenOn{Year: 2022, Month: 11, Day: 12},
+	)
+
+	selectors := append([]types.Selector{}, leaves...)
+	selectors = append(selectors, types.And{}, types.Or{}, types.And{Operands: []types.Selector{leaves[0]}})
+	for i, a := range leaves {
+		b := leaves[(i*7+3)%len(leaves)]
+		c := leaves[(i*13+5)%len(leaves)]
+		selectors = append(selectors,
+			types.And{Operands: []types.Selector{a, b}},
+			types.Or{Operands: []types.Selector{a, b, c}},
+			types.Difference{Starting: a, Excluding: b},
+			types.Difference{Starting: types.Or{Operands: []types.Selector{a, c}}, Excluding: types.And{Operands: []types.Selector{b, c}}},
+		)
+	}
+	return selectors
+}
@@ -3,33 +3,33 @@ package digikam
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/anitschke/photo-db-fs/types"
 )
 
-const photoInfoCTEName = "image_info"
-
 // cSpell:words imageid tagid
 
-// photoInfoCTE is a SQL common table expression (CTE) that builds all
-// aspects/properties of an image that we might need to query based off of into
-// a single row/table so we can build simple queries off of that single row.
-const photoInfoCTE = `
-WITH ` + photoInfoCTEName + ` as (
-SELECT r.specificPath AS root, a.relativePath AS path, i.name AS name, i.uniqueHash AS uniqueHash, t.id AS tagId, ii.rating AS rating 
-FROM Images i 
-LEFT JOIN ImageTags it ON it.imageid = i.id 
-LEFT JOIN ImageInformation ii ON ii.imageid = i.id 
-LEFT JOIN Tags t ON it.tagid = t.id
-LEFT JOIN Albums a ON i.album = a.id 
-LEFT JOIN AlbumRoots r ON albumRoot = r.id 
-WHERE root != '' AND path != ''
-)
-`
+// selectedPhotosCTEName is the name of the common table expression (CTE) that
+// holds the photos selected by a query. It has a row for each selected photo
+// with the photoProperties of the photo.
+const selectedPhotosCTEName = "selected_photos"
 
 // photoProperties are all the properties of a photo that we need in order to
 // construct a types.Photo object from our database.
-const photoProperties = "root, path, name, uniqueHash"
+const photoProperties = "imageId, root, path, name, uniqueHash"
+
+// selectPhotos builds a query that selects the photoProperties of the images
+// that match the where condition, which can refer to the image as i. Images
+// that aren't in an album are never selected since digiKam keeps them around
+// for a while after they have been deleted.
+func selectPhotos(where string) string {
+	return `SELECT i.id AS imageId, r.specificPath AS root, a.relativePath AS path, i.name AS name, i.uniqueHash AS uniqueHash
+FROM Images i
+JOIN Albums a ON i.album = a.id
+JOIN AlbumRoots r ON a.albumRoot = r.id
+WHERE r.specificPath != '' AND a.relativePath != '' AND ` + where
+}
 
 // visitResult keeps track of the result of visiting a selector.
 //
@@ -61,29 +61,36 @@ func selectorAccept(s types.Selector, v types.SelectorVisitor) (visitResult, err
 
 // selectVisitor is our implementation of a types.SelectorVisitor. The general
 // idea is that it is able to recursively visit Selectors down our selector
-// hierarchy in order to build up a the string of a query that we can use for
-// searching for that selector.
-type selectorVisitor struct{}
+// hierarchy in order to build up a SQL condition that is true for the images
+// selected by that selector. The image is referred to as i in the condition.
+//
+// Each selector is turned into an IN condition on the tables that hold the
+// property being selected on, and set operations are turned into AND, OR and
+// NOT of those conditions. This lets sqlite use the indexes on those tables to
+// find the images, rather than building up every property of every image and
+// then doing set operations on the results.
+type selectorVisitor struct {
+	// tags is used to look up the IDs of tags so they can be used directly in
+	// the query.
+	tags *tagTree
+}
 
 var _ = (types.SelectorVisitor)(selectorVisitor{})
 
 func (v selectorVisitor) VisitHasTag(s types.HasTag) (interface{}, error) {
-	// First we will use our helper that is able to generate a query that can
-	// get us the tagID for a tag in a hierarchy based on it's path
-	tagSubQuery, parameters, err := tagIDSubquery(s.Tag)
-	if err != nil {
-		return nil, err
+	if len(s.Tag.Path) == 0 {
+		return nil, fmt.Errorf("can't select photos with a tag with an empty path")
 	}
 
-	// Then we can use that to select the photo row that has that tagID
-	selectStatement := "SELECT " + photoProperties + " FROM " + photoInfoCTEName + " WHERE tagId = " + tagSubQuery
-
-	result := visitResult{
-		query:      selectStatement,
-		parameters: parameters,
+	// A tag that doesn't exist isn't applied to any photos.
+	tagID, ok := v.tags.id(s.Tag)
+	if !ok {
+		return visitResult{query: "0"}, nil
 	}
 
-	return result, nil
+	return visitResult{
+		query: "i.id IN (SELECT imageid FROM ImageTags WHERE tagid = " + strconv.FormatInt(tagID, 10) + ")",
+	}, nil
 }
 
 func (v selectorVisitor) VisitHasRating(s types.HasRating) (interface{}, error) {
@@ -95,56 +102,110 @@ func (v selectorVisitor) VisitHasRating(s types.HasRating) (interface{}, error)
 		return nil, fmt.Errorf("rating must be a whole number")
 	}
 
+	// digiKam uses a rating of -1 for photos that haven't been rated, these
+	// don't have a rating so they shouldn't be selected by any rating.
 	return visitResult{
-		query: "SELECT " + photoProperties + " FROM " + photoInfoCTEName + " WHERE rating " + string(s.Operator) + " " + strconv.Itoa(int(s.Rating)),
+		query: "i.id IN (SELECT imageid FROM ImageInformation WHERE rating >= 0 AND rating " + string(s.Operator) + " " + strconv.Itoa(int(s.Rating)) + ")",
 	}, nil
 }
 
+func (v selectorVisitor) VisitInAlbum(s types.InAlbum) (interface{}, error) {
+	albumSubQuery, parameters, err := albumIDSubquery(s.Album)
+	if err != nil {
+		return nil, err
+	}
+
+	return visitResult{
+		query:      "i.album IN " + albumSubQuery,
+		parameters: parameters,
+	}, nil
+}
+
+func (v selectorVisitor) VisitHasCamera(s types.HasCamera) (interface{}, error) {
+	return visitResult{
+		query:      imageMetadataCondition("COALESCE(make, '') = ? AND COALESCE(model, '') = ?", s.Camera.Make == "" && s.Camera.Model == ""),
+		parameters: []any{s.Camera.Make, s.Camera.Model},
+	}, nil
+}
+
+func (v selectorVisitor) VisitHasLens(s types.HasLens) (interface{}, error) {
+	return visitResult{
+		query:      imageMetadataCondition("COALESCE(lens, '') = ?", s.Lens == ""),
+		parameters: []any{s.Lens},
+	}, nil
+}
+
+// imageMetadataCondition builds a condition for images whose ImageMetadata
+// matches the where condition. Images without any ImageMetadata have empty
+// metadata, so if the where condition matches empty metadata then those images
+// are selected too.
+func imageMetadataCondition(where string, matchesEmpty bool) string {
+	condition := "i.id IN (SELECT imageid FROM ImageMetadata WHERE " + where + ")"
+	if matchesEmpty {
+		condition = "(" + condition + " OR i.id NOT IN (SELECT imageid FROM ImageMetadata))"
+	}
+	return condition
+}
+
+func (v selectorVisitor) VisitTakenOn(s types.TakenOn) (interface{}, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Zero parts of the date match anything, so only add conditions for the
+	// parts of the date that were specified.
+	conditions := []string{"creationDate IS NOT NULL"}
+	parameters := make([]any, 0, 3)
+	if s.Year != 0 {
+		conditions = append(conditions, "CAST(strftime('%Y', creationDate) AS INTEGER) = ?")
+		parameters = append(parameters, s.Year)
+	}
+	if s.Month != 0 {
+		conditions = append(conditions, "CAST(strftime('%m', creationDate) AS INTEGER) = ?")
+		parameters = append(parameters, int(s.Month))
+	}
+	if s.Day != 0 {
+		conditions = append(conditions, "CAST(strftime('%d', creationDate) AS INTEGER) = ?")
+		parameters = append(parameters, s.Day)
+	}
+
+	return visitResult{
+		query:      "i.id IN (SELECT imageid FROM ImageInformation WHERE " + strings.Join(conditions, " AND ") + ")",
+		parameters: parameters,
+	}, nil
+}
+
+// VisitAnd selects every photo if there are no operands, the same as an empty
+// And is evaluated in memory.
 func (v selectorVisitor) VisitAnd(s types.And) (interface{}, error) {
-	return v.visitSetOperation("INTERSECT", s.Operands)
+	return v.visitSetOperation("AND", "1", s.Operands)
 }
 
+// VisitOr selects nothing if there are no operands.
 func (v selectorVisitor) VisitOr(s types.Or) (interface{}, error) {
-	return v.visitSetOperation("UNION", s.Operands)
+	return v.visitSetOperation("OR", "0", s.Operands)
 }
 
-func (v selectorVisitor) visitSetOperation(operator string, operands []types.Selector) (interface{}, error) {
-	// We will handle AND and OR by querying each subquery and then doing a set
-	// intersection or union of all the results. I am sure this is probably very
-	// inefficient but I am pretty new to SQL and I don't see another way to do
-	// this and support ANDing/ORing some things like multiple tags.
-
-	if len(operands) < 2 {
-		return nil, fmt.Errorf("set operation selectors require at least two operands")
+func (v selectorVisitor) visitSetOperation(operator string, empty string, operands []types.Selector) (interface{}, error) {
+	if len(operands) == 0 {
+		return visitResult{query: empty}, nil
 	}
 
-	var selector string
+	conditions := make([]string, 0, len(operands))
 	parameters := make([]any, 0)
-
-	lastElement := len(operands) - 1
-	for i := 0; i < lastElement; i++ {
-		subResult, err := selectorAccept(operands[i], v)
+	for _, op := range operands {
+		subResult, err := selectorAccept(op, v)
 		if err != nil {
 			return nil, fmt.Errorf("error visiting subselector: %w", err)
 		}
-		selector += subResult.query + "\n" + operator + "\n"
+		conditions = append(conditions, subResult.query)
 		parameters = append(parameters, subResult.parameters...)
 	}
-	subResult, err := selectorAccept(operands[lastElement], v)
-	if err != nil {
-		return nil, fmt.Errorf("error visiting subselector: %w", err)
-	}
-	selector += subResult.query
-	parameters = append(parameters, subResult.parameters...)
-
-	selector = wrapSetOperationSelector(selector)
 
-	result := visitResult{
-		query:      selector,
+	return visitResult{
+		query:      "(" + strings.Join(conditions, "\n"+operator+" ") + ")",
 		parameters: parameters,
-	}
-
-	return result, nil
+	}, nil
 }
 
 func (v selectorVisitor) VisitDifference(s types.Difference) (interface{}, error) {
@@ -158,54 +219,116 @@ func (v selectorVisitor) VisitDifference(s types.Difference) (interface{}, error
 		return nil, fmt.Errorf("error visiting excluding selector: %w", err)
 	}
 
-	selector := startingResult.query + "\nEXCEPT\n" + excludingResult.query
 	parameters := make([]any, len(startingResult.parameters), len(startingResult.parameters)+len(excludingResult.parameters))
 	copy(parameters, startingResult.parameters)
 	parameters = append(parameters, excludingResult.parameters...)
 
-	selector = wrapSetOperationSelector(selector)
-
-	result := visitResult{
-		query:      selector,
+	return visitResult{
+		query:      "(" + startingResult.query + "\nAND NOT " + excludingResult.query + ")",
 		parameters: parameters,
+	}, nil
+}
+
+// buildSelectedPhotosCTE builds the selected_photos CTE for the photos
+// selected by the query, without the leading WITH.
+func buildSelectedPhotosCTE(q types.Query, tags *tagTree) (string, []any, error) {
+	// First things first lets build up the condition for the images selected
+	// by the query. We do this with the selectorVisitor, recursively visiting
+	// Selectors down our selector hierarchy.
+	v := selectorVisitor{tags: tags}
+	visitResult, err := selectorAccept(q.Selector, v)
+	if err != nil {
+		return "", nil, fmt.Errorf("error building selector: %w", err)
+	}
+
+	cte := selectedPhotosCTEName + " AS (\n" + selectPhotos(visitResult.query) + "\n)"
+	return cte, visitResult.parameters, nil
+}
+
+// buildDigikamPhotoTagsQuery builds a query that finds the IDs of all of the
+// tags that are applied to at least one of the photos selected by the query.
+func buildDigikamPhotoTagsQuery(q types.Query, tags *tagTree) (string, []any, error) {
+	cte, parameters, err := buildSelectedPhotosCTE(q, tags)
+	if err != nil {
+		return "", nil, err
 	}
 
-	return result, nil
+	queryString := "WITH " + cte + "\nSELECT DISTINCT tagid FROM ImageTags WHERE imageid IN (SELECT imageId FROM " + selectedPhotosCTEName + ")"
+	return queryString, parameters, nil
 }
 
-func wrapSetOperationSelector(s string) string {
-	// To be more generic we allow nesting any arbitrary selectors inside each
-	// other. So we could need to nest other set operations inside of this one
-	// and keep the order of operations correct. This gets a little weird with
-	// sqlite because it doesn't let you do brackets. It seems the only
-	// workaround is to do a "SELECT * FROM ( the_set_operations )" to group
-	// them together.
-	//
-	// For more details see https://stackoverflow.com/a/10828913
-	selector := "SELECT *\nFROM (\n" + s + "\n)"
-	return selector
+// buildDigikamTagCountsQuery builds a query that counts the photos selected by
+// the query that each tag is applied to.
+func buildDigikamTagCountsQuery(q types.Query, tags *tagTree) (string, []any, error) {
+	cte, parameters, err := buildSelectedPhotosCTE(q, tags)
+	if err != nil {
+		return "", nil, err
+	}
+
+	queryString := "WITH " + cte + "\nSELECT tagid, COUNT(*) FROM ImageTags WHERE imageid IN (SELECT imageId FROM " + selectedPhotosCTEName + ") GROUP BY tagid"
+	return queryString, parameters, nil
 }
 
-func buildDigikamPhotoQuery(q types.Query) (string, []any, error) {
+// buildDigikamRatingCountsQuery builds a query that counts the photos selected
+// by the query that have each rating.
+func buildDigikamRatingCountsQuery(q types.Query, tags *tagTree) (string, []any, error) {
+	cte, parameters, err := buildSelectedPhotosCTE(q, tags)
+	if err != nil {
+		return "", nil, err
+	}
 
-	// First things first lets build up th part of the query that is specific to
-	// this query. We do this with the selectorVisitor, recursively visiting
-	// Selectors down our selector hierarchy in order to build up a the string
-	// of a query that we can use for searching for that selector.
-	v := selectorVisitor{}
-	visitResult, err := selectorAccept(q.Selector, v)
+	// Photos that haven't been rated have a rating of -1, see VisitHasRating.
+	queryString := "WITH " + cte + `
+SELECT rating, COUNT(*)
+FROM ImageInformation
+WHERE rating >= 0 AND imageid IN (SELECT imageId FROM ` + selectedPhotosCTEName + `)
+GROUP BY rating
+ORDER BY rating`
+	return queryString, parameters, nil
+}
+
+// buildDigikamPhotoGroupsQuery builds a query that finds the distinct
+// combinations of album, camera and lens of the photos selected by the query.
+func buildDigikamPhotoGroupsQuery(q types.Query, tags *tagTree) (string, []any, error) {
+	cte, parameters, err := buildSelectedPhotosCTE(q, tags)
 	if err != nil {
-		return "", nil, fmt.Errorf("error building selector: %w", err)
+		return "", nil, err
 	}
 
-	// And now we can build up the whole query
-	queryString :=
-		// We will start with the photo info CTE string which builds up rows
-		// that have everything we might want to query photos based off of.
-		photoInfoCTE + "\n" +
-			// Now we will add in the actual selector wrapped with a SELECT DISTINCT
-			// to ensure we don't have any duplicate photos
-			"SELECT DISTINCT * FROM(\n" + visitResult.query + "\n)"
+	queryString := "WITH " + cte + `
+SELECT DISTINCT r.label, a.relativePath, COALESCE(im.make, ''), COALESCE(im.model, ''), COALESCE(im.lens, '')
+FROM Images i
+JOIN Albums a ON i.album = a.id
+JOIN AlbumRoots r ON a.albumRoot = r.id
+LEFT JOIN ImageMetadata im ON im.imageid = i.id
+WHERE i.id IN (SELECT imageId FROM ` + selectedPhotosCTEName + `)`
+	return queryString, parameters, nil
+}
+
+// buildDigikamTakenYearsQuery builds a query that finds the distinct years in
+// which the photos selected by the query were taken.
+func buildDigikamTakenYearsQuery(q types.Query, tags *tagTree) (string, []any, error) {
+	cte, parameters, err := buildSelectedPhotosCTE(q, tags)
+	if err != nil {
+		return "", nil, err
+	}
+
+	queryString := "WITH " + cte + `
+SELECT DISTINCT CAST(strftime('%Y', ii.creationDate) AS INTEGER) AS year
+FROM ImageInformation ii
+WHERE ii.creationDate IS NOT NULL AND ii.imageid IN (SELECT imageId FROM ` + selectedPhotosCTEName + `)`
+
+	return queryString, parameters, nil
+}
+
+func buildDigikamPhotoQuery(q types.Query, tags *tagTree) (string, []any, error) {
+	cte, parameters, err := buildSelectedPhotosCTE(q, tags)
+	if err != nil {
+		return "", nil, err
+	}
 
-	return queryString, visitResult.parameters, nil
+	// Each image only has a single row in selected_photos so there is no need
+	// for a SELECT DISTINCT.
+	queryString := "WITH " + cte + "\nSELECT " + photoProperties + " FROM " + selectedPhotosCTEName
+	return queryString, parameters, nil
 }
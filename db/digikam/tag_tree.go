@@ -0,0 +1,171 @@
+package digikam
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/anitschke/photo-db-fs/utils"
+	"go.uber.org/zap"
+)
+
+// tagTree is an in-memory copy of the hierarchy of tags in the Tags table.
+//
+// Tags are looked up constantly, every directory of a tag needs to find the
+// tag and its children and every query with a tag needs the ID of the tag. So
+// rather than querying the Tags table each time we load it once and only
+// reload it when the database changes, see DigikamSQLDatabase.tagTree.
+type tagTree struct {
+	// ids maps the key of each tag, see tagKey, to the ID of the tag.
+	ids map[string]int64
+
+	// paths maps the ID of each tag to the path of the tag.
+	paths map[int64][]string
+
+	// children maps the ID of each tag to the IDs of its children, the root
+	// tags are the children of 0.
+	children map[int64][]int64
+}
+
+// loadTagTree loads the whole Tags table into a tagTree.
+func loadTagTree(ctx context.Context, q queryer) (*tagTree, error) {
+	rows, err := q.QueryContext(ctx, "SELECT id, pid, name FROM Tags ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer utils.CloseAndLogErrors(rows)
+
+	type tagRow struct {
+		pid  int64
+		name string
+	}
+	tagRows := make(map[int64]tagRow)
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		var r tagRow
+		if err := rows.Scan(&id, &r.pid, &r.name); err != nil {
+			return nil, err
+		}
+		tagRows[id] = r
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	t := &tagTree{
+		ids:      make(map[string]int64, len(ids)),
+		paths:    make(map[int64][]string, len(ids)),
+		children: make(map[int64][]int64),
+	}
+
+	// Tags can be moved to a new parent, so a parent doesn't necessarily have
+	// a lower ID than its children. That means we need to walk up the parents
+	// of each tag to build its path rather than building paths in order.
+	var path func(id int64, depth int) ([]string, error)
+	path = func(id int64, depth int) ([]string, error) {
+		if p, ok := t.paths[id]; ok {
+			return p, nil
+		}
+		if depth > len(tagRows) {
+			return nil, fmt.Errorf("tag with id %d is its own ancestor", id)
+		}
+		r, ok := tagRows[id]
+		if !ok {
+			return nil, fmt.Errorf("failed to find parent tag with id %d", id)
+		}
+
+		var p []string
+		if r.pid != 0 {
+			parentPath, err := path(r.pid, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			p = make([]string, len(parentPath), len(parentPath)+1)
+			copy(p, parentPath)
+		}
+		p = append(p, r.name)
+		t.paths[id] = p
+		return p, nil
+	}
+
+	for _, id := range ids {
+		p, err := path(id, 0)
+		if err != nil {
+			return nil, err
+		}
+		t.ids[tagKey(p)] = id
+		pid := tagRows[id].pid
+		t.children[pid] = append(t.children[pid], id)
+	}
+
+	zap.L().Debug("db loaded tag tree", zap.Int("tagCount", len(ids)))
+	return t, nil
+}
+
+// id gets the ID of the tag, it returns false if the tag doesn't exist.
+func (t *tagTree) id(tag types.Tag) (int64, bool) {
+	if len(tag.Path) == 0 {
+		return 0, false
+	}
+	id, ok := t.ids[tagKey(tag.Path)]
+	return id, ok
+}
+
+// tag gets the tag with the ID, it returns false if there is no such tag.
+func (t *tagTree) tag(id int64) (types.Tag, bool) {
+	p, ok := t.paths[id]
+	if !ok {
+		return types.Tag{}, false
+	}
+	return newTag(p), true
+}
+
+// childTags gets the child tags of the tag with the ID, or the root tags if
+// the ID is 0.
+func (t *tagTree) childTags(id int64) []types.Tag {
+	var tags []types.Tag
+	for _, c := range t.children[id] {
+		tags = append(tags, newTag(t.paths[c]))
+	}
+	return tags
+}
+
+// newTag creates a tag with a copy of the path so that callers can't modify
+// the paths stored in the tree.
+func newTag(path []string) types.Tag {
+	p := make([]string, len(path))
+	copy(p, path)
+	return types.Tag{Path: p}
+}
+
+func tagKey(path []string) string {
+	// Tag names can contain just about any character so we use a null
+	// character as a separator since it can't be part of a tag name.
+	return strings.Join(path, "\x00")
+}
+
+// tagTree gets the tag tree for the current version of the database, loading
+// it if the database has changed since the tag tree was last loaded.
+func (db *DigikamSQLDatabase) tagTree(ctx context.Context) (*tagTree, error) {
+	version, err := db.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	db.tagsMu.Lock()
+	defer db.tagsMu.Unlock()
+	if db.tags != nil && db.tagsVersion == version {
+		return db.tags, nil
+	}
+
+	tags, err := loadTagTree(ctx, db.db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tags: %w", err)
+	}
+	db.tags = tags
+	db.tagsVersion = version
+	return tags, nil
+}
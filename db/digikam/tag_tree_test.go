@@ -0,0 +1,70 @@
+package digikam
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	digikamtestresources "github.com/anitschke/photo-db-fs/test-resources/digikam"
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDigikamSqliteDatabase_TagTreeReloadsOnChange(t *testing.T) {
+	assert := assert.New(t)
+
+	testDB, _, cleanup, err := digikamtestresources.PrepareBasicDB()
+	assert.Nil(err)
+	defer cleanup()
+
+	photoDB, err := NewDigikamSqliteDatabase(testDB)
+	assert.Nil(err)
+	defer func() {
+		assert.Nil(photoDB.Close())
+	}()
+
+	ctx := context.Background()
+	watersports := types.Tag{Path: []string{"activity", "watersports"}}
+	kayaking := types.Tag{Path: []string{"activity", "watersports", "kayaking"}}
+	movedKayaking := types.Tag{Path: []string{"kayaking", "kayaking"}}
+
+	children, err := photoDB.ChildrenTags(ctx, watersports)
+	assert.Nil(err)
+	assert.Contains(children, kayaking)
+	photos, err := photoDB.Photos(ctx, types.Query{Selector: types.HasTag{Tag: kayaking}})
+	assert.Nil(err)
+	assert.Len(photos, 1)
+
+	// Modify the tags from another connection, the same way digiKam would.
+	// The kayaking tag is moved under a new tag, so it ends up with a lower ID
+	// than its parent.
+	other, err := sql.Open("sqlite3", "file:"+testDB)
+	assert.Nil(err)
+	defer other.Close()
+	result, err := other.Exec("INSERT INTO Tags (pid, name) VALUES (0, 'kayaking')")
+	assert.Nil(err)
+	parentID, err := result.LastInsertId()
+	assert.Nil(err)
+	_, err = other.Exec("UPDATE Tags SET pid = ? WHERE name = 'kayaking' AND pid != 0", parentID)
+	assert.Nil(err)
+
+	children, err = photoDB.ChildrenTags(ctx, watersports)
+	assert.Nil(err)
+	assert.NotContains(children, kayaking)
+
+	children, err = photoDB.ChildrenTags(ctx, types.Tag{Path: []string{"kayaking"}})
+	assert.Nil(err)
+	assert.Equal([]types.Tag{movedKayaking}, children)
+
+	photos, err = photoDB.Photos(ctx, types.Query{Selector: types.HasTag{Tag: kayaking}})
+	assert.Nil(err)
+	assert.Empty(photos)
+
+	photos, err = photoDB.Photos(ctx, types.Query{Selector: types.HasTag{Tag: movedKayaking}})
+	assert.Nil(err)
+	if assert.Len(photos, 1) {
+		metadata, err := photoDB.PhotoMetadata(ctx, photos[0])
+		assert.Nil(err)
+		assert.Contains(metadata.Tags, movedKayaking)
+	}
+}
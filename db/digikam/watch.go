@@ -0,0 +1,162 @@
+package digikam
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"time"
+
+	// The receivers in this package are named db, so we need a different
+	// name for the db package in order to use it within methods.
+	photodb "github.com/anitschke/photo-db-fs/db"
+	"github.com/anitschke/photo-db-fs/utils"
+	"go.uber.org/zap"
+)
+
+var _ = (photodb.Watcher)((*DigikamSQLDatabase)(nil))
+
+// scopeFingerprintQueries are the queries that select the columns of the
+// tables that are relevant to each ChangeScope. digiKam writes to the database
+// for all sorts of reasons that we don't care about (thumbnails, settings, ...)
+// so when the data_version changes we compare hashes of what these queries
+// select to figure out if anything we care about actually changed.
+//
+// The rows are ordered so that the same data always hashes the same, and every
+// column we use is included so that any edit to them changes the hash, even
+// one that swaps values between rows.
+var scopeFingerprintQueries = map[photodb.ChangeScope][]string{
+	photodb.TagsChanged: {
+		"SELECT id, pid, name FROM Tags ORDER BY id",
+		"SELECT imageid, tagid FROM ImageTags ORDER BY imageid, tagid",
+	},
+
+	photodb.PhotoMetadataChanged: {
+		"SELECT imageid, rating, creationDate FROM ImageInformation ORDER BY imageid",
+		"SELECT imageid, make, model, lens FROM ImageMetadata ORDER BY imageid",
+		"SELECT id, imageid, type, language, comment FROM ImageComments ORDER BY id",
+	},
+
+	photodb.PhotosChanged: {
+		"SELECT id, album, name, status FROM Images ORDER BY id",
+		"SELECT id, albumRoot, relativePath FROM Albums ORDER BY id",
+		"SELECT id, label, specificPath FROM AlbumRoots ORDER BY id",
+	},
+}
+
+// Watch polls the data_version every interval and when it changes checks the
+// fingerprints of the tables we care about to figure out what changed.
+func (db *DigikamSQLDatabase) Watch(ctx context.Context, interval time.Duration) (<-chan photodb.ChangeEvent, error) {
+	zap.L().Debug("db watch", zap.Duration("interval", interval))
+
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid polling interval %v", interval)
+	}
+
+	version, err := db.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fingerprints, err := db.fingerprints(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c := make(chan photodb.ChangeEvent)
+	go func() {
+		defer close(c)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			v, err := db.Version(ctx)
+			if err != nil {
+				zap.L().Error("failed to get DB version", zap.Error(err))
+				continue
+			}
+			if v == version {
+				continue
+			}
+
+			current, err := db.fingerprints(ctx)
+			if err != nil {
+				zap.L().Error("failed to get DB fingerprints", zap.Error(err))
+				continue
+			}
+			version = v
+
+			var scope photodb.ChangeScope
+			for s, f := range current {
+				if fingerprints[s] != f {
+					scope |= s
+				}
+			}
+			fingerprints = current
+			if scope == 0 {
+				continue
+			}
+
+			zap.L().Debug("db changed", zap.Stringer("scope", scope))
+			select {
+			case c <- photodb.ChangeEvent{Scope: scope}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return c, nil
+}
+
+func (db *DigikamSQLDatabase) fingerprints(ctx context.Context) (map[photodb.ChangeScope]string, error) {
+	fingerprints := make(map[photodb.ChangeScope]string, len(scopeFingerprintQueries))
+	for scope, queries := range scopeFingerprintQueries {
+		h := sha256.New()
+		for _, q := range queries {
+			if err := db.hashRows(ctx, h, q); err != nil {
+				return nil, fmt.Errorf("failed to get fingerprint for %v: %w", scope, err)
+			}
+		}
+		fingerprints[scope] = string(h.Sum(nil))
+	}
+	return fingerprints, nil
+}
+
+// hashRows writes every column of every row selected by the query to h.
+func (db *DigikamSQLDatabase) hashRows(ctx context.Context, h hash.Hash, q string) error {
+	rows, err := db.db.QueryContext(ctx, q)
+	if err != nil {
+		return err
+	}
+	defer utils.CloseAndLogErrors(rows)
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		for _, v := range values {
+			// Each value is prefixed with its length so that values can't run
+			// into each other.
+			var prefix [8]byte
+			binary.LittleEndian.PutUint64(prefix[:], uint64(len(v)))
+			h.Write(prefix[:])
+			h.Write(v)
+		}
+	}
+	return rows.Err()
+}
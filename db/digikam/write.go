@@ -0,0 +1,267 @@
+package digikam
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	// The receivers in this package are named db, so we need a different
+	// name for the db package in order to use it within methods.
+	photodb "github.com/anitschke/photo-db-fs/db"
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/mattn/go-sqlite3"
+	"go.uber.org/zap"
+)
+
+// checkNotLocked makes sure that nobody else, ie digiKam, is currently holding
+// the lock on the database. It goes through the same immediate transaction as
+// every write so it finds the lock the same way a write would.
+func (db *DigikamSQLDatabase) checkNotLocked(ctx context.Context) error {
+	return db.write(ctx, func(tx *sql.Tx) error { return nil })
+}
+
+// beginTx starts a transaction for modifying the database. Since the database
+// is opened with immediate transactions this gets the write lock right away.
+func (db *DigikamSQLDatabase) beginTx(ctx context.Context) (*sql.Tx, error) {
+	if !db.writable {
+		return nil, photodb.ErrReadOnly
+	}
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, convertLockedError(err)
+	}
+	return tx, nil
+}
+
+// write runs f within a transaction, so either everything f does is committed
+// or none of it is.
+func (db *DigikamSQLDatabase) write(ctx context.Context, f func(tx *sql.Tx) error) error {
+	tx, err := db.beginTx(ctx)
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			zap.L().Error("failed to rollback transaction", zap.Error(rollbackErr))
+		}
+		return convertLockedError(err)
+	}
+	return convertLockedError(tx.Commit())
+}
+
+func convertLockedError(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked) {
+		return fmt.Errorf("%w, please close digiKam before modifying the database: %v", photodb.ErrLocked, err)
+	}
+	return err
+}
+
+func (db *DigikamSQLDatabase) AddTag(ctx context.Context, photo types.Photo, tag types.Tag) error {
+	zap.L().Debug("db add tag", zap.Any("photo", photo), zap.Any("tag", tag))
+	return db.write(ctx, func(tx *sql.Tx) error {
+		tagID, err := tagIDInTx(ctx, tx, tag)
+		if err != nil {
+			return err
+		}
+		imageIDs, err := imageIDs(ctx, tx, photo)
+		if err != nil {
+			return err
+		}
+		for _, imageID := range imageIDs {
+			if _, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO ImageTags (imageid, tagid) VALUES (?, ?)", imageID, tagID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (db *DigikamSQLDatabase) RemoveTag(ctx context.Context, photo types.Photo, tag types.Tag) error {
+	zap.L().Debug("db remove tag", zap.Any("photo", photo), zap.Any("tag", tag))
+	return db.write(ctx, func(tx *sql.Tx) error {
+		tagID, err := tagIDInTx(ctx, tx, tag)
+		if err != nil {
+			return err
+		}
+		imageIDs, err := imageIDs(ctx, tx, photo)
+		if err != nil {
+			return err
+		}
+		for _, imageID := range imageIDs {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM ImageTags WHERE imageid = ? AND tagid = ?", imageID, tagID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (db *DigikamSQLDatabase) CreateTag(ctx context.Context, tag types.Tag) error {
+	zap.L().Debug("db create tag", zap.Any("tag", tag))
+	if len(tag.Path) == 0 {
+		return fmt.Errorf("can't create a tag with an empty path")
+	}
+	return db.write(ctx, func(tx *sql.Tx) error {
+		var parentID int64
+		if len(tag.Path) > 1 {
+			var err error
+			parentID, err = tagIDInTx(ctx, tx, types.Tag{Path: tag.Path[:len(tag.Path)-1]})
+			if err != nil {
+				return err
+			}
+		}
+
+		// digiKam has triggers that keep the TagsTree table up to date, so all
+		// we need to do is insert the tag.
+		_, err := tx.ExecContext(ctx, "INSERT INTO Tags (pid, name) VALUES (?, ?)", parentID, tag.Name())
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrConstraint {
+			return fmt.Errorf("tag %q: %w", strings.Join(tag.Path, "/"), photodb.ErrExists)
+		}
+		return err
+	})
+}
+
+func (db *DigikamSQLDatabase) SetRating(ctx context.Context, photo types.Photo, rating float64) error {
+	zap.L().Debug("db set rating", zap.Any("photo", photo), zap.Float64("rating", rating))
+	validRating := false
+	for _, r := range db.Ratings() {
+		if rating == r {
+			validRating = true
+		}
+	}
+	if !validRating {
+		return fmt.Errorf("invalid rating %v, must be one of %v", rating, db.Ratings())
+	}
+	return db.write(ctx, func(tx *sql.Tx) error {
+		imageIDs, err := imageIDs(ctx, tx, photo)
+		if err != nil {
+			return err
+		}
+		for _, imageID := range imageIDs {
+			_, err := tx.ExecContext(ctx, `INSERT INTO ImageInformation (imageid, rating) VALUES (?, ?)
+				ON CONFLICT (imageid) DO UPDATE SET rating = excluded.rating`, imageID, int(rating))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (db *DigikamSQLDatabase) PhotosWithContent(ctx context.Context, content io.ReaderAt, size int64) ([]types.Photo, error) {
+	zap.L().Debug("db query photos with content", zap.Int64("size", size))
+
+	hash, err := uniqueHash(content, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash content: %w", err)
+	}
+
+	queryString := selectPhotos("i.uniqueHash = ? AND i.fileSize = ?")
+	return db.photos(ctx, queryString, []any{hash, size})
+}
+
+// uniqueHashChunkSize is how much of the start and end of a file digiKam uses
+// to compute the uniqueHash.
+const uniqueHashChunkSize = 100 * 1024
+
+// uniqueHash computes the same hash digiKam uses to identify files, which is
+// the md5 of the first and last 100KiB of the file. If the file is smaller
+// than 100KiB then the whole file ends up being hashed twice.
+func uniqueHash(content io.ReaderAt, size int64) (string, error) {
+	h := md5.New()
+
+	chunk := int64(uniqueHashChunkSize)
+	if size < chunk {
+		chunk = size
+	}
+	if _, err := io.Copy(h, io.NewSectionReader(content, 0, chunk)); err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(h, io.NewSectionReader(content, size-chunk, chunk)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func tagIDInTx(ctx context.Context, tx *sql.Tx, tag types.Tag) (int64, error) {
+	tagSubQuery, parameters, err := tagIDSubquery(tag)
+	if err != nil {
+		return 0, err
+	}
+
+	var tagID sql.NullInt64
+	if err := tx.QueryRowContext(ctx, "SELECT "+tagSubQuery, parameters...).Scan(&tagID); err != nil {
+		return 0, err
+	}
+	if !tagID.Valid {
+		return 0, fmt.Errorf("tag %q: %w", strings.Join(tag.Path, "/"), photodb.ErrNotFound)
+	}
+	return tagID.Int64, nil
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// imageIDs finds the IDs of the images for a photo, see db.DB.AddTag for how
+// photos are identified.
+func imageIDs(ctx context.Context, q queryer, photo types.Photo) ([]int64, error) {
+	var imageIDs []int64
+	if photo.ID != "" {
+		rows, err := q.QueryContext(ctx, "SELECT id FROM Images WHERE uniqueHash = ?", photo.ID)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				return nil, err
+			}
+			imageIDs = append(imageIDs, id)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	} else {
+		// Building the full path of a photo in SQL is a little messy, so we
+		// just find all the images with the right name and then check the
+		// full path here.
+		rows, err := q.QueryContext(ctx, `SELECT i.id, r.specificPath, a.relativePath
+			FROM Images i
+			JOIN Albums a ON i.album = a.id
+			JOIN AlbumRoots r ON a.albumRoot = r.id
+			WHERE i.name = ?`, filepath.Base(photo.Path))
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id int64
+			var root, path string
+			if err := rows.Scan(&id, &root, &path); err != nil {
+				return nil, err
+			}
+			if filepath.Join(root, path, filepath.Base(photo.Path)) == filepath.Clean(photo.Path) {
+				imageIDs = append(imageIDs, id)
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(imageIDs) == 0 {
+		return nil, fmt.Errorf("photo %q: %w", photo.Path, photodb.ErrNotFound)
+	}
+	return imageIDs, nil
+}
@@ -0,0 +1,240 @@
+package digikam
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/anitschke/photo-db-fs/db"
+	digikamtestresources "github.com/anitschke/photo-db-fs/test-resources/digikam"
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func skiingPhotos(t *testing.T, photoDB *DigikamSQLDatabase) []types.Photo {
+	photos, err := photoDB.Photos(context.Background(), types.Query{
+		Selector: types.HasTag{Tag: types.Tag{Path: []string{"activity", "skiing"}}},
+	})
+	assert.Nil(t, err)
+	return photos
+}
+
+func TestDigikamSqliteDatabase_AddRemoveTag(t *testing.T) {
+	assert := assert.New(t)
+
+	testDB, libraryRoot, cleanup, err := digikamtestresources.PrepareBasicDB()
+	assert.Nil(err)
+	defer cleanup()
+
+	photoDB, err := NewWritableDigikamSqliteDatabase(testDB)
+	assert.Nil(err)
+	defer func() {
+		err = photoDB.Close()
+		assert.Nil(err)
+	}()
+
+	ctx := context.Background()
+	skiing := types.Tag{Path: []string{"activity", "skiing"}}
+
+	byID := types.Photo{ID: "2c7f1b1dc8cd0c4a6f6e4ae8e7e1c6a1"}
+	err = photoDB.AddTag(ctx, byID, skiing)
+	assert.ErrorIs(err, db.ErrNotFound)
+
+	byPath := types.Photo{Path: libraryRoot + "/album1/GRAND_00626.jpg"}
+	assert.Len(skiingPhotos(t, photoDB), 3)
+	assert.Nil(photoDB.AddTag(ctx, byPath, skiing))
+	photos := skiingPhotos(t, photoDB)
+	assert.Len(photos, 4)
+
+	// Adding the tag a second time shouldn't do anything.
+	assert.Nil(photoDB.AddTag(ctx, byPath, skiing))
+	assert.Len(skiingPhotos(t, photoDB), 4)
+
+	var added types.Photo
+	for _, p := range photos {
+		if p.Path == byPath.Path {
+			added = p
+		}
+	}
+	assert.NotEmpty(added.ID)
+	assert.Nil(photoDB.RemoveTag(ctx, types.Photo{ID: added.ID}, skiing))
+	assert.Len(skiingPhotos(t, photoDB), 3)
+
+	err = photoDB.AddTag(ctx, byPath, types.Tag{Path: []string{"activity", "doesNotExist"}})
+	assert.ErrorIs(err, db.ErrNotFound)
+	err = photoDB.AddTag(ctx, types.Photo{Path: libraryRoot + "/album1/doesNotExist.jpg"}, skiing)
+	assert.ErrorIs(err, db.ErrNotFound)
+}
+
+func TestDigikamSqliteDatabase_CreateTag(t *testing.T) {
+	assert := assert.New(t)
+
+	testDB, _, cleanup, err := digikamtestresources.PrepareBasicDB()
+	assert.Nil(err)
+	defer cleanup()
+
+	photoDB, err := NewWritableDigikamSqliteDatabase(testDB)
+	assert.Nil(err)
+	defer func() {
+		err = photoDB.Close()
+		assert.Nil(err)
+	}()
+
+	ctx := context.Background()
+
+	assert.Nil(photoDB.CreateTag(ctx, types.Tag{Path: []string{"places"}}))
+	assert.Nil(photoDB.CreateTag(ctx, types.Tag{Path: []string{"places", "beach"}}))
+
+	rootTags, err := photoDB.RootTags(ctx)
+	assert.Nil(err)
+	assert.Contains(rootTags, types.Tag{Path: []string{"places"}})
+
+	children, err := photoDB.ChildrenTags(ctx, types.Tag{Path: []string{"places"}})
+	assert.Nil(err)
+	assert.Equal([]types.Tag{{Path: []string{"places", "beach"}}}, children)
+
+	err = photoDB.CreateTag(ctx, types.Tag{Path: []string{"places", "beach"}})
+	assert.ErrorIs(err, db.ErrExists)
+
+	err = photoDB.CreateTag(ctx, types.Tag{Path: []string{"doesNotExist", "beach"}})
+	assert.ErrorIs(err, db.ErrNotFound)
+}
+
+func TestDigikamSqliteDatabase_ReadOnly(t *testing.T) {
+	assert := assert.New(t)
+
+	testDB, libraryRoot, cleanup, err := digikamtestresources.PrepareBasicDB()
+	assert.Nil(err)
+	defer cleanup()
+
+	photoDB, err := NewDigikamSqliteDatabase(testDB)
+	assert.Nil(err)
+	defer func() {
+		err = photoDB.Close()
+		assert.Nil(err)
+	}()
+
+	ctx := context.Background()
+	photo := types.Photo{Path: libraryRoot + "/album1/GRAND_00626.jpg"}
+	tag := types.Tag{Path: []string{"activity", "skiing"}}
+
+	assert.ErrorIs(photoDB.AddTag(ctx, photo, tag), db.ErrReadOnly)
+	assert.ErrorIs(photoDB.RemoveTag(ctx, photo, tag), db.ErrReadOnly)
+	assert.ErrorIs(photoDB.CreateTag(ctx, types.Tag{Path: []string{"places"}}), db.ErrReadOnly)
+}
+
+func TestDigikamSqliteDatabase_Locked(t *testing.T) {
+	assert := assert.New(t)
+
+	testDB, libraryRoot, cleanup, err := digikamtestresources.PrepareBasicDB()
+	assert.Nil(err)
+	defer cleanup()
+
+	// Opened before digiKam takes the lock, so every write has to find out
+	// about the lock on its own.
+	photoDB, err := NewWritableDigikamSqliteDatabase(testDB)
+	assert.Nil(err)
+	defer func() {
+		assert.Nil(photoDB.Close())
+	}()
+
+	// Simulate digiKam holding the write lock on the database.
+	locker, err := sql.Open("sqlite3", "file:"+testDB)
+	assert.Nil(err)
+	defer func() {
+		assert.Nil(locker.Close())
+	}()
+	tx, err := locker.Begin()
+	assert.Nil(err)
+	_, err = tx.Exec(`UPDATE Tags SET name = "renamed" WHERE name = "kayaking"`)
+	assert.Nil(err)
+	defer func() {
+		assert.Nil(tx.Rollback())
+	}()
+
+	_, err = NewWritableDigikamSqliteDatabase(testDB)
+	assert.ErrorIs(err, db.ErrLocked)
+
+	ctx := context.Background()
+	photo := types.Photo{Path: libraryRoot + "/album1/GRAND_00626.jpg"}
+	tag := types.Tag{Path: []string{"activity", "skiing"}}
+	assert.ErrorIs(photoDB.AddTag(ctx, photo, tag), db.ErrLocked)
+	assert.ErrorIs(photoDB.RemoveTag(ctx, photo, tag), db.ErrLocked)
+	assert.ErrorIs(photoDB.CreateTag(ctx, types.Tag{Path: []string{"places"}}), db.ErrLocked)
+	assert.ErrorIs(photoDB.SetRating(ctx, photo, 5), db.ErrLocked)
+}
+
+func TestDigikamSqliteDatabase_PhotosWithContent(t *testing.T) {
+	assert := assert.New(t)
+
+	testDB, libraryRoot, cleanup, err := digikamtestresources.PrepareBasicDB()
+	assert.Nil(err)
+	defer cleanup()
+
+	photoDB, err := NewDigikamSqliteDatabase(testDB)
+	assert.Nil(err)
+	defer func() {
+		err = photoDB.Close()
+		assert.Nil(err)
+	}()
+
+	ctx := context.Background()
+	path := libraryRoot + "/album2/DSC_0196.jpg"
+	content, err := os.ReadFile(path)
+	assert.Nil(err)
+
+	photos, err := photoDB.PhotosWithContent(ctx, bytes.NewReader(content), int64(len(content)))
+	assert.Nil(err)
+	assert.Equal([]types.Photo{{Path: path, ID: "d5b701b4043c51007430119971b17ae2"}}, photos)
+
+	// Changing a single byte means it isn't the same photo anymore.
+	content[len(content)-1] ^= 0xff
+	photos, err = photoDB.PhotosWithContent(ctx, bytes.NewReader(content), int64(len(content)))
+	assert.Nil(err)
+	assert.Empty(photos)
+}
+
+func TestUniqueHash_SmallFile(t *testing.T) {
+	assert := assert.New(t)
+
+	// digiKam hashes files smaller than the chunk size twice.
+	content := []byte("hello")
+	hash, err := uniqueHash(bytes.NewReader(content), int64(len(content)))
+	assert.Nil(err)
+	assert.Equal("23b431acfeb41e15d466d75de822307c", hash) // md5("hellohello")
+}
+
+func TestDigikamSqliteDatabase_SetRating(t *testing.T) {
+	assert := assert.New(t)
+
+	testDB, libraryRoot, cleanup, err := digikamtestresources.PrepareBasicDB()
+	assert.Nil(err)
+	defer cleanup()
+
+	photoDB, err := NewWritableDigikamSqliteDatabase(testDB)
+	assert.Nil(err)
+	defer func() {
+		err = photoDB.Close()
+		assert.Nil(err)
+	}()
+
+	ctx := context.Background()
+	fiveStars := types.Query{Selector: types.HasRating{Operator: types.Equal, Rating: 5}}
+	photo := types.Photo{Path: libraryRoot + "/album2/DSC_0196.jpg"}
+
+	before, err := photoDB.Photos(ctx, fiveStars)
+	assert.Nil(err)
+	assert.NotContains(before, types.Photo{Path: photo.Path, ID: "d5b701b4043c51007430119971b17ae2"})
+
+	assert.Nil(photoDB.SetRating(ctx, photo, 5))
+	after, err := photoDB.Photos(ctx, fiveStars)
+	assert.Nil(err)
+	assert.Len(after, len(before)+1)
+	assert.Contains(after, types.Photo{Path: photo.Path, ID: "d5b701b4043c51007430119971b17ae2"})
+
+	assert.NotNil(photoDB.SetRating(ctx, photo, 2.5))
+	assert.NotNil(photoDB.SetRating(ctx, photo, 6))
+	assert.ErrorIs(photoDB.SetRating(ctx, types.Photo{Path: libraryRoot + "/album2/doesNotExist.jpg"}, 3), db.ErrNotFound)
+}
@@ -0,0 +1,188 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/anitschke/photo-db-fs/types"
+)
+
+// ExcludingDB is a DB that removes the photos selected by an exclude selector
+// from the results of every query that selects photos, so that they never show
+// up anywhere in the file system. The albums, cameras and lenses that only
+// have excluded photos are left out too, since they would otherwise give away
+// that the excluded photos exist.
+//
+// The tags and modifying the DB are passed straight through to the wrapped DB.
+type ExcludingDB struct {
+	DB
+	exclude types.Selector
+}
+
+var _ = (DB)((*ExcludingDB)(nil))
+var _ = (Watcher)((*ExcludingDB)(nil))
+var _ = (Counter)((*ExcludingDB)(nil))
+var _ = (PhotoStreamer)((*ExcludingDB)(nil))
+var _ = (Grouper)((*ExcludingDB)(nil))
+
+// NewExcludingDB wraps d so that the photos selected by exclude are removed
+// from every query. If exclude is nil then d is returned as is.
+func NewExcludingDB(d DB, exclude types.Selector) DB {
+	if exclude == nil {
+		return d
+	}
+	return &ExcludingDB{DB: d, exclude: exclude}
+}
+
+// WithoutExclusions gets the DB that d wraps if it is an ExcludingDB, this
+// allows specific views to opt out of exclusions.
+func WithoutExclusions(d DB) DB {
+	if e, ok := d.(*ExcludingDB); ok {
+		return e.DB
+	}
+	return d
+}
+
+func (e *ExcludingDB) excludeFrom(q types.Query) types.Query {
+	q.Selector = types.Difference{
+		Starting:  q.Selector,
+		Excluding: e.exclude,
+	}
+	return q
+}
+
+func (e *ExcludingDB) Photos(ctx context.Context, q types.Query) ([]types.Photo, error) {
+	return e.DB.Photos(ctx, e.excludeFrom(q))
+}
+
+func (e *ExcludingDB) StreamPhotos(ctx context.Context, q types.Query, fn func(types.Photo) error) error {
+	return StreamPhotos(ctx, e.DB, e.excludeFrom(q), fn)
+}
+
+func (e *ExcludingDB) PhotoTags(ctx context.Context, q types.Query) ([]types.Tag, error) {
+	return e.DB.PhotoTags(ctx, e.excludeFrom(q))
+}
+
+func (e *ExcludingDB) TakenYears(ctx context.Context, q types.Query) ([]int, error) {
+	return e.DB.TakenYears(ctx, e.excludeFrom(q))
+}
+
+func (e *ExcludingDB) TagCounts(ctx context.Context, q types.Query) ([]types.TagCount, error) {
+	return TagCounts(ctx, e.DB, e.excludeFrom(q))
+}
+
+func (e *ExcludingDB) RatingCounts(ctx context.Context, q types.Query) ([]types.RatingCount, error) {
+	return RatingCounts(ctx, e.DB, e.excludeFrom(q))
+}
+
+// Watch watches the wrapped DB, since wrapping it would otherwise hide the
+// Watcher or Versioner that it implements.
+func (e *ExcludingDB) Watch(ctx context.Context, interval time.Duration) (<-chan ChangeEvent, error) {
+	return Watch(ctx, e.DB, interval)
+}
+
+func (e *ExcludingDB) PhotoGroups(ctx context.Context, q types.Query) (types.PhotoGroups, error) {
+	return PhotoGroups(ctx, e.DB, e.excludeFrom(q))
+}
+
+// groups gets the albums, cameras and lenses that have photos that aren't
+// excluded, all in one query so that it doesn't matter how many of them there
+// are.
+func (e *ExcludingDB) groups(ctx context.Context) (types.PhotoGroups, error) {
+	return e.PhotoGroups(ctx, types.Query{Selector: types.And{}})
+}
+
+func (e *ExcludingDB) Albums(ctx context.Context) ([]types.Album, error) {
+	albums, err := e.DB.Albums(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return e.albumsWithPhotos(ctx, albums)
+}
+
+func (e *ExcludingDB) ChildAlbums(ctx context.Context, parent types.Album) ([]types.Album, error) {
+	albums, err := e.DB.ChildAlbums(ctx, parent)
+	if err != nil {
+		return nil, err
+	}
+	return e.albumsWithPhotos(ctx, albums)
+}
+
+// albumsWithPhotos gets the albums that have photos that aren't excluded in
+// them or in any of the albums nested under them.
+func (e *ExcludingDB) albumsWithPhotos(ctx context.Context, albums []types.Album) ([]types.Album, error) {
+	groups, err := e.groups(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// An album has photos if any album with photos is nested under it, so
+	// every album that an album with photos is nested under has photos too.
+	withPhotos := make(map[string]struct{})
+	for _, a := range groups.Albums {
+		for i := 1; i <= len(a.Path); i++ {
+			withPhotos[albumKey(a.Path[:i])] = struct{}{}
+		}
+	}
+
+	var kept []types.Album
+	for _, a := range albums {
+		if _, ok := withPhotos[albumKey(a.Path)]; ok {
+			kept = append(kept, a)
+		}
+	}
+	return kept, nil
+}
+
+func albumKey(path []string) string {
+	// Album names can contain just about any character so we use a null
+	// character as a separator since it can't be part of a name.
+	return strings.Join(path, "\x00")
+}
+
+func (e *ExcludingDB) Cameras(ctx context.Context) ([]types.Camera, error) {
+	cameras, err := e.DB.Cameras(ctx)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := e.groups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	withPhotos := make(map[types.Camera]struct{}, len(groups.Cameras))
+	for _, c := range groups.Cameras {
+		withPhotos[c] = struct{}{}
+	}
+
+	var kept []types.Camera
+	for _, c := range cameras {
+		if _, ok := withPhotos[c]; ok {
+			kept = append(kept, c)
+		}
+	}
+	return kept, nil
+}
+
+func (e *ExcludingDB) Lenses(ctx context.Context) ([]string, error) {
+	lenses, err := e.DB.Lenses(ctx)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := e.groups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	withPhotos := make(map[string]struct{}, len(groups.Lenses))
+	for _, l := range groups.Lenses {
+		withPhotos[l] = struct{}{}
+	}
+
+	var kept []string
+	for _, l := range lenses {
+		if _, ok := withPhotos[l]; ok {
+			kept = append(kept, l)
+		}
+	}
+	return kept, nil
+}
@@ -0,0 +1,90 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/anitschke/photo-db-fs/db"
+	"github.com/anitschke/photo-db-fs/db/mocks"
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestExcludingDB(t *testing.T) {
+	assert := assert.New(t)
+
+	mockDB := mocks.NewDB(t)
+	assert.Same(mockDB, db.NewExcludingDB(mockDB, nil))
+
+	private := types.HasTag{Tag: types.Tag{Path: []string{"Private"}}}
+	skiing := types.HasTag{Tag: types.Tag{Path: []string{"activity", "skiing"}}}
+	excluded := types.Query{Selector: types.Difference{Starting: skiing, Excluding: private}}
+
+	photo := types.Photo{Path: "/photos/foo.jpg", ID: "foo"}
+	mockDB.On("Photos", mock.Anything, excluded).Return([]types.Photo{photo}, nil).Once()
+	mockDB.On("PhotoTags", mock.Anything, excluded).Return([]types.Tag{skiing.Tag}, nil).Once()
+	mockDB.On("TakenYears", mock.Anything, excluded).Return([]int{2022}, nil).Once()
+	mockDB.On("RootTags", mock.Anything).Return([]types.Tag{private.Tag}, nil).Once()
+
+	ctx := context.Background()
+	e := db.NewExcludingDB(mockDB, private)
+
+	photos, err := e.Photos(ctx, types.Query{Selector: skiing})
+	assert.Nil(err)
+	assert.Equal([]types.Photo{photo}, photos)
+
+	tags, err := e.PhotoTags(ctx, types.Query{Selector: skiing})
+	assert.Nil(err)
+	assert.Equal([]types.Tag{skiing.Tag}, tags)
+
+	years, err := e.TakenYears(ctx, types.Query{Selector: skiing})
+	assert.Nil(err)
+	assert.Equal([]int{2022}, years)
+
+	// Albums, cameras and lenses that only have excluded photos are left out,
+	// which is found out with one query for all of them. An album is kept if
+	// any of the albums nested under it have photos.
+	pictures := types.Album{Path: []string{"Pictures"}}
+	nested := types.Album{Path: []string{"Pictures", "2022"}}
+	hidden := types.Album{Path: []string{"Hidden"}}
+	nikon := types.Camera{Make: "NIKON CORPORATION", Model: "NIKON D5500"}
+	canon := types.Camera{Make: "Canon", Model: "Canon EOS R5"}
+	grouper := groupingDB{DB: mockDB}
+	grouped := db.NewExcludingDB(grouper, private)
+	all := types.Query{Selector: types.Difference{Starting: types.And{}, Excluding: private}}
+	grouper.On("PhotoGroups", mock.Anything, all).Return(types.PhotoGroups{
+		Albums:  []types.Album{nested},
+		Cameras: []types.Camera{canon},
+		Lenses:  []string{"wide"},
+	}, nil).Times(4)
+
+	mockDB.On("Albums", mock.Anything).Return([]types.Album{pictures, hidden}, nil).Once()
+	albums, err := grouped.Albums(ctx)
+	assert.Nil(err)
+	assert.Equal([]types.Album{pictures}, albums)
+
+	mockDB.On("ChildAlbums", mock.Anything, pictures).Return([]types.Album{nested, {Path: []string{"Pictures", "2021"}}}, nil).Once()
+	albums, err = grouped.ChildAlbums(ctx, pictures)
+	assert.Nil(err)
+	assert.Equal([]types.Album{nested}, albums)
+
+	mockDB.On("Cameras", mock.Anything).Return([]types.Camera{nikon, canon}, nil).Once()
+	cameras, err := grouped.Cameras(ctx)
+	assert.Nil(err)
+	assert.Equal([]types.Camera{canon}, cameras)
+
+	mockDB.On("Lenses", mock.Anything).Return([]string{"wide", "tele"}, nil).Once()
+	lenses, err := grouped.Lenses(ctx)
+	assert.Nil(err)
+	assert.Equal([]string{"wide"}, lenses)
+
+	// The tags and anything else that doesn't select photos is passed straight
+	// through.
+	tags, err = e.RootTags(ctx)
+	assert.Nil(err)
+	assert.Equal([]types.Tag{private.Tag}, tags)
+
+	assert.Same(mockDB, db.WithoutExclusions(e))
+	assert.Same(mockDB, db.WithoutExclusions(mockDB))
+}
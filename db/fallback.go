@@ -0,0 +1,414 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/anitschke/photo-db-fs/db/memory"
+	"github.com/anitschke/photo-db-fs/types"
+)
+
+// FallbackDB is a DB that can select photos with every kind of selector, even
+// if the DB it wraps can't natively.
+//
+// The parts of a selector that the wrapped DB supports are queried from the DB
+// as is. The wrapped DB is first asked for a set of candidate photos that is
+// narrowed down as much as possible using these parts, then the rest of the
+// selector is evaluated in memory using the PhotoMetadata of each candidate.
+// The metadata of all of the candidates is fetched in one batch if the wrapped
+// DB is a MetadataBatcher.
+type FallbackDB struct {
+	DB
+	native map[types.SelectorKind]bool
+}
+
+var _ = (DB)((*FallbackDB)(nil))
+var _ = (Watcher)((*FallbackDB)(nil))
+var _ = (Counter)((*FallbackDB)(nil))
+var _ = (PhotoStreamer)((*FallbackDB)(nil))
+var _ = (Grouper)((*FallbackDB)(nil))
+
+// NewFallbackDB wraps d so that it can select photos with every kind of
+// selector. If d doesn't implement CapabilityReporter, or it supports every
+// kind of selector, then d is returned as is.
+func NewFallbackDB(d DB) DB {
+	reporter, ok := d.(CapabilityReporter)
+	if !ok {
+		return d
+	}
+	native := make(map[types.SelectorKind]bool)
+	for _, k := range reporter.Capabilities() {
+		native[k] = true
+	}
+	for _, k := range types.SelectorKinds {
+		if !native[k] {
+			return &FallbackDB{DB: d, native: native}
+		}
+	}
+	return d
+}
+
+func (f *FallbackDB) Photos(ctx context.Context, q types.Query) ([]types.Photo, error) {
+	if f.isNative(q.Selector) {
+		return f.DB.Photos(ctx, q)
+	}
+	index, err := f.evaluate(ctx, q.Selector)
+	if err != nil {
+		return nil, err
+	}
+	return index.Photos(types.Query{Selector: types.And{}})
+}
+
+// StreamPhotos only streams the photos if the wrapped DB supports the selector,
+// otherwise all of the candidates need to be in memory anyway.
+func (f *FallbackDB) StreamPhotos(ctx context.Context, q types.Query, fn func(types.Photo) error) error {
+	if f.isNative(q.Selector) {
+		return StreamPhotos(ctx, f.DB, q, fn)
+	}
+	photos, err := f.Photos(ctx, q)
+	if err != nil {
+		return err
+	}
+	for _, p := range photos {
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FallbackDB) PhotoTags(ctx context.Context, q types.Query) ([]types.Tag, error) {
+	if f.isNative(q.Selector) {
+		return f.DB.PhotoTags(ctx, q)
+	}
+	index, err := f.evaluate(ctx, q.Selector)
+	if err != nil {
+		return nil, err
+	}
+	return index.PhotoTags(types.Query{Selector: types.And{}})
+}
+
+func (f *FallbackDB) TakenYears(ctx context.Context, q types.Query) ([]int, error) {
+	if f.isNative(q.Selector) {
+		return f.DB.TakenYears(ctx, q)
+	}
+	index, err := f.evaluate(ctx, q.Selector)
+	if err != nil {
+		return nil, err
+	}
+	return index.TakenYears(types.Query{Selector: types.And{}})
+}
+
+// TagCounts counts the photos of every tag in memory if the wrapped DB doesn't
+// support the selector, so unlike the wrapped DB it can always count photos.
+func (f *FallbackDB) TagCounts(ctx context.Context, q types.Query) ([]types.TagCount, error) {
+	if f.isNative(q.Selector) {
+		return TagCounts(ctx, f.DB, q)
+	}
+	index, err := f.evaluate(ctx, q.Selector)
+	if err != nil {
+		return nil, err
+	}
+	return index.TagCounts(types.Query{Selector: types.And{}})
+}
+
+func (f *FallbackDB) RatingCounts(ctx context.Context, q types.Query) ([]types.RatingCount, error) {
+	if f.isNative(q.Selector) {
+		return RatingCounts(ctx, f.DB, q)
+	}
+	index, err := f.evaluate(ctx, q.Selector)
+	if err != nil {
+		return nil, err
+	}
+	return index.RatingCounts(types.Query{Selector: types.And{}})
+}
+
+func (f *FallbackDB) PhotoGroups(ctx context.Context, q types.Query) (types.PhotoGroups, error) {
+	if f.isNative(q.Selector) {
+		return PhotoGroups(ctx, f.DB, q)
+	}
+	index, err := f.evaluate(ctx, q.Selector)
+	if err != nil {
+		return types.PhotoGroups{}, err
+	}
+	return index.PhotoGroups(types.Query{Selector: types.And{}})
+}
+
+// Watch watches the wrapped DB, since wrapping it would otherwise hide the
+// Watcher or Versioner that it implements.
+func (f *FallbackDB) Watch(ctx context.Context, interval time.Duration) (<-chan ChangeEvent, error) {
+	return Watch(ctx, f.DB, interval)
+}
+
+// isNative checks if the wrapped DB supports the selector and all of its
+// operands.
+func (f *FallbackDB) isNative(s types.Selector) bool {
+	if !f.native[types.KindOf(s)] {
+		return false
+	}
+	switch t := s.(type) {
+	case types.And:
+		return f.allNative(t.Operands)
+	case types.Or:
+		return f.allNative(t.Operands)
+	case types.Difference:
+		return f.isNative(t.Starting) && f.isNative(t.Excluding)
+	default:
+		return true
+	}
+}
+
+func (f *FallbackDB) allNative(operands []types.Selector) bool {
+	for _, op := range operands {
+		if !f.isNative(op) {
+			return false
+		}
+	}
+	return true
+}
+
+// narrow gets a selector that the wrapped DB supports which selects at least
+// all of the photos that s selects. It returns false if there is no such
+// selector other than selecting every photo.
+func (f *FallbackDB) narrow(s types.Selector) (types.Selector, bool) {
+	if f.isNative(s) {
+		return s, true
+	}
+	switch t := s.(type) {
+	case types.And:
+		// Any one of the operands selects all of the photos the And does, so
+		// we can drop the ones that can't be narrowed down.
+		var operands []types.Selector
+		for _, op := range t.Operands {
+			if n, ok := f.narrow(op); ok {
+				operands = append(operands, n)
+			}
+		}
+		if len(operands) == 0 {
+			return nil, false
+		}
+		if len(operands) == 1 || !f.native[types.AndKind] {
+			return operands[0], true
+		}
+		return types.And{Operands: operands}, true
+	case types.Or:
+		// But all of the operands of an Or need to be narrowed down, since
+		// any one of them could select a photo.
+		if !f.native[types.OrKind] {
+			return nil, false
+		}
+		operands := make([]types.Selector, 0, len(t.Operands))
+		for _, op := range t.Operands {
+			n, ok := f.narrow(op)
+			if !ok {
+				return nil, false
+			}
+			operands = append(operands, n)
+		}
+		return types.Or{Operands: operands}, true
+	case types.Difference:
+		return f.narrow(t.Starting)
+	default:
+		return nil, false
+	}
+}
+
+// evaluate gets an index of the photos selected by s, along with their
+// metadata.
+func (f *FallbackDB) evaluate(ctx context.Context, s types.Selector) (*memory.Index, error) {
+	if s == nil {
+		return nil, fmt.Errorf("can't evaluate a nil selector")
+	}
+
+	narrowed, ok := f.narrow(s)
+	var candidates []types.Photo
+	var err error
+	if ok {
+		candidates, err = f.DB.Photos(ctx, types.Query{Selector: narrowed})
+	} else {
+		candidates, err = f.allPhotos(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := PhotosMetadata(ctx, f.DB, candidates)
+	if err != nil {
+		return nil, err
+	}
+	photos := make([]memory.Photo, len(candidates))
+	for i, p := range candidates {
+		photos[i] = memory.Photo{
+			Photo:  p,
+			Tags:   metadata[i].Tags,
+			Rating: metadata[i].Rating,
+			Album:  metadata[i].Album,
+			Camera: metadata[i].Camera,
+			Lens:   metadata[i].Lens,
+			Taken:  metadata[i].Taken,
+		}
+	}
+
+	e := fallbackEvaluator{
+		ctx:      ctx,
+		db:       f,
+		narrowed: narrowed,
+		photos:   photos,
+		index:    memory.NewIndex(photos),
+		keys:     make(map[photoKey]int),
+	}
+	for i, p := range candidates {
+		e.keys[keyOf(p)] = i
+	}
+	selected, err := e.evaluate(s)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]memory.Photo, 0)
+	for i, ok := range selected {
+		if ok {
+			result = append(result, photos[i])
+		}
+	}
+	return memory.NewIndex(result), nil
+}
+
+// allPhotos gets every photo in the DB, either with an And with no operands or
+// by going through every album.
+func (f *FallbackDB) allPhotos(ctx context.Context) ([]types.Photo, error) {
+	if f.native[types.AndKind] {
+		return f.DB.Photos(ctx, types.Query{Selector: types.And{}})
+	}
+	if !f.native[types.InAlbumKind] {
+		return nil, fmt.Errorf("can't narrow down the photos to evaluate the selector on")
+	}
+
+	albums, err := f.DB.Albums(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var photos []types.Photo
+	for i := 0; i < len(albums); i++ {
+		children, err := f.DB.ChildAlbums(ctx, albums[i])
+		if err != nil {
+			return nil, err
+		}
+		albums = append(albums, children...)
+
+		inAlbum, err := f.DB.Photos(ctx, types.Query{Selector: types.InAlbum{Album: albums[i]}})
+		if err != nil {
+			return nil, err
+		}
+		photos = append(photos, inAlbum...)
+	}
+	return photos, nil
+}
+
+type photoKey struct {
+	path string
+	id   string
+}
+
+func keyOf(p types.Photo) photoKey {
+	return photoKey{path: p.Path, id: p.ID}
+}
+
+// fallbackEvaluator evaluates a selector over a set of candidate photos,
+// querying the wrapped DB for the parts of the selector that it supports and
+// evaluating the rest in memory.
+type fallbackEvaluator struct {
+	ctx context.Context
+	db  *FallbackDB
+
+	// narrowed is the selector that selected the candidates, or nil if all
+	// photos are candidates.
+	narrowed types.Selector
+
+	photos []memory.Photo
+	index  *memory.Index
+	keys   map[photoKey]int
+}
+
+// evaluate gets which of the candidate photos are selected by s.
+func (e fallbackEvaluator) evaluate(s types.Selector) ([]bool, error) {
+	if reflect.DeepEqual(s, e.narrowed) {
+		// No need to query the DB again since every candidate is selected.
+		return e.all(), nil
+	}
+	if e.db.isNative(s) {
+		photos, err := e.db.DB.Photos(e.ctx, types.Query{Selector: s})
+		if err != nil {
+			return nil, err
+		}
+		return e.toSet(photos), nil
+	}
+
+	switch t := s.(type) {
+	case types.And:
+		result := e.all()
+		for _, op := range t.Operands {
+			set, err := e.evaluate(op)
+			if err != nil {
+				return nil, err
+			}
+			for i := range result {
+				result[i] = result[i] && set[i]
+			}
+		}
+		return result, nil
+	case types.Or:
+		result := make([]bool, len(e.photos))
+		for _, op := range t.Operands {
+			set, err := e.evaluate(op)
+			if err != nil {
+				return nil, err
+			}
+			for i := range result {
+				result[i] = result[i] || set[i]
+			}
+		}
+		return result, nil
+	case types.Difference:
+		starting, err := e.evaluate(t.Starting)
+		if err != nil {
+			return nil, err
+		}
+		excluding, err := e.evaluate(t.Excluding)
+		if err != nil {
+			return nil, err
+		}
+		for i := range starting {
+			starting[i] = starting[i] && !excluding[i]
+		}
+		return starting, nil
+	}
+
+	photos, err := e.index.Photos(types.Query{Selector: s})
+	if err != nil {
+		return nil, err
+	}
+	return e.toSet(photos), nil
+}
+
+func (e fallbackEvaluator) all() []bool {
+	set := make([]bool, len(e.photos))
+	for i := range set {
+		set[i] = true
+	}
+	return set
+}
+
+// toSet gets which of the candidate photos are in photos, any photos that
+// aren't candidates are ignored.
+func (e fallbackEvaluator) toSet(photos []types.Photo) []bool {
+	set := make([]bool, len(e.photos))
+	for _, p := range photos {
+		if i, ok := e.keys[keyOf(p)]; ok {
+			set[i] = true
+		}
+	}
+	return set
+}
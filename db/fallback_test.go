@@ -0,0 +1,122 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/anitschke/photo-db-fs/db"
+	"github.com/anitschke/photo-db-fs/db/mocks"
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// limitedDB is a DB that only supports some kinds of selectors.
+type limitedDB struct {
+	*mocks.DB
+	kinds []types.SelectorKind
+}
+
+var _ = (db.CapabilityReporter)(limitedDB{})
+
+func (l limitedDB) Capabilities() []types.SelectorKind {
+	return l.kinds
+}
+
+func TestNewFallbackDB(t *testing.T) {
+	assert := assert.New(t)
+
+	mockDB := mocks.NewDB(t)
+	assert.Same(mockDB, db.NewFallbackDB(mockDB))
+
+	all := limitedDB{DB: mockDB, kinds: types.SelectorKinds}
+	assert.Equal(all, db.NewFallbackDB(all))
+
+	limited := limitedDB{DB: mockDB, kinds: []types.SelectorKind{types.HasTagKind}}
+	assert.IsType(&db.FallbackDB{}, db.NewFallbackDB(limited))
+}
+
+func TestFallbackDB(t *testing.T) {
+	assert := assert.New(t)
+
+	skiing := types.HasTag{Tag: types.Tag{Path: []string{"activity", "skiing"}}}
+	kayaking := types.HasTag{Tag: types.Tag{Path: []string{"activity", "kayaking"}}}
+	fourStars := types.HasRating{Operator: types.GreaterThanOrEqual, Rating: 4}
+	album := types.Album{Path: []string{"photos"}}
+	subAlbum := types.Album{Path: []string{"photos", "2022"}}
+
+	p1 := types.Photo{Path: "/photos/1.jpg", ID: "1"}
+	p2 := types.Photo{Path: "/photos/2.jpg", ID: "2"}
+	p3 := types.Photo{Path: "/photos/2022/3.jpg", ID: "3"}
+
+	mockDB := mocks.NewDB(t)
+	mockDB.On("PhotoMetadata", mock.Anything, p1).Return(types.PhotoMetadata{Tags: []types.Tag{skiing.Tag}, Rating: rating(5), Taken: time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC), Lens: "Tokina"}, nil)
+	mockDB.On("PhotoMetadata", mock.Anything, p2).Return(types.PhotoMetadata{Tags: []types.Tag{skiing.Tag, kayaking.Tag}, Rating: rating(2)}, nil)
+	mockDB.On("PhotoMetadata", mock.Anything, p3).Return(types.PhotoMetadata{Tags: []types.Tag{kayaking.Tag}, Rating: rating(4), Taken: time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC)}, nil)
+
+	ctx := context.Background()
+	f := db.NewFallbackDB(limitedDB{DB: mockDB, kinds: []types.SelectorKind{types.HasTagKind, types.InAlbumKind, types.DifferenceKind}})
+
+	// Selectors the DB supports are passed straight through.
+	native := types.Query{Selector: types.Difference{Starting: skiing, Excluding: kayaking}}
+	mockDB.On("Photos", mock.Anything, native).Return([]types.Photo{p1}, nil).Once()
+	photos, err := f.Photos(ctx, native)
+	assert.Nil(err)
+	assert.Equal([]types.Photo{p1}, photos)
+
+	// Otherwise the candidates are narrowed down by the DB and the rest is
+	// evaluated in memory.
+	mockDB.On("Photos", mock.Anything, types.Query{Selector: skiing}).Return([]types.Photo{p1, p2}, nil).Times(5)
+	q := types.Query{Selector: types.And{Operands: []types.Selector{skiing, fourStars}}}
+
+	photos, err = f.Photos(ctx, q)
+	assert.Nil(err)
+	assert.Equal([]types.Photo{p1}, photos)
+
+	tags, err := f.PhotoTags(ctx, q)
+	assert.Nil(err)
+	assert.Equal([]types.Tag{skiing.Tag}, tags)
+
+	years, err := f.TakenYears(ctx, q)
+	assert.Nil(err)
+	assert.Equal([]int{2021}, years)
+
+	// Photos are counted in memory, even though the DB can't count them.
+	tagCounts, err := f.(db.Counter).TagCounts(ctx, q)
+	assert.Nil(err)
+	assert.Equal([]types.TagCount{{Tag: skiing.Tag, Photos: 1}}, tagCounts)
+
+	ratingCounts, err := f.(db.Counter).RatingCounts(ctx, q)
+	assert.Nil(err)
+	assert.Equal([]types.RatingCount{{Rating: 5, Photos: 1}}, ratingCounts)
+
+	// If the candidates can't be narrowed down then every photo is a
+	// candidate. Supported parts of the selector are still queried from the
+	// DB.
+	mockDB.On("Albums", mock.Anything).Return([]types.Album{album}, nil).Once()
+	mockDB.On("ChildAlbums", mock.Anything, album).Return([]types.Album{subAlbum}, nil).Once()
+	mockDB.On("ChildAlbums", mock.Anything, subAlbum).Return([]types.Album{}, nil).Once()
+	mockDB.On("Photos", mock.Anything, types.Query{Selector: types.InAlbum{Album: album}}).Return([]types.Photo{p1, p2}, nil).Once()
+	mockDB.On("Photos", mock.Anything, types.Query{Selector: types.InAlbum{Album: subAlbum}}).Return([]types.Photo{p3}, nil).Once()
+	mockDB.On("Photos", mock.Anything, types.Query{Selector: kayaking}).Return([]types.Photo{p2, p3}, nil).Once()
+	photos, err = f.Photos(ctx, types.Query{Selector: types.Or{Operands: []types.Selector{
+		types.Difference{Starting: fourStars, Excluding: kayaking},
+		types.TakenOn{Year: 2022},
+	}}})
+	assert.Nil(err)
+	assert.Equal([]types.Photo{p1, p3}, photos)
+
+	// Every kind of selector can be evaluated in memory.
+	mockDB.On("Photos", mock.Anything, types.Query{Selector: skiing}).Return([]types.Photo{p1, p2}, nil).Once()
+	photos, err = f.Photos(ctx, types.Query{Selector: types.And{Operands: []types.Selector{
+		skiing,
+		types.HasLens{Lens: "Tokina"},
+	}}})
+	assert.Nil(err)
+	assert.Equal([]types.Photo{p1}, photos)
+}
+
+func rating(r float64) *float64 {
+	return &r
+}
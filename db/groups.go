@@ -0,0 +1,39 @@
+package db
+
+import (
+	"context"
+
+	"github.com/anitschke/photo-db-fs/types"
+)
+
+// Grouper can optionally be implemented by a DB that is able to get the
+// albums, cameras and lenses of the photos selected by a query all at once.
+// This is used to find which albums, cameras and lenses still have photos once
+// some photos are excluded without having to query each of them separately.
+type Grouper interface {
+	// PhotoGroups should return the distinct albums, cameras and lenses of
+	// the photos selected by the query.
+	PhotoGroups(ctx context.Context, q types.Query) (types.PhotoGroups, error)
+}
+
+// PhotoGroups gets the albums, cameras and lenses of the photos selected by
+// the query in one go if the DB implements Grouper, otherwise we fall back to
+// going through the metadata of each of the photos.
+func PhotoGroups(ctx context.Context, d DB, q types.Query) (types.PhotoGroups, error) {
+	if g, ok := d.(Grouper); ok {
+		return g.PhotoGroups(ctx, q)
+	}
+	photos, err := d.Photos(ctx, q)
+	if err != nil {
+		return types.PhotoGroups{}, err
+	}
+	metadata, err := PhotosMetadata(ctx, d, photos)
+	if err != nil {
+		return types.PhotoGroups{}, err
+	}
+	var groups types.PhotoGroupsBuilder
+	for _, m := range metadata {
+		groups.Add(m.Album, m.Camera, m.Lens)
+	}
+	return groups.Groups(), nil
+}
@@ -0,0 +1,60 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/anitschke/photo-db-fs/db"
+	"github.com/anitschke/photo-db-fs/db/mocks"
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// groupingDB is a mock DB that can also get the groups of photos.
+type groupingDB struct {
+	*mocks.DB
+}
+
+var _ = (db.Grouper)(groupingDB{})
+
+func (g groupingDB) PhotoGroups(ctx context.Context, q types.Query) (types.PhotoGroups, error) {
+	args := g.Called(ctx, q)
+	return args.Get(0).(types.PhotoGroups), args.Error(1)
+}
+
+func TestPhotoGroups(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	all := types.Query{Selector: types.And{}}
+	album := types.Album{Path: []string{"Pictures", "2022"}}
+	nikon := types.Camera{Make: "NIKON CORPORATION", Model: "NIKON D5500"}
+
+	// Without a Grouper the metadata of every photo is gone through, leaving
+	// out duplicates and photos the camera or lens isn't known of.
+	mockDB := mocks.NewDB(t)
+	foo := types.Photo{Path: "/photos/foo.jpg", ID: "foo"}
+	bar := types.Photo{Path: "/photos/bar.jpg", ID: "bar"}
+	baz := types.Photo{Path: "/photos/baz.jpg", ID: "baz"}
+	mockDB.On("Photos", mock.Anything, all).Return([]types.Photo{foo, bar, baz}, nil).Once()
+	mockDB.On("PhotoMetadata", mock.Anything, foo).Return(types.PhotoMetadata{Album: album, Camera: nikon, Lens: "wide"}, nil).Once()
+	mockDB.On("PhotoMetadata", mock.Anything, bar).Return(types.PhotoMetadata{Album: album, Camera: nikon, Lens: "tele"}, nil).Once()
+	mockDB.On("PhotoMetadata", mock.Anything, baz).Return(types.PhotoMetadata{Album: album}, nil).Once()
+
+	groups, err := db.PhotoGroups(ctx, mockDB, all)
+	assert.Nil(err)
+	assert.Equal(types.PhotoGroups{
+		Albums:  []types.Album{album},
+		Cameras: []types.Camera{nikon},
+		Lenses:  []string{"wide", "tele"},
+	}, groups)
+
+	// But a Grouper is asked for all of them at once.
+	grouper := groupingDB{DB: mocks.NewDB(t)}
+	exp := types.PhotoGroups{Albums: []types.Album{album}}
+	grouper.On("PhotoGroups", mock.Anything, all).Return(exp, nil).Once()
+	groups, err = db.PhotoGroups(ctx, grouper, all)
+	assert.Nil(err)
+	assert.Equal(exp, groups)
+}
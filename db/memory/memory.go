@@ -0,0 +1,373 @@
+// Package memory evaluates selectors against an in-memory index of photos.
+//
+// It is meant for simple DBs that don't have a query engine of their own, they
+// can just enumerate all of their photos into an Index and let it do the rest.
+// It is also a straightforward specification of what each selector means, that
+// the translation of selectors into the query languages of other DBs can be
+// tested against.
+package memory
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/anitschke/photo-db-fs/types"
+)
+
+// Photo is a photo along with all of the properties of the photo that
+// selectors can select photos by.
+type Photo struct {
+	types.Photo
+
+	// Tags are the tags that are directly applied to the photo, the photo is
+	// not considered to have the ancestors of these tags.
+	Tags []types.Tag
+
+	// Rating is nil if the photo hasn't been rated, in which case no
+	// HasRating selector selects the photo.
+	Rating *float64
+
+	// Album is the album the photo is directly within.
+	Album types.Album
+
+	Camera types.Camera
+	Lens   string
+
+	// Taken is when the photo was taken, in the wall clock time of wherever it
+	// was taken. It is the zero time if it isn't known, in which case no
+	// TakenOn selector selects the photo.
+	Taken time.Time
+}
+
+// Index is a set of photos that selectors can be evaluated against.
+type Index struct {
+	photos []Photo
+
+	// byTag maps the key of each tag to the indices of the photos the tag is
+	// applied to, since selecting photos by tag is by far the most common.
+	byTag map[string][]int
+}
+
+// NewIndex creates an Index of the photos.
+func NewIndex(photos []Photo) *Index {
+	i := &Index{
+		photos: photos,
+		byTag:  make(map[string][]int),
+	}
+	for pi, p := range photos {
+		for _, t := range p.Tags {
+			key := tagKey(t)
+			i.byTag[key] = append(i.byTag[key], pi)
+		}
+	}
+	return i
+}
+
+// Photos gets the photos selected by the query in the order they are in the
+// index.
+func (i *Index) Photos(q types.Query) ([]types.Photo, error) {
+	selected, err := i.evaluate(q.Selector)
+	if err != nil {
+		return nil, err
+	}
+	photos := make([]types.Photo, 0)
+	for pi, ok := range selected {
+		if ok {
+			photos = append(photos, i.photos[pi].Photo)
+		}
+	}
+	return photos, nil
+}
+
+// PhotoTags gets all of the tags that are applied to at least one of the
+// photos selected by the query.
+func (i *Index) PhotoTags(q types.Query) ([]types.Tag, error) {
+	selected, err := i.evaluate(q.Selector)
+	if err != nil {
+		return nil, err
+	}
+	tags := make([]types.Tag, 0)
+	seen := make(map[string]struct{})
+	for pi, ok := range selected {
+		if !ok {
+			continue
+		}
+		for _, t := range i.photos[pi].Tags {
+			key := tagKey(t)
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			tags = append(tags, t)
+		}
+	}
+	return tags, nil
+}
+
+// TakenYears gets the distinct years in which the photos selected by the query
+// were taken.
+func (i *Index) TakenYears(q types.Query) ([]int, error) {
+	selected, err := i.evaluate(q.Selector)
+	if err != nil {
+		return nil, err
+	}
+	years := make([]int, 0)
+	seen := make(map[int]struct{})
+	for pi, ok := range selected {
+		if !ok || i.photos[pi].Taken.IsZero() {
+			continue
+		}
+		y := i.photos[pi].Taken.Year()
+		if _, ok := seen[y]; ok {
+			continue
+		}
+		seen[y] = struct{}{}
+		years = append(years, y)
+	}
+	return years, nil
+}
+
+// TagCounts gets the number of photos selected by the query that each tag is
+// directly applied to, tags that aren't applied to any of them are left out.
+func (i *Index) TagCounts(q types.Query) ([]types.TagCount, error) {
+	selected, err := i.evaluate(q.Selector)
+	if err != nil {
+		return nil, err
+	}
+	counts := make([]types.TagCount, 0)
+	positions := make(map[string]int)
+	for pi, ok := range selected {
+		if !ok {
+			continue
+		}
+		for _, t := range i.photos[pi].Tags {
+			key := tagKey(t)
+			pos, ok := positions[key]
+			if !ok {
+				pos = len(counts)
+				positions[key] = pos
+				counts = append(counts, types.TagCount{Tag: t})
+			}
+			counts[pos].Photos++
+		}
+	}
+	return counts, nil
+}
+
+// RatingCounts gets the number of photos selected by the query that have each
+// rating, photos that haven't been rated are left out.
+func (i *Index) RatingCounts(q types.Query) ([]types.RatingCount, error) {
+	selected, err := i.evaluate(q.Selector)
+	if err != nil {
+		return nil, err
+	}
+	counts := make([]types.RatingCount, 0)
+	positions := make(map[float64]int)
+	for pi, ok := range selected {
+		if !ok || i.photos[pi].Rating == nil {
+			continue
+		}
+		r := *i.photos[pi].Rating
+		pos, ok := positions[r]
+		if !ok {
+			pos = len(counts)
+			positions[r] = pos
+			counts = append(counts, types.RatingCount{Rating: r})
+		}
+		counts[pos].Photos++
+	}
+	return counts, nil
+}
+
+// PhotoGroups gets the distinct albums, cameras and lenses of the photos
+// selected by the query.
+func (i *Index) PhotoGroups(q types.Query) (types.PhotoGroups, error) {
+	selected, err := i.evaluate(q.Selector)
+	if err != nil {
+		return types.PhotoGroups{}, err
+	}
+	var groups types.PhotoGroupsBuilder
+	for pi, ok := range selected {
+		if ok {
+			groups.Add(i.photos[pi].Album, i.photos[pi].Camera, i.photos[pi].Lens)
+		}
+	}
+	return groups.Groups(), nil
+}
+
+func (i *Index) evaluate(s types.Selector) (photoSet, error) {
+	if s == nil {
+		return nil, fmt.Errorf("can't evaluate a nil selector")
+	}
+	return setAccept(s, evaluator{index: i})
+}
+
+// photoSet is a set of photos in an Index, the photo at each index of the
+// Index is in the set if the value at the same index of the set is true.
+type photoSet []bool
+
+func setAccept(s types.Selector, v types.SelectorVisitor) (photoSet, error) {
+	i, err := s.Accept(v)
+	if err != nil {
+		return nil, err
+	}
+
+	set, ok := i.(photoSet)
+	if !ok {
+		return nil, fmt.Errorf("could not convert return to photoSet")
+	}
+	return set, nil
+}
+
+// evaluator is our implementation of a types.SelectorVisitor, it evaluates
+// each selector into the set of photos in the index that it selects.
+type evaluator struct {
+	index *Index
+}
+
+var _ = (types.SelectorVisitor)(evaluator{})
+
+// filter gets the set of photos that match.
+func (e evaluator) filter(match func(p Photo) bool) photoSet {
+	set := make(photoSet, len(e.index.photos))
+	for pi, p := range e.index.photos {
+		set[pi] = match(p)
+	}
+	return set
+}
+
+func (e evaluator) VisitHasTag(s types.HasTag) (interface{}, error) {
+	set := make(photoSet, len(e.index.photos))
+	for _, pi := range e.index.byTag[tagKey(s.Tag)] {
+		set[pi] = true
+	}
+	return set, nil
+}
+
+func (e evaluator) VisitHasRating(s types.HasRating) (interface{}, error) {
+	if err := s.Operator.Validate(); err != nil {
+		return nil, err
+	}
+	return e.filter(func(p Photo) bool {
+		if p.Rating == nil {
+			return false
+		}
+		r := *p.Rating
+		switch s.Operator {
+		case types.Equal:
+			return r == s.Rating
+		case types.NotEqual:
+			return r != s.Rating
+		case types.LessThan:
+			return r < s.Rating
+		case types.LessThanOrEqual:
+			return r <= s.Rating
+		case types.GreaterThan:
+			return r > s.Rating
+		default:
+			return r >= s.Rating
+		}
+	}), nil
+}
+
+func (e evaluator) VisitInAlbum(s types.InAlbum) (interface{}, error) {
+	if len(s.Album.Path) == 0 {
+		return nil, fmt.Errorf("can't select photos in an album with an empty path")
+	}
+	return e.filter(func(p Photo) bool {
+		return equalPaths(p.Album.Path, s.Album.Path)
+	}), nil
+}
+
+func (e evaluator) VisitHasCamera(s types.HasCamera) (interface{}, error) {
+	return e.filter(func(p Photo) bool {
+		return p.Camera == s.Camera
+	}), nil
+}
+
+func (e evaluator) VisitHasLens(s types.HasLens) (interface{}, error) {
+	return e.filter(func(p Photo) bool {
+		return p.Lens == s.Lens
+	}), nil
+}
+
+func (e evaluator) VisitTakenOn(s types.TakenOn) (interface{}, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return e.filter(func(p Photo) bool {
+		if p.Taken.IsZero() {
+			return false
+		}
+		year, month, day := p.Taken.Date()
+		return (s.Year == 0 || s.Year == year) &&
+			(s.Month == 0 || s.Month == month) &&
+			(s.Day == 0 || s.Day == day)
+	}), nil
+}
+
+// VisitAnd selects the photos selected by all of the operands, so an And with
+// no operands selects every photo.
+func (e evaluator) VisitAnd(s types.And) (interface{}, error) {
+	result := e.filter(func(p Photo) bool { return true })
+	for _, op := range s.Operands {
+		set, err := setAccept(op, e)
+		if err != nil {
+			return nil, fmt.Errorf("error visiting subselector: %w", err)
+		}
+		for pi := range result {
+			result[pi] = result[pi] && set[pi]
+		}
+	}
+	return result, nil
+}
+
+// VisitOr selects the photos selected by any of the operands, so an Or with no
+// operands selects nothing.
+func (e evaluator) VisitOr(s types.Or) (interface{}, error) {
+	result := make(photoSet, len(e.index.photos))
+	for _, op := range s.Operands {
+		set, err := setAccept(op, e)
+		if err != nil {
+			return nil, fmt.Errorf("error visiting subselector: %w", err)
+		}
+		for pi := range result {
+			result[pi] = result[pi] || set[pi]
+		}
+	}
+	return result, nil
+}
+
+func (e evaluator) VisitDifference(s types.Difference) (interface{}, error) {
+	starting, err := setAccept(s.Starting, e)
+	if err != nil {
+		return nil, fmt.Errorf("error visiting starting selector: %w", err)
+	}
+	excluding, err := setAccept(s.Excluding, e)
+	if err != nil {
+		return nil, fmt.Errorf("error visiting excluding selector: %w", err)
+	}
+	for pi := range starting {
+		starting[pi] = starting[pi] && !excluding[pi]
+	}
+	return starting, nil
+}
+
+func equalPaths(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func tagKey(t types.Tag) string {
+	// Tag names can contain just about any character so we use a null
+	// character as a separator since it can't be part of a tag name.
+	return strings.Join(t.Path, "\x00")
+}
@@ -0,0 +1,234 @@
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	kayaking = types.Tag{Path: []string{"activity", "watersports", "kayaking"}}
+	rafting  = types.Tag{Path: []string{"activity", "watersports", "rafting"}}
+	alice    = types.Tag{Path: []string{"People", "Alice"}}
+
+	nikon = types.Camera{Make: "NIKON CORPORATION", Model: "NIKON D5500"}
+	canon = types.Camera{Make: "Canon", Model: "EOS R"}
+)
+
+func rating(r float64) *float64 {
+	return &r
+}
+
+func testIndex() (*Index, []types.Photo) {
+	photos := []Photo{
+		{
+			Photo:  types.Photo{Path: "/photos/a/1.jpg", ID: "1"},
+			Tags:   []types.Tag{kayaking, alice},
+			Rating: rating(5),
+			Album:  types.Album{Path: []string{"photos", "a"}},
+			Camera: nikon,
+			Lens:   "Sigma",
+			Taken:  time.Date(2022, 7, 10, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			Photo:  types.Photo{Path: "/photos/a/2.jpg", ID: "2"},
+			Tags:   []types.Tag{rafting},
+			Rating: rating(0),
+			Album:  types.Album{Path: []string{"photos", "a"}},
+			Camera: nikon,
+			Lens:   "Tokina",
+			Taken:  time.Date(2022, 7, 11, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			Photo:  types.Photo{Path: "/photos/b/3.jpg", ID: "3"},
+			Tags:   []types.Tag{alice},
+			Album:  types.Album{Path: []string{"photos", "b"}},
+			Camera: canon,
+			Taken:  time.Date(2021, 11, 12, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			Photo: types.Photo{Path: "/photos/4.jpg", ID: "4"},
+			Album: types.Album{Path: []string{"photos"}},
+		},
+	}
+	result := make([]types.Photo, len(photos))
+	for i, p := range photos {
+		result[i] = p.Photo
+	}
+	return NewIndex(photos), result
+}
+
+func TestIndex_Photos(t *testing.T) {
+	index, p := testIndex()
+
+	tests := []struct {
+		name     string
+		selector types.Selector
+		expected []types.Photo
+	}{
+		{
+			name:     "HasTag",
+			selector: types.HasTag{Tag: alice},
+			expected: []types.Photo{p[0], p[2]},
+		},
+		{
+			name:     "HasTagParent",
+			selector: types.HasTag{Tag: types.Tag{Path: []string{"activity", "watersports"}}},
+			expected: []types.Photo{},
+		},
+		{
+			name:     "HasRatingEqual",
+			selector: types.HasRating{Operator: types.Equal, Rating: 5},
+			expected: []types.Photo{p[0]},
+		},
+		{
+			name:     "HasRatingNotEqual",
+			selector: types.HasRating{Operator: types.NotEqual, Rating: 5},
+			expected: []types.Photo{p[1]},
+		},
+		{
+			name:     "HasRatingLessThan",
+			selector: types.HasRating{Operator: types.LessThan, Rating: 3},
+			expected: []types.Photo{p[1]},
+		},
+		{
+			name:     "HasRatingGreaterThanOrEqual",
+			selector: types.HasRating{Operator: types.GreaterThanOrEqual, Rating: 0},
+			expected: []types.Photo{p[0], p[1]},
+		},
+		{
+			name:     "InAlbum",
+			selector: types.InAlbum{Album: types.Album{Path: []string{"photos", "a"}}},
+			expected: []types.Photo{p[0], p[1]},
+		},
+		{
+			name:     "InAlbumNotRecursive",
+			selector: types.InAlbum{Album: types.Album{Path: []string{"photos"}}},
+			expected: []types.Photo{p[3]},
+		},
+		{
+			name:     "HasCamera",
+			selector: types.HasCamera{Camera: nikon},
+			expected: []types.Photo{p[0], p[1]},
+		},
+		{
+			name:     "HasLens",
+			selector: types.HasLens{Lens: "Tokina"},
+			expected: []types.Photo{p[1]},
+		},
+		{
+			name:     "TakenOnYear",
+			selector: types.TakenOn{Year: 2022},
+			expected: []types.Photo{p[0], p[1]},
+		},
+		{
+			name:     "TakenOnDay",
+			selector: types.TakenOn{Month: 7, Day: 11},
+			expected: []types.Photo{p[1]},
+		},
+		{
+			name:     "And",
+			selector: types.And{Operands: []types.Selector{types.HasTag{Tag: alice}, types.HasCamera{Camera: nikon}}},
+			expected: []types.Photo{p[0]},
+		},
+		{
+			name:     "AndNoOperands",
+			selector: types.And{},
+			expected: p,
+		},
+		{
+			name:     "Or",
+			selector: types.Or{Operands: []types.Selector{types.HasTag{Tag: rafting}, types.HasCamera{Camera: canon}}},
+			expected: []types.Photo{p[1], p[2]},
+		},
+		{
+			name:     "OrNoOperands",
+			selector: types.Or{},
+			expected: []types.Photo{},
+		},
+		{
+			name:     "Difference",
+			selector: types.Difference{Starting: types.InAlbum{Album: types.Album{Path: []string{"photos", "a"}}}, Excluding: types.HasTag{Tag: alice}},
+			expected: []types.Photo{p[1]},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			photos, err := index.Photos(types.Query{Selector: tt.selector})
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expected, photos)
+		})
+	}
+}
+
+func TestIndex_Photos_Errors(t *testing.T) {
+	assert := assert.New(t)
+	index, _ := testIndex()
+
+	_, err := index.Photos(types.Query{})
+	assert.EqualError(err, "can't evaluate a nil selector")
+
+	_, err = index.Photos(types.Query{Selector: types.HasRating{Operator: "~", Rating: 1}})
+	assert.Error(err)
+
+	_, err = index.Photos(types.Query{Selector: types.TakenOn{Month: 13}})
+	assert.Error(err)
+
+	_, err = index.Photos(types.Query{Selector: types.And{Operands: []types.Selector{types.InAlbum{}}}})
+	assert.EqualError(err, "error visiting subselector: can't select photos in an album with an empty path")
+}
+
+func TestIndex_PhotoTags(t *testing.T) {
+	assert := assert.New(t)
+	index, _ := testIndex()
+
+	tags, err := index.PhotoTags(types.Query{Selector: types.HasCamera{Camera: nikon}})
+	assert.Nil(err)
+	assert.Equal([]types.Tag{kayaking, alice, rafting}, tags)
+
+	tags, err = index.PhotoTags(types.Query{Selector: types.Or{}})
+	assert.Nil(err)
+	assert.Equal([]types.Tag{}, tags)
+}
+
+func TestIndex_TagCounts(t *testing.T) {
+	assert := assert.New(t)
+	index, _ := testIndex()
+
+	counts, err := index.TagCounts(types.Query{Selector: types.And{}})
+	assert.Nil(err)
+	assert.Equal([]types.TagCount{{Tag: kayaking, Photos: 1}, {Tag: alice, Photos: 2}, {Tag: rafting, Photos: 1}}, counts)
+
+	counts, err = index.TagCounts(types.Query{Selector: types.HasCamera{Camera: canon}})
+	assert.Nil(err)
+	assert.Equal([]types.TagCount{{Tag: alice, Photos: 1}}, counts)
+}
+
+func TestIndex_RatingCounts(t *testing.T) {
+	assert := assert.New(t)
+	index, _ := testIndex()
+
+	counts, err := index.RatingCounts(types.Query{Selector: types.And{}})
+	assert.Nil(err)
+	assert.Equal([]types.RatingCount{{Rating: 5, Photos: 1}, {Rating: 0, Photos: 1}}, counts)
+
+	counts, err = index.RatingCounts(types.Query{Selector: types.HasCamera{Camera: canon}})
+	assert.Nil(err)
+	assert.Equal([]types.RatingCount{}, counts)
+}
+
+func TestIndex_TakenYears(t *testing.T) {
+	assert := assert.New(t)
+	index, _ := testIndex()
+
+	years, err := index.TakenYears(types.Query{Selector: types.And{}})
+	assert.Nil(err)
+	assert.Equal([]int{2022, 2021}, years)
+
+	years, err = index.TakenYears(types.Query{Selector: types.InAlbum{Album: types.Album{Path: []string{"photos"}}}})
+	assert.Nil(err)
+	assert.Equal([]int{}, years)
+}
@@ -0,0 +1,36 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/anitschke/photo-db-fs/types"
+)
+
+// MetadataBatcher can optionally be implemented by a DB that can get the
+// metadata of many photos at once much more cheaply than asking for each of
+// them with PhotoMetadata.
+type MetadataBatcher interface {
+	// PhotosMetadata should return the metadata of each of the photos in the
+	// same order as the photos. The photos are identified the same way as for
+	// PhotoMetadata.
+	PhotosMetadata(ctx context.Context, photos []types.Photo) ([]types.PhotoMetadata, error)
+}
+
+// PhotosMetadata gets the metadata of each of the photos in one batch if the DB
+// implements MetadataBatcher, otherwise we fall back to asking for the metadata
+// of one photo at a time.
+func PhotosMetadata(ctx context.Context, d DB, photos []types.Photo) ([]types.PhotoMetadata, error) {
+	if b, ok := d.(MetadataBatcher); ok {
+		return b.PhotosMetadata(ctx, photos)
+	}
+	metadata := make([]types.PhotoMetadata, len(photos))
+	for i, p := range photos {
+		m, err := d.PhotoMetadata(ctx, p)
+		if err != nil {
+			return nil, fmt.Errorf("error getting metadata of photo %q: %w", p.Path, err)
+		}
+		metadata[i] = m
+	}
+	return metadata, nil
+}
@@ -0,0 +1,55 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/anitschke/photo-db-fs/db"
+	"github.com/anitschke/photo-db-fs/db/mocks"
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// batchingDB is a DB that gets the metadata of photos in a batch.
+type batchingDB struct {
+	*mocks.DB
+	batches int
+}
+
+var _ = (db.MetadataBatcher)((*batchingDB)(nil))
+
+func (b *batchingDB) PhotosMetadata(ctx context.Context, photos []types.Photo) ([]types.PhotoMetadata, error) {
+	b.batches++
+	metadata := make([]types.PhotoMetadata, len(photos))
+	for i, p := range photos {
+		metadata[i].Caption = p.ID
+	}
+	return metadata, nil
+}
+
+func TestPhotosMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	p1 := types.Photo{Path: "/photos/1.jpg", ID: "1"}
+	p2 := types.Photo{Path: "/photos/2.jpg", ID: "2"}
+
+	// DBs that can't batch fall back to asking for one photo at a time.
+	mockDB := mocks.NewDB(t)
+	mockDB.On("PhotoMetadata", mock.Anything, p1).Return(types.PhotoMetadata{Caption: "1"}, nil).Once()
+	mockDB.On("PhotoMetadata", mock.Anything, p2).Return(types.PhotoMetadata{Caption: "2"}, nil).Once()
+	metadata, err := db.PhotosMetadata(ctx, mockDB, []types.Photo{p1, p2})
+	assert.Nil(err)
+	assert.Equal([]types.PhotoMetadata{{Caption: "1"}, {Caption: "2"}}, metadata)
+
+	mockDB.On("PhotoMetadata", mock.Anything, p1).Return(types.PhotoMetadata{}, db.ErrNotFound).Once()
+	_, err = db.PhotosMetadata(ctx, mockDB, []types.Photo{p1, p2})
+	assert.ErrorIs(err, db.ErrNotFound)
+
+	batching := &batchingDB{DB: mocks.NewDB(t)}
+	metadata, err = db.PhotosMetadata(ctx, batching, []types.Photo{p1, p2})
+	assert.Nil(err)
+	assert.Equal([]types.PhotoMetadata{{Caption: "1"}, {Caption: "2"}}, metadata)
+	assert.Equal(1, batching.batches)
+}
@@ -5,6 +5,8 @@ package mocks
 import (
 	context "context"
 
+	io "io"
+
 	mock "github.com/stretchr/testify/mock"
 
 	types "github.com/anitschke/photo-db-fs/types"
@@ -15,6 +17,89 @@ type DB struct {
 	mock.Mock
 }
 
+// AddTag provides a mock function with given fields: ctx, photo, tag
+func (_m *DB) AddTag(ctx context.Context, photo types.Photo, tag types.Tag) error {
+	ret := _m.Called(ctx, photo, tag)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, types.Photo, types.Tag) error); ok {
+		r0 = rf(ctx, photo, tag)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Albums provides a mock function with given fields: ctx
+func (_m *DB) Albums(ctx context.Context) ([]types.Album, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []types.Album
+	if rf, ok := ret.Get(0).(func(context.Context) []types.Album); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]types.Album)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Cameras provides a mock function with given fields: ctx
+func (_m *DB) Cameras(ctx context.Context) ([]types.Camera, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []types.Camera
+	if rf, ok := ret.Get(0).(func(context.Context) []types.Camera); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]types.Camera)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ChildAlbums provides a mock function with given fields: ctx, parent
+func (_m *DB) ChildAlbums(ctx context.Context, parent types.Album) ([]types.Album, error) {
+	ret := _m.Called(ctx, parent)
+
+	var r0 []types.Album
+	if rf, ok := ret.Get(0).(func(context.Context, types.Album) []types.Album); ok {
+		r0 = rf(ctx, parent)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]types.Album)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, types.Album) error); ok {
+		r1 = rf(ctx, parent)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ChildrenTags provides a mock function with given fields: ctx, parent
 func (_m *DB) ChildrenTags(ctx context.Context, parent types.Tag) ([]types.Tag, error) {
 	ret := _m.Called(ctx, parent)
@@ -38,6 +123,20 @@ func (_m *DB) ChildrenTags(ctx context.Context, parent types.Tag) ([]types.Tag,
 	return r0, r1
 }
 
+// CreateTag provides a mock function with given fields: ctx, tag
+func (_m *DB) CreateTag(ctx context.Context, tag types.Tag) error {
+	ret := _m.Called(ctx, tag)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, types.Tag) error); ok {
+		r0 = rf(ctx, tag)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Close provides a mock function with given fields:
 func (_m *DB) Close() error {
 	ret := _m.Called()
@@ -52,6 +151,50 @@ func (_m *DB) Close() error {
 	return r0
 }
 
+// Lenses provides a mock function with given fields: ctx
+func (_m *DB) Lenses(ctx context.Context) ([]string, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []string
+	if rf, ok := ret.Get(0).(func(context.Context) []string); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PhotoMetadata provides a mock function with given fields: ctx, photo
+func (_m *DB) PhotoMetadata(ctx context.Context, photo types.Photo) (types.PhotoMetadata, error) {
+	ret := _m.Called(ctx, photo)
+
+	var r0 types.PhotoMetadata
+	if rf, ok := ret.Get(0).(func(context.Context, types.Photo) types.PhotoMetadata); ok {
+		r0 = rf(ctx, photo)
+	} else {
+		r0 = ret.Get(0).(types.PhotoMetadata)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, types.Photo) error); ok {
+		r1 = rf(ctx, photo)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Photos provides a mock function with given fields: ctx, q
 func (_m *DB) Photos(ctx context.Context, q types.Query) ([]types.Photo, error) {
 	ret := _m.Called(ctx, q)
@@ -75,6 +218,52 @@ func (_m *DB) Photos(ctx context.Context, q types.Query) ([]types.Photo, error)
 	return r0, r1
 }
 
+// PhotosWithContent provides a mock function with given fields: ctx, content, size
+func (_m *DB) PhotosWithContent(ctx context.Context, content io.ReaderAt, size int64) ([]types.Photo, error) {
+	ret := _m.Called(ctx, content, size)
+
+	var r0 []types.Photo
+	if rf, ok := ret.Get(0).(func(context.Context, io.ReaderAt, int64) []types.Photo); ok {
+		r0 = rf(ctx, content, size)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]types.Photo)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, io.ReaderAt, int64) error); ok {
+		r1 = rf(ctx, content, size)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PhotoTags provides a mock function with given fields: ctx, q
+func (_m *DB) PhotoTags(ctx context.Context, q types.Query) ([]types.Tag, error) {
+	ret := _m.Called(ctx, q)
+
+	var r0 []types.Tag
+	if rf, ok := ret.Get(0).(func(context.Context, types.Query) []types.Tag); ok {
+		r0 = rf(ctx, q)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]types.Tag)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, types.Query) error); ok {
+		r1 = rf(ctx, q)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Ratings provides a mock function with given fields:
 func (_m *DB) Ratings() []float64 {
 	ret := _m.Called()
@@ -91,6 +280,20 @@ func (_m *DB) Ratings() []float64 {
 	return r0
 }
 
+// RemoveTag provides a mock function with given fields: ctx, photo, tag
+func (_m *DB) RemoveTag(ctx context.Context, photo types.Photo, tag types.Tag) error {
+	ret := _m.Called(ctx, photo, tag)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, types.Photo, types.Tag) error); ok {
+		r0 = rf(ctx, photo, tag)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // RootTags provides a mock function with given fields: ctx
 func (_m *DB) RootTags(ctx context.Context) ([]types.Tag, error) {
 	ret := _m.Called(ctx)
@@ -114,6 +317,43 @@ func (_m *DB) RootTags(ctx context.Context) ([]types.Tag, error) {
 	return r0, r1
 }
 
+// SetRating provides a mock function with given fields: ctx, photo, rating
+func (_m *DB) SetRating(ctx context.Context, photo types.Photo, rating float64) error {
+	ret := _m.Called(ctx, photo, rating)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, types.Photo, float64) error); ok {
+		r0 = rf(ctx, photo, rating)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// TakenYears provides a mock function with given fields: ctx, q
+func (_m *DB) TakenYears(ctx context.Context, q types.Query) ([]int, error) {
+	ret := _m.Called(ctx, q)
+
+	var r0 []int
+	if rf, ok := ret.Get(0).(func(context.Context, types.Query) []int); ok {
+		r0 = rf(ctx, q)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]int)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, types.Query) error); ok {
+		r1 = rf(ctx, q)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 type mockConstructorTestingTNewDB interface {
 	mock.TestingT
 	Cleanup(func())
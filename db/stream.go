@@ -0,0 +1,36 @@
+package db
+
+import (
+	"context"
+
+	"github.com/anitschke/photo-db-fs/types"
+)
+
+// PhotoStreamer can optionally be implemented by a DB that can go through the
+// photos selected by a query one at a time as it reads them, rather than
+// collecting all of them into a slice first. For queries that select a large
+// part of a big library this saves holding every photo in memory twice.
+type PhotoStreamer interface {
+	// StreamPhotos should call fn with each of the photos that Photos would
+	// return for the query. If fn returns an error then StreamPhotos should
+	// stop and return the error.
+	StreamPhotos(ctx context.Context, q types.Query, fn func(types.Photo) error) error
+}
+
+// StreamPhotos streams the photos selected by the query if the DB implements
+// PhotoStreamer, otherwise we fall back to going through the results of Photos.
+func StreamPhotos(ctx context.Context, d DB, q types.Query, fn func(types.Photo) error) error {
+	if s, ok := d.(PhotoStreamer); ok {
+		return s.StreamPhotos(ctx, q, fn)
+	}
+	photos, err := d.Photos(ctx, q)
+	if err != nil {
+		return err
+	}
+	for _, p := range photos {
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/anitschke/photo-db-fs/db"
+	"github.com/anitschke/photo-db-fs/db/mocks"
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestStreamPhotos(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	skiing := types.HasTag{Tag: types.Tag{Path: []string{"activity", "skiing"}}}
+	private := types.HasTag{Tag: types.Tag{Path: []string{"Private"}}}
+	p1 := types.Photo{Path: "/photos/1.jpg", ID: "1"}
+	p2 := types.Photo{Path: "/photos/2.jpg", ID: "2"}
+
+	// DBs that can't stream photos fall back to Photos, which still goes
+	// through the photos one at a time and stops at the first error.
+	mockDB := mocks.NewDB(t)
+	mockDB.On("Photos", mock.Anything, types.Query{Selector: skiing}).Return([]types.Photo{p1, p2}, nil).Twice()
+
+	var photos []types.Photo
+	err := db.StreamPhotos(ctx, mockDB, types.Query{Selector: skiing}, func(p types.Photo) error {
+		photos = append(photos, p)
+		return nil
+	})
+	assert.Nil(err)
+	assert.Equal([]types.Photo{p1, p2}, photos)
+
+	stop := errors.New("stop")
+	calls := 0
+	err = db.StreamPhotos(ctx, mockDB, types.Query{Selector: skiing}, func(p types.Photo) error {
+		calls++
+		return stop
+	})
+	assert.ErrorIs(err, stop)
+	assert.Equal(1, calls)
+
+	// Excluded photos are removed before streaming.
+	excluded := types.Query{Selector: types.Difference{Starting: skiing, Excluding: private}}
+	mockDB.On("Photos", mock.Anything, excluded).Return([]types.Photo{p2}, nil).Once()
+	photos = nil
+	err = db.StreamPhotos(ctx, db.NewExcludingDB(mockDB, private), types.Query{Selector: skiing}, func(p types.Photo) error {
+		photos = append(photos, p)
+		return nil
+	})
+	assert.Nil(err)
+	assert.Equal([]types.Photo{p2}, photos)
+}
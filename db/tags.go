@@ -0,0 +1,76 @@
+package db
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/anitschke/photo-db-fs/types"
+)
+
+// UnknownTags gets the tags that are used by the HasTag selectors within s but
+// don't exist in the DB. Selecting photos by a tag that doesn't exist never
+// selects anything, which is usually because of a typo in the tag, so this is
+// used to warn about it.
+func UnknownTags(ctx context.Context, d DB, s types.Selector) ([]types.Tag, error) {
+	var unknown []types.Tag
+	for _, tag := range selectorTags(s, nil) {
+		exists, err := tagExists(ctx, d, tag)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			unknown = append(unknown, tag)
+		}
+	}
+	return unknown, nil
+}
+
+// selectorTags appends the tags used by the HasTag selectors within s to tags,
+// skipping any that are already in tags.
+func selectorTags(s types.Selector, tags []types.Tag) []types.Tag {
+	switch t := s.(type) {
+	case types.HasTag:
+		for _, existing := range tags {
+			if reflect.DeepEqual(existing, t.Tag) {
+				return tags
+			}
+		}
+		return append(tags, t.Tag)
+	case types.And:
+		for _, op := range t.Operands {
+			tags = selectorTags(op, tags)
+		}
+	case types.Or:
+		for _, op := range t.Operands {
+			tags = selectorTags(op, tags)
+		}
+	case types.Difference:
+		tags = selectorTags(t.Starting, tags)
+		tags = selectorTags(t.Excluding, tags)
+	}
+	return tags
+}
+
+func tagExists(ctx context.Context, d DB, tag types.Tag) (bool, error) {
+	if len(tag.Path) == 0 {
+		return false, nil
+	}
+
+	var siblings []types.Tag
+	var err error
+	if len(tag.Path) == 1 {
+		siblings, err = d.RootTags(ctx)
+	} else {
+		siblings, err = d.ChildrenTags(ctx, types.Tag{Path: tag.Path[:len(tag.Path)-1]})
+	}
+	if err != nil {
+		return false, err
+	}
+
+	for _, s := range siblings {
+		if reflect.DeepEqual(s.Path, tag.Path) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
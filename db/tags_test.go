@@ -0,0 +1,41 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/anitschke/photo-db-fs/db"
+	"github.com/anitschke/photo-db-fs/db/mocks"
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestUnknownTags(t *testing.T) {
+	assert := assert.New(t)
+
+	activity := types.Tag{Path: []string{"activity"}}
+	skiing := types.Tag{Path: []string{"activity", "skiing"}}
+	typo := types.Tag{Path: []string{"activity", "sking"}}
+	missingRoot := types.Tag{Path: []string{"places", "beach"}}
+
+	mockDB := mocks.NewDB(t)
+	mockDB.On("RootTags", mock.Anything).Return([]types.Tag{activity}, nil).Once()
+	mockDB.On("ChildrenTags", mock.Anything, activity).Return([]types.Tag{skiing}, nil).Twice()
+	mockDB.On("ChildrenTags", mock.Anything, types.Tag{Path: []string{"places"}}).Return([]types.Tag(nil), nil).Once()
+
+	unknown, err := db.UnknownTags(context.Background(), mockDB, types.Difference{
+		Starting: types.And{Operands: []types.Selector{
+			types.HasTag{Tag: activity},
+			types.HasTag{Tag: skiing},
+			types.HasRating{Operator: types.GreaterThan, Rating: 3},
+		}},
+		Excluding: types.Or{Operands: []types.Selector{
+			types.HasTag{Tag: typo},
+			types.HasTag{Tag: missingRoot},
+			types.HasTag{Tag: typo},
+		}},
+	})
+	assert.Nil(err)
+	assert.Equal([]types.Tag{typo, missingRoot}, unknown)
+}
@@ -0,0 +1,214 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/anitschke/photo-db-fs/types"
+	"go.uber.org/zap"
+)
+
+// ChangeScope is a coarse description of what changed in the DB. It is a bit
+// mask so a single ChangeEvent can cover multiple kinds of changes.
+type ChangeScope int
+
+const (
+	// TagsChanged means the tag hierarchy changed or tags were applied to or
+	// removed from photos.
+	TagsChanged ChangeScope = 1 << iota
+
+	// PhotoMetadataChanged means metadata of existing photos changed, such as
+	// ratings, captions, dates or camera information.
+	PhotoMetadataChanged
+
+	// PhotosChanged means photos were added, removed or moved.
+	PhotosChanged
+
+	// AllChanged is used when the DB knows something changed but not what.
+	AllChanged = TagsChanged | PhotoMetadataChanged | PhotosChanged
+)
+
+func (s ChangeScope) String() string {
+	var names []string
+	if s&TagsChanged != 0 {
+		names = append(names, "tags")
+	}
+	if s&PhotoMetadataChanged != 0 {
+		names = append(names, "photoMetadata")
+	}
+	if s&PhotosChanged != 0 {
+		names = append(names, "photos")
+	}
+	return strings.Join(names, "|")
+}
+
+// ChangeEvent is sent by a Watcher when the DB changes.
+type ChangeEvent struct {
+	Scope ChangeScope
+}
+
+// Watcher can optionally be implemented by a DB that is able to tell when it
+// has been modified, for example by the photo manager that owns the database.
+type Watcher interface {
+	// Watch should send a ChangeEvent on the returned channel every time the
+	// DB changes until ctx is done, at which point the channel should be
+	// closed.
+	//
+	// Backends that need to poll for changes should check every interval,
+	// backends that are notified of changes may ignore it.
+	Watch(ctx context.Context, interval time.Duration) (<-chan ChangeEvent, error)
+}
+
+// Watch watches the DB for changes. If the DB implements Watcher then it is
+// used, otherwise we fall back to a PollingWatcher.
+func Watch(ctx context.Context, d DB, interval time.Duration) (<-chan ChangeEvent, error) {
+	if w, ok := d.(Watcher); ok {
+		return w.Watch(ctx, interval)
+	}
+	return NewPollingWatcher(d).Watch(ctx, interval)
+}
+
+// PollingWatcher is a Watcher for DBs that can't watch for changes themselves.
+//
+// If the DB is a Versioner then the version is polled and any change is
+// reported as AllChanged since we can't tell what changed. Otherwise we poll a
+// snapshot of everything the DB interface lets us list without a query: the
+// tag hierarchy for TagsChanged, the album hierarchy for PhotosChanged, and the
+// cameras and lenses for PhotoMetadataChanged. This is a lot more expensive
+// and can't see every change, for example a photo being added to an existing
+// album, so backends should implement Watcher where possible.
+type PollingWatcher struct {
+	db DB
+}
+
+var _ = (Watcher)((*PollingWatcher)(nil))
+
+func NewPollingWatcher(d DB) *PollingWatcher {
+	return &PollingWatcher{db: d}
+}
+
+func (w *PollingWatcher) Watch(ctx context.Context, interval time.Duration) (<-chan ChangeEvent, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid polling interval %v", interval)
+	}
+
+	last, err := w.snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c := make(chan ChangeEvent)
+	go func() {
+		defer close(c)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			current, err := w.snapshot(ctx)
+			if err != nil {
+				zap.L().Error("failed to poll DB for changes", zap.Error(err))
+				continue
+			}
+
+			var scope ChangeScope
+			for s, fingerprint := range current {
+				if last[s] != fingerprint {
+					scope |= s
+				}
+			}
+			last = current
+			if scope == 0 {
+				continue
+			}
+
+			select {
+			case c <- ChangeEvent{Scope: scope}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return c, nil
+}
+
+// snapshot gets a fingerprint of the DB for every ChangeScope we are able to
+// detect changes to.
+func (w *PollingWatcher) snapshot(ctx context.Context) (map[ChangeScope]string, error) {
+	if v, ok := w.db.(Versioner); ok {
+		version, err := v.Version(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return map[ChangeScope]string{AllChanged: fmt.Sprint(version)}, nil
+	}
+
+	var tags strings.Builder
+	rootTags, err := w.db.RootTags(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.writeTags(ctx, &tags, rootTags); err != nil {
+		return nil, err
+	}
+
+	var albums strings.Builder
+	rootAlbums, err := w.db.Albums(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.writeAlbums(ctx, &albums, rootAlbums); err != nil {
+		return nil, err
+	}
+
+	cameras, err := w.db.Cameras(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lenses, err := w.db.Lenses(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[ChangeScope]string{
+		TagsChanged:          tags.String(),
+		PhotosChanged:        albums.String(),
+		PhotoMetadataChanged: fmt.Sprint(cameras, lenses),
+	}, nil
+}
+
+func (w *PollingWatcher) writeTags(ctx context.Context, b *strings.Builder, tags []types.Tag) error {
+	for _, t := range tags {
+		b.WriteString(strings.Join(t.Path, "\x00"))
+		b.WriteByte('\n')
+		children, err := w.db.ChildrenTags(ctx, t)
+		if err != nil {
+			return err
+		}
+		if err := w.writeTags(ctx, b, children); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *PollingWatcher) writeAlbums(ctx context.Context, b *strings.Builder, albums []types.Album) error {
+	for _, a := range albums {
+		b.WriteString(strings.Join(a.Path, "\x00"))
+		b.WriteByte('\n')
+		children, err := w.db.ChildAlbums(ctx, a)
+		if err != nil {
+			return err
+		}
+		if err := w.writeAlbums(ctx, b, children); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/anitschke/photo-db-fs/db"
+	"github.com/anitschke/photo-db-fs/db/mocks"
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestChangeScope_String(t *testing.T) {
+	assert.Equal(t, "tags", db.TagsChanged.String())
+	assert.Equal(t, "tags|photoMetadata|photos", db.AllChanged.String())
+}
+
+func TestPollingWatcher(t *testing.T) {
+	assert := assert.New(t)
+
+	mockDB := mocks.NewDB(t)
+
+	before := []types.Tag{{Path: []string{"a"}}}
+	after := []types.Tag{{Path: []string{"a"}}, {Path: []string{"b"}}}
+
+	// The first snapshot is taken when we start watching, then the tags change
+	// on the first poll and stay the same after that.
+	mockDB.On("RootTags", mock.Anything).Return(before, nil).Once()
+	mockDB.On("RootTags", mock.Anything).Return(after, nil)
+	mockDB.On("ChildrenTags", mock.Anything, mock.Anything).Return([]types.Tag{}, nil)
+	mockDB.On("Albums", mock.Anything).Return([]types.Album{{Path: []string{"photos"}}}, nil)
+	mockDB.On("ChildAlbums", mock.Anything, mock.Anything).Return([]types.Album{}, nil)
+	mockDB.On("Cameras", mock.Anything).Return([]types.Camera{}, nil)
+	mockDB.On("Lenses", mock.Anything).Return([]string{}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes, err := db.Watch(ctx, mockDB, 10*time.Millisecond)
+	assert.Nil(err)
+
+	select {
+	case e := <-changes:
+		assert.Equal(db.ChangeEvent{Scope: db.TagsChanged}, e)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for change event")
+	}
+
+	cancel()
+	for e := range changes {
+		t.Errorf("unexpected change event %v", e)
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/anitschke/photo-db-fs
 
-go 1.19
+go 1.20
 
 require (
 	github.com/hanwen/go-fuse/v2 v2.1.0
@@ -4,6 +4,7 @@ import (
 	"context"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/anitschke/photo-db-fs/db"
 	_ "github.com/anitschke/photo-db-fs/db/digikam"
@@ -26,7 +27,7 @@ func TestDigikamIntegration(t *testing.T) {
 	defer cleanup()
 	ctx := context.Background()
 
-	db, err := db.New("digikam-sqlite", testDB)
+	db, err := db.New("digikam-sqlite", testDB, db.Options{})
 	assert.Nil(err)
 
 	queries := []types.NamedQuery{
@@ -54,7 +55,12 @@ func TestDigikamIntegration(t *testing.T) {
 		},
 	}
 
-	server, err := photofs.Mount(ctx, mountPoint, db, queries)
+	// The on this day view depends on the date, so pin it to a day that there
+	// are photos from a previous year for.
+	now := time.Date(2023, time.July, 10, 12, 0, 0, 0, time.Local)
+	opts := photofs.Options{Clock: func() time.Time { return now }}
+
+	server, err := photofs.Mount(ctx, mountPoint, db, queries, opts)
 	assert.Nil(err)
 	serverDoneWG := sync.WaitGroup{}
 	serverDoneWG.Add(1)
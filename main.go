@@ -23,13 +23,71 @@ func main() {
 		os.Exit(1)
 	}
 
-	queries, err := types.ConfigsToQueries(cfg.Queries)
+	if err := types.ValidateQueryConfigs(cfg.Queries); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	refs, err := types.NewSelectorRefs(cfg.Definitions, cfg.Queries)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	if err := types.ValidateQueryConfigs(cfg.Queries); err != nil {
+	queries, err := types.ConfigsToQueries(cfg.Queries, refs)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	currentPhoto, err := types.ConfigToCurrentPhoto(cfg.CurrentPhoto)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	refreshInterval, err := types.ConfigToRefreshInterval(cfg.RefreshInterval)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	pageSize, err := types.ConfigToPageSize(cfg.PageSize)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	maxLoadedDirs, err := types.ConfigToMaxLoadedDirs(cfg.MaxLoadedDirs)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	cache, err := types.ConfigToCache(cfg.Cache)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	exclude, err := types.ConfigToExclude(cfg.Exclude, refs)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	layout, err := types.ConfigToLayout(cfg.Layout, cfg.Templates)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if err := layout.ValidateQueryTemplates(queries); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if err := layout.ValidateQueriesAtRoot(queries); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
@@ -43,18 +101,39 @@ func main() {
 
 	ctx := context.Background()
 
-	db, err := db.New(cfg.DB.Type, cfg.DB.Source)
+	photoDB, err := db.New(cfg.DB.Type, cfg.DB.Source, db.Options{Writable: cfg.Writable})
 	if err != nil {
 		zap.L().Fatal("failed to connect to database", zap.Error(err))
 	}
 	defer func() {
-		err := db.Close()
+		err := photoDB.Close()
 		if err != nil {
 			zap.L().Error("error closing database", zap.Error(err))
 		}
 	}()
-
-	server, err := photofs.Mount(ctx, cfg.MountPoint, db, queries)
+	photoDB = db.NewFallbackDB(photoDB)
+	photoDB = db.NewCachingDB(photoDB, db.CacheOptions{TTL: cache.TTL, MaxBytes: cache.MaxBytes})
+	if c, ok := photoDB.(*db.CachingDB); ok {
+		defer func() {
+			stats := c.Stats()
+			zap.L().Info("query cache statistics",
+				zap.Int64("hits", stats.Hits),
+				zap.Int64("misses", stats.Misses),
+				zap.Int64("shared", stats.Shared))
+		}()
+	}
+	photoDB = db.NewExcludingDB(photoDB, exclude)
+
+	warnUnknownTags(ctx, photoDB, queries, exclude)
+
+	server, err := photofs.Mount(ctx, cfg.MountPoint, photoDB, queries, photofs.Options{
+		CurrentPhoto:    currentPhoto,
+		RefreshInterval: refreshInterval,
+		Writable:        cfg.Writable,
+		Layout:          &layout,
+		PageSize:        pageSize,
+		MaxLoadedDirs:   maxLoadedDirs,
+	})
 	if err != nil {
 		zap.L().Fatal("failed to mount file system", zap.Error(err))
 		return
@@ -101,6 +180,30 @@ func main() {
 	close(doneC)
 }
 
+// warnUnknownTags logs a warning for every tag that is used by the queries or
+// the exclusions but doesn't exist in the DB, since these are likely typos that
+// would otherwise just show up as empty directories.
+func warnUnknownTags(ctx context.Context, photoDB db.DB, queries []types.NamedQuery, exclude types.Selector) {
+	warn := func(what string, s types.Selector) {
+		if s == nil {
+			return
+		}
+		unknown, err := db.UnknownTags(ctx, photoDB, s)
+		if err != nil {
+			zap.L().Error("failed to check for unknown tags", zap.String("in", what), zap.Error(err))
+			return
+		}
+		for _, t := range unknown {
+			zap.L().Warn("tag does not exist in the database", zap.String("in", what), zap.Strings("tag", t.Path))
+		}
+	}
+
+	for _, q := range queries {
+		warn(fmt.Sprintf("query %q", q.Name), q.Selector)
+	}
+	warn("exclude", exclude)
+}
+
 func setupLogging(logLevel string) (*zap.Logger, error) {
 	zapConfig := zap.NewProductionConfig()
 
@@ -0,0 +1,114 @@
+package photofs
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/anitschke/photo-db-fs/db"
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// rootAlbumsNode is the top FUSE directory that contains the whole album
+// hierarchy under it.
+type rootAlbumsNode struct {
+	db   db.DB
+	opts *Options
+}
+
+var _ = (Node)((*rootAlbumsNode)(nil))
+var _ = (DirNode)((*rootAlbumsNode)(nil))
+
+func (n *rootAlbumsNode) Name() string {
+	return n.opts.viewName(types.AlbumsView)
+}
+
+func (n *rootAlbumsNode) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (n *rootAlbumsNode) INode(ctx context.Context) (fs.InodeEmbedder, error) {
+	return NewDirINode(ctx, n)
+}
+
+func (n *rootAlbumsNode) Children(ctx context.Context) (map[string]Node, error) {
+	albums, err := n.db.Albums(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return albumSliceToNodeMap(n.db, n.opts, albums)
+}
+
+type albumNodeInfo struct {
+	album types.Album
+	db    db.DB
+	opts  *Options
+}
+
+type albumNode struct {
+	albumNodeInfo
+}
+
+var _ = (Node)((*albumNode)(nil))
+var _ = (DirNode)((*albumNode)(nil))
+
+func (n *albumNode) Name() string {
+	return n.album.Name()
+}
+
+func (n *albumNode) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (n *albumNode) INode(ctx context.Context) (fs.InodeEmbedder, error) {
+	return NewDirINode(ctx, n)
+}
+
+func (n *albumNode) Children(ctx context.Context) (map[string]Node, error) {
+	albumSelector := types.InAlbum{Album: n.album}
+	childrenNodes := []Node{
+		&childAlbumsNode{albumNodeInfo: n.albumNodeInfo},
+		&ratingsParentNode{db: n.db, opts: n.opts, baseSelector: albumSelector},
+		&queryNode{db: n.db, name: "photos", query: types.Query{Selector: albumSelector}, currentPhoto: n.opts.currentPhoto(), now: n.opts.clock(), pageSize: n.opts.pageSize()},
+	}
+	ignoreDups := false
+	return nodeSliceToNodeMap(childrenNodes, ignoreDups)
+}
+
+type childAlbumsNode struct {
+	albumNodeInfo
+}
+
+var _ = (Node)((*childAlbumsNode)(nil))
+var _ = (DirNode)((*childAlbumsNode)(nil))
+
+func (n *childAlbumsNode) Name() string {
+	return "albums"
+}
+
+func (n *childAlbumsNode) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (n *childAlbumsNode) INode(ctx context.Context) (fs.InodeEmbedder, error) {
+	return NewDirINode(ctx, n)
+}
+
+func (n *childAlbumsNode) Children(ctx context.Context) (map[string]Node, error) {
+	children, err := n.db.ChildAlbums(ctx, n.album)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get albums that are children of album %q: %w", path.Join(n.album.Path...), err)
+	}
+	return albumSliceToNodeMap(n.db, n.opts, children)
+}
+
+func albumSliceToNodeMap(db db.DB, opts *Options, albumSlice []types.Album) (map[string]Node, error) {
+	nodes := make([]Node, 0, len(albumSlice))
+	for _, a := range albumSlice {
+		nodes = append(nodes, &albumNode{albumNodeInfo: albumNodeInfo{db: db, opts: opts, album: a}})
+	}
+	ignoreDups := false
+	return nodeSliceToNodeMap(nodes, ignoreDups)
+}
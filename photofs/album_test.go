@@ -0,0 +1,168 @@
+package photofs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/anitschke/photo-db-fs/db"
+	"github.com/anitschke/photo-db-fs/db/mocks"
+	"github.com/anitschke/photo-db-fs/testtools"
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func rootAlbumInode(ctx context.Context, db db.DB) (fs.InodeEmbedder, error) {
+	n := rootAlbumsNode{db: db}
+	return n.INode(ctx)
+}
+
+func makeAlbum(path ...string) types.Album {
+	return types.Album{
+		Path: path,
+	}
+}
+
+// WARNING when there are bugs in these tests they tend to deadlock
+// even with a timeout specified in the test runner. See tag_test.go for more
+// details.
+
+func TestAlbumFS(t *testing.T) {
+	assert := assert.New(t)
+
+	albumDB := mocks.NewDB(t)
+
+	ctx := context.Background()
+	albumRoot, err := rootAlbumInode(ctx, albumDB)
+	assert.NotNil(albumRoot)
+	assert.Nil(err)
+
+	mountPoint, cleanup, err := testtools.MountPoint()
+	assert.Nil(err)
+	defer cleanup()
+
+	server, err := testtools.MountTestFs(mountPoint, albumRoot)
+	assert.Nil(err)
+	serverDoneWG := sync.WaitGroup{}
+	serverDoneWG.Add(1)
+	go func() {
+		server.Wait()
+		serverDoneWG.Done()
+	}()
+
+	defer func() {
+		err := server.Unmount()
+		assert.Nil(err)
+		serverDoneWG.Wait()
+
+		// If we don't walk the DB then we should never need to ask for any
+		// albums
+		albumDB.AssertNotCalled(t, "Albums", mock.Anything)
+		albumDB.AssertNotCalled(t, "ChildAlbums", mock.Anything, mock.Anything)
+	}()
+}
+
+func TestAlbumFS_WalkPhotos(t *testing.T) {
+	assert := assert.New(t)
+
+	albumDB := mocks.NewDB(t)
+
+	albumDB.On("Albums", mock.Anything).Return(
+		[]types.Album{
+			makeAlbum("root"),
+		},
+		nil,
+	).Once()
+	albumDB.On("ChildAlbums", mock.Anything, makeAlbum("root")).Return(
+		[]types.Album{
+			makeAlbum("root", "album1"),
+			makeAlbum("root", "album2"),
+		},
+		nil,
+	).Once()
+	albumDB.On("ChildAlbums", mock.Anything, makeAlbum("root", "album1")).Return(
+		[]types.Album{},
+		nil,
+	).Once()
+	albumDB.On("ChildAlbums", mock.Anything, makeAlbum("root", "album2")).Return(
+		[]types.Album{},
+		nil,
+	).Once()
+
+	// Make the test simpler by making the DB say that it doesn't have any ratings
+	albumDB.On("Ratings").Return(
+		[]float64{},
+		nil,
+	)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		panic(err)
+	}
+
+	libraryRoot := filepath.Join(wd, "..", "test-resources", "photos", "basic")
+
+	inAlbum1 := types.Photo{
+		Path: filepath.Join(libraryRoot, "album1", "GRAND_00626.jpg"),
+		ID:   "inAlbum1",
+	}
+	inAlbum2 := types.Photo{
+		Path: filepath.Join(libraryRoot, "album2", "DSC_0196.jpg"),
+		ID:   "inAlbum2",
+	}
+
+	albumDB.On("Photos", mock.Anything, types.Query{Selector: types.InAlbum{Album: makeAlbum("root")}}).Return(
+		[]types.Photo{},
+		nil,
+	).Once()
+	albumDB.On("Photos", mock.Anything, types.Query{Selector: types.InAlbum{Album: makeAlbum("root", "album1")}}).Return(
+		[]types.Photo{
+			inAlbum1,
+		},
+		nil,
+	).Once()
+	albumDB.On("Photos", mock.Anything, types.Query{Selector: types.InAlbum{Album: makeAlbum("root", "album2")}}).Return(
+		[]types.Photo{
+			inAlbum2,
+		},
+		nil,
+	).Once()
+
+	ctx := context.Background()
+	albumRoot, err := rootAlbumInode(ctx, albumDB)
+	assert.NotNil(albumRoot)
+	assert.Nil(err)
+
+	mountPoint, cleanup, err := testtools.MountPoint()
+	assert.Nil(err)
+	defer cleanup()
+
+	server, err := testtools.MountTestFs(mountPoint, albumRoot)
+	assert.Nil(err)
+	serverDoneWG := sync.WaitGroup{}
+	serverDoneWG.Add(1)
+	go func() {
+		server.Wait()
+		serverDoneWG.Done()
+	}()
+
+	defer func() {
+		err := server.Unmount()
+		assert.Nil(err)
+		serverDoneWG.Wait()
+	}()
+
+	actTreeInfo, err := testtools.Walk(mountPoint)
+	assert.Nil(err)
+
+	testtools.VerifyJpegAreValid(t, actTreeInfo)
+
+	testtools.ToGoldFileFormat(actTreeInfo, mountPoint, libraryRoot)
+	updateGold := false
+	expTreeInfo := testtools.GetOrUpdateGoldFile("./"+t.Name()+"_GoldTree.json", actTreeInfo, updateGold)
+	assert.ElementsMatch(actTreeInfo, expTreeInfo)
+}
@@ -0,0 +1,101 @@
+package photofs
+
+import (
+	"context"
+	"sort"
+
+	"github.com/anitschke/photo-db-fs/db"
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// rootCamerasNode is the top FUSE directory that contains a folder for every
+// camera used to take photos in the DB.
+type rootCamerasNode struct {
+	db   db.DB
+	opts *Options
+}
+
+var _ = (Node)((*rootCamerasNode)(nil))
+var _ = (DirNode)((*rootCamerasNode)(nil))
+
+func (n *rootCamerasNode) Name() string {
+	return n.opts.viewName(types.CamerasView)
+}
+
+func (n *rootCamerasNode) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (n *rootCamerasNode) INode(ctx context.Context) (fs.InodeEmbedder, error) {
+	return NewDirINode(ctx, n)
+}
+
+func (n *rootCamerasNode) Children(ctx context.Context) (map[string]Node, error) {
+	cameras, err := n.db.Cameras(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sanitizing the names could result in two different cameras having the
+	// same name, so uniqueNames adds a suffix to them. Sort the cameras first so
+	// the same camera gets the same suffix regardless of the order the DB
+	// returns them in.
+	sorted := make([]types.Camera, len(cameras))
+	copy(sorted, cameras)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Make != sorted[j].Make {
+			return sorted[i].Make < sorted[j].Make
+		}
+		return sorted[i].Model < sorted[j].Model
+	})
+
+	names := make([]string, len(sorted))
+	for i, c := range sorted {
+		names[i] = c.Name()
+	}
+	names = uniqueNames(names)
+
+	nodes := make([]Node, 0, len(sorted))
+	for i, c := range sorted {
+		nodes = append(nodes, &cameraNode{db: n.db, opts: n.opts, camera: c, name: names[i]})
+	}
+
+	ignoreDups := false
+	return nodeSliceToNodeMap(nodes, ignoreDups)
+}
+
+type cameraNode struct {
+	camera types.Camera
+	db     db.DB
+	opts   *Options
+
+	// name is the sanitized name of the camera, see rootCamerasNode.Children.
+	name string
+}
+
+var _ = (Node)((*cameraNode)(nil))
+var _ = (DirNode)((*cameraNode)(nil))
+
+func (n *cameraNode) Name() string {
+	return n.name
+}
+
+func (n *cameraNode) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (n *cameraNode) INode(ctx context.Context) (fs.InodeEmbedder, error) {
+	return NewDirINode(ctx, n)
+}
+
+func (n *cameraNode) Children(ctx context.Context) (map[string]Node, error) {
+	cameraSelector := types.HasCamera{Camera: n.camera}
+	childrenNodes := []Node{
+		&ratingsParentNode{db: n.db, opts: n.opts, baseSelector: cameraSelector},
+		&queryNode{db: n.db, name: "photos", query: types.Query{Selector: cameraSelector}, currentPhoto: n.opts.currentPhoto(), now: n.opts.clock(), pageSize: n.opts.pageSize()},
+	}
+	ignoreDups := false
+	return nodeSliceToNodeMap(childrenNodes, ignoreDups)
+}
@@ -0,0 +1,153 @@
+package photofs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/anitschke/photo-db-fs/db"
+	"github.com/anitschke/photo-db-fs/db/mocks"
+	"github.com/anitschke/photo-db-fs/testtools"
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func rootCameraInode(ctx context.Context, db db.DB) (fs.InodeEmbedder, error) {
+	n := rootCamerasNode{db: db}
+	return n.INode(ctx)
+}
+
+// WARNING when there are bugs in these tests they tend to deadlock
+// even with a timeout specified in the test runner. See tag_test.go for more
+// details.
+
+func TestCameraFS_WalkPhotos(t *testing.T) {
+	assert := assert.New(t)
+
+	mockDB := mocks.NewDB(t)
+
+	nikon := types.Camera{Make: "NIKON CORPORATION", Model: "NIKON D5500"}
+	// The make shouldn't be repeated if the model already starts with it
+	canon := types.Camera{Make: "Canon", Model: "Canon EOS R5"}
+	// Names need to be sanitized before they can be used as a file name
+	slashes := types.Camera{Make: "Acme", Model: "Model 1/2"}
+
+	mockDB.On("Cameras", mock.Anything).Return(
+		[]types.Camera{
+			nikon,
+			canon,
+			slashes,
+		},
+		nil,
+	).Once()
+
+	// Make the test simpler by making the DB say that it doesn't have any ratings
+	mockDB.On("Ratings").Return(
+		[]float64{},
+		nil,
+	)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		panic(err)
+	}
+
+	libraryRoot := filepath.Join(wd, "..", "test-resources", "photos", "basic")
+
+	takenByNikon := types.Photo{
+		Path: filepath.Join(libraryRoot, "album1", "GRAND_00626.jpg"),
+		ID:   "takenByNikon",
+	}
+	takenByCanon := types.Photo{
+		Path: filepath.Join(libraryRoot, "album1", "GRAND_00626.jpg"),
+		ID:   "takenByCanon",
+	}
+	takenBySlashes := types.Photo{
+		Path: filepath.Join(libraryRoot, "album2", "DSC_0196.jpg"),
+		ID:   "takenBySlashes",
+	}
+
+	mockDB.On("Photos", mock.Anything, types.Query{Selector: types.HasCamera{Camera: nikon}}).Return(
+		[]types.Photo{
+			takenByNikon,
+		},
+		nil,
+	).Once()
+	mockDB.On("Photos", mock.Anything, types.Query{Selector: types.HasCamera{Camera: canon}}).Return(
+		[]types.Photo{
+			takenByCanon,
+		},
+		nil,
+	).Once()
+	mockDB.On("Photos", mock.Anything, types.Query{Selector: types.HasCamera{Camera: slashes}}).Return(
+		[]types.Photo{
+			takenBySlashes,
+		},
+		nil,
+	).Once()
+
+	ctx := context.Background()
+	cameraRoot, err := rootCameraInode(ctx, mockDB)
+	assert.NotNil(cameraRoot)
+	assert.Nil(err)
+
+	mountPoint, cleanup, err := testtools.MountPoint()
+	assert.Nil(err)
+	defer cleanup()
+
+	server, err := testtools.MountTestFs(mountPoint, cameraRoot)
+	assert.Nil(err)
+	serverDoneWG := sync.WaitGroup{}
+	serverDoneWG.Add(1)
+	go func() {
+		server.Wait()
+		serverDoneWG.Done()
+	}()
+
+	defer func() {
+		err := server.Unmount()
+		assert.Nil(err)
+		serverDoneWG.Wait()
+	}()
+
+	actTreeInfo, err := testtools.Walk(mountPoint)
+	assert.Nil(err)
+
+	testtools.VerifyJpegAreValid(t, actTreeInfo)
+
+	testtools.ToGoldFileFormat(actTreeInfo, mountPoint, libraryRoot)
+	updateGold := false
+	expTreeInfo := testtools.GetOrUpdateGoldFile("./"+t.Name()+"_GoldTree.json", actTreeInfo, updateGold)
+	assert.ElementsMatch(actTreeInfo, expTreeInfo)
+}
+
+func TestCameraFS_CollidingNames(t *testing.T) {
+	assert := assert.New(t)
+
+	// These cameras all have the same name once they are sanitized.
+	slash := types.Camera{Make: "Acme", Model: "Model 1/2"}
+	underscore := types.Camera{Make: "Acme", Model: "Model 1_2"}
+	spaces := types.Camera{Make: "Acme", Model: "Model  1_2"}
+
+	// The same camera should get the same name regardless of the order the DB
+	// returns them in.
+	for _, cameras := range [][]types.Camera{
+		{slash, underscore, spaces},
+		{spaces, underscore, slash},
+	} {
+		mockDB := mocks.NewDB(t)
+		mockDB.On("Cameras", mock.Anything).Return(cameras, nil).Once()
+
+		n := rootCamerasNode{db: mockDB}
+		children, err := n.Children(context.Background())
+		assert.Nil(err)
+		assert.Len(children, 3)
+		assert.Equal(spaces, children["Acme Model 1_2"].(*cameraNode).camera)
+		assert.Equal(slash, children["Acme Model 1_2 (2)"].(*cameraNode).camera)
+		assert.Equal(underscore, children["Acme Model 1_2 (3)"].(*cameraNode).camera)
+	}
+}
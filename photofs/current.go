@@ -0,0 +1,143 @@
+package photofs
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// currentPhotoNode is a current.<ext> symlink that points to a single photo
+// out of a directory of photos and rotates to a different photo every
+// interval. This is useful for wallpaper setters and lock screens that want a
+// single fixed path rather than a folder.
+//
+// Rather than keeping track of which photo is current with a timer the current
+// photo is computed from the current time, so the time is split up into slots
+// that are one interval long and each slot maps to a photo.
+//
+// The extension of the current photo can change when it rotates so the
+// DirINode looks it up by whatever its name is at the time rather than keeping
+// it with the rest of the children, and it expires at the end of the slot so
+// the kernel will look it up again and get the new name and target.
+type currentPhotoNode struct {
+	// photos are sorted by UniqueStableName so the order is stable regardless
+	// of the order the DB returns them in. They are shared with the photoIndex
+	// of the directory.
+	photos []types.Photo
+	config types.CurrentPhoto
+
+	// seed makes the random order different for every directory
+	seed int64
+
+	// now gets the current time, see Options.Clock.
+	now func() time.Time
+
+	// mu guards cycle and order, which are the random order of the photos for
+	// the cycle through them that was last asked for. This way the order is
+	// only shuffled once per cycle rather than every time the symlink is read.
+	mu    sync.Mutex
+	cycle int64
+	order []int
+}
+
+var _ = (Node)((*currentPhotoNode)(nil))
+var _ = (ExpiringNode)((*currentPhotoNode)(nil))
+
+func newCurrentPhotoNode(photos *photoIndex, config types.CurrentPhoto, now func() time.Time) *currentPhotoNode {
+	h := fnv.New64a()
+	for _, p := range photos.photos {
+		h.Write([]byte(p.UniqueStableName()))
+	}
+
+	return &currentPhotoNode{
+		photos: photos.photos,
+		config: config,
+		seed:   int64(h.Sum64()),
+		now:    now,
+	}
+}
+
+func (n *currentPhotoNode) Name() string {
+	return "current" + filepath.Ext(n.current().Path)
+}
+
+func (n *currentPhotoNode) Mode() uint32 {
+	return fuse.S_IFLNK
+}
+
+func (n *currentPhotoNode) Expires() time.Time {
+	next := n.slot() + 1
+	return time.Unix(0, next*int64(n.config.Interval))
+}
+
+func (n *currentPhotoNode) INode(ctx context.Context) (fs.InodeEmbedder, error) {
+	return &currentPhotoSymlink{node: n}, nil
+}
+
+func (n *currentPhotoNode) slot() int64 {
+	return n.now().UnixNano() / int64(n.config.Interval)
+}
+
+// current gets the photo that the symlink currently points to.
+func (n *currentPhotoNode) current() types.Photo {
+	slot := n.slot()
+	count := int64(len(n.photos))
+
+	switch n.config.Order {
+	case types.SequentialOrder:
+		return n.photos[slot%count]
+	default:
+		// Shuffle the photos once per cycle through all of them, that way we
+		// show every photo once before we start repeating photos.
+		order := n.shuffled(slot / count)
+		return n.photos[order[slot%count]]
+	}
+}
+
+// shuffled gets the random order of the photos for a cycle through them.
+func (n *currentPhotoNode) shuffled(cycle int64) []int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.order == nil || n.cycle != cycle {
+		n.order = rand.New(rand.NewSource(n.seed ^ cycle)).Perm(len(n.photos))
+		n.cycle = cycle
+	}
+	return n.order
+}
+
+// currentPhotoSymlink is the INode for a currentPhotoNode. Unlike a
+// fs.MemSymlink the target is computed every time the link is read, that way
+// even if something holds on to the INode past when the node expires it will
+// still see the new target.
+type currentPhotoSymlink struct {
+	fs.Inode
+	node *currentPhotoNode
+}
+
+var _ = (fs.NodeReadlinker)((*currentPhotoSymlink)(nil))
+
+func (s *currentPhotoSymlink) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
+	return []byte(s.node.current().Path), 0
+}
+
+// currentPhotoFor gets the currentPhotoNode for the photos of a directory, or
+// nil if the config doesn't turn it on.
+func currentPhotoFor(config *types.CurrentPhoto, photos *photoIndex, now func() time.Time) *currentPhotoNode {
+	if !currentPhotoEnabled(config) || photos.len() == 0 {
+		return nil
+	}
+	return newCurrentPhotoNode(photos, *config, now)
+}
+
+// currentPhotoEnabled gets if the config turns on the current photo.
+func currentPhotoEnabled(config *types.CurrentPhoto) bool {
+	return config != nil && !config.Disabled
+}
@@ -0,0 +1,180 @@
+package photofs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anitschke/photo-db-fs/db/mocks"
+	"github.com/anitschke/photo-db-fs/testtools"
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// WARNING when there are bugs in these tests they tend to deadlock
+// even with a timeout specified in the test runner. See tag_test.go for more
+// details.
+
+func currentTestPhotos() []types.Photo {
+	// Intentionally out of order to make sure the photoIndex sorts them
+	return []types.Photo{
+		{Path: "/photos/c.png", ID: "c"},
+		{Path: "/photos/a.jpg", ID: "a"},
+		{Path: "/photos/b.jpg", ID: "b"},
+	}
+}
+
+func TestCurrentPhoto_Sequential(t *testing.T) {
+	assert := assert.New(t)
+
+	var now time.Time
+	n := newCurrentPhotoNode(newPhotoIndex(nil, currentTestPhotos()), types.CurrentPhoto{Interval: time.Minute, Order: types.SequentialOrder}, func() time.Time { return now })
+
+	start := time.Unix(0, 0).Add(30 * time.Minute)
+	var names, targets []string
+	for i := 0; i < 4; i++ {
+		now = start.Add(time.Duration(i) * time.Minute)
+		names = append(names, n.Name())
+		targets = append(targets, n.current().Path)
+		assert.Equal(now.Add(time.Minute), n.Expires())
+	}
+
+	assert.Equal([]string{"current.jpg", "current.jpg", "current.png", "current.jpg"}, names)
+	assert.Equal([]string{"/photos/a.jpg", "/photos/b.jpg", "/photos/c.png", "/photos/a.jpg"}, targets)
+}
+
+func TestCurrentPhoto_RandomShowsEveryPhotoEachCycle(t *testing.T) {
+	assert := assert.New(t)
+
+	photos := currentTestPhotos()
+	var now time.Time
+	n := newCurrentPhotoNode(newPhotoIndex(nil, photos), types.CurrentPhoto{Interval: time.Hour, Order: types.RandomOrder}, func() time.Time { return now })
+
+	for cycle := 0; cycle < 5; cycle++ {
+		seen := map[string]struct{}{}
+		var order []int
+		for i := 0; i < len(photos); i++ {
+			now = time.Unix(0, 0).Add(time.Duration(cycle*len(photos)+i) * time.Hour)
+			seen[n.current().Path] = struct{}{}
+
+			// The photos are only shuffled once per cycle.
+			if order == nil {
+				order = n.order
+			}
+			assert.Same(&order[0], &n.order[0])
+		}
+		assert.Len(seen, len(photos))
+	}
+}
+
+func TestCurrentPhoto_Expires(t *testing.T) {
+	now := func() time.Time { return time.Date(2023, time.July, 10, 12, 34, 56, 0, time.UTC) }
+	n := newCurrentPhotoNode(newPhotoIndex(nil, currentTestPhotos()), types.CurrentPhoto{Interval: time.Hour, Order: types.RandomOrder}, now)
+	assert.Equal(t, time.Date(2023, time.July, 10, 13, 0, 0, 0, time.UTC), n.Expires().UTC())
+}
+
+func TestCurrentPhotoFS_WalkQueries(t *testing.T) {
+	assert := assert.New(t)
+
+	mockDB := mocks.NewDB(t)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		panic(err)
+	}
+
+	libraryRoot := filepath.Join(wd, "..", "test-resources", "photos", "basic")
+
+	photo := types.Photo{
+		Path: filepath.Join(libraryRoot, "album1", "GRAND_00626.jpg"),
+		ID:   "photo",
+	}
+
+	withCurrent := types.Query{Selector: types.HasTag{Tag: types.Tag{Path: []string{"withCurrent"}}}}
+	withoutCurrent := types.Query{Selector: types.HasTag{Tag: types.Tag{Path: []string{"withoutCurrent"}}}}
+
+	mockDB.On("Photos", mock.Anything, withCurrent).Return([]types.Photo{photo}, nil).Once()
+	mockDB.On("Photos", mock.Anything, withoutCurrent).Return([]types.Photo{photo}, nil).Once()
+
+	opts := &Options{
+		CurrentPhoto: &types.CurrentPhoto{Interval: time.Minute, Order: types.SequentialOrder},
+	}
+	queries := []types.NamedQuery{
+		{Name: "withCurrent", Query: withCurrent},
+		{Name: "withoutCurrent", Query: withoutCurrent, CurrentPhoto: &types.CurrentPhoto{Disabled: true}},
+	}
+
+	ctx := context.Background()
+	n := rootQueriesNode{db: mockDB, opts: opts, queries: queries}
+	queriesRoot, err := n.INode(ctx)
+	assert.NotNil(queriesRoot)
+	assert.Nil(err)
+
+	mountPoint, cleanup, err := testtools.MountPoint()
+	assert.Nil(err)
+	defer cleanup()
+
+	server, err := testtools.MountTestFs(mountPoint, queriesRoot)
+	assert.Nil(err)
+	serverDoneWG := sync.WaitGroup{}
+	serverDoneWG.Add(1)
+	go func() {
+		server.Wait()
+		serverDoneWG.Done()
+	}()
+
+	defer func() {
+		err := server.Unmount()
+		assert.Nil(err)
+		serverDoneWG.Wait()
+	}()
+
+	actTreeInfo, err := testtools.Walk(mountPoint)
+	assert.Nil(err)
+
+	testtools.VerifyJpegAreValid(t, actTreeInfo)
+
+	testtools.ToGoldFileFormat(actTreeInfo, mountPoint, libraryRoot)
+	updateGold := false
+	expTreeInfo := testtools.GetOrUpdateGoldFile("./"+t.Name()+"_GoldTree.json", actTreeInfo, updateGold)
+	assert.ElementsMatch(actTreeInfo, expTreeInfo)
+}
+
+func TestCurrentPhoto_RatingGetsPhotosOnce(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	mockDB := mocks.NewDB(t)
+	rating := types.Query{Selector: types.HasRating{Operator: types.Equal, Rating: 3}}
+	mockDB.On("Photos", mock.Anything, rating).Return(currentTestPhotos(), nil).Once()
+
+	// The current photo rotates with the clock of the options.
+	now := time.Unix(0, 0).Add(2 * time.Minute)
+	opts := &Options{
+		CurrentPhoto: &types.CurrentPhoto{Interval: time.Minute, Order: types.SequentialOrder},
+		Clock:        func() time.Time { return now },
+	}
+	n := &ratingNode{db: mockDB, opts: opts, operator: types.Equal, rating: 3}
+	in, err := n.INode(ctx)
+	assert.Nil(err)
+	d := in.(*DirINode)
+	assert.Nil(d.load(ctx))
+	assert.NotNil(d.current)
+	assert.Equal("current.png", d.current.Name())
+	_, ok := d.lookupCurrent(d.current.Name())
+	assert.True(ok)
+
+	// The photos directory uses the photos that the rating already got.
+	in, err = d.children["photos"].INode(ctx)
+	assert.Nil(err)
+	photos := in.(*DirINode)
+	assert.Nil(photos.load(ctx))
+	assert.Equal(3, photos.photos.len())
+	assert.Equal(d.current.Name(), photos.current.Name())
+	now = now.Add(time.Minute)
+	assert.Equal("current.jpg", photos.current.Name())
+}
@@ -3,7 +3,10 @@ package photofs
 import (
 	"context"
 	"fmt"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
@@ -31,6 +34,113 @@ type Node interface {
 	INode(context.Context) (fs.InodeEmbedder, error)
 }
 
+// HiddenNode can optionally be implemented by a Node that should not be listed
+// when reading the contents of its parent directory but can still be accessed by
+// looking it up by name. This is useful for directories that would result in a
+// near endless tree if tools that recursively walk the file system (find,
+// rclone, backup tools, ...) were to descend into them.
+type HiddenNode interface {
+	Hidden() bool
+}
+
+// ExpiringNode can optionally be implemented by a Node whose contents depend on
+// when it was looked up, for example a directory that depends on the current
+// date. The kernel will only cache the node until it expires, after which it
+// will look the node up again and get a freshly created INode.
+//
+// If a DirNode implements ExpiringNode then the kernel is also told to only
+// cache failed lookups of its children until it expires.
+type ExpiringNode interface {
+	Expires() time.Time
+}
+
+// CountedNode can optionally be implemented by a directory Node that knows how
+// many photos are in it. The count is reported as the size of the directory and
+// in its link count, so `ls -l` gives a hint of which directories are worth
+// opening. It returns false if the count isn't known, for example because the
+// DB can't count photos.
+type CountedNode interface {
+	PhotoCount() (int, bool)
+}
+
+// AliasedNode can optionally be implemented by a Node whose name has extra
+// information in it, such as the number of photos, that isn't part of what the
+// Node is. The Node can also be looked up by its alias, which doesn't change
+// along with the extra information, so paths to it keep working.
+type AliasedNode interface {
+	Alias() string
+}
+
+// KeyedNode can optionally be implemented by a Node that shows more than its
+// name says, such as a directory of the photos selected by a query. The key
+// identifies what the Node shows, so that when the parent directory is
+// refreshed a child that kept its name but shows something else is replaced.
+type KeyedNode interface {
+	Key() string
+}
+
+// nodeKey identifies a child of a directory, refresh replaces the child if its
+// key changes. Nodes can't be compared directly since some of them hold funcs,
+// which never compare as equal.
+func nodeKey(c Node) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%T\x00%s\x00%o", c, c.Name(), c.Mode())
+	if a, ok := c.(AliasedNode); ok {
+		fmt.Fprintf(&b, "\x00%s", a.Alias())
+	}
+	if cn, ok := c.(CountedNode); ok {
+		count, known := cn.PhotoCount()
+		fmt.Fprintf(&b, "\x00%d,%t", count, known)
+	}
+	if h, ok := c.(HiddenNode); ok {
+		fmt.Fprintf(&b, "\x00%t", h.Hidden())
+	}
+	if k, ok := c.(KeyedNode); ok {
+		fmt.Fprintf(&b, "\x00%s", k.Key())
+	}
+	return b.String()
+}
+
+// setCountAttr reports the number of photos of a CountedNode in the attributes
+// of its directory.
+func setCountAttr(n interface{}, attr *fuse.Attr) {
+	c, ok := n.(CountedNode)
+	if !ok {
+		return
+	}
+	count, ok := c.PhotoCount()
+	if !ok {
+		return
+	}
+	attr.Size = uint64(count)
+	attr.Nlink = uint32(count) + 2
+}
+
+// minTimeout is the smallest timeout that we will give to the kernel for caching
+// an ExpiringNode. go-fuse treats a zero timeout as "use the default timeout"
+// so we can't go all the way down to zero.
+const minTimeout = time.Millisecond
+
+func timeoutUntil(t time.Time) time.Duration {
+	d := time.Until(t)
+	if d < minTimeout {
+		return minTimeout
+	}
+	return d
+}
+
+// expiringNode wraps any Node to make it an ExpiringNode
+type expiringNode struct {
+	Node
+	expires time.Time
+}
+
+var _ = (ExpiringNode)((*expiringNode)(nil))
+
+func (n *expiringNode) Expires() time.Time {
+	return n.expires
+}
+
 // DirNode is our interface for a directory.
 //
 // Note that DirNode is not a Node because we only need to be a node for
@@ -41,48 +151,326 @@ type DirNode interface {
 	Children(context.Context) (map[string]Node, error)
 }
 
+// photosDirNode can optionally be implemented by a DirNode whose children are
+// mostly photos. Rather than getting a Node for each of the photos the
+// DirINode keeps them in a photoIndex, see photoIndex for why. photoChildren
+// gets the photos along with the rest of the children, which should be the
+// same as what Children returns.
+type photosDirNode interface {
+	photoChildren(context.Context) (photoDirChildren, error)
+}
+
+// photoDirChildren are the children of a photosDirNode.
+type photoDirChildren struct {
+	// children are the children other than the photos and the current photo.
+	children map[string]Node
+
+	// photos are the photos in the directory, it is nil if the photos are
+	// split into pages instead.
+	photos *photoIndex
+
+	// current is the current.<ext> symlink of the directory, it is nil if the
+	// directory doesn't have one. Since its name changes as it rotates it is
+	// looked up by whatever its name is at the time rather than being in
+	// children.
+	current *currentPhotoNode
+}
+
+// photoDirChildrenMap puts all of the children of a photosDirNode in a map, for
+// implementing Children.
+func photoDirChildrenMap(c photoDirChildren, err error) (map[string]Node, error) {
+	if err != nil {
+		return nil, err
+	}
+	children := c.children
+	for pos := 0; pos < c.photos.len(); pos++ {
+		p := c.photos.node(pos)
+		children[p.Name()] = p
+	}
+	if c.current != nil {
+		children[c.current.Name()] = c.current
+	}
+	return children, nil
+}
+
 type DirINode struct {
 	fs.Inode
+
+	// node is the DirNode this INode was created for, we hold on to it so we
+	// can ask for the children again if the DB changes.
+	node DirNode
+
+	// mu protects everything that is loaded from the DirNode since it is
+	// loaded lazily, can be replaced by refresh and can be released by the
+	// dirLRU while the kernel is reading the directory.
+	mu sync.RWMutex
+
+	// loaded is set once the children have been loaded, see rlockLoaded.
+	loaded   bool
 	children map[string]Node
+
+	// current is the current.<ext> symlink if the DirNode is a photosDirNode
+	// that has one, it is not in children since its name isn't fixed.
+	current *currentPhotoNode
+
+	// aliases maps the alias of each child that is an AliasedNode to the
+	// child.
+	aliases map[string]Node
+
+	// photos are the photos in the directory if the DirNode is a
+	// photosDirNode, these are not in children.
+	photos *photoIndex
+
+	// expiring is set if the DirNode this INode was created for is an
+	// ExpiringNode.
+	expiring ExpiringNode
+
+	// expires is when the DirNode said it would expire when the children were
+	// loaded, see expired.
+	expires time.Time
+
+	// lru limits how many directories in the tree have their children loaded,
+	// it is handed down to every DirINode that is looked up from this one. If
+	// it is nil the children are never released.
+	lru *dirLRU
 }
 
 var _ = (fs.NodeReaddirer)((*DirINode)(nil))
 
 func NewDirINode(ctx context.Context, n DirNode) (fs.InodeEmbedder, error) {
-	// We will ask for the children of this dir node once when we create the
-	// INode and then cache the results. This allows us to minimize DB lookups.
-	// We also do it here instead of an on demand caching because it allows us to
-	// avoid needing a mutex in an on demand function since concurrent calls to
-	// Lookup are possible.
+	// We don't ask for the children of this dir node until the kernel first
+	// reads the directory or looks up a child, since a lot of the time the
+	// kernel only wants to stat the directory. After that we cache the results
+	// to minimize DB lookups. The children are only asked for again if we
+	// detect that the DB has changed, see refresh, or if they were released to
+	// save memory, see dirLRU.
+	expiring, _ := n.(ExpiringNode)
+	return &DirINode{
+		node:     n,
+		expiring: expiring,
+	}, nil
+}
 
-	c, err := n.Children(ctx)
+// rlockLoaded read locks mu, loading the children first if they haven't been
+// loaded yet or if they have expired. Concurrent callers all wait for a single
+// load rather than each asking the DirNode for the children. If there is no
+// error the caller must call mu.RUnlock.
+func (n *DirINode) rlockLoaded(ctx context.Context) error {
+	n.lru.touch(n)
+	n.mu.RLock()
+	stale := n.expired()
+	for !n.loaded || stale {
+		n.mu.RUnlock()
+		if err := n.load(ctx); err != nil {
+			return err
+		}
+		stale = false
+		n.mu.RLock()
+	}
+	return nil
+}
+
+// load asks the DirNode for the children if they haven't been loaded yet or if
+// they have expired.
+func (n *DirINode) load(ctx context.Context) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.loaded && !n.expired() {
+		return nil
+	}
+	expires := n.nodeExpires()
+	c, err := dirNodeChildren(ctx, n.node)
 	if err != nil {
-		return nil, fmt.Errorf("failed to lookup directory children: %w", err)
+		return err
 	}
-	return &DirINode{
-		children: c,
-	}, nil
+	n.setChildren(c, expires)
+	return nil
+}
+
+// nodeExpires gets when the DirNode currently says it will expire, or the zero
+// time if it isn't an ExpiringNode.
+func (n *DirINode) nodeExpires() time.Time {
+	if n.expiring == nil {
+		return time.Time{}
+	}
+	return n.expiring.Expires()
+}
+
+// expired checks if the loaded children have expired, the caller must hold mu.
+//
+// The kernel looks up an ExpiringNode again once it expires, which gets a new
+// DirINode, but anything that was already holding on to the old DirINode, such
+// as a shell sitting in the directory, would otherwise keep seeing the old
+// children forever. Once an ExpiringNode expires it reports a new time that it
+// will expire at, so we know the children have expired once that changes.
+func (n *DirINode) expired() bool {
+	return n.loaded && n.expiring != nil && !n.expiring.Expires().Equal(n.expires)
+}
+
+// setChildren replaces the children, the caller must hold mu. expires is
+// what nodeExpires returned before the children were asked for.
+func (n *DirINode) setChildren(c photoDirChildren, expires time.Time) {
+	n.loaded = true
+	n.children = c.children
+	n.current = c.current
+	n.aliases = nodeAliases(c.children)
+	n.photos = c.photos
+	n.expires = expires
+}
+
+// unload releases the children, they are loaded again the next time they are
+// needed.
+func (n *DirINode) unload() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.loaded = false
+	n.children = nil
+	n.current = nil
+	n.aliases = nil
+	n.photos = nil
+}
+
+// nodeAliases gets the children that can be looked up by an alias.
+func nodeAliases(children map[string]Node) map[string]Node {
+	aliases := make(map[string]Node)
+	for _, c := range children {
+		if a, ok := c.(AliasedNode); ok {
+			aliases[a.Alias()] = c
+		}
+	}
+	return aliases
+}
+
+// nodeNames gets all of the names that a child can be looked up by.
+func nodeNames(c Node) []string {
+	names := []string{c.Name()}
+	if a, ok := c.(AliasedNode); ok && a.Alias() != c.Name() {
+		names = append(names, a.Alias())
+	}
+	return names
+}
+
+// dirNodeChildren gets the children of a DirNode, splitting out the photos
+// and current photo of a photosDirNode.
+func dirNodeChildren(ctx context.Context, n DirNode) (photoDirChildren, error) {
+	var c photoDirChildren
+	var err error
+	if p, ok := n.(photosDirNode); ok {
+		c, err = p.photoChildren(ctx)
+	} else {
+		c.children, err = n.Children(ctx)
+	}
+	if err != nil {
+		return photoDirChildren{}, fmt.Errorf("failed to lookup directory children: %w", err)
+	}
+	return c, nil
 }
 
 func (n *DirINode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
-	r := make([]fuse.DirEntry, 0, len(n.children))
-	for _, c := range n.children {
+	if err := n.rlockLoaded(ctx); err != nil {
+		zap.L().Error("error loading directory children", zap.Error(err))
+		return nil, dbERROR
+	}
+	defer n.mu.RUnlock()
+
+	r := make([]fuse.DirEntry, 0, len(n.children)+1)
+	for _, c := range n.allChildren() {
+		if h, ok := c.(HiddenNode); ok && h.Hidden() {
+			continue
+		}
 		r = append(r, fuse.DirEntry{
 			Name: c.Name(),
 			Mode: c.Mode(),
 		})
 	}
-	return fs.NewListDirStream(r), 0
+	return &dirStream{entries: r, photos: n.photos}, 0
+}
+
+// dirStream lists the entries of the children of a directory followed by its
+// photos. The kernel reads directories a page at a time, so rather than
+// creating the entries of all of the photos up front they are only created as
+// the kernel reads them.
+//
+// A photoIndex is never modified, refresh replaces it instead, so the stream
+// can keep going through the photos without holding the lock of the directory.
+type dirStream struct {
+	entries []fuse.DirEntry
+	photos  *photoIndex
+	next    int
+}
+
+var _ = (fs.DirStream)((*dirStream)(nil))
+
+func (s *dirStream) HasNext() bool {
+	return s.next < len(s.entries)+s.photos.len()
+}
+
+func (s *dirStream) Next() (fuse.DirEntry, syscall.Errno) {
+	i := s.next
+	s.next++
+	if i < len(s.entries) {
+		return s.entries[i], 0
+	}
+	return fuse.DirEntry{
+		Name: s.photos.photos[i-len(s.entries)].UniqueStableName(),
+		Mode: fuse.S_IFLNK,
+	}, 0
+}
+
+func (s *dirStream) Close() {}
+
+// allChildren gets the fixed children and the current photo, the caller must
+// hold mu.
+func (n *DirINode) allChildren() []Node {
+	all := make([]Node, 0, len(n.children)+1)
+	for _, c := range n.children {
+		all = append(all, c)
+	}
+	if n.current != nil {
+		all = append(all, n.current)
+	}
+	return all
 }
 
 var _ = (fs.NodeLookuper)((*DirINode)(nil))
 
 func (n *DirINode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	if err := n.rlockLoaded(ctx); err != nil {
+		zap.L().Error("error loading directory children", zap.Error(err))
+		return nil, dbERROR
+	}
 	c, ok := n.children[name]
 	if !ok {
+		c, ok = n.lookupCurrent(name)
+	}
+	if !ok {
+		c, ok = n.aliases[name]
+	}
+	if !ok {
+		c, ok = n.photos.lookup(name)
+	}
+	var negativeExpires time.Time
+	hasNegativeExpires := false
+	if !ok {
+		negativeExpires, hasNegativeExpires = n.negativeExpires()
+	}
+	n.mu.RUnlock()
+
+	if !ok {
+		if hasNegativeExpires {
+			out.SetEntryTimeout(timeoutUntil(negativeExpires))
+		}
 		return nil, syscall.ENOENT
 	}
 
+	if e, ok := c.(ExpiringNode); ok {
+		timeout := timeoutUntil(e.Expires())
+		out.SetEntryTimeout(timeout)
+		out.SetAttrTimeout(timeout)
+	}
+
+	setCountAttr(c, &out.Attr)
+
 	stable := fs.StableAttr{
 		Mode: c.Mode(),
 	}
@@ -92,11 +480,116 @@ func (n *DirINode) Lookup(ctx context.Context, name string, out *fuse.EntryOut)
 		zap.L().Error("error getting child INode", zap.Any("child", c), zap.Error(err))
 		return nil, dbERROR
 	}
+	if d, ok := operations.(*DirINode); ok {
+		d.lru = n.lru
+	}
 
 	childNode := n.NewInode(ctx, operations, stable)
 	return childNode, 0
 }
 
+var _ = (fs.NodeGetattrer)((*DirINode)(nil))
+
+func (n *DirINode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	setCountAttr(n.node, &out.Attr)
+	return 0
+}
+
+// refresh asks the DirNode for its children again and replaces the cached
+// children with them. It returns the names of all children that were added,
+// removed or changed, including the old and new names of the current photo
+// since we can't tell if it changed, and the aliases of any of them.
+//
+// If the children aren't loaded there is nothing to compare against, so they
+// are left to be loaded when they are next needed and every child that go-fuse
+// has an Inode for is reported as changed instead.
+func (n *DirINode) refresh(ctx context.Context) ([]string, error) {
+	n.mu.RLock()
+	loaded := n.loaded
+	n.mu.RUnlock()
+	if !loaded {
+		return n.inodeNames(), nil
+	}
+
+	expires := n.nodeExpires()
+	c, err := dirNodeChildren(ctx, n.node)
+	if err != nil {
+		return nil, err
+	}
+	children := c.children
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	// The children may have been released while we were asking for them.
+	if !n.loaded {
+		return n.inodeNames(), nil
+	}
+
+	var changed []string
+	for name, old := range n.children {
+		if c, ok := children[name]; !ok || nodeKey(c) != nodeKey(old) {
+			changed = append(changed, nodeNames(old)...)
+		}
+	}
+	for name, child := range children {
+		if _, ok := n.children[name]; !ok {
+			changed = append(changed, nodeNames(child)...)
+		}
+	}
+	if n.current != nil {
+		changed = append(changed, n.current.Name())
+	}
+	if c.current != nil {
+		changed = append(changed, c.current.Name())
+	}
+	changed = append(changed, n.photos.changed(c.photos)...)
+
+	n.setChildren(c, expires)
+	return changed, nil
+}
+
+// inodeNames gets the names of the children that go-fuse has an Inode for.
+func (n *DirINode) inodeNames() []string {
+	var names []string
+	for name := range n.Children() {
+		names = append(names, name)
+	}
+	return names
+}
+
+// lookupCurrent finds the current photo by its current name, the caller must
+// hold mu.
+func (n *DirINode) lookupCurrent(name string) (Node, bool) {
+	if n.current != nil && n.current.Name() == name {
+		return n.current, true
+	}
+	return nil, false
+}
+
+// negativeExpires gets when the kernel should stop caching a failed lookup of a
+// child. This is when the directory itself expires or when the current photo
+// could take on a new name, whichever comes first. The caller must hold mu.
+func (n *DirINode) negativeExpires() (time.Time, bool) {
+	var expires time.Time
+	found := false
+	consider := func(e ExpiringNode) {
+		t := e.Expires()
+		if !found || t.Before(expires) {
+			expires = t
+			found = true
+		}
+	}
+
+	if n.expiring != nil {
+		consider(n.expiring)
+	}
+	if n.current != nil {
+		consider(n.current)
+	}
+	return expires, found
+}
+
 func nodeSliceToNodeMap(nodeSlice []Node, ignoreDups bool) (map[string]Node, error) {
 	nodeMap := make(map[string]Node, len(nodeSlice))
 
@@ -123,3 +616,62 @@ func nodeSliceToNodeMap(nodeSlice []Node, ignoreDups bool) (map[string]Node, err
 	}
 	return nodeMap, nil
 }
+
+// uniqueNames sanitizes names with sanitizeName and adds a " (2)", " (3)", ...
+// suffix to any names that collide after sanitizing, so that every name is a
+// unique file name. The first of the colliding names keeps the plain name, so
+// names should be in a stable order to get the same file names every time.
+func uniqueNames(names []string) []string {
+	unique := make([]string, len(names))
+	taken := make(map[string]struct{}, len(names))
+
+	// Hand out the plain names first so a suffixed name can't take the plain
+	// name of a different value that happens to end in something like " (2)".
+	var collisions []int
+	for i, n := range names {
+		name := sanitizeName(n)
+		if _, ok := taken[name]; ok {
+			collisions = append(collisions, i)
+			continue
+		}
+		taken[name] = struct{}{}
+		unique[i] = name
+	}
+
+	for _, i := range collisions {
+		name := sanitizeName(names[i])
+		for suffix := 2; ; suffix++ {
+			candidate := fmt.Sprintf("%s (%d)", name, suffix)
+			if _, ok := taken[candidate]; !ok {
+				taken[candidate] = struct{}{}
+				unique[i] = candidate
+				break
+			}
+		}
+	}
+	return unique
+}
+
+// sanitizeName turns an arbitrary string that comes from the DB, such as a
+// camera model, into a valid file name.
+func sanitizeName(name string) string {
+	// A "/" would be interpreted as a directory separator and a null character
+	// isn't allowed in file names at all.
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\x00':
+			return '_'
+		}
+		return r
+	}, name)
+
+	// Metadata frequently has leading/trailing or repeated whitespace, which
+	// makes for file names that are annoying to type.
+	name = strings.Join(strings.Fields(name), " ")
+
+	switch name {
+	case "", ".", "..":
+		return "_" + name
+	}
+	return name
+}
@@ -0,0 +1,216 @@
+package photofs
+
+import (
+	"context"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/anitschke/photo-db-fs/db/mocks"
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSanitizeName(t *testing.T) {
+	assert.Equal(t, "NIKON CORPORATION NIKON D5500", sanitizeName("NIKON CORPORATION NIKON D5500"))
+	assert.Equal(t, "Tamron 18-270mm f_3.5-6.3", sanitizeName("Tamron 18-270mm f/3.5-6.3"))
+	assert.Equal(t, "Canon EOS 5D", sanitizeName("  Canon   EOS 5D "))
+	assert.Equal(t, "a_b", sanitizeName("a\x00b"))
+	assert.Equal(t, "_", sanitizeName(""))
+	assert.Equal(t, "_", sanitizeName("   "))
+	assert.Equal(t, "_.", sanitizeName("."))
+	assert.Equal(t, "_..", sanitizeName(".."))
+}
+
+func TestUniqueNames(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]string{"a", "b"}, uniqueNames([]string{"a", "b"}))
+	assert.Equal([]string{"a_b", "a_b (2)", "a_b (3)"}, uniqueNames([]string{"a/b", "a\x00b", "a_b"}))
+
+	// A suffix can't take the name of a different value.
+	assert.Equal([]string{"a_b", "a_b (2)", "a_b (3)"}, uniqueNames([]string{"a/b", "a_b (2)", "a_b"}))
+}
+
+func TestDirINode_Refresh(t *testing.T) {
+	assert := assert.New(t)
+
+	mockDB := mocks.NewDB(t)
+
+	query := types.Query{Selector: types.HasTag{Tag: types.Tag{Path: []string{"a"}}}}
+	removed := types.Photo{Path: "/photos/removed.jpg", ID: "removed"}
+	moved := types.Photo{Path: "/photos/before/moved.jpg", ID: "moved"}
+	unchanged := types.Photo{Path: "/photos/unchanged.jpg", ID: "unchanged"}
+	added := types.Photo{Path: "/photos/added.jpg", ID: "added"}
+	movedAfter := types.Photo{Path: "/photos/after/moved.jpg", ID: "moved"}
+
+	mockDB.On("Photos", mock.Anything, query).Return([]types.Photo{removed, moved, unchanged}, nil).Once()
+	mockDB.On("Photos", mock.Anything, query).Return([]types.Photo{unchanged, movedAfter, added}, nil).Once()
+
+	ctx := context.Background()
+	n := &queryNode{db: mockDB, name: "photos", query: query}
+	in, err := n.INode(ctx)
+	assert.Nil(err)
+	d := in.(*DirINode)
+
+	_, errno := d.child(ctx, "removed.jpg")
+	assert.Equal(syscall.Errno(0), errno)
+
+	changed, err := d.refresh(ctx)
+	assert.Nil(err)
+	assert.ElementsMatch([]string{"removed.jpg", "moved.jpg", "added.jpg"}, changed)
+
+	assert.Equal(3, d.photos.len())
+	c, errno := d.child(ctx, "moved.jpg")
+	assert.Equal(syscall.Errno(0), errno)
+	assert.Equal(&photoNode{photo: movedAfter, db: mockDB}, c)
+	_, errno = d.child(ctx, "removed.jpg")
+	assert.Equal(syscall.ENOENT, errno)
+}
+
+func TestDirINode_RefreshComparesKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	layout := types.DefaultLayout()
+	layout.QueriesAtRoot = true
+	n := &rootNode{
+		db:      mocks.NewDB(t),
+		queries: []types.NamedQuery{{Name: "TV", Query: types.Query{Selector: types.HasTag{Tag: makeTag("TV")}}}},
+		opts:    &Options{Layout: &layout},
+	}
+
+	ctx := context.Background()
+	in, err := NewDirINode(ctx, n)
+	assert.Nil(err)
+	d := in.(*DirINode)
+	assert.Nil(d.load(ctx))
+
+	// Children that are the same aren't changed, even if they hold funcs like
+	// on-this-day does.
+	changed, err := d.refresh(ctx)
+	assert.Nil(err)
+	assert.Empty(changed)
+
+	// A child that keeps its name but selects different photos is changed.
+	n.queries = []types.NamedQuery{{Name: "TV", Query: types.Query{Selector: types.HasTag{Tag: makeTag("Television")}}}}
+	changed, err = d.refresh(ctx)
+	assert.Nil(err)
+	assert.Equal([]string{"TV"}, changed)
+}
+
+func TestDirINode_LazyLoad(t *testing.T) {
+	assert := assert.New(t)
+
+	mockDB := mocks.NewDB(t)
+	query := types.Query{Selector: types.HasTag{Tag: types.Tag{Path: []string{"a"}}}}
+	photo := types.Photo{Path: "/photos/photo.jpg", ID: "photo"}
+
+	// Creating the INode doesn't ask for the children, and refreshing a
+	// directory whose children were never loaded doesn't either.
+	ctx := context.Background()
+	n := &queryNode{db: mockDB, name: "photos", query: query}
+	in, err := n.INode(ctx)
+	assert.Nil(err)
+	d := in.(*DirINode)
+	changed, err := d.refresh(ctx)
+	assert.Nil(err)
+	assert.Empty(changed)
+
+	// However many goroutines need the children at once they are only asked
+	// for once.
+	mockDB.On("Photos", mock.Anything, query).Return([]types.Photo{photo}, nil).Once()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errno := d.child(ctx, "photo.jpg")
+			assert.Equal(syscall.Errno(0), errno)
+		}()
+	}
+	wg.Wait()
+	mockDB.AssertNumberOfCalls(t, "Photos", 1)
+}
+
+func TestDirINode_LRU(t *testing.T) {
+	assert := assert.New(t)
+
+	mockDB := mocks.NewDB(t)
+	aQuery := types.Query{Selector: types.HasTag{Tag: types.Tag{Path: []string{"a"}}}}
+	bQuery := types.Query{Selector: types.HasTag{Tag: types.Tag{Path: []string{"b"}}}}
+	photo := types.Photo{Path: "/photos/photo.jpg", ID: "photo"}
+	mockDB.On("Photos", mock.Anything, aQuery).Return([]types.Photo{photo}, nil).Twice()
+	mockDB.On("Photos", mock.Anything, bQuery).Return([]types.Photo{photo}, nil).Once()
+
+	ctx := context.Background()
+	lru := newDirLRU(1)
+	dir := func(q types.Query) *DirINode {
+		in, err := (&queryNode{db: mockDB, name: "photos", query: q}).INode(ctx)
+		assert.Nil(err)
+		d := in.(*DirINode)
+		d.lru = lru
+		return d
+	}
+	a := dir(aQuery)
+	b := dir(bQuery)
+
+	// Using a again before anything else is loaded doesn't release it.
+	_, errno := a.child(ctx, "photo.jpg")
+	assert.Equal(syscall.Errno(0), errno)
+	_, errno = a.child(ctx, "photo.jpg")
+	assert.Equal(syscall.Errno(0), errno)
+	assert.True(a.loaded)
+
+	// Loading b releases a, which is loaded again when it is next used.
+	_, errno = b.child(ctx, "photo.jpg")
+	assert.Equal(syscall.Errno(0), errno)
+	assert.False(a.loaded)
+	assert.Nil(a.photos)
+	_, errno = a.child(ctx, "photo.jpg")
+	assert.Equal(syscall.Errno(0), errno)
+	assert.False(b.loaded)
+
+	assert.Nil(newDirLRU(0))
+}
+
+func TestDirINode_Rename(t *testing.T) {
+	assert := assert.New(t)
+
+	mockDB := mocks.NewDB(t)
+
+	photo := types.Photo{Path: "/photos/photo.jpg", ID: "photo"}
+	threeQuery := types.Query{Selector: types.HasRating{Operator: types.Equal, Rating: 3}}
+	fiveQuery := types.Query{Selector: types.HasRating{Operator: types.Equal, Rating: 5}}
+
+	mockDB.On("Photos", mock.Anything, threeQuery).Return([]types.Photo{photo}, nil).Once()
+	mockDB.On("SetRating", mock.Anything, photo, float64(5)).Return(nil).Once()
+	mockDB.On("Photos", mock.Anything, threeQuery).Return([]types.Photo{}, nil).Once()
+	mockDB.On("Photos", mock.Anything, fiveQuery).Return([]types.Photo{photo}, nil).Once()
+
+	ctx := context.Background()
+	opts := &Options{Writable: true}
+	three := &ratingPhotosNode{queryNode: queryNode{db: mockDB, name: "photos", query: threeQuery}, rating: 3, opts: opts}
+	five := &ratingPhotosNode{queryNode: queryNode{db: mockDB, name: "photos", query: fiveQuery}, rating: 5, opts: opts}
+
+	in, err := three.INode(ctx)
+	assert.Nil(err)
+	threeDir := in.(*DirINode)
+	in, err = five.INode(ctx)
+	assert.Nil(err)
+	fiveDir := in.(*DirINode)
+
+	assert.Equal(syscall.ENOENT, threeDir.Rename(ctx, "missing.jpg", fiveDir, "missing.jpg", 0))
+	assert.Equal(syscall.Errno(0), threeDir.Rename(ctx, "photo.jpg", fiveDir, "photo.jpg", 0))
+
+	_, errno := threeDir.child(ctx, "photo.jpg")
+	assert.Equal(syscall.ENOENT, errno)
+	_, errno = fiveDir.child(ctx, "photo.jpg")
+	assert.Equal(syscall.Errno(0), errno)
+
+	// Anything that isn't a MoveIntoDirNode can't be moved into.
+	q := &queryNode{db: mockDB, name: "photos", query: types.Query{Selector: types.HasRating{Operator: types.GreaterThanOrEqual, Rating: 4}}}
+	in, err = q.INode(ctx)
+	assert.Nil(err)
+	assert.Equal(syscall.EPERM, fiveDir.Rename(ctx, "photo.jpg", in, "photo.jpg", 0))
+}
@@ -0,0 +1,88 @@
+package photofs
+
+import (
+	"context"
+	"sort"
+
+	"github.com/anitschke/photo-db-fs/db"
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// rootLensesNode is the top FUSE directory that contains a folder for every
+// lens used to take photos in the DB.
+type rootLensesNode struct {
+	db   db.DB
+	opts *Options
+}
+
+var _ = (Node)((*rootLensesNode)(nil))
+var _ = (DirNode)((*rootLensesNode)(nil))
+
+func (n *rootLensesNode) Name() string {
+	return n.opts.viewName(types.LensesView)
+}
+
+func (n *rootLensesNode) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (n *rootLensesNode) INode(ctx context.Context) (fs.InodeEmbedder, error) {
+	return NewDirINode(ctx, n)
+}
+
+func (n *rootLensesNode) Children(ctx context.Context) (map[string]Node, error) {
+	lenses, err := n.db.Lenses(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// see rootCamerasNode.Children for why we sort the lenses
+	sorted := make([]string, len(lenses))
+	copy(sorted, lenses)
+	sort.Strings(sorted)
+	names := uniqueNames(sorted)
+
+	nodes := make([]Node, 0, len(sorted))
+	for i, l := range sorted {
+		nodes = append(nodes, &lensNode{db: n.db, opts: n.opts, lens: l, name: names[i]})
+	}
+
+	ignoreDups := false
+	return nodeSliceToNodeMap(nodes, ignoreDups)
+}
+
+type lensNode struct {
+	lens string
+	db   db.DB
+	opts *Options
+
+	// name is the sanitized name of the lens, see rootLensesNode.Children.
+	name string
+}
+
+var _ = (Node)((*lensNode)(nil))
+var _ = (DirNode)((*lensNode)(nil))
+
+func (n *lensNode) Name() string {
+	return n.name
+}
+
+func (n *lensNode) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (n *lensNode) INode(ctx context.Context) (fs.InodeEmbedder, error) {
+	return NewDirINode(ctx, n)
+}
+
+func (n *lensNode) Children(ctx context.Context) (map[string]Node, error) {
+	lensSelector := types.HasLens{Lens: n.lens}
+	childrenNodes := []Node{
+		&ratingsParentNode{db: n.db, opts: n.opts, baseSelector: lensSelector},
+		&queryNode{db: n.db, name: "photos", query: types.Query{Selector: lensSelector}, currentPhoto: n.opts.currentPhoto(), now: n.opts.clock(), pageSize: n.opts.pageSize()},
+	}
+	ignoreDups := false
+	return nodeSliceToNodeMap(childrenNodes, ignoreDups)
+}
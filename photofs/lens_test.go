@@ -0,0 +1,138 @@
+package photofs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/anitschke/photo-db-fs/db"
+	"github.com/anitschke/photo-db-fs/db/mocks"
+	"github.com/anitschke/photo-db-fs/testtools"
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func rootLensInode(ctx context.Context, db db.DB) (fs.InodeEmbedder, error) {
+	n := rootLensesNode{db: db}
+	return n.INode(ctx)
+}
+
+// WARNING when there are bugs in these tests they tend to deadlock
+// even with a timeout specified in the test runner. See tag_test.go for more
+// details.
+
+func TestLensFS_WalkPhotos(t *testing.T) {
+	assert := assert.New(t)
+
+	mockDB := mocks.NewDB(t)
+
+	sigma := "Sigma 18-250mm F3.5-6.3 DC OS Macro HSM"
+	// Names need to be sanitized before they can be used as a file name
+	slashes := "Acme 50mm f/1.8"
+
+	mockDB.On("Lenses", mock.Anything).Return(
+		[]string{
+			sigma,
+			slashes,
+		},
+		nil,
+	).Once()
+
+	// Make the test simpler by making the DB say that it doesn't have any ratings
+	mockDB.On("Ratings").Return(
+		[]float64{},
+		nil,
+	)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		panic(err)
+	}
+
+	libraryRoot := filepath.Join(wd, "..", "test-resources", "photos", "basic")
+
+	takenBySigma := types.Photo{
+		Path: filepath.Join(libraryRoot, "album1", "GRAND_00626.jpg"),
+		ID:   "takenBySigma",
+	}
+	takenBySlashes := types.Photo{
+		Path: filepath.Join(libraryRoot, "album2", "DSC_0196.jpg"),
+		ID:   "takenBySlashes",
+	}
+
+	mockDB.On("Photos", mock.Anything, types.Query{Selector: types.HasLens{Lens: sigma}}).Return(
+		[]types.Photo{
+			takenBySigma,
+		},
+		nil,
+	).Once()
+	mockDB.On("Photos", mock.Anything, types.Query{Selector: types.HasLens{Lens: slashes}}).Return(
+		[]types.Photo{
+			takenBySlashes,
+		},
+		nil,
+	).Once()
+
+	ctx := context.Background()
+	lensRoot, err := rootLensInode(ctx, mockDB)
+	assert.NotNil(lensRoot)
+	assert.Nil(err)
+
+	mountPoint, cleanup, err := testtools.MountPoint()
+	assert.Nil(err)
+	defer cleanup()
+
+	server, err := testtools.MountTestFs(mountPoint, lensRoot)
+	assert.Nil(err)
+	serverDoneWG := sync.WaitGroup{}
+	serverDoneWG.Add(1)
+	go func() {
+		server.Wait()
+		serverDoneWG.Done()
+	}()
+
+	defer func() {
+		err := server.Unmount()
+		assert.Nil(err)
+		serverDoneWG.Wait()
+	}()
+
+	actTreeInfo, err := testtools.Walk(mountPoint)
+	assert.Nil(err)
+
+	testtools.VerifyJpegAreValid(t, actTreeInfo)
+
+	testtools.ToGoldFileFormat(actTreeInfo, mountPoint, libraryRoot)
+	updateGold := false
+	expTreeInfo := testtools.GetOrUpdateGoldFile("./"+t.Name()+"_GoldTree.json", actTreeInfo, updateGold)
+	assert.ElementsMatch(actTreeInfo, expTreeInfo)
+}
+
+func TestLensFS_CollidingNames(t *testing.T) {
+	assert := assert.New(t)
+
+	// These lenses have the same name once they are sanitized.
+	slash := "Acme 50mm f/1.8"
+	underscore := "Acme 50mm f_1.8"
+
+	// The same lens should get the same name regardless of the order the DB
+	// returns them in.
+	for _, lenses := range [][]string{
+		{slash, underscore},
+		{underscore, slash},
+	} {
+		mockDB := mocks.NewDB(t)
+		mockDB.On("Lenses", mock.Anything).Return(lenses, nil).Once()
+
+		n := rootLensesNode{db: mockDB}
+		children, err := n.Children(context.Background())
+		assert.Nil(err)
+		assert.Len(children, 2)
+		assert.Equal(slash, children["Acme 50mm f_1.8"].(*lensNode).lens)
+		assert.Equal(underscore, children["Acme 50mm f_1.8 (2)"].(*lensNode).lens)
+	}
+}
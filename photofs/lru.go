@@ -0,0 +1,75 @@
+package photofs
+
+import (
+	"container/list"
+	"sync"
+)
+
+// dirLRU limits how many DirINodes have their children loaded at once.
+//
+// go-fuse only drops an Inode once the kernel forgets about it, and the kernel
+// will happily hold on to every directory that something like `find` walked
+// over for as long as it has memory to spare. The children of a directory can
+// be thousands of photos so that adds up quickly. Instead once more than max
+// directories have been loaded the children of the least recently used
+// directory are released, they are loaded again if the directory is used
+// again.
+//
+// The version of go-fuse that we use doesn't tell us when the kernel forgets
+// about an Inode, so the dirLRU also holds on to directories that the kernel
+// has forgotten about until they fall off the end.
+type dirLRU struct {
+	max int
+
+	mu sync.Mutex
+
+	// order is the directories from most to least recently used.
+	order *list.List
+	elems map[*DirINode]*list.Element
+}
+
+// newDirLRU creates a dirLRU that keeps the children of at most max
+// directories loaded. If max is zero there is no limit, so there is no need for
+// a dirLRU and it returns nil.
+func newDirLRU(max int) *dirLRU {
+	if max <= 0 {
+		return nil
+	}
+	return &dirLRU{
+		max:   max,
+		order: list.New(),
+		elems: make(map[*DirINode]*list.Element),
+	}
+}
+
+// touch marks the directory as the most recently used, releasing the children
+// of the least recently used directories if there are now too many. It is safe
+// to call on a nil dirLRU.
+//
+// Releasing the children of a directory locks it, so the caller must not hold
+// the lock of any directory.
+func (l *dirLRU) touch(d *DirINode) {
+	if l == nil {
+		return
+	}
+
+	var evicted []*DirINode
+	l.mu.Lock()
+	if e, ok := l.elems[d]; ok {
+		l.order.MoveToFront(e)
+	} else {
+		l.elems[d] = l.order.PushFront(d)
+	}
+	for l.order.Len() > l.max {
+		e := l.order.Back()
+		l.order.Remove(e)
+		old := e.Value.(*DirINode)
+		delete(l.elems, old)
+		evicted = append(evicted, old)
+	}
+	l.mu.Unlock()
+
+	for _, old := range evicted {
+		old.unload()
+	}
+}
@@ -22,9 +22,9 @@ import (
 	"go.uber.org/zap/zapcore"
 )
 
-func Mount(ctx context.Context, mountPoint string, db db.DB, queries []types.NamedQuery) (*fuse.Server, error) {
+func Mount(ctx context.Context, mountPoint string, db db.DB, queries []types.NamedQuery, opts Options) (*fuse.Server, error) {
 
-	root, err := NewRoot(ctx, db, queries)
+	root, err := NewRoot(ctx, db, queries, opts)
 	if err != nil {
 		zap.L().Fatal("failed to create file system", zap.Error(err))
 	}
@@ -48,22 +48,31 @@ func Mount(ctx context.Context, mountPoint string, db db.DB, queries []types.Nam
 	// so I am going to set this value to 10 min, I didn't do any special
 	// experiments to get to this value, it just feels about right.
 	//
-	// TODO long term I need to look into if there is a way we can watch the
-	// database file for changes and if there are invalidate the cache. go-fuse
-	// seems to have a way to do this with an individual files viac
-	// Inode.NotifyEntry, but I don't see a good way to ask the kernel for all
-	// of inodes it has cached so we can invalidate all of them.
+	// If the DB supports it we also watch for changes to the DB and tell the
+	// kernel to invalidate any of its cached entries that changed, see
+	// watchForChanges.
 	timeout := 10 * time.Minute
 
-	return fs.Mount(mountPoint, root, &fs.Options{
+	mountOptions := fuse.MountOptions{
+		Name: "photo-db-fs",
+	}
+	if !opts.Writable {
+		mountOptions.Options = append(mountOptions.Options, "ro")
+	}
+
+	server, err := fs.Mount(mountPoint, root, &fs.Options{
 		EntryTimeout:    &timeout,
 		AttrTimeout:     &timeout,
 		NegativeTimeout: &timeout,
 
 		Logger: fuseLogger,
 
-		MountOptions: fuse.MountOptions{
-			Name: "photo-db-fs",
-		},
+		MountOptions: mountOptions,
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	watchForChanges(ctx, server, root.EmbeddedInode(), db, opts.RefreshInterval)
+	return server, nil
 }
@@ -0,0 +1,83 @@
+package photofs
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/anitschke/photo-db-fs/db"
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// onThisDayNode is the top FUSE directory that contains photos that were taken
+// on the current month and day in any previous year, grouped into a directory
+// for each year.
+//
+// Since the contents of this directory change every day at midnight it is an
+// ExpiringNode so the kernel will only cache it, and the year directories
+// under it, until the end of the day.
+type onThisDayNode struct {
+	db   db.DB
+	opts *Options
+
+	// now gets the current local time, it is only replaced for testing.
+	now func() time.Time
+}
+
+var _ = (Node)((*onThisDayNode)(nil))
+var _ = (DirNode)((*onThisDayNode)(nil))
+var _ = (ExpiringNode)((*onThisDayNode)(nil))
+
+func (n *onThisDayNode) Name() string {
+	return n.opts.viewName(types.OnThisDayView)
+}
+
+func (n *onThisDayNode) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (n *onThisDayNode) Expires() time.Time {
+	return nextMidnight(n.now())
+}
+
+func (n *onThisDayNode) INode(ctx context.Context) (fs.InodeEmbedder, error) {
+	return NewDirINode(ctx, n)
+}
+
+func (n *onThisDayNode) Children(ctx context.Context) (map[string]Node, error) {
+	today := n.now()
+	expires := nextMidnight(today)
+
+	var years []int
+	q, ok := optimizeQuery(types.Query{Selector: types.TakenOn{Month: today.Month(), Day: today.Day()}})
+	if ok {
+		var err error
+		years, err = n.db.TakenYears(ctx, q)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get years with photos taken on this day: %w", err)
+		}
+	}
+
+	nodes := make([]Node, 0, len(years))
+	for _, y := range years {
+		if y >= today.Year() {
+			continue
+		}
+		selector := types.TakenOn{Year: y, Month: today.Month(), Day: today.Day()}
+		nodes = append(nodes, &expiringNode{
+			Node:    &queryNode{db: n.db, name: strconv.Itoa(y), query: types.Query{Selector: selector}, currentPhoto: n.opts.currentPhoto(), now: n.opts.clock(), pageSize: n.opts.pageSize()},
+			expires: expires,
+		})
+	}
+	ignoreDups := false
+	return nodeSliceToNodeMap(nodes, ignoreDups)
+}
+
+// nextMidnight gets the start of the day after t in the same location as t
+func nextMidnight(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, t.Location())
+}
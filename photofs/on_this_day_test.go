@@ -0,0 +1,134 @@
+package photofs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/anitschke/photo-db-fs/db/mocks"
+	"github.com/anitschke/photo-db-fs/testtools"
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// WARNING when there are bugs in these tests they tend to deadlock
+// even with a timeout specified in the test runner. See tag_test.go for more
+// details.
+
+func TestOnThisDayFS_Expires(t *testing.T) {
+	now := time.Date(2023, time.December, 31, 23, 59, 0, 0, time.Local)
+	n := onThisDayNode{db: mocks.NewDB(t), now: func() time.Time { return now }}
+	assert.Equal(t, time.Date(2024, time.January, 1, 0, 0, 0, 0, time.Local), n.Expires())
+}
+
+func TestOnThisDayFS_ReloadsAtMidnight(t *testing.T) {
+	assert := assert.New(t)
+
+	mockDB := mocks.NewDB(t)
+	mockDB.On("TakenYears", mock.Anything, types.Query{Selector: types.TakenOn{Month: time.July, Day: 10}}).Return([]int{2019}, nil).Once()
+	mockDB.On("TakenYears", mock.Anything, types.Query{Selector: types.TakenOn{Month: time.July, Day: 11}}).Return([]int{2020}, nil).Once()
+
+	ctx := context.Background()
+	now := time.Date(2023, time.July, 10, 23, 59, 0, 0, time.Local)
+	n := &rootNode{db: mockDB, opts: &Options{Clock: func() time.Time { return now }}}
+	in, err := n.viewNode(types.OnThisDayView).INode(ctx)
+	assert.Nil(err)
+	d := in.(*DirINode)
+
+	_, errno := d.child(ctx, "2019")
+	assert.Equal(syscall.Errno(0), errno)
+	_, errno = d.child(ctx, "2019")
+	assert.Equal(syscall.Errno(0), errno)
+
+	// Something that holds on to the directory past midnight sees the photos
+	// of the new day.
+	now = now.Add(2 * time.Minute)
+	_, errno = d.child(ctx, "2019")
+	assert.Equal(syscall.ENOENT, errno)
+	_, errno = d.child(ctx, "2020")
+	assert.Equal(syscall.Errno(0), errno)
+}
+
+func TestOnThisDayFS_WalkPhotos(t *testing.T) {
+	assert := assert.New(t)
+
+	mockDB := mocks.NewDB(t)
+
+	now := time.Date(2023, time.July, 10, 12, 0, 0, 0, time.Local)
+
+	// Photos taken today shouldn't show up since they are not from a previous
+	// year.
+	mockDB.On("TakenYears", mock.Anything, types.Query{Selector: types.TakenOn{Month: time.July, Day: 10}}).Return(
+		[]int{2019, 2021, 2023},
+		nil,
+	).Once()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		panic(err)
+	}
+
+	libraryRoot := filepath.Join(wd, "..", "test-resources", "photos", "basic")
+
+	taken2019 := types.Photo{
+		Path: filepath.Join(libraryRoot, "album1", "GRAND_00626.jpg"),
+		ID:   "taken2019",
+	}
+	taken2021 := types.Photo{
+		Path: filepath.Join(libraryRoot, "album2", "DSC_0196.jpg"),
+		ID:   "taken2021",
+	}
+
+	mockDB.On("Photos", mock.Anything, types.Query{Selector: types.TakenOn{Year: 2019, Month: time.July, Day: 10}}).Return(
+		[]types.Photo{
+			taken2019,
+		},
+		nil,
+	).Once()
+	mockDB.On("Photos", mock.Anything, types.Query{Selector: types.TakenOn{Year: 2021, Month: time.July, Day: 10}}).Return(
+		[]types.Photo{
+			taken2021,
+		},
+		nil,
+	).Once()
+
+	ctx := context.Background()
+	n := onThisDayNode{db: mockDB, now: func() time.Time { return now }}
+	onThisDayRoot, err := n.INode(ctx)
+	assert.NotNil(onThisDayRoot)
+	assert.Nil(err)
+
+	mountPoint, cleanup, err := testtools.MountPoint()
+	assert.Nil(err)
+	defer cleanup()
+
+	server, err := testtools.MountTestFs(mountPoint, onThisDayRoot)
+	assert.Nil(err)
+	serverDoneWG := sync.WaitGroup{}
+	serverDoneWG.Add(1)
+	go func() {
+		server.Wait()
+		serverDoneWG.Done()
+	}()
+
+	defer func() {
+		err := server.Unmount()
+		assert.Nil(err)
+		serverDoneWG.Wait()
+	}()
+
+	actTreeInfo, err := testtools.Walk(mountPoint)
+	assert.Nil(err)
+
+	testtools.VerifyJpegAreValid(t, actTreeInfo)
+
+	testtools.ToGoldFileFormat(actTreeInfo, mountPoint, libraryRoot)
+	updateGold := false
+	expTreeInfo := testtools.GetOrUpdateGoldFile("./"+t.Name()+"_GoldTree.json", actTreeInfo, updateGold)
+	assert.ElementsMatch(actTreeInfo, expTreeInfo)
+}
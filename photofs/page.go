@@ -0,0 +1,182 @@
+package photofs
+
+import (
+	"context"
+	"hash/fnv"
+	"io"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/anitschke/photo-db-fs/db"
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// Some programs, such as picture frames and file pickers, can't cope with
+// directories that have thousands of entries. So if a directory of photos has
+// more photos than the page size they are split up into page directories
+// instead.
+//
+// Each page is a run of the photos in order by name. Splitting the photos up
+// into runs of exactly the page size would mean that adding a single photo
+// pushes a photo from every later page onto the next page. Instead the photos
+// that start a page are picked by their names, so adding or removing a photo
+// only changes the page that it is on. Pages are half the page size on
+// average, which leaves room for photos to be added to them, and a page that
+// ends up with too many photos anyway is split evenly into as few pages as
+// will fit them. Each page is named after the first photo on it rather than
+// its position, so that the pages after a page that is split or merged keep
+// their names, and with them the paths of the photos on them.
+
+// startsPage gets if a photo starts a new page when pages have target photos
+// on average.
+func startsPage(p types.Photo, target int) bool {
+	h := fnv.New64a()
+	h.Write([]byte(p.UniqueStableName()))
+	return h.Sum64()%uint64(target) == 0
+}
+
+// pageStarts gets the position of the first photo of each page of the photos,
+// which must be sorted by name, so that no page has more than pageSize photos.
+func pageStarts(photos []types.Photo, pageSize int) []int {
+	target := pageSize / 2
+	if target < 1 {
+		target = 1
+	}
+
+	starts := []int{0}
+	split := func(from, to int) {
+		pages := (to - from + pageSize - 1) / pageSize
+		for i := 1; i < pages; i++ {
+			starts = append(starts, from+(to-from)*i/pages)
+		}
+	}
+	from := 0
+	for i := 1; i < len(photos); i++ {
+		if startsPage(photos[i], target) {
+			split(from, i)
+			starts = append(starts, i)
+			from = i
+		}
+	}
+	split(from, len(photos))
+	return starts
+}
+
+// pageNodes gets the pages that the photos of a directory are split into. The
+// photos must be sorted by name. dir is the DirNode of the directory, which
+// modifications made within the pages are passed on to.
+func pageNodes(photoDB db.DB, dir DirNode, photos []types.Photo, pageSize int) []Node {
+	starts := pageStarts(photos, pageSize)
+	nodes := make([]Node, len(starts))
+	for page, start := range starts {
+		end := len(photos)
+		if page+1 < len(starts) {
+			end = starts[page+1]
+		}
+		nodes[page] = &pageNode{
+			db:     photoDB,
+			dir:    dir,
+			photos: photos[start:end],
+		}
+	}
+	return nodes
+}
+
+// pageNode is one page of the photos of a directory that has too many photos
+// to list directly.
+type pageNode struct {
+	db db.DB
+
+	// dir is the DirNode of the directory the page is in.
+	dir DirNode
+
+	// photos are the photos on the page, sorted by name. They are a slice of
+	// the photos of the directory the page is in, so that the photos aren't
+	// queried again for each page. When the directory is refreshed it gets new
+	// pages rather than refreshing the photos of the existing ones.
+	photos []types.Photo
+}
+
+var _ = (Node)((*pageNode)(nil))
+var _ = (DirNode)((*pageNode)(nil))
+var _ = (photosDirNode)((*pageNode)(nil))
+var _ = (CountedNode)((*pageNode)(nil))
+var _ = (SymlinkDirNode)((*pageNode)(nil))
+var _ = (CreateDirNode)((*pageNode)(nil))
+var _ = (UnlinkDirNode)((*pageNode)(nil))
+var _ = (MoveIntoDirNode)((*pageNode)(nil))
+var _ = (KeyedNode)((*pageNode)(nil))
+
+// Name is the name of the first photo on the page, without the extension. The
+// photos are sorted by name, so the pages sort in the same order as the photos
+// on them.
+func (n *pageNode) Name() string {
+	first := n.photos[0].UniqueStableName()
+	return "page-" + strings.TrimSuffix(first, filepath.Ext(first))
+}
+
+func (n *pageNode) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (n *pageNode) INode(ctx context.Context) (fs.InodeEmbedder, error) {
+	return NewDirINode(ctx, n)
+}
+
+func (n *pageNode) PhotoCount() (int, bool) {
+	return len(n.photos), true
+}
+
+// Key is the names of the photos on the page, since which photos are on a page
+// can change without its name changing.
+func (n *pageNode) Key() string {
+	return photoNamesKey(n.photos)
+}
+
+func (n *pageNode) Children(ctx context.Context) (map[string]Node, error) {
+	return photoDirChildrenMap(n.photoChildren(ctx))
+}
+
+func (n *pageNode) photoChildren(ctx context.Context) (photoDirChildren, error) {
+	// The photos are already sorted and deduplicated, so they don't need to go
+	// through newPhotoIndex.
+	return photoDirChildren{
+		children: make(map[string]Node),
+		photos:   &photoIndex{db: n.db, photos: n.photos},
+	}, nil
+}
+
+func (n *pageNode) Symlink(ctx context.Context, target, name string) error {
+	s, ok := n.dir.(SymlinkDirNode)
+	if !ok {
+		return syscall.EPERM
+	}
+	return s.Symlink(ctx, target, name)
+}
+
+func (n *pageNode) Create(ctx context.Context, name string, content io.ReaderAt, size int64) error {
+	c, ok := n.dir.(CreateDirNode)
+	if !ok {
+		return syscall.EPERM
+	}
+	return c.Create(ctx, name, content, size)
+}
+
+func (n *pageNode) Unlink(ctx context.Context, child Node) error {
+	u, ok := n.dir.(UnlinkDirNode)
+	if !ok {
+		return syscall.EPERM
+	}
+	return u.Unlink(ctx, child)
+}
+
+func (n *pageNode) MoveInto(ctx context.Context, child Node, newName string) error {
+	m, ok := n.dir.(MoveIntoDirNode)
+	if !ok {
+		return syscall.EPERM
+	}
+	return m.MoveInto(ctx, child, newName)
+}
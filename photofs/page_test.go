@@ -0,0 +1,202 @@
+package photofs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/anitschke/photo-db-fs/db/mocks"
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func makePhotos(n int) []types.Photo {
+	photos := make([]types.Photo, n)
+	for i := range photos {
+		id := fmt.Sprintf("%05d", i)
+		photos[i] = types.Photo{Path: "/photos/" + id + ".jpg", ID: id}
+	}
+	return photos
+}
+
+// photoPages gets the names of the photos on each page of the photos.
+func photoPages(t *testing.T, ctx context.Context, children map[string]Node) map[string][]string {
+	pages := make(map[string][]string)
+	for name, c := range children {
+		if !strings.HasPrefix(name, "page-") {
+			continue
+		}
+		photos, err := c.(*pageNode).Children(ctx)
+		assert.Nil(t, err)
+		names := childNames(photos)
+		sort.Strings(names)
+		pages[name] = names
+	}
+	return pages
+}
+
+func TestQueryNode_Pages(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	mockDB := mocks.NewDB(t)
+	query := types.Query{Selector: types.HasTag{Tag: makeTag("a")}}
+	photos := makePhotos(1000)
+	mockDB.On("Photos", mock.Anything, query).Return(photos, nil).Twice()
+
+	// Directories with no more than a page of photos aren't split up.
+	n := &queryNode{db: mockDB, name: "photos", query: query, pageSize: 1000}
+	children, err := n.Children(ctx)
+	assert.Nil(err)
+	assert.Len(children, 1000)
+
+	n.pageSize = 100
+	children, err = n.Children(ctx)
+	assert.Nil(err)
+	pages := photoPages(t, ctx, children)
+	assert.Len(children, len(pages))
+
+	// The pages are named after their first photo, and going through them in
+	// order goes through the photos in order. Listing the pages doesn't query
+	// the photos again.
+	var pageNames []string
+	for name := range pages {
+		pageNames = append(pageNames, name)
+	}
+	sort.Strings(pageNames)
+	var all []string
+	for _, name := range pageNames {
+		names := pages[name]
+		assert.NotEmpty(names)
+		assert.Equal("page-"+strings.TrimSuffix(names[0], ".jpg"), name)
+		assert.LessOrEqual(len(names), 100)
+		count, ok := children[name].(CountedNode).PhotoCount()
+		assert.True(ok)
+		assert.Equal(len(names), count)
+		all = append(all, names...)
+	}
+	var expected []string
+	for _, p := range photos {
+		expected = append(expected, p.UniqueStableName())
+	}
+	assert.Equal(expected, all)
+}
+
+func TestQueryNode_PagesAreStable(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	query := types.Query{Selector: types.HasTag{Tag: makeTag("a")}}
+	pages := func(photos []types.Photo) map[string][]string {
+		mockDB := mocks.NewDB(t)
+		mockDB.On("Photos", mock.Anything, query).Return(photos, nil)
+		n := &queryNode{db: mockDB, name: "photos", query: query, pageSize: 100}
+		children, err := n.Children(ctx)
+		assert.Nil(err)
+		return photoPages(t, ctx, children)
+	}
+
+	// Adding or removing a photo only changes the page the photo is on.
+	photos := makePhotos(1000)
+	before := pages(photos)
+	for _, c := range []struct {
+		photos []types.Photo
+		name   string
+	}{
+		{photos: append(photos[:1000:1000], types.Photo{Path: "/photos/added.jpg", ID: "added"}), name: "added.jpg"},
+		{photos: append(photos[:501:501], append([]types.Photo{{Path: "/photos/00500a.jpg", ID: "00500a"}}, photos[501:]...)...), name: "00500a.jpg"},
+		{photos: append(photos[:600:600], photos[601:]...), name: "00600.jpg"},
+	} {
+		after := pages(c.photos)
+		assert.Len(after, len(before))
+		var changed []string
+		for name, names := range after {
+			if strings.Join(before[name], "/") != strings.Join(names, "/") {
+				changed = append(changed, name)
+				assert.True(contains(names, c.name) || contains(before[name], c.name))
+			}
+		}
+		assert.Len(changed, 1, c.name)
+	}
+}
+
+func TestQueryNode_PagesKeepNamesWhenSplit(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	query := types.Query{Selector: types.HasTag{Tag: makeTag("a")}}
+	pages := func(photos []types.Photo) map[string][]string {
+		mockDB := mocks.NewDB(t)
+		mockDB.On("Photos", mock.Anything, query).Return(photos, nil)
+		n := &queryNode{db: mockDB, name: "photos", query: query, pageSize: 100}
+		children, err := n.Children(ctx)
+		assert.Nil(err)
+		return photoPages(t, ctx, children)
+	}
+
+	// Find a photo that starts a new page and sorts near the start, so adding
+	// it splits one of the first pages.
+	var added types.Photo
+	for i := 0; ; i++ {
+		added = types.Photo{Path: fmt.Sprintf("/photos/00010-%d.jpg", i), ID: fmt.Sprintf("00010-%d", i)}
+		if startsPage(added, 50) {
+			break
+		}
+	}
+
+	photos := makePhotos(1000)
+	before := pages(photos)
+	after := pages(append(photos[:11:11], append([]types.Photo{added}, photos[11:]...)...))
+	assert.Len(after, len(before)+1)
+
+	// Only the page that was split changed, and the new page is named after
+	// the photo that was added. Every other page kept its name and photos.
+	var changed []string
+	for name, names := range before {
+		if strings.Join(after[name], "/") != strings.Join(names, "/") {
+			changed = append(changed, name)
+		}
+	}
+	assert.Len(changed, 1)
+	assert.Contains(after, "page-"+added.ID)
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPageNode_Writable(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	tag := makeTag("a")
+	photo := types.Photo{Path: "/photos/foo.jpg", ID: "foo"}
+
+	tagDB := mocks.NewDB(t)
+	tagDB.On("Photos", mock.Anything, mock.Anything).Return(makePhotos(10), nil).Once()
+	n := &tagPhotosNode{queryNode: queryNode{db: tagDB, name: "photos", pageSize: 5}, tag: tag, opts: &Options{Writable: true}}
+	c, err := n.photoChildren(ctx)
+	assert.Nil(err)
+	var page *pageNode
+	for _, c := range c.children {
+		page = c.(*pageNode)
+	}
+
+	// Modifications made in a page are made to the directory the page is in.
+	tagDB.On("AddTag", mock.Anything, types.Photo{Path: "/photos/foo.jpg"}, tag).Return(nil).Once()
+	assert.Nil(page.Symlink(ctx, "/photos/foo.jpg", "foo.jpg"))
+	tagDB.On("RemoveTag", mock.Anything, photo, tag).Return(nil).Once()
+	assert.Nil(page.Unlink(ctx, &photoNode{photo: photo}))
+
+	readOnly := &pageNode{dir: &queryNode{}}
+	assert.ErrorIs(readOnly.Symlink(ctx, "/photos/foo.jpg", "foo.jpg"), syscall.EPERM)
+}
@@ -2,42 +2,287 @@ package photofs
 
 import (
 	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 
+	"github.com/anitschke/photo-db-fs/db"
 	"github.com/anitschke/photo-db-fs/types"
 	"github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
+	"go.uber.org/zap"
 )
 
 type photoNode struct {
-	name string
-	path string
+	photo types.Photo
+	db    db.DB
 }
 
 var _ = (Node)((*photoNode)(nil))
+var _ = (KeyedNode)((*photoNode)(nil))
 
 func (n *photoNode) Name() string {
-	return n.name
+	return n.photo.UniqueStableName()
 }
 
 func (n *photoNode) Mode() uint32 {
 	return fuse.S_IFLNK
 }
 
+// Key is the target of the symlink, which changes if the photo is moved.
+func (n *photoNode) Key() string {
+	return n.photo.Path
+}
+
 func (n *photoNode) INode(ctx context.Context) (fs.InodeEmbedder, error) {
-	symlink := &fs.MemSymlink{
-		Data: []byte(n.path),
+	symlink := &photoSymlink{
+		MemSymlink: fs.MemSymlink{
+			Data: []byte(n.photo.Path),
+		},
+		photo: n.photo,
+		db:    n.db,
 	}
 	return symlink, nil
 }
 
-func photoSliceToNodeMap(photoSlice []types.Photo) (map[string]Node, error) {
-	nodes := make([]Node, 0, len(photoSlice))
-	for _, p := range photoSlice {
-		nodes = append(nodes, &photoNode{
-			name: p.UniqueStableName(),
-			path: p.Path,
+// photoIndex is the photos of a directory sorted by name. Directories of photos
+// can be huge, so rather than creating a Node for every photo up front we only
+// keep the photos themselves and create the Node of a photo when it is looked
+// up. Since the photos are sorted they can be looked up by name with a binary
+// search and listed in order without building all of the entries at once.
+type photoIndex struct {
+	db db.DB
+
+	// photos are sorted by UniqueStableName. If multiple photos have the same
+	// name only one of them is kept, see nodeSliceToNodeMap.
+	photos []types.Photo
+}
+
+// streamPhotoIndex creates a photoIndex of the photos selected by a query as
+// they are streamed from the DB, after optimizing the selector of the query.
+func streamPhotoIndex(ctx context.Context, photoDB db.DB, q types.Query) (*photoIndex, error) {
+	var photos []types.Photo
+	q, ok := optimizeQuery(q)
+	if ok {
+		err := db.StreamPhotos(ctx, photoDB, q, func(p types.Photo) error {
+			photos = append(photos, p)
+			return nil
 		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return newPhotoIndex(photoDB, photos), nil
+}
+
+// newPhotoIndex creates a photoIndex of the photos, it takes ownership of the
+// photos slice.
+func newPhotoIndex(photoDB db.DB, photos []types.Photo) *photoIndex {
+	sort.SliceStable(photos, func(i, j int) bool {
+		return photos[i].UniqueStableName() < photos[j].UniqueStableName()
+	})
+
+	deduped := photos[:0]
+	for _, p := range photos {
+		if len(deduped) > 0 {
+			if last := deduped[len(deduped)-1]; last.UniqueStableName() == p.UniqueStableName() {
+				zap.L().Warn("detected node with duplicate name", zap.Any("existing", last), zap.Any("new", p))
+				continue
+			}
+		}
+		deduped = append(deduped, p)
+	}
+	return &photoIndex{db: photoDB, photos: deduped}
+}
+
+// photoNamesKey gets a key for a directory of photos that changes when any of
+// the photos do, see KeyedNode.
+func photoNamesKey(photos []types.Photo) string {
+	names := make([]string, len(photos))
+	for i, p := range photos {
+		names[i] = p.UniqueStableName()
+	}
+	return strings.Join(names, "/")
+}
+
+// len gets the number of photos, it is safe to call on a nil photoIndex.
+func (i *photoIndex) len() int {
+	if i == nil {
+		return 0
+	}
+	return len(i.photos)
+}
+
+// lookup gets the Node of the photo with the name, it is safe to call on a nil
+// photoIndex.
+func (i *photoIndex) lookup(name string) (Node, bool) {
+	n := i.len()
+	pos := sort.Search(n, func(j int) bool {
+		return i.photos[j].UniqueStableName() >= name
+	})
+	if pos == n || i.photos[pos].UniqueStableName() != name {
+		return nil, false
+	}
+	return i.node(pos), true
+}
+
+// node gets the Node of the photo at position pos.
+func (i *photoIndex) node(pos int) Node {
+	return &photoNode{photo: i.photos[pos], db: i.db}
+}
+
+// changed gets the names of the photos that were added, removed or changed
+// between i and other, either of which may be nil.
+func (i *photoIndex) changed(other *photoIndex) []string {
+	var changed []string
+	a, b := 0, 0
+	for a < i.len() || b < other.len() {
+		switch {
+		case b == other.len():
+			changed = append(changed, i.photos[a].UniqueStableName())
+			a++
+		case a == i.len():
+			changed = append(changed, other.photos[b].UniqueStableName())
+			b++
+		default:
+			nameA := i.photos[a].UniqueStableName()
+			nameB := other.photos[b].UniqueStableName()
+			switch {
+			case nameA < nameB:
+				changed = append(changed, nameA)
+				a++
+			case nameB < nameA:
+				changed = append(changed, nameB)
+				b++
+			default:
+				if i.photos[a] != other.photos[b] {
+					changed = append(changed, nameA)
+				}
+				a++
+				b++
+			}
+		}
+	}
+	return changed
+}
+
+// The extended attributes of a photo that describe why it shows up where it
+// does without needing to query the DB again.
+const (
+	xattrTags    = "user.photodb.tags"
+	xattrRating  = "user.photodb.rating"
+	xattrCaption = "user.photodb.caption"
+	xattrID      = "user.photodb.id"
+	xattrTaken   = "user.photodb.taken"
+)
+
+// xattrTakenLayout is the layout of the user.photodb.taken attribute. We don't
+// include a time zone since photo databases generally don't know the time zone
+// a photo was taken in.
+const xattrTakenLayout = "2006-01-02T15:04:05"
+
+// photoSymlink is the INode of a photo, it is a symlink to the photo that also
+// exposes the metadata of the photo as extended attributes.
+//
+// The metadata is only looked up the first time an attribute is asked for and
+// is then cached until it is cleared when the DB changes.
+type photoSymlink struct {
+	fs.MemSymlink
+	photo types.Photo
+	db    db.DB
+
+	mu       sync.Mutex
+	metadata *types.PhotoMetadata
+}
+
+var _ = (fs.NodeGetxattrer)((*photoSymlink)(nil))
+var _ = (fs.NodeListxattrer)((*photoSymlink)(nil))
+
+// clearMetadata drops the cached metadata so that it is looked up again the
+// next time it is needed.
+func (n *photoSymlink) clearMetadata() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.metadata = nil
+}
+
+// xattrs gets all the extended attributes of the photo. Attributes the DB
+// doesn't have a value for are left out.
+func (n *photoSymlink) xattrs(ctx context.Context) (map[string]string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.metadata == nil {
+		m, err := n.db.PhotoMetadata(ctx, n.photo)
+		if err != nil {
+			return nil, err
+		}
+		n.metadata = &m
+	}
+
+	attrs := map[string]string{
+		xattrID: n.photo.ID,
+	}
+	if len(n.metadata.Tags) > 0 {
+		tags := make([]string, 0, len(n.metadata.Tags))
+		for _, t := range n.metadata.Tags {
+			tags = append(tags, strings.Join(t.Path, "/"))
+		}
+		attrs[xattrTags] = strings.Join(tags, "\n")
+	}
+	if n.metadata.Rating != nil {
+		attrs[xattrRating] = strconv.FormatFloat(*n.metadata.Rating, 'f', -1, 64)
+	}
+	if n.metadata.Caption != "" {
+		attrs[xattrCaption] = n.metadata.Caption
+	}
+	if !n.metadata.Taken.IsZero() {
+		attrs[xattrTaken] = n.metadata.Taken.Format(xattrTakenLayout)
+	}
+	return attrs, nil
+}
+
+func (n *photoSymlink) Getxattr(ctx context.Context, attr string, dest []byte) (uint32, syscall.Errno) {
+	attrs, err := n.xattrs(ctx)
+	if err != nil {
+		zap.L().Error("failed to get photo metadata", zap.String("path", n.photo.Path), zap.Error(err))
+		return 0, dbERROR
+	}
+	v, ok := attrs[attr]
+	if !ok {
+		return 0, fs.ENOATTR
+	}
+	return copyXattr(dest, []byte(v))
+}
+
+func (n *photoSymlink) Listxattr(ctx context.Context, dest []byte) (uint32, syscall.Errno) {
+	attrs, err := n.xattrs(ctx)
+	if err != nil {
+		zap.L().Error("failed to get photo metadata", zap.String("path", n.photo.Path), zap.Error(err))
+		return 0, dbERROR
+	}
+
+	var list []byte
+	for _, name := range []string{xattrTags, xattrRating, xattrCaption, xattrID, xattrTaken} {
+		if _, ok := attrs[name]; ok {
+			list = append(list, name...)
+			list = append(list, 0)
+		}
+	}
+	return copyXattr(dest, list)
+}
+
+// copyXattr copies the value of an extended attribute into dest following the
+// getxattr/listxattr conventions, if dest is empty the caller just wants to
+// know the size and if it is too small we return ERANGE.
+func copyXattr(dest []byte, value []byte) (uint32, syscall.Errno) {
+	if len(dest) == 0 {
+		return uint32(len(value)), 0
+	}
+	if len(dest) < len(value) {
+		return uint32(len(value)), syscall.ERANGE
 	}
-	ignoreDups := true
-	return nodeSliceToNodeMap(nodes, ignoreDups)
+	return uint32(copy(dest, value)), 0
 }
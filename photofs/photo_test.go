@@ -0,0 +1,147 @@
+package photofs
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/anitschke/photo-db-fs/db/mocks"
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPhotoSymlink_Xattr(t *testing.T) {
+	assert := assert.New(t)
+
+	mockDB := mocks.NewDB(t)
+	photo := types.Photo{Path: "/photos/foo.jpg", ID: "foo"}
+	rating := float64(4)
+	mockDB.On("PhotoMetadata", mock.Anything, photo).Return(types.PhotoMetadata{
+		Tags:    []types.Tag{makeTag("a", "b"), makeTag("c")},
+		Rating:  &rating,
+		Caption: "A caption",
+		Taken:   time.Date(2022, 7, 10, 15, 2, 21, 0, time.Local),
+	}, nil).Once()
+
+	ctx := context.Background()
+	n := photoNode{photo: photo, db: mockDB}
+	in, err := n.INode(ctx)
+	assert.Nil(err)
+	p := in.(*photoSymlink)
+
+	getxattr := func(attr string) (string, syscall.Errno) {
+		size, errno := p.Getxattr(ctx, attr, nil)
+		if errno != 0 {
+			return "", errno
+		}
+		dest := make([]byte, size)
+		size, errno = p.Getxattr(ctx, attr, dest)
+		return string(dest[:size]), errno
+	}
+
+	v, errno := getxattr("user.photodb.tags")
+	assert.Equal(syscall.Errno(0), errno)
+	assert.Equal("a/b\nc", v)
+
+	v, errno = getxattr("user.photodb.rating")
+	assert.Equal(syscall.Errno(0), errno)
+	assert.Equal("4", v)
+
+	v, errno = getxattr("user.photodb.caption")
+	assert.Equal(syscall.Errno(0), errno)
+	assert.Equal("A caption", v)
+
+	v, errno = getxattr("user.photodb.id")
+	assert.Equal(syscall.Errno(0), errno)
+	assert.Equal("foo", v)
+
+	v, errno = getxattr("user.photodb.taken")
+	assert.Equal(syscall.Errno(0), errno)
+	assert.Equal("2022-07-10T15:02:21", v)
+
+	_, errno = getxattr("user.other")
+	assert.Equal(fs.ENOATTR, errno)
+
+	_, errno = p.Getxattr(ctx, "user.photodb.id", make([]byte, 1))
+	assert.Equal(syscall.ERANGE, errno)
+
+	list := make([]byte, 200)
+	size, errno := p.Listxattr(ctx, list)
+	assert.Equal(syscall.Errno(0), errno)
+	assert.Equal("user.photodb.tags\x00user.photodb.rating\x00user.photodb.caption\x00user.photodb.id\x00user.photodb.taken\x00", string(list[:size]))
+
+	// The metadata is cached until it is cleared.
+	p.clearMetadata()
+	mockDB.On("PhotoMetadata", mock.Anything, photo).Return(types.PhotoMetadata{}, nil).Once()
+	size, errno = p.Listxattr(ctx, list)
+	assert.Equal(syscall.Errno(0), errno)
+	assert.Equal("user.photodb.id\x00", string(list[:size]))
+}
+
+func TestPhotoIndex(t *testing.T) {
+	assert := assert.New(t)
+
+	mockDB := mocks.NewDB(t)
+	c := types.Photo{Path: "/photos/c.jpg", ID: "c"}
+	a := types.Photo{Path: "/photos/a.jpg", ID: "a"}
+	b := types.Photo{Path: "/photos/b.png", ID: "b"}
+	aCopy := types.Photo{Path: "/photos/copy/a.jpg", ID: "a"}
+
+	// Photos are sorted by name and duplicate names are dropped.
+	index := newPhotoIndex(mockDB, []types.Photo{c, a, b, aCopy})
+	assert.Equal([]types.Photo{a, b, c}, index.photos)
+
+	n, ok := index.lookup("b.png")
+	assert.True(ok)
+	assert.Equal(&photoNode{photo: b, db: mockDB}, n)
+	_, ok = index.lookup("b.jpg")
+	assert.False(ok)
+	_, ok = index.lookup("z.jpg")
+	assert.False(ok)
+
+	var empty *photoIndex
+	assert.Equal(0, empty.len())
+	_, ok = empty.lookup("a.jpg")
+	assert.False(ok)
+
+	bMoved := types.Photo{Path: "/photos/moved/b.png", ID: "b"}
+	d := types.Photo{Path: "/photos/d.jpg", ID: "d"}
+	other := newPhotoIndex(mockDB, []types.Photo{d, bMoved, c})
+	assert.ElementsMatch([]string{"a.jpg", "b.png", "d.jpg"}, index.changed(other))
+	assert.ElementsMatch([]string{"a.jpg", "b.png", "c.jpg"}, index.changed(nil))
+	assert.Empty(index.changed(index))
+}
+
+func TestDirINode_ReaddirPhotos(t *testing.T) {
+	assert := assert.New(t)
+
+	mockDB := mocks.NewDB(t)
+	query := types.Query{Selector: types.HasTag{Tag: types.Tag{Path: []string{"a"}}}}
+	photos := []types.Photo{
+		{Path: "/photos/2.jpg", ID: "2"},
+		{Path: "/photos/1.jpg", ID: "1"},
+		{Path: "/photos/3.jpg", ID: "3"},
+	}
+	mockDB.On("Photos", mock.Anything, query).Return(photos, nil).Once()
+
+	ctx := context.Background()
+	n := &queryNode{db: mockDB, name: "photos", query: query, currentPhoto: &types.CurrentPhoto{Interval: time.Hour}, now: time.Now}
+	in, err := n.INode(ctx)
+	assert.Nil(err)
+	d := in.(*DirINode)
+
+	// The current photo comes first, followed by the photos in order.
+	stream, errno := d.Readdir(ctx)
+	assert.Equal(syscall.Errno(0), errno)
+	var names []string
+	for stream.HasNext() {
+		e, errno := stream.Next()
+		assert.Equal(syscall.Errno(0), errno)
+		names = append(names, e.Name)
+	}
+	stream.Close()
+	assert.Equal([]string{"current.jpg", "1.jpg", "2.jpg", "3.jpg"}, names)
+}
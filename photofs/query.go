@@ -3,6 +3,9 @@ package photofs
 import (
 	"context"
 	"fmt"
+	"path"
+	"strings"
+	"time"
 
 	"github.com/anitschke/photo-db-fs/db"
 	"github.com/anitschke/photo-db-fs/types"
@@ -14,6 +17,7 @@ import (
 // queries
 type rootQueriesNode struct {
 	db      db.DB
+	opts    *Options
 	queries []types.NamedQuery
 }
 
@@ -21,7 +25,7 @@ var _ = (Node)((*rootQueriesNode)(nil))
 var _ = (DirNode)((*rootQueriesNode)(nil))
 
 func (n *rootQueriesNode) Name() string {
-	return "queries"
+	return n.opts.viewName(types.QueriesView)
 }
 
 func (n *rootQueriesNode) Mode() uint32 {
@@ -33,18 +37,215 @@ func (n *rootQueriesNode) INode(ctx context.Context) (fs.InodeEmbedder, error) {
 }
 
 func (n *rootQueriesNode) Children(ctx context.Context) (map[string]Node, error) {
-	nodes := make([]Node, 0, len(n.queries))
-	for _, q := range n.queries {
-		nodes = append(nodes, &queryNode{db: n.db, name: q.Name, query: q.Query})
+	ignoreDups := false
+	return nodeSliceToNodeMap(n.queryNodes(), ignoreDups)
+}
+
+// queryNodes gets the nodes for the top level of the queries. Queries with a
+// "/" in their name are nested in folders.
+func (n *rootQueriesNode) queryNodes() []Node {
+	return queryTreeNodes(n.db, n.opts, n.queries, "")
+}
+
+// queryTreeNodes gets the nodes for the queries and folders of queries that are
+// directly in the folder with the path prefix. The prefix is empty for the top
+// level and otherwise is the path of the folder followed by a "/".
+func queryTreeNodes(photoDB db.DB, opts *Options, queries []types.NamedQuery, prefix string) []Node {
+	var nodes []Node
+	folders := make(map[string]struct{})
+	for _, q := range queries {
+		if !strings.HasPrefix(q.Name, prefix) {
+			continue
+		}
+		name := q.Name[len(prefix):]
+		if i := strings.Index(name, "/"); i >= 0 {
+			folder := name[:i]
+			if _, ok := folders[folder]; !ok {
+				folders[folder] = struct{}{}
+				nodes = append(nodes, &queryFolderNode{db: photoDB, opts: opts, queries: queries, path: prefix + folder})
+			}
+			continue
+		}
+		nodes = append(nodes, namedQueryNode(photoDB, opts, q, name))
+	}
+	return nodes
+}
+
+// namedQueryNode gets the node for the directory of a query.
+func namedQueryNode(photoDB db.DB, opts *Options, q types.NamedQuery, name string) Node {
+	currentPhoto := q.CurrentPhoto
+	if currentPhoto == nil {
+		currentPhoto = opts.currentPhoto()
+	}
+	pageSize := opts.pageSize()
+	if q.PageSize != nil {
+		pageSize = *q.PageSize
+	}
+	if q.IncludeExcluded {
+		photoDB = db.WithoutExclusions(photoDB)
+	}
+	if q.Template != "" {
+		return &queryTemplateNode{db: photoDB, opts: opts, name: name, query: q.Query, template: q.Template, currentPhoto: currentPhoto, pageSize: pageSize}
+	}
+	return &queryNode{db: photoDB, name: name, query: q.Query, currentPhoto: currentPhoto, now: opts.clock(), pageSize: pageSize}
+}
+
+// queryFolderNode is a folder for all of the queries whose names start with
+// the path of the folder.
+type queryFolderNode struct {
+	db      db.DB
+	opts    *Options
+	queries []types.NamedQuery
+
+	// path is the path of the folder within the queries, without a trailing
+	// "/".
+	path string
+}
+
+var _ = (Node)((*queryFolderNode)(nil))
+var _ = (DirNode)((*queryFolderNode)(nil))
+
+func (n *queryFolderNode) Name() string {
+	return path.Base(n.path)
+}
+
+func (n *queryFolderNode) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (n *queryFolderNode) INode(ctx context.Context) (fs.InodeEmbedder, error) {
+	return NewDirINode(ctx, n)
+}
+
+func (n *queryFolderNode) Children(ctx context.Context) (map[string]Node, error) {
+	ignoreDups := false
+	return nodeSliceToNodeMap(queryTreeNodes(n.db, n.opts, n.queries, n.path+"/"), ignoreDups)
+}
+
+// queryTemplateNode is the directory of a query that uses a view template, for
+// example to have the same ratings and tags subtrees as a tag. The photos of the
+// query are then in the photos directory rather than directly in the directory
+// of the query.
+type queryTemplateNode struct {
+	name     string
+	query    types.Query
+	db       db.DB
+	opts     *Options
+	template string
+
+	// currentPhoto configures the current.<ext> symlink in the photos
+	// directory.
+	currentPhoto *types.CurrentPhoto
+
+	// pageSize is the page size of the photos directory.
+	pageSize int
+}
+
+var _ = (Node)((*queryTemplateNode)(nil))
+var _ = (DirNode)((*queryTemplateNode)(nil))
+
+func (n *queryTemplateNode) Name() string {
+	return n.name
+}
+
+func (n *queryTemplateNode) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (n *queryTemplateNode) INode(ctx context.Context) (fs.InodeEmbedder, error) {
+	return NewDirINode(ctx, n)
+}
+
+func (n *queryTemplateNode) Children(ctx context.Context) (map[string]Node, error) {
+	dir := templateDir{
+		db:           n.db,
+		opts:         n.opts,
+		selector:     n.query.Selector,
+		currentPhoto: n.currentPhoto,
+		pageSize:     n.pageSize,
 	}
+	childrenNodes := dir.nodes(n.opts.template(n.template, ""))
 	ignoreDups := false
-	return nodeSliceToNodeMap(nodes, ignoreDups)
+	return nodeSliceToNodeMap(childrenNodes, ignoreDups)
+}
+
+// queryTagsNode is the tags directory of a query, or of any other directory of
+// photos that isn't a tag. It contains the tag hierarchy, but only the tags
+// that are applied to at least one of the photos of the directory. Entering one
+// of those tags narrows down the photos to those that also have the tag, in the
+// same way as the "and" directory of a tag.
+type queryTagsNode struct {
+	db       db.DB
+	opts     *Options
+	selector types.Selector
+
+	// template is the name of the view template used for the tags, if empty
+	// the tag template of the layout is used.
+	template string
+}
+
+var _ = (Node)((*queryTagsNode)(nil))
+var _ = (DirNode)((*queryTagsNode)(nil))
+
+func (n *queryTagsNode) Name() string {
+	return "tags"
+}
+
+func (n *queryTagsNode) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (n *queryTagsNode) INode(ctx context.Context) (fs.InodeEmbedder, error) {
+	return NewDirINode(ctx, n)
+}
+
+func (n *queryTagsNode) Children(ctx context.Context) (map[string]Node, error) {
+	// Counting the photos of each tag also tells us which tags are applied to
+	// the photos, so if the DB can count photos that is all we need to ask it.
+	counts, err := countTags(ctx, n.db, n.selector)
+	if err != nil {
+		return nil, err
+	}
+	var tags []types.Tag
+	if counts != nil {
+		tags = counts.tags
+	} else {
+		tags, err = photoTags(ctx, n.db, n.selector)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get tags of photos: %w", err)
+		}
+	}
+
+	facet := &tagFacet{
+		base:    n.selector,
+		exclude: false,
+		tags:    newTagTree(tags, nil),
+		counts:  counts,
+	}
+	return tagSliceToNodeMap(n.db, n.opts, facet, nil, n.template, facet.tags.children(types.Tag{}), facet.counts)
 }
 
 type queryNode struct {
 	name  string
 	query types.Query
 	db    db.DB
+
+	// currentPhoto configures the current.<ext> symlink in this directory, if
+	// nil there is no current.<ext> symlink.
+	currentPhoto *types.CurrentPhoto
+
+	// now gets the current time for the current.<ext> symlink, see
+	// Options.Clock.
+	now func() time.Time
+
+	// pageSize is how many photos the directory can have before they are
+	// split into pages, see pageNode. If it is zero they never are.
+	pageSize int
+
+	// photos are the photos of the query if the parent directory has already
+	// gotten them, in which case they are used rather than asking the DB for
+	// them again. If it is nil they are gotten from the DB.
+	photos *photoIndex
 }
 
 var _ = (Node)((*queryNode)(nil))
@@ -63,9 +264,60 @@ func (n *queryNode) INode(ctx context.Context) (fs.InodeEmbedder, error) {
 }
 
 func (n *queryNode) Children(ctx context.Context) (map[string]Node, error) {
-	children, err := n.db.Photos(ctx, n.query)
+	return photoDirChildrenMap(n.photoChildren(ctx))
+}
+
+var _ = (photosDirNode)((*queryNode)(nil))
+var _ = (KeyedNode)((*queryNode)(nil))
+
+// Key is the selector of the query, along with the photos if the parent
+// directory already got them since they can change without the query
+// changing.
+func (n *queryNode) Key() string {
+	key, err := types.CanonicalKey(n.query.Selector)
 	if err != nil {
-		return nil, fmt.Errorf("failed perform named query %q: %w", n.name, err)
+		key = fmt.Sprintf("%#v", n.query.Selector)
+	}
+	if n.photos != nil {
+		key += "\x00" + photoNamesKey(n.photos.photos)
 	}
-	return photoSliceToNodeMap(children)
+	return key
+}
+
+func (n *queryNode) photoChildren(ctx context.Context) (photoDirChildren, error) {
+	return n.photoChildrenIn(ctx, n)
+}
+
+// photoChildrenIn gets the photos and other children of the directory. dir is
+// the DirNode of the directory, which is a node that embeds the queryNode if
+// the directory can be modified, so that pages can pass modifications on to it.
+func (n *queryNode) photoChildrenIn(ctx context.Context, dir DirNode) (photoDirChildren, error) {
+	photos := n.photos
+	if photos == nil {
+		var err error
+		photos, err = streamPhotoIndex(ctx, n.db, n.query)
+		if err != nil {
+			return photoDirChildren{}, fmt.Errorf("failed perform named query %q: %w", n.name, err)
+		}
+	}
+	c := photoDirChildren{
+		children: make(map[string]Node),
+		current:  currentPhotoFor(n.currentPhoto, photos, n.now),
+	}
+	if n.pageSize <= 0 || photos.len() <= n.pageSize {
+		c.photos = photos
+		return c, nil
+	}
+	for _, p := range pageNodes(n.db, dir, photos.photos, n.pageSize) {
+		c.children[p.Name()] = p
+	}
+	return c, nil
+}
+
+// optimizeQuery optimizes the selector of a query before it is given to the
+// DB. It returns false if the optimized selector can't select any photos, in
+// which case we don't bother asking the DB at all.
+func optimizeQuery(q types.Query) (types.Query, bool) {
+	q.Selector = types.Optimize(q.Selector)
+	return q, !types.SelectsNothing(q.Selector)
 }
@@ -192,3 +192,159 @@ func TestQueriesFS_WalkPhotos(t *testing.T) {
 	expTreeInfo := testtools.GetOrUpdateGoldFile("./"+t.Name()+"_GoldTree.json", actTreeInfo, updateGold)
 	assert.ElementsMatch(actTreeInfo, expTreeInfo)
 }
+
+func TestQueriesFS_WalkNestedQueries(t *testing.T) {
+	assert := assert.New(t)
+
+	mockDB := mocks.NewDB(t)
+
+	q := []types.NamedQuery{
+		{
+			Name:     "Trips/Iceland",
+			Query:    types.Query{Selector: types.HasTag{Tag: makeTag("Iceland")}},
+			Template: types.DefaultQueryTemplate,
+		},
+		{
+			Name:  "Trips/Norway",
+			Query: types.Query{Selector: types.HasTag{Tag: makeTag("Norway")}},
+		},
+		{
+			Name:  "Other",
+			Query: types.Query{Selector: types.HasTag{Tag: makeTag("Other")}},
+		},
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		panic(err)
+	}
+
+	libraryRoot := filepath.Join(wd, "..", "test-resources", "photos", "basic")
+
+	photo := types.Photo{
+		Path: filepath.Join(libraryRoot, "album1", "GRAND_00626.jpg"),
+		ID:   "photo",
+	}
+
+	// We only care about the structure of the tree here, so every query gets
+	// the same photo.
+	mockDB.On("Photos", mock.Anything, mock.Anything).Return([]types.Photo{photo}, nil)
+	mockDB.On("Ratings").Return([]float64{1, 2})
+	mockDB.On("PhotoTags", mock.Anything, q[0].Query).Return([]types.Tag{makeTag("People", "Alice"), makeTag("People")}, nil).Once()
+
+	ctx := context.Background()
+	tagRoot, err := rootQueriesInode(ctx, mockDB, q)
+	assert.NotNil(tagRoot)
+	assert.Nil(err)
+
+	mountPoint, cleanup, err := testtools.MountPoint()
+	assert.Nil(err)
+	defer cleanup()
+
+	server, err := testtools.MountTestFs(mountPoint, tagRoot)
+	assert.Nil(err)
+	serverDoneWG := sync.WaitGroup{}
+	serverDoneWG.Add(1)
+	go func() {
+		server.Wait()
+		serverDoneWG.Done()
+	}()
+
+	defer func() {
+		err := server.Unmount()
+		assert.Nil(err)
+		serverDoneWG.Wait()
+
+		// The tags under a query should narrow down the photos of the query.
+		mockDB.AssertCalled(t, "Photos", mock.Anything, types.Query{Selector: types.And{Operands: []types.Selector{
+			q[0].Query.Selector,
+			types.HasTag{Tag: makeTag("People", "Alice")},
+		}}})
+	}()
+
+	actTreeInfo, err := testtools.Walk(mountPoint)
+	assert.Nil(err)
+
+	testtools.VerifyJpegAreValid(t, actTreeInfo)
+
+	testtools.ToGoldFileFormat(actTreeInfo, mountPoint, libraryRoot)
+	updateGold := false
+	expTreeInfo := testtools.GetOrUpdateGoldFile("./"+t.Name()+"_GoldTree.json", actTreeInfo, updateGold)
+	assert.ElementsMatch(actTreeInfo, expTreeInfo)
+}
+
+func TestRootQueriesNode_IncludeExcluded(t *testing.T) {
+	assert := assert.New(t)
+
+	mockDB := mocks.NewDB(t)
+	excludingDB := db.NewExcludingDB(mockDB, types.HasTag{Tag: makeTag("Private")})
+
+	n := rootQueriesNode{
+		db: excludingDB,
+		queries: []types.NamedQuery{
+			{Name: "tv", Query: types.Query{Selector: types.HasTag{Tag: makeTag("a")}}},
+			{Name: "admin", Query: types.Query{Selector: types.HasTag{Tag: makeTag("a")}}, IncludeExcluded: true},
+		},
+	}
+	children, err := n.Children(context.Background())
+	assert.Nil(err)
+	assert.Same(excludingDB, children["tv"].(*queryNode).db)
+	assert.Same(mockDB, children["admin"].(*queryNode).db)
+}
+
+func TestQueryNode_Optimize(t *testing.T) {
+	assert := assert.New(t)
+
+	mockDB := mocks.NewDB(t)
+	hasA := types.HasTag{Tag: makeTag("a")}
+	hasB := types.HasTag{Tag: makeTag("b")}
+	ctx := context.Background()
+
+	// The selector is optimized before it is handed to the DB.
+	photo := types.Photo{Path: "/photos/foo.jpg", ID: "foo"}
+	mockDB.On("Photos", mock.Anything, types.Query{Selector: types.And{Operands: []types.Selector{hasA, hasB}}}).Return([]types.Photo{photo}, nil).Once()
+	n := queryNode{db: mockDB, name: "q", query: types.Query{Selector: types.And{Operands: []types.Selector{
+		hasA,
+		types.And{Operands: []types.Selector{hasB, hasA}},
+	}}}}
+	children, err := n.Children(ctx)
+	assert.Nil(err)
+	assert.ElementsMatch([]string{photo.UniqueStableName()}, childNames(children))
+
+	// If the selector can't select anything the DB isn't asked at all.
+	n.query = types.Query{Selector: types.Difference{Starting: hasA, Excluding: hasA}}
+	children, err = n.Children(ctx)
+	assert.Nil(err)
+	assert.Empty(children)
+}
+
+func TestQueryTagsNode_Optimize(t *testing.T) {
+	assert := assert.New(t)
+
+	mockDB := mocks.NewDB(t)
+	counter := countingDB{DB: mockDB}
+	hasA := types.HasTag{Tag: makeTag("a")}
+	hasB := types.HasTag{Tag: makeTag("b")}
+	ctx := context.Background()
+
+	// The tags and their counts are gotten with the optimized selector too.
+	optimized := types.Query{Selector: types.And{Operands: []types.Selector{hasA, hasB}}}
+	counter.On("TagCounts", mock.Anything, optimized).Return([]types.TagCount{{Tag: makeTag("c"), Photos: 1}}, nil).Once()
+	n := queryTagsNode{db: counter, selector: types.And{Operands: []types.Selector{
+		hasA,
+		types.And{Operands: []types.Selector{hasB, hasA}},
+	}}}
+	children, err := n.Children(ctx)
+	assert.Nil(err)
+	assert.ElementsMatch([]string{"c"}, childNames(children))
+
+	// If the selector can't select anything the DB isn't asked at all, whether
+	// or not it can count photos.
+	n.selector = types.Difference{Starting: hasA, Excluding: hasA}
+	for _, d := range []db.DB{mockDB, counter} {
+		n.db = d
+		children, err = n.Children(ctx)
+		assert.Nil(err)
+		assert.Empty(children)
+	}
+}
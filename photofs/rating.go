@@ -2,7 +2,9 @@ package photofs
 
 import (
 	"context"
-	"strconv"
+	"errors"
+	"fmt"
+	"syscall"
 
 	"github.com/anitschke/photo-db-fs/db"
 	"github.com/anitschke/photo-db-fs/types"
@@ -13,13 +15,18 @@ import (
 type ratingsParentNode struct {
 	baseSelector types.Selector
 	db           db.DB
+	opts         *Options
+
+	// template is the name of the view template used for each rating, if
+	// empty types.DefaultRatingTemplate is used.
+	template string
 }
 
 var _ = (Node)((*ratingsParentNode)(nil))
 var _ = (DirNode)((*ratingsParentNode)(nil))
 
 func (n *ratingsParentNode) Name() string {
-	return "ratings"
+	return n.opts.viewName(types.RatingsView)
 }
 
 func (n *ratingsParentNode) Mode() uint32 {
@@ -38,13 +45,26 @@ func (n *ratingsParentNode) Children(ctx context.Context) (map[string]Node, erro
 		return map[string]Node{}, nil
 	}
 
+	counts, err := n.countRatings(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	children := make([]Node, 0, 2*len(ratings)-1)
 
 	maxRating := ratings[len(ratings)-1]
 	for _, r := range ratings {
-		children = append(children, &ratingNode{baseSelector: n.baseSelector, operator: types.Equal, rating: r, db: n.db})
+		operators := []types.RelationalOperator{types.Equal}
 		if r != maxRating {
-			children = append(children, &ratingNode{baseSelector: n.baseSelector, operator: types.GreaterThanOrEqual, rating: r, db: n.db})
+			operators = append(operators, types.GreaterThanOrEqual)
+		}
+		for _, op := range operators {
+			c := &ratingNode{baseSelector: n.baseSelector, operator: op, rating: r, db: n.db, opts: n.opts, template: n.template}
+			if counts != nil {
+				c.photos = countRating(counts, op, r)
+				c.counted = true
+			}
+			children = append(children, c)
 		}
 	}
 
@@ -52,18 +72,71 @@ func (n *ratingsParentNode) Children(ctx context.Context) (map[string]Node, erro
 	return nodeSliceToNodeMap(children, ignoreDups)
 }
 
+// countRatings counts the photos with each rating within the base photos, it
+// returns nil if the DB can't count photos.
+func (n *ratingsParentNode) countRatings(ctx context.Context) ([]types.RatingCount, error) {
+	var s types.Selector = types.And{}
+	if n.baseSelector != nil {
+		s = n.baseSelector
+	}
+	q, ok := optimizeQuery(types.Query{Selector: s})
+	if !ok {
+		return []types.RatingCount{}, nil
+	}
+	counts, err := db.RatingCounts(ctx, n.db, q)
+	if errors.Is(err, db.ErrNotSupported) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to count photos of ratings: %w", err)
+	}
+	return counts, nil
+}
+
+// countRating gets the number of photos that are selected by a rating from the
+// number of photos with each rating.
+func countRating(counts []types.RatingCount, operator types.RelationalOperator, rating float64) int {
+	total := 0
+	for _, c := range counts {
+		if (operator == types.Equal && c.Rating == rating) || (operator == types.GreaterThanOrEqual && c.Rating >= rating) {
+			total += c.Photos
+		}
+	}
+	return total
+}
+
 type ratingNode struct {
 	baseSelector types.Selector
 	operator     types.RelationalOperator
 	rating       float64
 	db           db.DB
+	opts         *Options
+
+	// template is the name of the view template used for the children of the
+	// rating, if empty types.DefaultRatingTemplate is used.
+	template string
+
+	// photos is the number of photos selected by the rating, it is only known
+	// if counted is set.
+	photos  int
+	counted bool
 }
 
 var _ = (Node)((*ratingNode)(nil))
 var _ = (DirNode)((*ratingNode)(nil))
+var _ = (CountedNode)((*ratingNode)(nil))
+var _ = (AliasedNode)((*ratingNode)(nil))
 
 func (n *ratingNode) Name() string {
-	return string(n.operator) + strconv.Itoa(int(n.rating))
+	return n.opts.countedName(n.Alias(), n.photos, n.counted)
+}
+
+func (n *ratingNode) Alias() string {
+	return n.opts.layout().RatingName(n.operator, n.rating)
+}
+
+func (n *ratingNode) PhotoCount() (int, bool) {
+	return n.photos, n.counted
 }
 
 func (n *ratingNode) Mode() uint32 {
@@ -75,6 +148,16 @@ func (n *ratingNode) INode(ctx context.Context) (fs.InodeEmbedder, error) {
 }
 
 func (n *ratingNode) Children(ctx context.Context) (map[string]Node, error) {
+	return photoDirChildrenMap(n.photoChildren(ctx))
+}
+
+var _ = (photosDirNode)((*ratingNode)(nil))
+
+// photoChildren gets the children of the rating, which doesn't have any photos
+// directly in it. If the rating has a current photo then the photos are gotten
+// here and passed on to the photos directory, so that they are only gotten
+// once for both of them.
+func (n *ratingNode) photoChildren(ctx context.Context) (photoDirChildren, error) {
 
 	hasRatingSelector := types.HasRating{
 		Operator: n.operator,
@@ -97,9 +180,80 @@ func (n *ratingNode) Children(ctx context.Context) (map[string]Node, error) {
 		Selector: selector,
 	}
 
-	childrenNodes := []Node{
-		&queryNode{db: n.db, name: "photos", query: query},
+	currentPhoto := n.opts.currentPhoto()
+	var photos *photoIndex
+	var current *currentPhotoNode
+	if currentPhotoEnabled(currentPhoto) {
+		var err error
+		photos, err = streamPhotoIndex(ctx, n.db, query)
+		if err != nil {
+			return photoDirChildren{}, fmt.Errorf("failed to get photos of rating %q: %w", n.Name(), err)
+		}
+		current = currentPhotoFor(currentPhoto, photos, n.opts.clock())
+	}
+
+	dir := templateDir{
+		db:           n.db,
+		opts:         n.opts,
+		selector:     selector,
+		currentPhoto: currentPhoto,
+		pageSize:     n.opts.pageSize(),
+		photos:       photos,
+		rating:       n,
 	}
+	childrenNodes := dir.nodes(n.opts.template(n.template, types.DefaultRatingTemplate))
 	ignoreDups := false
-	return nodeSliceToNodeMap(childrenNodes, ignoreDups)
+	children, err := nodeSliceToNodeMap(childrenNodes, ignoreDups)
+	if err != nil {
+		return photoDirChildren{}, err
+	}
+	return photoDirChildren{
+		children: children,
+		current:  current,
+	}, nil
+}
+
+// photosNode gets the node for the photos directory of a rating. The rating of
+// a photo can be changed by moving it into the photos directory of an "=="
+// rating.
+func (n *ratingNode) photosNode(query types.Query, currentPhoto *types.CurrentPhoto, pageSize int, photos *photoIndex) Node {
+	q := queryNode{db: n.db, name: "photos", query: query, currentPhoto: currentPhoto, now: n.opts.clock(), pageSize: pageSize, photos: photos}
+
+	// There is no single rating we could give a photo moved into a ">="
+	// directory, so we don't allow it.
+	if n.operator != types.Equal {
+		return &q
+	}
+	return &ratingPhotosNode{queryNode: q, rating: n.rating, opts: n.opts}
+}
+
+// ratingPhotosNode is the photos directory of an "==" rating, moving a photo
+// into the directory sets the rating of the photo.
+type ratingPhotosNode struct {
+	queryNode
+	rating float64
+	opts   *Options
+}
+
+var _ = (Node)((*ratingPhotosNode)(nil))
+var _ = (DirNode)((*ratingPhotosNode)(nil))
+var _ = (MoveIntoDirNode)((*ratingPhotosNode)(nil))
+
+func (n *ratingPhotosNode) INode(ctx context.Context) (fs.InodeEmbedder, error) {
+	return NewDirINode(ctx, n)
+}
+
+func (n *ratingPhotosNode) photoChildren(ctx context.Context) (photoDirChildren, error) {
+	return n.photoChildrenIn(ctx, n)
+}
+
+func (n *ratingPhotosNode) MoveInto(ctx context.Context, child Node, newName string) error {
+	if !n.opts.writable() {
+		return db.ErrReadOnly
+	}
+	p, ok := child.(*photoNode)
+	if !ok {
+		return syscall.EPERM
+	}
+	return n.db.SetRating(ctx, p.photo, n.rating)
 }
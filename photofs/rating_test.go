@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"syscall"
 	"testing"
 
 	"github.com/anitschke/photo-db-fs/db"
@@ -39,10 +40,6 @@ func TestRatingFS(t *testing.T) {
 	assert := assert.New(t)
 
 	mockDB := mocks.NewDB(t)
-	mockDB.On("Ratings").Return(
-		[]float64{1, 2, 3},
-		nil,
-	).Once()
 
 	ctx := context.Background()
 	ratingNode, err := rootRatingInode(ctx, mockDB)
@@ -67,7 +64,9 @@ func TestRatingFS(t *testing.T) {
 		assert.Nil(err)
 		serverDoneWG.Wait()
 
-		// If we don't walk the DB then we should never need to query about photos
+		// If we don't walk the DB then we should never need to query about
+		// ratings or photos
+		mockDB.AssertNotCalled(t, "Ratings")
 		mockDB.AssertNotCalled(t, "Photos", mock.Anything, mock.Anything)
 	}()
 }
@@ -394,3 +393,48 @@ func TestRatingFS_WalkPhotos_NestedUnderAnotherFS(t *testing.T) {
 	expTreeInfo := testtools.GetOrUpdateGoldFile("./"+t.Name()+"_GoldTree.json", actTreeInfo, updateGold)
 	assert.ElementsMatch(actTreeInfo, expTreeInfo)
 }
+
+func TestRatingPhotosNode_MoveInto(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	mockDB := mocks.NewDB(t)
+	photo := types.Photo{Path: "/photos/foo.jpg", ID: "foo"}
+
+	n := ratingPhotosNode{queryNode: queryNode{db: mockDB}, rating: 2, opts: &Options{}}
+	assert.ErrorIs(n.MoveInto(ctx, &photoNode{photo: photo}, "foo.jpg"), db.ErrReadOnly)
+
+	n.opts = &Options{Writable: true}
+	mockDB.On("SetRating", mock.Anything, photo, float64(2)).Return(db.ErrLocked).Once()
+	assert.ErrorIs(n.MoveInto(ctx, &photoNode{photo: photo}, "foo.jpg"), db.ErrLocked)
+	assert.Equal(syscall.EPERM, n.MoveInto(ctx, &currentPhotoNode{}, "current.jpg"))
+
+	// Only "==" ratings allow moving photos into them.
+	r := ratingNode{operator: types.GreaterThanOrEqual, rating: 2, db: mockDB, opts: n.opts}
+	_, ok := r.photosNode(types.Query{}, nil, 0, nil).(MoveIntoDirNode)
+	assert.False(ok)
+	r.operator = types.Equal
+	_, ok = r.photosNode(types.Query{}, nil, 0, nil).(MoveIntoDirNode)
+	assert.True(ok)
+}
+
+func TestRatingsParentNode_Counts(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	base := types.HasTag{Tag: types.Tag{Path: []string{"a"}}}
+	counter := countingDB{DB: mocks.NewDB(t)}
+	counter.On("Ratings").Return([]float64{1, 2, 3})
+	counter.On("RatingCounts", mock.Anything, types.Query{Selector: base}).Return([]types.RatingCount{
+		{Rating: 1, Photos: 4},
+		{Rating: 3, Photos: 2},
+	}, nil).Once()
+
+	layout := types.DefaultLayout()
+	layout.CountsInNames = true
+	n := &ratingsParentNode{db: counter, opts: &Options{Layout: &layout}, baseSelector: base}
+	children, err := n.Children(ctx)
+	assert.Nil(err)
+	assert.ElementsMatch([]string{"==1 (4)", ">=1 (6)", "==2 (0)", ">=2 (2)", "==3 (2)"}, childNames(children))
+	assert.Equal("==1", children["==1 (4)"].(AliasedNode).Alias())
+}
@@ -0,0 +1,89 @@
+package photofs
+
+import (
+	"context"
+	"syscall"
+	"time"
+
+	"github.com/anitschke/photo-db-fs/db"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"go.uber.org/zap"
+)
+
+// watchForChanges watches the DB for changes until the server is unmounted.
+// When the DB changes every directory the kernel knows about is refreshed in
+// place. If interval is zero then we don't watch for changes.
+func watchForChanges(ctx context.Context, server *fuse.Server, root *fs.Inode, photoDB db.DB, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	changes, err := db.Watch(ctx, photoDB, interval)
+	if err != nil {
+		cancel()
+		zap.L().Error("failed to watch DB, changes to the DB will not be detected", zap.Error(err))
+		return
+	}
+
+	go func() {
+		server.Wait()
+		cancel()
+	}()
+
+	go func() {
+		for e := range changes {
+			// We don't have a good way to map a change scope to the
+			// directories that depend on it, so we just refresh everything.
+			zap.L().Debug("detected DB change, refreshing file system", zap.Stringer("scope", e.Scope))
+			refreshTree(ctx, root)
+		}
+	}()
+}
+
+// refreshTree refreshes the children of every DirINode in the tree under n and
+// tells the kernel to forget about any entries that have changed.
+//
+// go-fuse only keeps an Inode in the tree for as long as the kernel knows about
+// it, so walking the tree refreshes exactly the directories that the kernel
+// might have cached.
+func refreshTree(ctx context.Context, n *fs.Inode) {
+	// The metadata of a photo may have changed even if where it shows up
+	// didn't, so we drop any metadata cached for extended attributes.
+	if p, ok := n.Operations().(*photoSymlink); ok {
+		p.clearMetadata()
+		return
+	}
+
+	d, ok := n.Operations().(*DirINode)
+	if !ok {
+		return
+	}
+
+	changed, err := d.refresh(ctx)
+	if err != nil {
+		zap.L().Error("failed to refresh directory", zap.String("path", n.Path(nil)), zap.Error(err))
+		return
+	}
+
+	for _, name := range changed {
+		// Drop the child from the tree so the next lookup creates a new INode
+		// for the new Node rather than reusing the old one.
+		n.RmChild(name)
+
+		// ENOENT just means the kernel didn't have the entry cached.
+		if errno := n.NotifyEntry(name); errno != 0 && errno != syscall.ENOENT {
+			zap.L().Debug("failed to notify kernel of changed entry", zap.String("name", name), zap.Error(errno))
+		}
+	}
+	if len(changed) > 0 {
+		if errno := n.NotifyContent(0, 0); errno != 0 && errno != syscall.ENOENT {
+			zap.L().Debug("failed to notify kernel of changed directory", zap.String("path", n.Path(nil)), zap.Error(errno))
+		}
+	}
+
+	for _, c := range n.Children() {
+		refreshTree(ctx, c)
+	}
+}
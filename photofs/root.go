@@ -2,14 +2,129 @@ package photofs
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/anitschke/photo-db-fs/db"
 	"github.com/anitschke/photo-db-fs/types"
 	"github.com/hanwen/go-fuse/v2/fs"
 )
 
-func NewRoot(ctx context.Context, db db.DB, queries []types.NamedQuery) (fs.InodeEmbedder, error) {
-	return NewDirINode(ctx, &rootNode{db: db, queries: queries})
+// Options control how the photos in the DB are presented in the file system.
+type Options struct {
+	// CurrentPhoto is the default configuration for the rotating current.<ext>
+	// symlink that is added to directories of photos. If it is nil then no
+	// current.<ext> symlinks are added.
+	CurrentPhoto *types.CurrentPhoto
+
+	// RefreshInterval is how often to check if the DB has changed so the file
+	// system can be refreshed. If it is zero, or the DB can't tell when it has
+	// changed, then the file system is never refreshed.
+	RefreshInterval time.Duration
+
+	// Writable allows modifying the DB through the file system. The DB must
+	// also have been opened as writable.
+	Writable bool
+
+	// Layout controls which directories are in the file system and what they
+	// are called. If it is nil then the types.DefaultLayout is used.
+	Layout *types.Layout
+
+	// PageSize is how many photos a directory of photos can have before they
+	// are split into pages. If it is zero they never are.
+	PageSize int
+
+	// Clock gets the current local time, which decides what is in date based
+	// directories such as the on this day view. If it is nil time.Now is
+	// used.
+	Clock func() time.Time
+
+	// MaxLoadedDirs is how many directories can have their children loaded
+	// from the DB at once, the children of the least recently used directories
+	// are released past this. If it is zero there is no limit.
+	MaxLoadedDirs int
+}
+
+var defaultLayout = types.DefaultLayout()
+
+// layout gets the Layout, it is safe to call on nil Options.
+func (o *Options) layout() *types.Layout {
+	if o == nil || o.Layout == nil {
+		return &defaultLayout
+	}
+	return o.Layout
+}
+
+// viewName gets the name of the directory for a view. Views that are nested
+// within other directories, such as ratings, use the same name as the top
+// level view even if the top level view isn't enabled.
+func (o *Options) viewName(v types.View) string {
+	return o.layout().ViewName(v)
+}
+
+// template gets the view template with the given name, or the template named
+// fallback if name is empty. Templates are validated when the layout is
+// created, so if there is no such template we just use an empty one.
+func (o *Options) template(name, fallback string) types.ViewTemplate {
+	t, _ := o.layout().Template(name, fallback)
+	return t
+}
+
+// tagTemplate gets the name of the view template used for tags.
+func (o *Options) tagTemplate() string {
+	if t := o.layout().TagTemplate; t != "" {
+		return t
+	}
+	return types.DefaultTagTemplate
+}
+
+// countedName adds the number of photos to the name of a directory if the
+// layout asks for it and the number is known.
+func (o *Options) countedName(name string, count int, counted bool) string {
+	if !counted || !o.layout().CountsInNames {
+		return name
+	}
+	return fmt.Sprintf("%s (%d)", name, count)
+}
+
+// currentPhoto gets the CurrentPhoto config, it is safe to call on nil Options
+// so nodes that were created without options use the defaults.
+func (o *Options) currentPhoto() *types.CurrentPhoto {
+	if o == nil {
+		return nil
+	}
+	return o.CurrentPhoto
+}
+
+// pageSize gets the PageSize, it is safe to call on nil Options.
+func (o *Options) pageSize() int {
+	if o == nil {
+		return 0
+	}
+	return o.PageSize
+}
+
+// clock gets the Clock, it is safe to call on nil Options.
+func (o *Options) clock() func() time.Time {
+	if o == nil || o.Clock == nil {
+		return time.Now
+	}
+	return o.Clock
+}
+
+// writable checks if the file system is writable, it is safe to call on nil
+// Options.
+func (o *Options) writable() bool {
+	return o != nil && o.Writable
+}
+
+func NewRoot(ctx context.Context, db db.DB, queries []types.NamedQuery, opts Options) (fs.InodeEmbedder, error) {
+	in, err := NewDirINode(ctx, &rootNode{db: db, queries: queries, opts: &opts})
+	if err != nil {
+		return nil, err
+	}
+	in.(*DirINode).lru = newDirLRU(opts.MaxLoadedDirs)
+	return in, nil
 }
 
 // rootNode is the root FUSE directory that contains the rest of our FUSE file
@@ -17,16 +132,53 @@ func NewRoot(ctx context.Context, db db.DB, queries []types.NamedQuery) (fs.Inod
 type rootNode struct {
 	db      db.DB
 	queries []types.NamedQuery
+	opts    *Options
 }
 
 var _ = (DirNode)((*rootNode)(nil))
 
 func (n *rootNode) Children(ctx context.Context) (map[string]Node, error) {
-	nodes := []Node{
-		&rootTagsNode{db: n.db},
-		&rootQueriesNode{db: n.db, queries: n.queries},
-		&ratingsParentNode{db: n.db},
+	layout := n.opts.layout()
+
+	var nodes []Node
+	if layout.RootTemplate != "" {
+		dir := templateDir{db: n.db, opts: n.opts, root: n}
+		nodes = dir.nodes(n.opts.template(layout.RootTemplate, ""))
+	} else {
+		for _, v := range types.AllViews {
+			if _, ok := layout.Views[v]; !ok {
+				continue
+			}
+			nodes = append(nodes, n.viewNode(v))
+		}
+	}
+
+	queries := &rootQueriesNode{db: n.db, opts: n.opts, queries: n.queries}
+	if layout.QueriesAtRoot {
+		nodes = append(nodes, queries.queryNodes()...)
 	}
+
 	ignoreDups := false
 	return nodeSliceToNodeMap(nodes, ignoreDups)
 }
+
+func (n *rootNode) viewNode(v types.View) Node {
+	switch v {
+	case types.TagsView:
+		return &rootTagsNode{db: n.db, opts: n.opts}
+	case types.AlbumsView:
+		return &rootAlbumsNode{db: n.db, opts: n.opts}
+	case types.CamerasView:
+		return &rootCamerasNode{db: n.db, opts: n.opts}
+	case types.LensesView:
+		return &rootLensesNode{db: n.db, opts: n.opts}
+	case types.OnThisDayView:
+		return &onThisDayNode{db: n.db, opts: n.opts, now: n.opts.clock()}
+	case types.QueriesView:
+		return &rootQueriesNode{db: n.db, opts: n.opts, queries: n.queries}
+	case types.RatingsView:
+		return &ratingsParentNode{db: n.db, opts: n.opts}
+	default:
+		panic(fmt.Sprintf("unknown view %q", string(v)))
+	}
+}
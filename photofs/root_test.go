@@ -0,0 +1,126 @@
+package photofs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/anitschke/photo-db-fs/db/mocks"
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func childNames(children map[string]Node) []string {
+	names := make([]string, 0, len(children))
+	for name := range children {
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestRootNode_DefaultLayout(t *testing.T) {
+	assert := assert.New(t)
+
+	n := rootNode{db: mocks.NewDB(t)}
+	children, err := n.Children(context.Background())
+	assert.Nil(err)
+	assert.ElementsMatch([]string{"tags", "albums", "cameras", "lenses", "on-this-day", "queries", "ratings"}, childNames(children))
+}
+
+func TestRootNode_Layout(t *testing.T) {
+	assert := assert.New(t)
+
+	mockDB := mocks.NewDB(t)
+	mockDB.On("Ratings").Return([]float64{0, 1})
+	layout := types.Layout{
+		Views: map[types.View]string{
+			types.TagsView:    "Tags",
+			types.RatingsView: "Stars",
+		},
+		QueriesAtRoot:                true,
+		EqualRatingName:              "{rating}-stars",
+		GreaterThanOrEqualRatingName: "{rating}-stars-and-up",
+	}
+	n := rootNode{
+		db:      mockDB,
+		queries: []types.NamedQuery{{Name: "TV", Query: types.Query{Selector: types.HasTag{Tag: makeTag("TV")}}}},
+		opts:    &Options{Layout: &layout},
+	}
+
+	ctx := context.Background()
+	children, err := n.Children(ctx)
+	assert.Nil(err)
+	assert.ElementsMatch([]string{"Tags", "Stars", "TV"}, childNames(children))
+
+	ratings, err := children["Stars"].(DirNode).Children(ctx)
+	assert.Nil(err)
+	assert.ElementsMatch([]string{"0-stars", "0-stars-and-up", "1-stars"}, childNames(ratings))
+
+	// Nested rating directories use the same names.
+	mockDB.On("RootTags", mock.Anything).Return([]types.Tag{makeTag("a")}, nil).Once()
+	tags, err := children["Tags"].(DirNode).Children(ctx)
+	assert.Nil(err)
+	tag, err := tags["a"].(DirNode).Children(ctx)
+	assert.Nil(err)
+	assert.Contains(tag, "Stars")
+
+	// A query at the root can't have the same name as a view.
+	n.queries[0].Name = "Tags"
+	_, err = n.Children(ctx)
+	assert.Error(err)
+}
+
+func TestRootNode_Template(t *testing.T) {
+	assert := assert.New(t)
+
+	mockDB := mocks.NewDB(t)
+	mockDB.On("Ratings").Return([]float64{1})
+	layout := types.DefaultLayout()
+	layout.RootTemplate = "root"
+	layout.Templates["root"] = types.ViewTemplate{
+		{View: types.TagsView, Template: "simpleTag"},
+		{View: types.RatingsView, Template: "ratingWithTags"},
+	}
+	layout.Templates["simpleTag"] = types.ViewTemplate{
+		{View: types.TagsView, Template: "simpleTag"},
+		{View: types.PhotosView},
+	}
+	layout.Templates["ratingWithTags"] = types.ViewTemplate{
+		{View: types.PhotosView},
+		{View: types.TagsView, Template: "simpleTag"},
+	}
+	n := rootNode{db: mockDB, opts: &Options{Layout: &layout}}
+
+	ctx := context.Background()
+	children, err := n.Children(ctx)
+	assert.Nil(err)
+	assert.ElementsMatch([]string{"tags", "ratings"}, childNames(children))
+
+	mockDB.On("RootTags", mock.Anything).Return([]types.Tag{makeTag("a")}, nil).Once()
+	tags, err := children["tags"].(DirNode).Children(ctx)
+	assert.Nil(err)
+	tag, err := tags["a"].(DirNode).Children(ctx)
+	assert.Nil(err)
+	assert.ElementsMatch([]string{"tags", "photos"}, childNames(tag))
+
+	ratings, err := children["ratings"].(DirNode).Children(ctx)
+	assert.Nil(err)
+	rating, err := ratings["==1"].(DirNode).Children(ctx)
+	assert.Nil(err)
+	assert.ElementsMatch([]string{"photos", "tags"}, childNames(rating))
+
+	// The tags of a rating only include the tags of the photos with the rating
+	// and narrow down the photos of the rating.
+	hasRating := types.HasRating{Operator: types.Equal, Rating: 1}
+	mockDB.On("PhotoTags", mock.Anything, types.Query{Selector: hasRating}).Return([]types.Tag{makeTag("b")}, nil).Once()
+	ratingTags, err := rating["tags"].(DirNode).Children(ctx)
+	assert.Nil(err)
+	assert.ElementsMatch([]string{"b"}, childNames(ratingTags))
+	ratingTag, err := ratingTags["b"].(DirNode).Children(ctx)
+	assert.Nil(err)
+	assert.ElementsMatch([]string{"tags", "photos"}, childNames(ratingTag))
+
+	mockDB.On("Photos", mock.Anything, types.Query{Selector: types.And{Operands: []types.Selector{types.HasTag{Tag: makeTag("b")}, hasRating}}}).Return([]types.Photo{}, nil).Once()
+	_, err = ratingTag["photos"].(DirNode).Children(ctx)
+	assert.Nil(err)
+}
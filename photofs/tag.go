@@ -2,8 +2,13 @@ package photofs
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"path"
+	"path/filepath"
+	"sync"
+	"syscall"
 
 	"github.com/anitschke/photo-db-fs/db"
 	"github.com/anitschke/photo-db-fs/types"
@@ -14,14 +19,23 @@ import (
 // rootTagsNode is the top FUSE directory that contains the whole tag hierarchy
 // under it.
 type rootTagsNode struct {
-	db db.DB
+	db   db.DB
+	opts *Options
+
+	// template is the name of the view template used for the tags, if empty
+	// the tag template of the layout is used.
+	template string
+
+	// library has the counts of the photos of every tag, which are shared with
+	// all of the tags under this directory.
+	library *libraryTagCounts
 }
 
 var _ = (Node)((*rootTagsNode)(nil))
 var _ = (DirNode)((*rootTagsNode)(nil))
 
 func (n *rootTagsNode) Name() string {
-	return "tags"
+	return n.opts.viewName(types.TagsView)
 }
 
 func (n *rootTagsNode) Mode() uint32 {
@@ -37,25 +51,115 @@ func (n *rootTagsNode) Children(ctx context.Context) (map[string]Node, error) {
 	if err != nil {
 		return nil, err
 	}
-	return tagSliceToNodeMap(n.db, rootTags)
+	counts, err := countTags(ctx, n.db, types.And{})
+	if err != nil {
+		return nil, err
+	}
+	if n.library == nil {
+		n.library = &libraryTagCounts{}
+	}
+	n.library.set(counts)
+	return tagSliceToNodeMap(n.db, n.opts, nil, n.library, n.template, rootTags, counts)
+}
+
+// libraryTagCounts are the number of photos of every tag in the library. They
+// are counted when the root of the tag hierarchy is listed and shared with all
+// of the tags under it, rather than every tag counting the whole library again.
+// The root is refreshed before the tags under it, so they always get the latest
+// counts.
+type libraryTagCounts struct {
+	mu     sync.Mutex
+	counts *tagCounts
+}
+
+// get gets the counts, it is nil if the photos aren't counted.
+func (l *libraryTagCounts) get() *tagCounts {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.counts
+}
+
+func (l *libraryTagCounts) set(counts *tagCounts) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.counts = counts
+}
+
+var _ = (MkdirDirNode)((*rootTagsNode)(nil))
+
+func (n *rootTagsNode) Mkdir(ctx context.Context, name string) error {
+	if !n.opts.writable() {
+		return db.ErrReadOnly
+	}
+	return n.db.CreateTag(ctx, types.Tag{Path: []string{name}})
 }
 
 type tagNodeInfo struct {
-	tag types.Tag
-	db  db.DB
+	tag  types.Tag
+	db   db.DB
+	opts *Options
+
+	// facet is used to narrow down the photos of tags that are nested under an
+	// "and" or "not" directory. For the plain tag hierarchy it is nil.
+	facet *tagFacet
+
+	// library has the counts of the plain tag hierarchy, see libraryTagCounts.
+	// It is nil under an "and" or "not" directory.
+	library *libraryTagCounts
+
+	// template is the name of the view template used for the children of the
+	// tag, if empty the tag template of the layout is used.
+	template string
+}
+
+// selector gets the selector for the photos that are represented by the tag
+// node.
+func (i tagNodeInfo) selector() types.Selector {
+	tagSelector := types.HasTag{Tag: i.tag}
+	if i.facet == nil {
+		return tagSelector
+	}
+	return i.facet.narrow(tagSelector)
 }
 
 type tagNode struct {
 	tagNodeInfo
+
+	// photos is the number of photos the tag is directly applied to, it is
+	// only known if counted is set.
+	photos  int
+	counted bool
+
+	// empty is set if the tag and all of its descendants aren't applied to
+	// any photos.
+	empty bool
 }
 
 var _ = (Node)((*tagNode)(nil))
 var _ = (DirNode)((*tagNode)(nil))
+var _ = (CountedNode)((*tagNode)(nil))
+var _ = (AliasedNode)((*tagNode)(nil))
+var _ = (HiddenNode)((*tagNode)(nil))
 
 func (n *tagNode) Name() string {
+	return n.opts.countedName(n.tag.Name(), n.photos, n.counted)
+}
+
+func (n *tagNode) Alias() string {
 	return n.tag.Name()
 }
 
+func (n *tagNode) PhotoCount() (int, bool) {
+	return n.photos, n.counted
+}
+
+func (n *tagNode) Hidden() bool {
+	return n.empty && n.opts.layout().HideEmptyTags
+}
+
 func (n *tagNode) Mode() uint32 {
 	return fuse.S_IFDIR
 }
@@ -65,11 +169,27 @@ func (n *tagNode) INode(ctx context.Context) (fs.InodeEmbedder, error) {
 }
 
 func (n *tagNode) Children(ctx context.Context) (map[string]Node, error) {
-	tagSelector := types.HasTag{Tag: n.tag}
-	childrenNodes := []Node{
-		&childTagsNode{tagNodeInfo: n.tagNodeInfo},
-		&ratingsParentNode{db: n.db, baseSelector: tagSelector},
-		&queryNode{db: n.db, name: "photos", query: types.Query{Selector: tagSelector}},
+	dir := templateDir{
+		db:           n.db,
+		opts:         n.opts,
+		selector:     n.selector(),
+		currentPhoto: n.opts.currentPhoto(),
+		pageSize:     n.opts.pageSize(),
+		tag:          n,
+	}
+	childrenNodes := dir.nodes(n.opts.template(n.template, n.opts.tagTemplate()))
+
+	// A tag that is only there so that the tags under it can be reached
+	// doesn't narrow down the photos, so there is nothing to show for it but
+	// those tags.
+	if n.facet != nil && !n.facet.tags.narrows(n.tag) {
+		tagsNodes := childrenNodes[:0]
+		for _, c := range childrenNodes {
+			if _, ok := c.(*childTagsNode); ok {
+				tagsNodes = append(tagsNodes, c)
+			}
+		}
+		childrenNodes = tagsNodes
 	}
 	ignoreDups := false
 	return nodeSliceToNodeMap(childrenNodes, ignoreDups)
@@ -77,6 +197,10 @@ func (n *tagNode) Children(ctx context.Context) (map[string]Node, error) {
 
 type childTagsNode struct {
 	tagNodeInfo
+
+	// template is the name of the view template used for the child tags, if
+	// empty the tag template of the layout is used.
+	template string
 }
 
 var _ = (Node)((*childTagsNode)(nil))
@@ -94,20 +218,216 @@ func (n *childTagsNode) INode(ctx context.Context) (fs.InodeEmbedder, error) {
 	return NewDirINode(ctx, n)
 }
 
+var _ = (MkdirDirNode)((*childTagsNode)(nil))
+
+func (n *childTagsNode) Mkdir(ctx context.Context, name string) error {
+	if !n.opts.writable() {
+		return db.ErrReadOnly
+	}
+	// Creating a tag under an "and" or "not" directory wouldn't narrow down
+	// the photos at all, so we don't allow it.
+	if n.facet != nil {
+		return syscall.EPERM
+	}
+	tagPath := make([]string, 0, len(n.tag.Path)+1)
+	tagPath = append(tagPath, n.tag.Path...)
+	tagPath = append(tagPath, name)
+	return n.db.CreateTag(ctx, types.Tag{Path: tagPath})
+}
+
 func (n *childTagsNode) Children(ctx context.Context) (map[string]Node, error) {
 
+	// Under an "and" or "not" directory we only show the tags that actually
+	// narrow down the photos, which we already know from the facet.
+	if n.facet != nil {
+		return tagSliceToNodeMap(n.db, n.opts, n.facet, nil, n.template, n.facet.tags.children(n.tag), n.facet.counts)
+	}
+
 	children, err := n.db.ChildrenTags(ctx, n.tag)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get tags that are children of tag %q: %w", path.Join(n.tag.Path...), err)
 	}
-	return tagSliceToNodeMap(n.db, children)
+	return tagSliceToNodeMap(n.db, n.opts, nil, n.library, n.template, children, n.library.get())
 }
 
-func tagSliceToNodeMap(db db.DB, tagSlice []types.Tag) (map[string]Node, error) {
+// tagSliceToNodeMap creates the directories for the tags. If counts is nil
+// then the number of photos of each tag isn't known.
+func tagSliceToNodeMap(db db.DB, opts *Options, facet *tagFacet, library *libraryTagCounts, template string, tagSlice []types.Tag, counts *tagCounts) (map[string]Node, error) {
 	nodes := make([]Node, 0, len(tagSlice))
 	for _, t := range tagSlice {
-		nodes = append(nodes, &tagNode{tagNodeInfo: tagNodeInfo{db: db, tag: t}})
+		n := &tagNode{tagNodeInfo: tagNodeInfo{db: db, opts: opts, tag: t, facet: facet, library: library, template: template}}
+		if counts != nil {
+			key := tagTreeKey(t.Path)
+			n.photos = counts.photos[key]
+			n.counted = true
+			_, nonEmpty := counts.nonEmpty[key]
+			n.empty = !nonEmpty
+		}
+		nodes = append(nodes, n)
 	}
 	ignoreDups := false
 	return nodeSliceToNodeMap(nodes, ignoreDups)
 }
+
+// tagCounts are the number of photos of each tag within some set of photos.
+type tagCounts struct {
+	// tags are the tags that are applied to at least one of the photos.
+	tags []types.Tag
+
+	// photos maps the key of each tag to the number of photos that the tag is
+	// directly applied to, see tagTreeKey.
+	photos map[string]int
+
+	// nonEmpty has the keys of the tags that are applied to at least one of
+	// the photos, along with the keys of all of their ancestors.
+	nonEmpty map[string]struct{}
+}
+
+// countTags counts the photos of every tag within the photos selected by s. It
+// returns nil if the DB can't count photos.
+func countTags(ctx context.Context, d db.DB, s types.Selector) (*tagCounts, error) {
+	var counts []types.TagCount
+	q, ok := optimizeQuery(types.Query{Selector: s})
+	if ok {
+		var err error
+		counts, err = db.TagCounts(ctx, d, q)
+		if errors.Is(err, db.ErrNotSupported) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to count photos of tags: %w", err)
+		}
+	}
+
+	c := &tagCounts{
+		photos:   make(map[string]int, len(counts)),
+		nonEmpty: make(map[string]struct{}),
+	}
+	for _, tc := range counts {
+		if tc.Photos == 0 {
+			continue
+		}
+		c.tags = append(c.tags, tc.Tag)
+		c.photos[tagTreeKey(tc.Tag.Path)] = tc.Photos
+		for i := 1; i <= len(tc.Tag.Path); i++ {
+			c.nonEmpty[tagTreeKey(tc.Tag.Path[:i])] = struct{}{}
+		}
+	}
+	return c, nil
+}
+
+// photoTags gets the tags that are applied to the photos selected by s.
+func photoTags(ctx context.Context, d db.DB, s types.Selector) ([]types.Tag, error) {
+	q, ok := optimizeQuery(types.Query{Selector: s})
+	if !ok {
+		return nil, nil
+	}
+	return d.PhotoTags(ctx, q)
+}
+
+// narrowing gets the tags that are applied to some but not all of the photos,
+// which are the tags that narrow the photos down both when selecting the photos
+// with the tag and when excluding them. total is the number of photos.
+func (c *tagCounts) narrowing(tags []types.Tag, total int) []types.Tag {
+	var narrowing []types.Tag
+	for _, t := range tags {
+		if count := c.photos[tagTreeKey(t.Path)]; count > 0 && count < total {
+			narrowing = append(narrowing, t)
+		}
+	}
+	return narrowing
+}
+
+// remaining gets the number of photos that are left when photos with each of
+// the tags in the tree are excluded from the photos, total is the number of
+// photos. Every tag in the tree is taken to leave some photos, see narrowing.
+func (c *tagCounts) remaining(tree *tagTree, total int) *tagCounts {
+	r := &tagCounts{
+		photos:   make(map[string]int),
+		nonEmpty: make(map[string]struct{}),
+	}
+	for _, t := range tree.all() {
+		key := tagTreeKey(t.Path)
+		r.photos[key] = total - c.photos[key]
+		r.nonEmpty[key] = struct{}{}
+	}
+	return r
+}
+
+// photosNode gets the node for the photos directory of a tag. In the plain tag
+// hierarchy photos can be tagged and untagged through this directory.
+func (n *tagNode) photosNode(tagSelector types.Selector) Node {
+	q := queryNode{db: n.db, name: "photos", query: types.Query{Selector: tagSelector}, currentPhoto: n.opts.currentPhoto(), now: n.opts.clock(), pageSize: n.opts.pageSize()}
+
+	// Under an "and" or "not" directory adding or removing a single tag wouldn't
+	// result in the photo showing up or going away, so we don't allow it.
+	if n.facet != nil {
+		return &q
+	}
+	return &tagPhotosNode{queryNode: q, tag: n.tag, opts: n.opts}
+}
+
+// tagPhotosNode is the photos directory of a tag, which allows tagging photos
+// by creating a symlink to or copying a photo into the directory, and untagging
+// them by removing them from the directory.
+type tagPhotosNode struct {
+	queryNode
+	tag  types.Tag
+	opts *Options
+}
+
+var _ = (Node)((*tagPhotosNode)(nil))
+var _ = (DirNode)((*tagPhotosNode)(nil))
+var _ = (SymlinkDirNode)((*tagPhotosNode)(nil))
+var _ = (CreateDirNode)((*tagPhotosNode)(nil))
+var _ = (UnlinkDirNode)((*tagPhotosNode)(nil))
+
+func (n *tagPhotosNode) INode(ctx context.Context) (fs.InodeEmbedder, error) {
+	return NewDirINode(ctx, n)
+}
+
+func (n *tagPhotosNode) photoChildren(ctx context.Context) (photoDirChildren, error) {
+	return n.photoChildrenIn(ctx, n)
+}
+
+func (n *tagPhotosNode) Symlink(ctx context.Context, target, name string) error {
+	if !n.opts.writable() {
+		return db.ErrReadOnly
+	}
+	// We have no idea where a relative link would be relative to outside of
+	// this file system, so we need the full path to the photo.
+	if !filepath.IsAbs(target) {
+		return syscall.EINVAL
+	}
+	return n.db.AddTag(ctx, types.Photo{Path: target}, n.tag)
+}
+
+func (n *tagPhotosNode) Create(ctx context.Context, name string, content io.ReaderAt, size int64) error {
+	if !n.opts.writable() {
+		return db.ErrReadOnly
+	}
+	photos, err := n.db.PhotosWithContent(ctx, content, size)
+	if err != nil {
+		return err
+	}
+	if len(photos) == 0 {
+		return fmt.Errorf("copy of photo %q: %w", name, db.ErrNotFound)
+	}
+	for _, p := range photos {
+		if err := n.db.AddTag(ctx, p, n.tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *tagPhotosNode) Unlink(ctx context.Context, child Node) error {
+	if !n.opts.writable() {
+		return db.ErrReadOnly
+	}
+	p, ok := child.(*photoNode)
+	if !ok {
+		return syscall.EPERM
+	}
+	return n.db.RemoveTag(ctx, p.photo, n.tag)
+}
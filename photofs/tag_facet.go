@@ -0,0 +1,218 @@
+package photofs
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// tagFacetNode is the "and" or "not" directory under a tag. It contains the tag
+// hierarchy again, but only the tags that are applied to at least one of the
+// photos of the parent tag. Entering one of those tags narrows down the photos
+// of the parent tag to those that also have (for "and") or don't have (for
+// "not") the tag. Since the tags under it have their own "and" and "not"
+// directories this allows drilling down into any intersection of tags just by
+// navigating paths, for example tags/People/tags/Alice/and/Location/tags/Paris/photos
+//
+// Only tags that leave some photos are listed, so going further down always
+// narrows the photos down until there is nothing left to narrow down by. Even
+// so there is a directory for almost every combination of tags, so these are
+// only part of the tag directories if the tag template asks for them.
+type tagFacetNode struct {
+	tagNodeInfo
+	exclude bool
+
+	// photos is the number of photos of the parent tag, it is only known if
+	// counted is set.
+	photos  int
+	counted bool
+
+	// template is the name of the view template used for the tags under the
+	// directory, if empty the tag template of the layout is used.
+	template string
+}
+
+var _ = (Node)((*tagFacetNode)(nil))
+var _ = (DirNode)((*tagFacetNode)(nil))
+
+func (n *tagFacetNode) Name() string {
+	if n.exclude {
+		return "not"
+	}
+	return "and"
+}
+
+func (n *tagFacetNode) Mode() uint32 {
+	return fuse.S_IFDIR
+}
+
+func (n *tagFacetNode) INode(ctx context.Context) (fs.InodeEmbedder, error) {
+	return NewDirINode(ctx, n)
+}
+
+func (n *tagFacetNode) Children(ctx context.Context) (map[string]Node, error) {
+	base := n.selector()
+
+	// Counting the photos of each tag also tells us which tags are applied to
+	// the photos, so if the DB can count photos that is all we need to ask it.
+	counts, err := countTags(ctx, n.db, base)
+	if err != nil {
+		return nil, err
+	}
+	var tags []types.Tag
+	if counts != nil {
+		tags = counts.tags
+	} else {
+		tags, err = photoTags(ctx, n.db, base)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get tags of photos with tag %q: %w", path.Join(n.tag.Path...), err)
+		}
+	}
+
+	// A tag that all of the photos have would leave nothing when excluding it
+	// and wouldn't narrow anything down when selecting it, which we can only
+	// tell if we know how many photos there are. Only listing tags that narrow
+	// down the photos keeps the tree from going on for longer than it needs
+	// to.
+	if counts != nil && n.counted {
+		tags = counts.narrowing(tags, n.photos)
+	} else if n.exclude {
+		counts = nil
+	}
+
+	var selected []types.Tag
+	if n.facet != nil {
+		selected = make([]types.Tag, len(n.facet.selected), len(n.facet.selected)+1)
+		copy(selected, n.facet.selected)
+	}
+	selected = append(selected, n.tag)
+
+	facet := &tagFacet{
+		base:     base,
+		exclude:  n.exclude,
+		selected: selected,
+		tags:     newTagTree(tags, selected),
+	}
+	if counts != nil && n.exclude {
+		counts = counts.remaining(facet.tags, n.photos)
+	}
+	facet.counts = counts
+	return tagSliceToNodeMap(n.db, n.opts, facet, nil, n.template, facet.tags.children(types.Tag{}), facet.counts)
+}
+
+// tagFacet keeps track of the photos that tags under a tagFacetNode are
+// narrowing down.
+type tagFacet struct {
+	// base selects the photos that are being narrowed down
+	base types.Selector
+
+	// exclude is true if photos with the tag should be removed from the base
+	// photos rather than intersected with them.
+	exclude bool
+
+	// selected are all of the tags that have already been used to narrow down
+	// the base photos.
+	selected []types.Tag
+
+	// tags are all of the tags that can be used to further narrow down the
+	// base photos.
+	tags *tagTree
+
+	// counts are the number of the base photos that each tag is applied to,
+	// it is nil if they aren't known.
+	counts *tagCounts
+}
+
+func (f *tagFacet) narrow(tagSelector types.Selector) types.Selector {
+	if f.exclude {
+		return types.Difference{
+			Starting:  f.base,
+			Excluding: tagSelector,
+		}
+	}
+	return types.And{
+		Operands: []types.Selector{
+			f.base,
+			tagSelector,
+		},
+	}
+}
+
+// tagTree is a set of tags, along with all of their ancestors, that can be
+// navigated in the same way as the tag hierarchy of the DB.
+type tagTree struct {
+	childrenOf map[string][]types.Tag
+
+	// narrowing has the keys of the tags the tree was created with, as opposed
+	// to their ancestors that are only in the tree so that they can be
+	// reached.
+	narrowing map[string]struct{}
+}
+
+func newTagTree(tags []types.Tag, ignore []types.Tag) *tagTree {
+	ignored := make(map[string]struct{}, len(ignore))
+	for _, t := range ignore {
+		ignored[tagTreeKey(t.Path)] = struct{}{}
+	}
+
+	tree := &tagTree{
+		childrenOf: make(map[string][]types.Tag),
+		narrowing:  make(map[string]struct{}),
+	}
+	added := make(map[string]struct{})
+	for _, t := range tags {
+		if _, ok := ignored[tagTreeKey(t.Path)]; ok {
+			continue
+		}
+		tree.narrowing[tagTreeKey(t.Path)] = struct{}{}
+
+		// Add the tag and any of its ancestors we haven't seen yet so that
+		// the tag can be reached by navigating down from the root.
+		for i := 1; i <= len(t.Path); i++ {
+			key := tagTreeKey(t.Path[:i])
+			if _, ok := added[key]; ok {
+				continue
+			}
+			added[key] = struct{}{}
+
+			p := make([]string, i)
+			copy(p, t.Path[:i])
+			parentKey := tagTreeKey(t.Path[:i-1])
+			tree.childrenOf[parentKey] = append(tree.childrenOf[parentKey], types.Tag{Path: p})
+		}
+	}
+	return tree
+}
+
+// all returns all of the tags in the tree.
+func (t *tagTree) all() []types.Tag {
+	var tags []types.Tag
+	for _, children := range t.childrenOf {
+		tags = append(tags, children...)
+	}
+	return tags
+}
+
+// narrows gets if the tag narrows down the photos, rather than only being in
+// the tree so that the tags under it can be reached.
+func (t *tagTree) narrows(tag types.Tag) bool {
+	_, ok := t.narrowing[tagTreeKey(tag.Path)]
+	return ok
+}
+
+// children returns the tags in the tree that are direct children of the
+// parent, or the root tags if the parent has an empty path.
+func (t *tagTree) children(parent types.Tag) []types.Tag {
+	return t.childrenOf[tagTreeKey(parent.Path)]
+}
+
+func tagTreeKey(p []string) string {
+	// Tag names can contain just about any character so we use a null
+	// character as a separator since it can't be part of a tag name.
+	return strings.Join(p, "\x00")
+}
@@ -0,0 +1,282 @@
+package photofs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/anitschke/photo-db-fs/db"
+	"github.com/anitschke/photo-db-fs/db/mocks"
+	"github.com/anitschke/photo-db-fs/testtools"
+	"github.com/anitschke/photo-db-fs/types"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// facetLayout is the default layout with "and" and "not" directories added to
+// the tags.
+func facetLayout() *types.Layout {
+	layout := types.DefaultLayout()
+	layout.Templates[types.DefaultTagTemplate] = append(layout.Templates[types.DefaultTagTemplate], types.TemplateView{View: types.AndView}, types.TemplateView{View: types.NotView})
+	return &layout
+}
+
+func tagFacetInode(ctx context.Context, db db.DB, tag types.Tag, exclude bool) (fs.InodeEmbedder, error) {
+	n := tagFacetNode{tagNodeInfo: tagNodeInfo{db: db, opts: &Options{Layout: facetLayout()}, tag: tag}, exclude: exclude}
+	return n.INode(ctx)
+}
+
+// WARNING when there are bugs in these tests they tend to deadlock
+// even with a timeout specified in the test runner. See tag_test.go for more
+// details.
+
+func TestTagFacetFS_Listed(t *testing.T) {
+	assert := assert.New(t)
+
+	// There are far too many combinations of tags to walk, so by default
+	// there aren't any "and" or "not" directories.
+	n := tagNode{tagNodeInfo: tagNodeInfo{db: mocks.NewDB(t), tag: makeTag("a")}}
+	children, err := n.Children(context.Background())
+	assert.Nil(err)
+	assert.ElementsMatch([]string{"tags", "ratings", "photos"}, childNames(children))
+
+	// But when the template asks for them they should be listed in the tag
+	// directory along with the rest of the views so that they can be found.
+	n.opts = &Options{Layout: facetLayout()}
+	children, err = n.Children(context.Background())
+	assert.Nil(err)
+
+	for _, name := range []string{"tags", "ratings", "photos", "and", "not"} {
+		c, ok := children[name]
+		assert.True(ok)
+		_, ok = c.(HiddenNode)
+		assert.False(ok)
+	}
+}
+
+func TestTagFacetFS_WalkAnd(t *testing.T) {
+	assert := assert.New(t)
+
+	mockDB := mocks.NewDB(t)
+
+	// Make the test simpler by making the DB say that it doesn't have any ratings
+	mockDB.On("Ratings").Return(
+		[]float64{},
+		nil,
+	)
+
+	hasA := types.HasTag{Tag: makeTag("a")}
+	hasAA := types.HasTag{Tag: makeTag("a", "a")}
+	hasB := types.HasTag{Tag: makeTag("b")}
+
+	// Note that the tag "a" is applied to all photos with the tag "a" so it
+	// will be returned by the DB, but it shouldn't be listed since it doesn't
+	// narrow anything down. However it should still be listed as a directory
+	// since it is the parent of "a/a", with only the tags under it.
+	mockDB.On("PhotoTags", mock.Anything, types.Query{Selector: hasA}).Return(
+		[]types.Tag{
+			makeTag("a"),
+			makeTag("a", "a"),
+			makeTag("b"),
+		},
+		nil,
+	).Once()
+
+	// The tags under here have nothing left to narrow them down by.
+	mockDB.On("PhotoTags", mock.Anything, mock.Anything).Return(
+		[]types.Tag{},
+		nil,
+	)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		panic(err)
+	}
+
+	libraryRoot := filepath.Join(wd, "..", "test-resources", "photos", "basic")
+
+	taggedByAandAA := types.Photo{
+		Path: filepath.Join(libraryRoot, "album1", "GRAND_00626.jpg"),
+		ID:   "taggedByAandAA",
+	}
+	taggedByAandB := types.Photo{
+		Path: filepath.Join(libraryRoot, "album2", "DSC_0196.jpg"),
+		ID:   "taggedByAandB",
+	}
+
+	mockDB.On("Photos", mock.Anything, types.Query{Selector: types.And{Operands: []types.Selector{hasA, hasAA}}}).Return(
+		[]types.Photo{
+			taggedByAandAA,
+		},
+		nil,
+	).Once()
+	mockDB.On("Photos", mock.Anything, types.Query{Selector: types.And{Operands: []types.Selector{hasA, hasB}}}).Return(
+		[]types.Photo{
+			taggedByAandB,
+		},
+		nil,
+	).Once()
+
+	ctx := context.Background()
+	facetRoot, err := tagFacetInode(ctx, mockDB, makeTag("a"), false)
+	assert.NotNil(facetRoot)
+	assert.Nil(err)
+
+	mountPoint, cleanup, err := testtools.MountPoint()
+	assert.Nil(err)
+	defer cleanup()
+
+	server, err := testtools.MountTestFs(mountPoint, facetRoot)
+	assert.Nil(err)
+	serverDoneWG := sync.WaitGroup{}
+	serverDoneWG.Add(1)
+	go func() {
+		server.Wait()
+		serverDoneWG.Done()
+	}()
+
+	defer func() {
+		err := server.Unmount()
+		assert.Nil(err)
+		serverDoneWG.Wait()
+	}()
+
+	actTreeInfo, err := testtools.Walk(mountPoint)
+	assert.Nil(err)
+
+	testtools.VerifyJpegAreValid(t, actTreeInfo)
+
+	testtools.ToGoldFileFormat(actTreeInfo, mountPoint, libraryRoot)
+	updateGold := false
+	expTreeInfo := testtools.GetOrUpdateGoldFile("./"+t.Name()+"_GoldTree.json", actTreeInfo, updateGold)
+	assert.ElementsMatch(actTreeInfo, expTreeInfo)
+}
+
+func TestTagFacetFS_WalkNot(t *testing.T) {
+	assert := assert.New(t)
+
+	mockDB := mocks.NewDB(t)
+
+	// Make the test simpler by making the DB say that it doesn't have any ratings
+	mockDB.On("Ratings").Return(
+		[]float64{},
+		nil,
+	)
+
+	hasA := types.HasTag{Tag: makeTag("a")}
+	hasB := types.HasTag{Tag: makeTag("b")}
+
+	mockDB.On("PhotoTags", mock.Anything, types.Query{Selector: hasA}).Return(
+		[]types.Tag{
+			makeTag("a"),
+			makeTag("b"),
+		},
+		nil,
+	).Once()
+
+	// The tags under here have nothing left to narrow them down by.
+	mockDB.On("PhotoTags", mock.Anything, mock.Anything).Return(
+		[]types.Tag{},
+		nil,
+	)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		panic(err)
+	}
+
+	libraryRoot := filepath.Join(wd, "..", "test-resources", "photos", "basic")
+
+	taggedByAOnly := types.Photo{
+		Path: filepath.Join(libraryRoot, "album1", "GRAND_00626.jpg"),
+		ID:   "taggedByAOnly",
+	}
+
+	mockDB.On("Photos", mock.Anything, types.Query{Selector: types.Difference{Starting: hasA, Excluding: hasB}}).Return(
+		[]types.Photo{
+			taggedByAOnly,
+		},
+		nil,
+	).Once()
+
+	ctx := context.Background()
+	facetRoot, err := tagFacetInode(ctx, mockDB, makeTag("a"), true)
+	assert.NotNil(facetRoot)
+	assert.Nil(err)
+
+	mountPoint, cleanup, err := testtools.MountPoint()
+	assert.Nil(err)
+	defer cleanup()
+
+	server, err := testtools.MountTestFs(mountPoint, facetRoot)
+	assert.Nil(err)
+	serverDoneWG := sync.WaitGroup{}
+	serverDoneWG.Add(1)
+	go func() {
+		server.Wait()
+		serverDoneWG.Done()
+	}()
+
+	defer func() {
+		err := server.Unmount()
+		assert.Nil(err)
+		serverDoneWG.Wait()
+	}()
+
+	actTreeInfo, err := testtools.Walk(mountPoint)
+	assert.Nil(err)
+
+	testtools.VerifyJpegAreValid(t, actTreeInfo)
+
+	testtools.ToGoldFileFormat(actTreeInfo, mountPoint, libraryRoot)
+	updateGold := false
+	expTreeInfo := testtools.GetOrUpdateGoldFile("./"+t.Name()+"_GoldTree.json", actTreeInfo, updateGold)
+	assert.ElementsMatch(actTreeInfo, expTreeInfo)
+}
+
+func TestTagFacetNode_Counts(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	hasA := types.HasTag{Tag: makeTag("a")}
+
+	// The counts are all that is asked of the DB if it can count photos.
+	counter := countingDB{DB: mocks.NewDB(t)}
+	counter.On("TagCounts", mock.Anything, types.Query{Selector: hasA}).Return([]types.TagCount{
+		{Tag: makeTag("a"), Photos: 4},
+		{Tag: makeTag("b"), Photos: 4},
+		{Tag: makeTag("c"), Photos: 1},
+		{Tag: makeTag("d", "e"), Photos: 3},
+		{Tag: makeTag("f"), Photos: 0},
+	}, nil).Twice()
+
+	count := func(children map[string]Node, name string) int {
+		c, ok := children[name].(CountedNode).PhotoCount()
+		assert.True(ok)
+		return c
+	}
+
+	// Tags that all of the photos have don't narrow them down with "and" and
+	// would leave nothing with "not", so neither of them lists them.
+	n := tagFacetNode{tagNodeInfo: tagNodeInfo{db: counter, tag: makeTag("a")}, photos: 4, counted: true}
+	children, err := n.Children(ctx)
+	assert.Nil(err)
+	assert.ElementsMatch([]string{"c", "d"}, childNames(children))
+	assert.Equal(1, count(children, "c"))
+
+	n.exclude = true
+	children, err = n.Children(ctx)
+	assert.Nil(err)
+	assert.ElementsMatch([]string{"c", "d"}, childNames(children))
+	assert.Equal(3, count(children, "c"))
+
+	// "d" is only there to get to "d/e", so only the tags under it are listed.
+	in, err := children["d"].INode(ctx)
+	assert.Nil(err)
+	d := in.(*DirINode)
+	assert.Nil(d.load(ctx))
+	assert.ElementsMatch([]string{"tags"}, childNames(d.children))
+}
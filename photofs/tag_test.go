@@ -6,7 +6,9 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"syscall"
 	"testing"
 
 	"github.com/anitschke/photo-db-fs/db"
@@ -14,6 +16,7 @@ import (
 	"github.com/anitschke/photo-db-fs/testtools"
 	"github.com/anitschke/photo-db-fs/types"
 	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -29,6 +32,23 @@ func makeTag(path ...string) types.Tag {
 	}
 }
 
+// countingDB is a mock DB that can also count photos.
+type countingDB struct {
+	*mocks.DB
+}
+
+var _ = (db.Counter)(countingDB{})
+
+func (c countingDB) TagCounts(ctx context.Context, q types.Query) ([]types.TagCount, error) {
+	args := c.Called(ctx, q)
+	return args.Get(0).([]types.TagCount), args.Error(1)
+}
+
+func (c countingDB) RatingCounts(ctx context.Context, q types.Query) ([]types.RatingCount, error) {
+	args := c.Called(ctx, q)
+	return args.Get(0).([]types.RatingCount), args.Error(1)
+}
+
 // WARNING when there are bugs in these tests they tend to deadlock
 // even with a timeout specified in the test runner. This seems to happen most
 // if the tagFS needs to make a call out to the mock database and that call
@@ -40,14 +60,6 @@ func TestTagFS(t *testing.T) {
 	assert := assert.New(t)
 
 	tagDB := mocks.NewDB(t)
-	tagDB.On("RootTags", mock.Anything).Return(
-		[]types.Tag{
-			makeTag("a"),
-			makeTag("b"),
-			makeTag("c"),
-		},
-		nil,
-	).Once()
 
 	ctx := context.Background()
 	tagRoot, err := rootTagInode(ctx, tagDB)
@@ -72,8 +84,8 @@ func TestTagFS(t *testing.T) {
 		assert.Nil(err)
 		serverDoneWG.Wait()
 
-		// If we don't walk the DB then we should never need to ask for children
-		// tags of these root tags
+		// If we don't walk the DB then we should never need to ask for any tags
+		tagDB.AssertNotCalled(t, "RootTags", mock.Anything)
 		tagDB.AssertNotCalled(t, "ChildrenTags", mock.Anything, mock.Anything)
 	}()
 }
@@ -290,3 +302,136 @@ func TestTagFS_WalkPhotos(t *testing.T) {
 	expTreeInfo := testtools.GetOrUpdateGoldFile("./"+t.Name()+"_GoldTree.json", actTreeInfo, updateGold)
 	assert.ElementsMatch(actTreeInfo, expTreeInfo)
 }
+
+func TestTagPhotosNode_Writable(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	tag := makeTag("a", "b")
+	photo := types.Photo{Path: "/photos/foo.jpg", ID: "foo"}
+
+	tagDB := mocks.NewDB(t)
+	n := tagPhotosNode{queryNode: queryNode{db: tagDB}, tag: tag, opts: &Options{Writable: true}}
+
+	tagDB.On("AddTag", mock.Anything, types.Photo{Path: "/photos/foo.jpg"}, tag).Return(nil).Once()
+	assert.Nil(n.Symlink(ctx, "/photos/foo.jpg", "foo.jpg"))
+	assert.Equal(syscall.EINVAL, n.Symlink(ctx, "../foo.jpg", "foo.jpg"))
+
+	content := strings.NewReader("foo")
+	tagDB.On("PhotosWithContent", mock.Anything, content, int64(3)).Return([]types.Photo{photo}, nil).Once()
+	tagDB.On("AddTag", mock.Anything, photo, tag).Return(nil).Once()
+	assert.Nil(n.Create(ctx, "foo.jpg", content, 3))
+
+	unknown := strings.NewReader("bar")
+	tagDB.On("PhotosWithContent", mock.Anything, unknown, int64(3)).Return([]types.Photo{}, nil).Once()
+	assert.ErrorIs(n.Create(ctx, "bar.jpg", unknown, 3), db.ErrNotFound)
+
+	tagDB.On("RemoveTag", mock.Anything, photo, tag).Return(nil).Once()
+	assert.Nil(n.Unlink(ctx, &photoNode{photo: photo}))
+	assert.Equal(syscall.EPERM, n.Unlink(ctx, &currentPhotoNode{}))
+}
+
+func TestTagPhotosNode_ReadOnly(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	tagDB := mocks.NewDB(t)
+	n := tagPhotosNode{queryNode: queryNode{db: tagDB}, tag: makeTag("a"), opts: &Options{}}
+
+	assert.ErrorIs(n.Symlink(ctx, "/photos/foo.jpg", "foo.jpg"), db.ErrReadOnly)
+	assert.ErrorIs(n.Create(ctx, "foo.jpg", strings.NewReader("foo"), 3), db.ErrReadOnly)
+	assert.ErrorIs(n.Unlink(ctx, &photoNode{photo: types.Photo{Path: "/photos/foo.jpg"}}), db.ErrReadOnly)
+
+	r := rootTagsNode{db: tagDB}
+	assert.ErrorIs(r.Mkdir(ctx, "a"), db.ErrReadOnly)
+}
+
+func TestTagsNode_Mkdir(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	opts := &Options{Writable: true}
+	tagDB := mocks.NewDB(t)
+
+	r := rootTagsNode{db: tagDB, opts: opts}
+	tagDB.On("CreateTag", mock.Anything, makeTag("a")).Return(nil).Once()
+	assert.Nil(r.Mkdir(ctx, "a"))
+
+	c := childTagsNode{tagNodeInfo: tagNodeInfo{db: tagDB, opts: opts, tag: makeTag("a", "b")}}
+	tagDB.On("CreateTag", mock.Anything, makeTag("a", "b", "c")).Return(db.ErrExists).Once()
+	assert.ErrorIs(c.Mkdir(ctx, "c"), db.ErrExists)
+
+	c.facet = &tagFacet{}
+	assert.Equal(syscall.EPERM, c.Mkdir(ctx, "c"))
+}
+
+func TestTagsNode_Counts(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	all := types.Query{Selector: types.And{}}
+	mockDB := mocks.NewDB(t)
+	counter := countingDB{DB: mockDB}
+	counter.On("RootTags", mock.Anything).Return([]types.Tag{makeTag("a"), makeTag("b"), makeTag("c")}, nil)
+	counter.On("TagCounts", mock.Anything, all).Return([]types.TagCount{
+		{Tag: makeTag("a", "x"), Photos: 3},
+		{Tag: makeTag("b"), Photos: 2},
+	}, nil)
+
+	// The counts are always reported in the attributes of the directories.
+	r := &rootTagsNode{db: counter, opts: &Options{}}
+	children, err := r.Children(ctx)
+	assert.Nil(err)
+	assert.ElementsMatch([]string{"a", "b", "c"}, childNames(children))
+
+	in, err := children["b"].INode(ctx)
+	assert.Nil(err)
+	var out fuse.AttrOut
+	assert.Equal(syscall.Errno(0), in.(*DirINode).Getattr(ctx, nil, &out))
+	assert.Equal(uint64(2), out.Size)
+	assert.Equal(uint32(4), out.Nlink)
+
+	// But only added to the names if the layout asks for it, in which case
+	// they can still be looked up without the count.
+	layout := types.DefaultLayout()
+	layout.CountsInNames = true
+	layout.HideEmptyTags = true
+	r.opts = &Options{Layout: &layout}
+	in, err = r.INode(ctx)
+	assert.Nil(err)
+	d := in.(*DirINode)
+	assert.Nil(d.load(ctx))
+	assert.ElementsMatch([]string{"a (0)", "b (2)", "c (0)"}, childNames(d.children))
+	assert.Same(d.children["b (2)"], d.aliases["b"])
+
+	// Tags that neither they or their descendants are applied to any photos
+	// are hidden.
+	stream, errno := d.Readdir(ctx)
+	assert.Equal(syscall.Errno(0), errno)
+	var listed []string
+	for stream.HasNext() {
+		e, _ := stream.Next()
+		listed = append(listed, e.Name)
+	}
+	assert.ElementsMatch([]string{"a (0)", "b (2)"}, listed)
+
+	// The tags under the root get the counts from the root rather than counting
+	// the whole library again.
+	counter.On("ChildrenTags", mock.Anything, makeTag("a")).Return([]types.Tag{makeTag("a", "x")}, nil)
+	c := &childTagsNode{tagNodeInfo: d.children["a (0)"].(*tagNode).tagNodeInfo}
+	children, err = c.Children(ctx)
+	assert.Nil(err)
+	count, counted := children["x (3)"].(CountedNode).PhotoCount()
+	assert.True(counted)
+	assert.Equal(3, count)
+	counter.AssertNumberOfCalls(t, "TagCounts", 2)
+
+	// Nothing is counted if the DB can't count photos.
+	r.db = mockDB
+	children, err = r.Children(ctx)
+	assert.Nil(err)
+	assert.ElementsMatch([]string{"a", "b", "c"}, childNames(children))
+	_, counted = children["b"].(CountedNode).PhotoCount()
+	assert.False(counted)
+	assert.False(children["c"].(HiddenNode).Hidden())
+}
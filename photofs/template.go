@@ -0,0 +1,84 @@
+package photofs
+
+import (
+	"github.com/anitschke/photo-db-fs/db"
+	"github.com/anitschke/photo-db-fs/types"
+)
+
+// templateDir is a directory whose children are made from a view template.
+type templateDir struct {
+	db   db.DB
+	opts *Options
+
+	// selector selects the photos of the directory, it is nil for the root.
+	selector types.Selector
+
+	// currentPhoto configures the current.<ext> symlink in the photos
+	// directory.
+	currentPhoto *types.CurrentPhoto
+
+	// pageSize is the page size of the photos directory.
+	pageSize int
+
+	// photos are the photos of the directory if they have already been gotten,
+	// see queryNode.photos.
+	photos *photoIndex
+
+	// At most one of these is set depending on what kind of directory it is,
+	// some views behave differently for them. For example the photos of a tag
+	// can be tagged and untagged.
+	root   *rootNode
+	tag    *tagNode
+	rating *ratingNode
+}
+
+// nodes gets the children of the directory for each of the views of the
+// template. Views that can't be used for the directory are skipped, although
+// they should already have been rejected when the template was validated.
+func (d templateDir) nodes(t types.ViewTemplate) []Node {
+	nodes := make([]Node, 0, len(t))
+	for _, tv := range t {
+		if n := d.viewNode(tv); n != nil {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+func (d templateDir) viewNode(tv types.TemplateView) Node {
+	switch tv.View {
+	case types.TagsView:
+		switch {
+		case d.root != nil:
+			return &rootTagsNode{db: d.db, opts: d.opts, template: tv.Template}
+		case d.tag != nil:
+			return &childTagsNode{tagNodeInfo: d.tag.tagNodeInfo, template: tv.Template}
+		default:
+			return &queryTagsNode{db: d.db, opts: d.opts, selector: d.selector, template: tv.Template}
+		}
+	case types.RatingsView:
+		return &ratingsParentNode{db: d.db, opts: d.opts, baseSelector: d.selector, template: tv.Template}
+	case types.PhotosView:
+		query := types.Query{Selector: d.selector}
+		switch {
+		case d.root != nil:
+			return nil
+		case d.tag != nil:
+			return d.tag.photosNode(d.selector)
+		case d.rating != nil:
+			return d.rating.photosNode(query, d.currentPhoto, d.pageSize, d.photos)
+		default:
+			return &queryNode{db: d.db, name: "photos", query: query, currentPhoto: d.currentPhoto, now: d.opts.clock(), pageSize: d.pageSize}
+		}
+	case types.AndView, types.NotView:
+		if d.tag == nil {
+			return nil
+		}
+		return &tagFacetNode{tagNodeInfo: d.tag.tagNodeInfo, exclude: tv.View == types.NotView, photos: d.tag.photos, counted: d.tag.counted, template: tv.Template}
+	default:
+		if d.root == nil {
+			return nil
+		}
+		return d.root.viewNode(tv.View)
+	}
+}
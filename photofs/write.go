@@ -0,0 +1,313 @@
+package photofs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/anitschke/photo-db-fs/db"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"go.uber.org/zap"
+)
+
+// When the file system is writable some directories allow modifying the DB by
+// creating, copying or removing files within them. A DirNode opts into this by
+// implementing any of the following interfaces, for any directory that doesn't
+// the kernel gets EPERM.
+
+// SymlinkDirNode can optionally be implemented by a DirNode that supports
+// creating symlinks within it.
+type SymlinkDirNode interface {
+	Symlink(ctx context.Context, target, name string) error
+}
+
+// CreateDirNode can optionally be implemented by a DirNode that supports
+// copying files into it. Create is called once the file has been fully written
+// with the content of the file.
+type CreateDirNode interface {
+	Create(ctx context.Context, name string, content io.ReaderAt, size int64) error
+}
+
+// UnlinkDirNode can optionally be implemented by a DirNode that supports
+// removing its children.
+type UnlinkDirNode interface {
+	Unlink(ctx context.Context, child Node) error
+}
+
+// MkdirDirNode can optionally be implemented by a DirNode that supports
+// creating directories within it.
+type MkdirDirNode interface {
+	Mkdir(ctx context.Context, name string) error
+}
+
+// MoveIntoDirNode can optionally be implemented by a DirNode that supports
+// having children of other directories moved into it. child is the Node that
+// is being moved, which belongs to some other directory.
+type MoveIntoDirNode interface {
+	MoveInto(ctx context.Context, child Node, newName string) error
+}
+
+// toErrno converts an error from modifying the DB into an errno for the kernel.
+func toErrno(err error) syscall.Errno {
+	var errno syscall.Errno
+	switch {
+	case errors.As(err, &errno):
+		return errno
+	case errors.Is(err, db.ErrReadOnly):
+		return syscall.EROFS
+	case errors.Is(err, db.ErrLocked):
+		return syscall.EBUSY
+	case errors.Is(err, db.ErrNotFound):
+		return syscall.ENOENT
+	case errors.Is(err, db.ErrExists):
+		return syscall.EEXIST
+	default:
+		return dbERROR
+	}
+}
+
+// refreshAfterWrite refreshes the children of the directory after it was
+// modified so the change is visible right away.
+func (n *DirINode) refreshAfterWrite(ctx context.Context) {
+	if _, err := n.refresh(ctx); err != nil {
+		zap.L().Error("failed to refresh directory after modifying it", zap.Error(err))
+	}
+}
+
+// tempEntry sets up the EntryOut for an entry that we need to give to the
+// kernel after a write, but that won't be listed in the directory since the
+// photo shows up under its unique stable name instead. We only let the kernel
+// cache these very briefly.
+func tempEntry(out *fuse.EntryOut) {
+	out.SetEntryTimeout(minTimeout)
+	out.SetAttrTimeout(minTimeout)
+}
+
+var _ = (fs.NodeSymlinker)((*DirINode)(nil))
+
+func (n *DirINode) Symlink(ctx context.Context, target, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	s, ok := n.node.(SymlinkDirNode)
+	if !ok {
+		return nil, syscall.EPERM
+	}
+	if err := s.Symlink(ctx, target, name); err != nil {
+		zap.L().Warn("failed to create symlink", zap.String("target", target), zap.String("name", name), zap.Error(err))
+		return nil, toErrno(err)
+	}
+	n.refreshAfterWrite(ctx)
+
+	tempEntry(out)
+	return n.NewInode(ctx, &fs.MemSymlink{Data: []byte(target)}, fs.StableAttr{Mode: fuse.S_IFLNK}), 0
+}
+
+var _ = (fs.NodeCreater)((*DirINode)(nil))
+
+func (n *DirINode) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (*fs.Inode, fs.FileHandle, uint32, syscall.Errno) {
+	c, ok := n.node.(CreateDirNode)
+	if !ok {
+		return nil, nil, 0, syscall.EPERM
+	}
+
+	f, err := os.CreateTemp("", "photo-db-fs-upload")
+	if err != nil {
+		zap.L().Error("failed to create temp file for upload", zap.Error(err))
+		return nil, nil, 0, syscall.EIO
+	}
+
+	tempEntry(out)
+	upload := &uploadHandle{file: f, dir: n, node: c, name: name}
+	return n.NewInode(ctx, &uploadINode{}, fs.StableAttr{Mode: fuse.S_IFREG}), upload, 0, 0
+}
+
+// child gets the child of the directory with the given name.
+func (n *DirINode) child(ctx context.Context, name string) (Node, syscall.Errno) {
+	if err := n.rlockLoaded(ctx); err != nil {
+		zap.L().Error("error loading directory children", zap.Error(err))
+		return nil, dbERROR
+	}
+	defer n.mu.RUnlock()
+	c, ok := n.children[name]
+	if !ok {
+		c, ok = n.lookupCurrent(name)
+	}
+	if !ok {
+		c, ok = n.photos.lookup(name)
+	}
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	return c, 0
+}
+
+var _ = (fs.NodeUnlinker)((*DirINode)(nil))
+
+func (n *DirINode) Unlink(ctx context.Context, name string) syscall.Errno {
+	u, ok := n.node.(UnlinkDirNode)
+	if !ok {
+		return syscall.EPERM
+	}
+
+	c, errno := n.child(ctx, name)
+	if errno != 0 {
+		return errno
+	}
+
+	if err := u.Unlink(ctx, c); err != nil {
+		zap.L().Warn("failed to unlink", zap.String("name", name), zap.Error(err))
+		return toErrno(err)
+	}
+	n.refreshAfterWrite(ctx)
+	return 0
+}
+
+var _ = (fs.NodeMkdirer)((*DirINode)(nil))
+
+func (n *DirINode) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	m, ok := n.node.(MkdirDirNode)
+	if !ok {
+		return nil, syscall.EPERM
+	}
+	if err := m.Mkdir(ctx, name); err != nil {
+		zap.L().Warn("failed to make directory", zap.String("name", name), zap.Error(err))
+		return nil, toErrno(err)
+	}
+	n.refreshAfterWrite(ctx)
+	return n.Lookup(ctx, name, out)
+}
+
+var _ = (fs.NodeRenamer)((*DirINode)(nil))
+
+// Rename moves a child of this directory into newParent. Since what moving
+// means depends on where the child is moved to it is up to the new parent to
+// handle it.
+func (n *DirINode) Rename(ctx context.Context, name string, newParent fs.InodeEmbedder, newName string, flags uint32) syscall.Errno {
+	// We can't support RENAME_EXCHANGE or RENAME_NOREPLACE in any meaningful
+	// way.
+	if flags != 0 {
+		return syscall.EINVAL
+	}
+
+	target, ok := newParent.(*DirINode)
+	if !ok {
+		return syscall.EPERM
+	}
+	m, ok := target.node.(MoveIntoDirNode)
+	if !ok {
+		return syscall.EPERM
+	}
+
+	c, errno := n.child(ctx, name)
+	if errno != 0 {
+		return errno
+	}
+
+	if err := m.MoveInto(ctx, c, newName); err != nil {
+		zap.L().Warn("failed to rename", zap.String("name", name), zap.String("newName", newName), zap.Error(err))
+		return toErrno(err)
+	}
+	n.refreshAfterWrite(ctx)
+	if target != n {
+		target.refreshAfterWrite(ctx)
+	}
+	return 0
+}
+
+// uploadINode is the INode of a file that is being copied into a CreateDirNode,
+// everything is handled by the uploadHandle.
+type uploadINode struct {
+	fs.Inode
+}
+
+// uploadHandle buffers the content of a file that is being copied into a
+// CreateDirNode into a temp file, and then hands it off to the CreateDirNode
+// when the file is closed.
+type uploadHandle struct {
+	mu    sync.Mutex
+	file  *os.File
+	dirty bool
+
+	dir  *DirINode
+	node CreateDirNode
+	name string
+}
+
+var _ = (fs.FileWriter)((*uploadHandle)(nil))
+var _ = (fs.FileGetattrer)((*uploadHandle)(nil))
+var _ = (fs.FileSetattrer)((*uploadHandle)(nil))
+var _ = (fs.FileFlusher)((*uploadHandle)(nil))
+var _ = (fs.FileReleaser)((*uploadHandle)(nil))
+
+func (h *uploadHandle) Write(ctx context.Context, data []byte, off int64) (uint32, syscall.Errno) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.dirty = true
+	n, err := h.file.WriteAt(data, off)
+	if err != nil {
+		return uint32(n), fs.ToErrno(err)
+	}
+	return uint32(n), 0
+}
+
+func (h *uploadHandle) Getattr(ctx context.Context, out *fuse.AttrOut) syscall.Errno {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	info, err := h.file.Stat()
+	if err != nil {
+		return fs.ToErrno(err)
+	}
+	out.Mode = fuse.S_IFREG | 0644
+	out.Size = uint64(info.Size())
+	return 0
+}
+
+func (h *uploadHandle) Setattr(ctx context.Context, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	if size, ok := in.GetSize(); ok {
+		h.mu.Lock()
+		h.dirty = true
+		err := h.file.Truncate(int64(size))
+		h.mu.Unlock()
+		if err != nil {
+			return fs.ToErrno(err)
+		}
+	}
+	return h.Getattr(ctx, out)
+}
+
+// Flush is called every time the file is closed, we hand off the content here
+// rather than in Release so that any error is reported to the program that
+// closed the file.
+func (h *uploadHandle) Flush(ctx context.Context) syscall.Errno {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.dirty {
+		return 0
+	}
+
+	info, err := h.file.Stat()
+	if err != nil {
+		return fs.ToErrno(err)
+	}
+	if err := h.node.Create(ctx, h.name, h.file, info.Size()); err != nil {
+		zap.L().Warn("failed to create file", zap.String("name", h.name), zap.Error(err))
+		return toErrno(err)
+	}
+	h.dirty = false
+	h.dir.refreshAfterWrite(ctx)
+	return 0
+}
+
+func (h *uploadHandle) Release(ctx context.Context) syscall.Errno {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.file.Close(); err != nil {
+		zap.L().Error("failed to close upload temp file", zap.Error(err))
+	}
+	if err := os.Remove(h.file.Name()); err != nil {
+		zap.L().Error("failed to remove upload temp file", zap.Error(err))
+	}
+	return 0
+}
@@ -0,0 +1,25 @@
+package photofs
+
+import (
+	"fmt"
+	"syscall"
+	"testing"
+
+	"github.com/anitschke/photo-db-fs/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToErrno(t *testing.T) {
+	assert := assert.New(t)
+
+	// The DB wraps these errors with more details so make sure we still find
+	// them.
+	wrap := func(err error) error { return fmt.Errorf("%w, more details", err) }
+
+	assert.Equal(syscall.EBUSY, toErrno(wrap(db.ErrLocked)))
+	assert.Equal(syscall.EROFS, toErrno(wrap(db.ErrReadOnly)))
+	assert.Equal(syscall.ENOENT, toErrno(wrap(db.ErrNotFound)))
+	assert.Equal(syscall.EEXIST, toErrno(wrap(db.ErrExists)))
+	assert.Equal(syscall.EPERM, toErrno(syscall.EPERM))
+	assert.Equal(dbERROR, toErrno(fmt.Errorf("oops")))
+}
@@ -0,0 +1,107 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CanonicalKey gets a string that identifies the photos a selector selects, so
+// results of queries can be cached by it. Selectors that only differ in ways
+// that Optimize removes, or in the order of the operands of And and Or
+// selectors, get the same key.
+func CanonicalKey(s Selector) (string, error) {
+	if s == nil {
+		return "", fmt.Errorf("can't get the key of a nil selector")
+	}
+	return stringAccept(Optimize(s), keyVisitor{})
+}
+
+func stringAccept(s Selector, v SelectorVisitor) (string, error) {
+	i, err := s.Accept(v)
+	if err != nil {
+		return "", err
+	}
+
+	str, ok := i.(string)
+	if !ok {
+		return "", fmt.Errorf("could not convert return to string")
+	}
+	return str, nil
+}
+
+// keyVisitor is our implementation of a SelectorVisitor that writes each
+// selector as a string of its kind followed by its fields in parentheses.
+// Strings are quoted so that no character in a tag, album or camera can be
+// confused with the punctuation between the fields.
+type keyVisitor struct{}
+
+var _ = (SelectorVisitor)(keyVisitor{})
+
+func quotePath(path []string) string {
+	quoted := make([]string, len(path))
+	for i, p := range path {
+		quoted[i] = strconv.Quote(p)
+	}
+	return "[" + strings.Join(quoted, ",") + "]"
+}
+
+func (v keyVisitor) VisitHasTag(s HasTag) (interface{}, error) {
+	return fmt.Sprintf("%s(%s)", HasTagKind, quotePath(s.Tag.Path)), nil
+}
+
+func (v keyVisitor) VisitHasRating(s HasRating) (interface{}, error) {
+	return fmt.Sprintf("%s(%s,%s)", HasRatingKind, s.Operator, strconv.FormatFloat(s.Rating, 'g', -1, 64)), nil
+}
+
+func (v keyVisitor) VisitInAlbum(s InAlbum) (interface{}, error) {
+	return fmt.Sprintf("%s(%s)", InAlbumKind, quotePath(s.Album.Path)), nil
+}
+
+func (v keyVisitor) VisitHasCamera(s HasCamera) (interface{}, error) {
+	return fmt.Sprintf("%s(%s,%s)", HasCameraKind, strconv.Quote(s.Camera.Make), strconv.Quote(s.Camera.Model)), nil
+}
+
+func (v keyVisitor) VisitHasLens(s HasLens) (interface{}, error) {
+	return fmt.Sprintf("%s(%s)", HasLensKind, strconv.Quote(s.Lens)), nil
+}
+
+func (v keyVisitor) VisitTakenOn(s TakenOn) (interface{}, error) {
+	return fmt.Sprintf("%s(%d,%d,%d)", TakenOnKind, s.Year, s.Month, s.Day), nil
+}
+
+// visitSetOperation writes the operands in sorted order since the order
+// doesn't change which photos an And or Or selects.
+func (v keyVisitor) visitSetOperation(kind SelectorKind, operands []Selector) (interface{}, error) {
+	keys := make([]string, len(operands))
+	for i, op := range operands {
+		key, err := stringAccept(op, v)
+		if err != nil {
+			return nil, fmt.Errorf("error visiting subselector: %w", err)
+		}
+		keys[i] = key
+	}
+	sort.Strings(keys)
+	return fmt.Sprintf("%s(%s)", kind, strings.Join(keys, ",")), nil
+}
+
+func (v keyVisitor) VisitAnd(s And) (interface{}, error) {
+	return v.visitSetOperation(AndKind, s.Operands)
+}
+
+func (v keyVisitor) VisitOr(s Or) (interface{}, error) {
+	return v.visitSetOperation(OrKind, s.Operands)
+}
+
+func (v keyVisitor) VisitDifference(s Difference) (interface{}, error) {
+	starting, err := stringAccept(s.Starting, v)
+	if err != nil {
+		return nil, fmt.Errorf("error visiting starting selector: %w", err)
+	}
+	excluding, err := stringAccept(s.Excluding, v)
+	if err != nil {
+		return nil, fmt.Errorf("error visiting excluding selector: %w", err)
+	}
+	return fmt.Sprintf("%s(%s,%s)", DifferenceKind, starting, excluding), nil
+}
@@ -0,0 +1,97 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalKey(t *testing.T) {
+	a := HasTag{Tag: Tag{Path: []string{"a"}}}
+	b := HasTag{Tag: Tag{Path: []string{"b"}}}
+	fourStars := HasRating{Operator: GreaterThanOrEqual, Rating: 4}
+	camera := HasCamera{Camera: Camera{Make: "Canon", Model: "EOS R"}}
+
+	tests := []struct {
+		name     string
+		selector Selector
+		expected string
+	}{
+		{
+			name:     "HasTag",
+			selector: HasTag{Tag: Tag{Path: []string{"People", "Alice"}}},
+			expected: `hasTag(["People","Alice"])`,
+		},
+		{
+			name:     "HasRating",
+			selector: HasRating{Operator: LessThan, Rating: 2.5},
+			expected: `hasRating(<,2.5)`,
+		},
+		{
+			name:     "InAlbum",
+			selector: InAlbum{Album: Album{Path: []string{"photos", "2022"}}},
+			expected: `inAlbum(["photos","2022"])`,
+		},
+		{
+			name:     "HasCamera",
+			selector: camera,
+			expected: `hasCamera("Canon","EOS R")`,
+		},
+		{
+			name:     "HasLens",
+			selector: HasLens{Lens: "Sigma"},
+			expected: `hasLens("Sigma")`,
+		},
+		{
+			name:     "TakenOn",
+			selector: TakenOn{Month: 7, Day: 10},
+			expected: `takenOn(0,7,10)`,
+		},
+		{
+			name:     "And",
+			selector: And{Operands: []Selector{fourStars, a}},
+			expected: `and(hasRating(>=,4),hasTag(["a"]))`,
+		},
+		{
+			name:     "AndOtherOrder",
+			selector: And{Operands: []Selector{a, fourStars}},
+			expected: `and(hasRating(>=,4),hasTag(["a"]))`,
+		},
+		{
+			name:     "OrOtherOrder",
+			selector: Or{Operands: []Selector{b, Or{Operands: []Selector{a}}}},
+			expected: `or(hasTag(["a"]),hasTag(["b"]))`,
+		},
+		{
+			name:     "AndNoOperands",
+			selector: And{},
+			expected: `and()`,
+		},
+		{
+			name:     "Optimized",
+			selector: And{Operands: []Selector{a, a}},
+			expected: `hasTag(["a"])`,
+		},
+		{
+			name:     "Difference",
+			selector: Difference{Starting: a, Excluding: b},
+			expected: `difference(hasTag(["a"]),hasTag(["b"]))`,
+		},
+		{
+			name:     "QuotedPunctuation",
+			selector: HasTag{Tag: Tag{Path: []string{`a","b`}}},
+			expected: `hasTag(["a\",\"b"])`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := CanonicalKey(tt.selector)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expected, key)
+		})
+	}
+
+	_, err := CanonicalKey(nil)
+	assert.Error(t, err)
+}
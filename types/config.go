@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 )
 
 type Config struct {
@@ -11,6 +12,102 @@ type Config struct {
 	DB         DB            `json:"db"`
 	LogLevel   string        `json:"logLevel,omitempty"`
 	Queries    []QueryConfig `json:"queries,omitempty"`
+
+	// CurrentPhoto turns on the current.<ext> symlink in every directory of
+	// photos.
+	CurrentPhoto *CurrentPhotoConfig `json:"currentPhoto,omitempty"`
+
+	// RefreshInterval is how often to check if the DB has changed, as a Go
+	// duration string. "0s" turns off checking for changes.
+	RefreshInterval string `json:"refreshInterval,omitempty"`
+
+	// MaxLoadedDirs is how many directories can have their contents held in
+	// memory at once, 0 means no limit. If it isn't specified
+	// DefaultMaxLoadedDirs is used.
+	MaxLoadedDirs *int `json:"maxLoadedDirs,omitempty"`
+
+	// PageSize splits directories of photos with more than this many photos
+	// into pages, 0 means photos are never split into pages.
+	PageSize int `json:"pageSize,omitempty"`
+
+	// Cache controls the cache of query results that is shared by the whole
+	// file system.
+	Cache *CacheConfig `json:"cache,omitempty"`
+
+	// Writable allows photos to be tagged, untagged and rated and tags to be
+	// created through the file system.
+	Writable bool `json:"writable,omitempty"`
+
+	// Exclude selects photos that should never show up anywhere in the file
+	// system.
+	Exclude *SelectorConfig `json:"exclude,omitempty"`
+
+	// Definitions are named selectors that can be used by the queries and
+	// exclude with a "ref" selector.
+	Definitions map[string]SelectorConfig `json:"definitions,omitempty"`
+
+	// Layout controls which directories are in the file system and what they
+	// are called.
+	Layout *LayoutConfig `json:"layout,omitempty"`
+
+	// Templates are named view templates that describe the children of a
+	// directory, they can be used by tags, queries and the root.
+	Templates map[string]TemplateConfig `json:"templates,omitempty"`
+}
+
+// ConfigToExclude transforms the Exclude of a Config into a Selector. A nil
+// config results in a nil Selector.
+func ConfigToExclude(config *SelectorConfig, refs *SelectorRefs) (Selector, error) {
+	if config == nil {
+		return nil, nil
+	}
+	s, err := configToSelector(*config, refs)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing exclude: %w", err)
+	}
+	return s, nil
+}
+
+// DefaultRefreshInterval is how often to check if the DB has changed if no
+// interval is specified.
+const DefaultRefreshInterval = 5 * time.Second
+
+// ConfigToRefreshInterval parses the RefreshInterval of a Config
+func ConfigToRefreshInterval(interval string) (time.Duration, error) {
+	if interval == "" {
+		return DefaultRefreshInterval, nil
+	}
+	d, err := time.ParseDuration(interval)
+	if err != nil {
+		return 0, fmt.Errorf("invalid refresh interval: %w", err)
+	}
+	if d < 0 {
+		return 0, errors.New("refresh interval must not be negative")
+	}
+	return d, nil
+}
+
+// ConfigToPageSize checks the PageSize of a Config
+func ConfigToPageSize(pageSize int) (int, error) {
+	if pageSize < 0 {
+		return 0, errors.New("page size must not be negative")
+	}
+	return pageSize, nil
+}
+
+// DefaultMaxLoadedDirs is how many directories can have their contents held in
+// memory at once if no limit is specified.
+const DefaultMaxLoadedDirs = 1000
+
+// ConfigToMaxLoadedDirs parses the MaxLoadedDirs of a Config
+func ConfigToMaxLoadedDirs(max *int) (int, error) {
+	if max == nil {
+		return DefaultMaxLoadedDirs, nil
+	}
+	if *max < 0 {
+		return 0, errors.New("max loaded dirs must not be negative")
+	}
+	return *max, nil
 }
 
 type DB struct {
@@ -21,6 +118,60 @@ type DB struct {
 type QueryConfig struct {
 	Name     string         `json:"name,omitempty"`
 	Selector SelectorConfig `json:"selector"`
+
+	// CurrentPhoto overrides the global CurrentPhoto config for this query.
+	CurrentPhoto *CurrentPhotoConfig `json:"currentPhoto,omitempty"`
+
+	// IncludeExcluded opts this query out of the global Exclude.
+	IncludeExcluded bool `json:"includeExcluded,omitempty"`
+
+	// PageSize overrides the global PageSize for this query.
+	PageSize *int `json:"pageSize,omitempty"`
+
+	// Subtrees adds the ratings and tags subtrees to the query, in which case
+	// the photos of the query are in a photos directory. It is a shorthand for
+	// using the DefaultQueryTemplate.
+	Subtrees bool `json:"subtrees,omitempty"`
+
+	// Template is the name of the view template used for the directory of the
+	// query.
+	Template string `json:"template,omitempty"`
+}
+
+type LayoutConfig struct {
+	// Views maps the top level views that should be enabled to the name of
+	// their directory, an empty name uses the default name. If Views isn't
+	// specified then all views are enabled.
+	Views map[string]string `json:"views,omitempty"`
+
+	QueriesAtRoot bool `json:"queriesAtRoot,omitempty"`
+
+	EqualRatingName              string `json:"equalRatingName,omitempty"`
+	GreaterThanOrEqualRatingName string `json:"greaterThanOrEqualRatingName,omitempty"`
+
+	RootTemplate string `json:"rootTemplate,omitempty"`
+	TagTemplate  string `json:"tagTemplate,omitempty"`
+
+	CountsInNames bool `json:"countsInNames,omitempty"`
+	HideEmptyTags bool `json:"hideEmptyTags,omitempty"`
+}
+
+type TemplateConfig []TemplateViewConfig
+
+type TemplateViewConfig struct {
+	View     string `json:"view"`
+	Template string `json:"template,omitempty"`
+}
+
+type CurrentPhotoConfig struct {
+	Disabled bool   `json:"disabled,omitempty"`
+	Interval string `json:"interval,omitempty"`
+	Order    string `json:"order,omitempty"`
+}
+
+type CacheConfig struct {
+	TTL         string `json:"ttl,omitempty"`
+	MaxMemoryMB *int   `json:"maxMemoryMB,omitempty"`
 }
 
 type SelectorPropertyMap map[string]SelectorProperty
@@ -54,22 +205,218 @@ type SelectorProperty struct {
 // perhaps I should just suck it up and implement json.Unmarshaler. But it
 // works, so I am going to leave it as is for now.
 func ConfigToQuery(config QueryConfig) (NamedQuery, error) {
-	s, err := configToSelector(config.Selector)
+	return configToQuery(config, nil)
+}
+
+func configToQuery(config QueryConfig, refs *SelectorRefs) (NamedQuery, error) {
+	var s Selector
+	var err error
+	if refs != nil {
+		// Resolving the query by name means references back to the query
+		// are caught as a cycle. The error already names the query.
+		s, err = refs.resolve(config.Name)
+		if err != nil {
+			return NamedQuery{}, err
+		}
+	} else {
+		s, err = configToSelector(config.Selector, nil)
+		if err != nil {
+			return NamedQuery{}, fmt.Errorf("error parsing config %q: %w", config.Name, err)
+		}
+	}
+	currentPhoto, err := ConfigToCurrentPhoto(config.CurrentPhoto)
 	if err != nil {
 		return NamedQuery{}, fmt.Errorf("error parsing config %q: %w", config.Name, err)
 	}
+	if config.PageSize != nil {
+		if _, err := ConfigToPageSize(*config.PageSize); err != nil {
+			return NamedQuery{}, fmt.Errorf("error parsing config %q: %w", config.Name, err)
+		}
+	}
+	template := config.Template
+	if config.Subtrees {
+		if template != "" {
+			return NamedQuery{}, fmt.Errorf("error parsing config %q: subtrees can't be used with a template", config.Name)
+		}
+		template = DefaultQueryTemplate
+	}
 	return NamedQuery{
 		Name: config.Name,
 		Query: Query{
 			Selector: s,
 		},
+		CurrentPhoto:    currentPhoto,
+		IncludeExcluded: config.IncludeExcluded,
+		PageSize:        config.PageSize,
+		Template:        template,
 	}, nil
 }
 
-func ConfigsToQueries(configs []QueryConfig) ([]NamedQuery, error) {
+// ConfigToCurrentPhoto transforms a CurrentPhotoConfig into a CurrentPhoto,
+// filling in defaults for anything that isn't specified. A nil config results
+// in a nil CurrentPhoto.
+func ConfigToCurrentPhoto(config *CurrentPhotoConfig) (*CurrentPhoto, error) {
+	if config == nil {
+		return nil, nil
+	}
+
+	c := CurrentPhoto{
+		Disabled: config.Disabled,
+		Interval: DefaultCurrentPhotoInterval,
+		Order:    RandomOrder,
+	}
+	if config.Interval != "" {
+		interval, err := time.ParseDuration(config.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid current photo interval: %w", err)
+		}
+		c.Interval = interval
+	}
+	if config.Order != "" {
+		c.Order = CurrentPhotoOrder(strings.ToLower(config.Order))
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Cache is how query results are cached.
+type Cache struct {
+	// TTL is how long results are cached for, if it is zero nothing is
+	// cached.
+	TTL time.Duration
+
+	// MaxBytes is roughly how much memory cached results can take up, if it is
+	// zero nothing is cached.
+	MaxBytes int64
+}
+
+const (
+	// DefaultCacheTTL is how long query results are cached for if no TTL is
+	// specified.
+	DefaultCacheTTL = 5 * time.Minute
+
+	// DefaultCacheMaxMemoryMB is how much memory cached query results can take
+	// up if no limit is specified.
+	DefaultCacheMaxMemoryMB = 64
+)
+
+// ConfigToCache transforms a CacheConfig into a Cache, filling in defaults for
+// anything that isn't specified.
+func ConfigToCache(config *CacheConfig) (Cache, error) {
+	c := Cache{
+		TTL:      DefaultCacheTTL,
+		MaxBytes: DefaultCacheMaxMemoryMB << 20,
+	}
+	if config == nil {
+		return c, nil
+	}
+	if config.TTL != "" {
+		ttl, err := time.ParseDuration(config.TTL)
+		if err != nil {
+			return Cache{}, fmt.Errorf("invalid cache ttl: %w", err)
+		}
+		if ttl < 0 {
+			return Cache{}, errors.New("cache ttl must not be negative")
+		}
+		c.TTL = ttl
+	}
+	if config.MaxMemoryMB != nil {
+		if *config.MaxMemoryMB < 0 {
+			return Cache{}, errors.New("cache max memory must not be negative")
+		}
+		c.MaxBytes = int64(*config.MaxMemoryMB) << 20
+	}
+	return c, nil
+}
+
+// ConfigToLayout transforms a LayoutConfig and the view templates into a
+// Layout, filling in defaults for anything that isn't specified. A nil config
+// with no templates results in the DefaultLayout.
+func ConfigToLayout(config *LayoutConfig, templates map[string]TemplateConfig) (Layout, error) {
+	l := DefaultLayout()
+	for name, c := range templates {
+		t, err := configToTemplate(c)
+		if err != nil {
+			return Layout{}, fmt.Errorf("invalid template %q: %w", name, err)
+		}
+		l.Templates[name] = t
+	}
+
+	if config != nil {
+		if config.Views != nil {
+			l.Views = make(map[View]string, len(config.Views))
+			for name, dirName := range config.Views {
+				v, err := configToView(name)
+				if err != nil {
+					return Layout{}, err
+				}
+				if dirName == "" {
+					dirName = defaultViewName(v)
+				}
+				l.Views[v] = dirName
+			}
+		}
+		l.QueriesAtRoot = config.QueriesAtRoot
+		if config.EqualRatingName != "" {
+			l.EqualRatingName = config.EqualRatingName
+		}
+		if config.GreaterThanOrEqualRatingName != "" {
+			l.GreaterThanOrEqualRatingName = config.GreaterThanOrEqualRatingName
+		}
+		l.RootTemplate = config.RootTemplate
+		if config.TagTemplate != "" {
+			l.TagTemplate = config.TagTemplate
+		}
+		l.CountsInNames = config.CountsInNames
+		l.HideEmptyTags = config.HideEmptyTags
+	}
+
+	if err := l.Validate(); err != nil {
+		return Layout{}, fmt.Errorf("invalid layout: %w", err)
+	}
+	return l, nil
+}
+
+func configToView(name string) (View, error) {
+	for _, v := range AllViews {
+		if strings.EqualFold(name, string(v)) {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("%q is not a valid view", name)
+}
+
+func configToTemplate(config TemplateConfig) (ViewTemplate, error) {
+	t := make(ViewTemplate, 0, len(config))
+	for _, c := range config {
+		v, err := configToTemplateView(c.View)
+		if err != nil {
+			return nil, err
+		}
+		t = append(t, TemplateView{View: v, Template: c.Template})
+	}
+	return t, nil
+}
+
+func configToTemplateView(name string) (View, error) {
+	for _, v := range templateViews {
+		if strings.EqualFold(name, string(v)) {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("unknown view kind %q", name)
+}
+
+// ConfigsToQueries transforms QueryConfigs into NamedQuerys. Any "ref"
+// selectors are resolved using refs, if refs is nil then the queries can't
+// use "ref" selectors.
+func ConfigsToQueries(configs []QueryConfig, refs *SelectorRefs) ([]NamedQuery, error) {
 	namedQueries := make([]NamedQuery, 0, len(configs))
 	for _, c := range configs {
-		q, err := ConfigToQuery(c)
+		q, err := configToQuery(c, refs)
 		if err != nil {
 			return nil, err
 		}
@@ -78,6 +425,9 @@ func ConfigsToQueries(configs []QueryConfig) ([]NamedQuery, error) {
 	return namedQueries, nil
 }
 
+// ValidateQueryConfigs checks that the names of the queries can be used for the
+// directories of the queries. Names may contain "/" to nest the query in
+// folders, but a query can't have the same name as a folder of other queries.
 func ValidateQueryConfigs(configs []QueryConfig) error {
 	names := make(map[string]struct{}, len(configs))
 	for _, c := range configs {
@@ -85,24 +435,49 @@ func ValidateQueryConfigs(configs []QueryConfig) error {
 		if ok {
 			return fmt.Errorf("query configs must have unique names, the name %q is used for more than one query", c.Name)
 		}
-		// TODO validate that c.Name is a valid file name
-		// https://github.com/anitschke/photo-db-fs/issues/1
+		names[c.Name] = struct{}{}
+
+		for _, segment := range strings.Split(c.Name, "/") {
+			if err := validateDirName(segment); err != nil {
+				return fmt.Errorf("invalid query name %q: %w", c.Name, err)
+			}
+		}
+	}
+
+	for name := range names {
+		segments := strings.Split(name, "/")
+		for i := 1; i < len(segments); i++ {
+			folder := strings.Join(segments[:i], "/")
+			if _, ok := names[folder]; ok {
+				return fmt.Errorf("the name %q is used for both a query and a folder containing the query %q", folder, name)
+			}
+		}
 	}
 	return nil
 }
 
-func configToSelector(config SelectorConfig) (Selector, error) {
+func configToSelector(config SelectorConfig, refs *SelectorRefs) (Selector, error) {
 	switch t := strings.ToLower(config.Type); t {
 	case "hastag": // cspell:disable-line
 		return configToHasTag(config)
 	case "hasrating": // cspell:disable-line
 		return configToHasRatting(config)
+	case "inalbum": // cspell:disable-line
+		return configToInAlbum(config)
+	case "hascamera": // cspell:disable-line
+		return configToHasCamera(config)
+	case "haslens": // cspell:disable-line
+		return configToHasLens(config)
+	case "takenon": // cspell:disable-line
+		return configToTakenOn(config)
 	case "and":
-		return configToAnd(config)
+		return configToAnd(config, refs)
 	case "or":
-		return configToOr(config)
+		return configToOr(config, refs)
 	case "difference":
-		return configToDifference(config)
+		return configToDifference(config, refs)
+	case "ref", "inquery": // cspell:disable-line
+		return configToRef(config, refs)
 	default:
 		return nil, fmt.Errorf("invalid selector type %q", config.Type)
 	}
@@ -139,14 +514,75 @@ func configToHasRatting(config SelectorConfig) (Selector, error) {
 	return s, nil
 }
 
-func configToAnd(config SelectorConfig) (Selector, error) {
+func configToInAlbum(config SelectorConfig) (Selector, error) {
+	var s InAlbum
+	for name, p := range config.Properties {
+		switch n := strings.ToLower(name); n {
+		case "album":
+			s.Album.Path = p.Strings
+		default:
+			return nil, fmt.Errorf("invalid property %q", name)
+		}
+	}
+	return s, nil
+}
+
+func configToHasCamera(config SelectorConfig) (Selector, error) {
+	var s HasCamera
+	for name, p := range config.Properties {
+		switch n := strings.ToLower(name); n {
+		case "make":
+			s.Camera.Make = p.String
+		case "model":
+			s.Camera.Model = p.String
+		default:
+			return nil, fmt.Errorf("invalid property %q", name)
+		}
+	}
+	return s, nil
+}
+
+func configToHasLens(config SelectorConfig) (Selector, error) {
+	var s HasLens
+	for name, p := range config.Properties {
+		switch n := strings.ToLower(name); n {
+		case "lens":
+			s.Lens = p.String
+		default:
+			return nil, fmt.Errorf("invalid property %q", name)
+		}
+	}
+	return s, nil
+}
+
+func configToTakenOn(config SelectorConfig) (Selector, error) {
+	var s TakenOn
+	for name, p := range config.Properties {
+		switch n := strings.ToLower(name); n {
+		case "year":
+			s.Year = int(p.Number)
+		case "month":
+			s.Month = time.Month(p.Number)
+		case "day":
+			s.Day = int(p.Number)
+		default:
+			return nil, fmt.Errorf("invalid property %q", name)
+		}
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func configToAnd(config SelectorConfig, refs *SelectorRefs) (Selector, error) {
 	var s And
 	for name, p := range config.Properties {
 		switch n := strings.ToLower(name); n {
 		case "operands":
 			s.Operands = make([]Selector, 0, len(p.Selectors))
 			for _, opConfig := range p.Selectors {
-				op, err := configToSelector(opConfig)
+				op, err := configToSelector(opConfig, refs)
 				if err != nil {
 					return nil, err
 				}
@@ -159,14 +595,14 @@ func configToAnd(config SelectorConfig) (Selector, error) {
 	return s, nil
 }
 
-func configToOr(config SelectorConfig) (Selector, error) {
+func configToOr(config SelectorConfig, refs *SelectorRefs) (Selector, error) {
 	var s Or
 	for name, p := range config.Properties {
 		switch n := strings.ToLower(name); n {
 		case "operands":
 			s.Operands = make([]Selector, 0, len(p.Selectors))
 			for _, opConfig := range p.Selectors {
-				op, err := configToSelector(opConfig)
+				op, err := configToSelector(opConfig, refs)
 				if err != nil {
 					return nil, err
 				}
@@ -179,7 +615,7 @@ func configToOr(config SelectorConfig) (Selector, error) {
 	return s, nil
 }
 
-func configToDifference(config SelectorConfig) (Selector, error) {
+func configToDifference(config SelectorConfig, refs *SelectorRefs) (Selector, error) {
 	var s Difference
 	for name, p := range config.Properties {
 		switch n := strings.ToLower(name); n {
@@ -187,7 +623,7 @@ func configToDifference(config SelectorConfig) (Selector, error) {
 			if p.Selector == nil {
 				return nil, errors.New("unspecified starting selector")
 			}
-			start, err := configToSelector(*p.Selector)
+			start, err := configToSelector(*p.Selector, refs)
 			if err != nil {
 				return nil, err
 			}
@@ -196,7 +632,7 @@ func configToDifference(config SelectorConfig) (Selector, error) {
 			if p.Selector == nil {
 				return nil, errors.New("unspecified excluding selector")
 			}
-			exclude, err := configToSelector(*p.Selector)
+			exclude, err := configToSelector(*p.Selector, refs)
 			if err != nil {
 				return nil, err
 			}
@@ -207,3 +643,21 @@ func configToDifference(config SelectorConfig) (Selector, error) {
 	}
 	return s, nil
 }
+
+// configToRef resolves a "ref" selector to the selector of the definition or
+// query it refers to.
+func configToRef(config SelectorConfig, refs *SelectorRefs) (Selector, error) {
+	var name string
+	for n, p := range config.Properties {
+		switch strings.ToLower(n) {
+		case "name":
+			name = p.String
+		default:
+			return nil, fmt.Errorf("invalid property %q", n)
+		}
+	}
+	if name == "" {
+		return nil, errors.New("unspecified name of ref")
+	}
+	return refs.resolve(name)
+}
@@ -3,6 +3,7 @@ package types
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -39,6 +40,106 @@ func TestQueryToSelector(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "SimpleInAlbum",
+			config: QueryConfig{
+				Name: "myAlbumQuery",
+				Selector: SelectorConfig{
+					Type: "inAlbum",
+					Properties: SelectorPropertyMap{
+						"album": SelectorProperty{
+							Strings: []string{"Pictures", "2022", "Iceland"},
+						},
+					},
+				},
+			},
+			expQuery: NamedQuery{
+				Name: "myAlbumQuery",
+				Query: Query{
+					Selector: InAlbum{
+						Album: Album{
+							Path: []string{"Pictures", "2022", "Iceland"},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "SimpleHasCamera",
+			config: QueryConfig{
+				Name: "myCameraQuery",
+				Selector: SelectorConfig{
+					Type: "hasCamera",
+					Properties: SelectorPropertyMap{
+						"make": SelectorProperty{
+							String: "NIKON CORPORATION",
+						},
+						"model": SelectorProperty{
+							String: "NIKON D5500",
+						},
+					},
+				},
+			},
+			expQuery: NamedQuery{
+				Name: "myCameraQuery",
+				Query: Query{
+					Selector: HasCamera{
+						Camera: Camera{
+							Make:  "NIKON CORPORATION",
+							Model: "NIKON D5500",
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "SimpleHasLens",
+			config: QueryConfig{
+				Name: "myLensQuery",
+				Selector: SelectorConfig{
+					Type: "hasLens",
+					Properties: SelectorPropertyMap{
+						"lens": SelectorProperty{
+							String: "Tokina atx-i 11-16mm F2.8 CF",
+						},
+					},
+				},
+			},
+			expQuery: NamedQuery{
+				Name: "myLensQuery",
+				Query: Query{
+					Selector: HasLens{
+						Lens: "Tokina atx-i 11-16mm F2.8 CF",
+					},
+				},
+			},
+		},
+		{
+			name: "SimpleTakenOn",
+			config: QueryConfig{
+				Name: "myTakenOnQuery",
+				Selector: SelectorConfig{
+					Type: "takenOn",
+					Properties: SelectorPropertyMap{
+						"month": SelectorProperty{
+							Number: 12,
+						},
+						"day": SelectorProperty{
+							Number: 25,
+						},
+					},
+				},
+			},
+			expQuery: NamedQuery{
+				Name: "myTakenOnQuery",
+				Query: Query{
+					Selector: TakenOn{
+						Month: time.December,
+						Day:   25,
+					},
+				},
+			},
+		},
 		{
 			name: "SimpleAnd",
 			config: QueryConfig{
@@ -198,6 +299,22 @@ func TestQueryToSelector(t *testing.T) {
 	}
 }
 
+func TestQueryToSelector_InvalidTakenOn(t *testing.T) {
+	config := QueryConfig{
+		Name: "myTakenOnQuery",
+		Selector: SelectorConfig{
+			Type: "takenOn",
+			Properties: SelectorPropertyMap{
+				"month": SelectorProperty{
+					Number: 13,
+				},
+			},
+		},
+	}
+	_, err := ConfigToQuery(config)
+	assert.Error(t, err)
+}
+
 func TestConfigQueryJsonDecode(t *testing.T) {
 	// We save config files in JSON format. This is just a quick and dirty test
 	// to make sure the serialization deserialization works correctly by doing a
@@ -321,3 +438,435 @@ func TestConfigQueryJsonDecode(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, actUnmarshal, config)
 }
+
+func TestConfigToCurrentPhoto(t *testing.T) {
+	type testData struct {
+		name   string
+		config *CurrentPhotoConfig
+		exp    *CurrentPhoto
+		expErr bool
+	}
+
+	td := []testData{
+		{
+			name:   "Nil",
+			config: nil,
+			exp:    nil,
+		},
+		{
+			name:   "Defaults",
+			config: &CurrentPhotoConfig{},
+			exp:    &CurrentPhoto{Interval: DefaultCurrentPhotoInterval, Order: RandomOrder},
+		},
+		{
+			name:   "Specified",
+			config: &CurrentPhotoConfig{Interval: "1h30m", Order: "Sequential"},
+			exp:    &CurrentPhoto{Interval: 90 * time.Minute, Order: SequentialOrder},
+		},
+		{
+			name:   "Disabled",
+			config: &CurrentPhotoConfig{Disabled: true},
+			exp:    &CurrentPhoto{Disabled: true, Interval: DefaultCurrentPhotoInterval, Order: RandomOrder},
+		},
+		{
+			name:   "InvalidInterval",
+			config: &CurrentPhotoConfig{Interval: "soon"},
+			expErr: true,
+		},
+		{
+			name:   "NegativeInterval",
+			config: &CurrentPhotoConfig{Interval: "-1m"},
+			expErr: true,
+		},
+		{
+			name:   "InvalidOrder",
+			config: &CurrentPhotoConfig{Order: "backwards"},
+			expErr: true,
+		},
+	}
+
+	for _, tt := range td {
+		t.Run(tt.name, func(t *testing.T) {
+			act, err := ConfigToCurrentPhoto(tt.config)
+			if tt.expErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.exp, act)
+		})
+	}
+}
+
+func TestConfigToRefreshInterval(t *testing.T) {
+	assert := assert.New(t)
+
+	d, err := ConfigToRefreshInterval("")
+	assert.NoError(err)
+	assert.Equal(DefaultRefreshInterval, d)
+
+	d, err = ConfigToRefreshInterval("1m")
+	assert.NoError(err)
+	assert.Equal(time.Minute, d)
+
+	d, err = ConfigToRefreshInterval("0s")
+	assert.NoError(err)
+	assert.Equal(time.Duration(0), d)
+
+	_, err = ConfigToRefreshInterval("-1s")
+	assert.Error(err)
+
+	_, err = ConfigToRefreshInterval("often")
+	assert.Error(err)
+}
+
+func TestConfigToCache(t *testing.T) {
+	assert := assert.New(t)
+
+	c, err := ConfigToCache(nil)
+	assert.NoError(err)
+	assert.Equal(Cache{TTL: DefaultCacheTTL, MaxBytes: DefaultCacheMaxMemoryMB << 20}, c)
+
+	mb := 16
+	c, err = ConfigToCache(&CacheConfig{TTL: "30s", MaxMemoryMB: &mb})
+	assert.NoError(err)
+	assert.Equal(Cache{TTL: 30 * time.Second, MaxBytes: 16 << 20}, c)
+
+	mb = 0
+	c, err = ConfigToCache(&CacheConfig{MaxMemoryMB: &mb})
+	assert.NoError(err)
+	assert.Equal(Cache{TTL: DefaultCacheTTL}, c)
+	c, err = ConfigToCache(&CacheConfig{TTL: "0s"})
+	assert.NoError(err)
+	assert.Equal(Cache{MaxBytes: DefaultCacheMaxMemoryMB << 20}, c)
+
+	_, err = ConfigToCache(&CacheConfig{TTL: "soon"})
+	assert.Error(err)
+	_, err = ConfigToCache(&CacheConfig{TTL: "-1s"})
+	assert.Error(err)
+	mb = -1
+	_, err = ConfigToCache(&CacheConfig{MaxMemoryMB: &mb})
+	assert.Error(err)
+}
+
+func TestConfigToPageSize(t *testing.T) {
+	assert := assert.New(t)
+
+	pageSize, err := ConfigToPageSize(0)
+	assert.NoError(err)
+	assert.Equal(0, pageSize)
+
+	pageSize, err = ConfigToPageSize(500)
+	assert.NoError(err)
+	assert.Equal(500, pageSize)
+
+	_, err = ConfigToPageSize(-1)
+	assert.Error(err)
+}
+
+func TestConfigToMaxLoadedDirs(t *testing.T) {
+	assert := assert.New(t)
+
+	max, err := ConfigToMaxLoadedDirs(nil)
+	assert.NoError(err)
+	assert.Equal(DefaultMaxLoadedDirs, max)
+
+	n := 50
+	max, err = ConfigToMaxLoadedDirs(&n)
+	assert.NoError(err)
+	assert.Equal(50, max)
+
+	n = 0
+	max, err = ConfigToMaxLoadedDirs(&n)
+	assert.NoError(err)
+	assert.Equal(0, max)
+
+	n = -1
+	_, err = ConfigToMaxLoadedDirs(&n)
+	assert.Error(err)
+}
+
+func TestConfigToExclude(t *testing.T) {
+	assert := assert.New(t)
+
+	s, err := ConfigToExclude(nil, nil)
+	assert.NoError(err)
+	assert.Nil(s)
+
+	s, err = ConfigToExclude(&SelectorConfig{
+		Type: "hasTag",
+		Properties: SelectorPropertyMap{
+			"tag": {Strings: []string{"Private"}},
+		},
+	}, nil)
+	assert.NoError(err)
+	assert.Equal(HasTag{Tag: Tag{Path: []string{"Private"}}}, s)
+
+	_, err = ConfigToExclude(&SelectorConfig{Type: "notASelector"}, nil)
+	assert.Error(err)
+}
+
+func TestConfigToLayout(t *testing.T) {
+	assert := assert.New(t)
+
+	l, err := ConfigToLayout(nil, nil)
+	assert.NoError(err)
+	assert.Equal(DefaultLayout(), l)
+	assert.Len(l.Views, len(AllViews))
+	assert.Equal("on-this-day", l.Views[OnThisDayView])
+
+	l, err = ConfigToLayout(&LayoutConfig{
+		Views: map[string]string{
+			"tags":      "Tags",
+			"onthisday": "",
+		},
+		QueriesAtRoot:                true,
+		EqualRatingName:              "{rating}-stars",
+		GreaterThanOrEqualRatingName: "{rating}-stars-and-up",
+		CountsInNames:                true,
+		HideEmptyTags:                true,
+	}, nil)
+	assert.NoError(err)
+	assert.Equal(Layout{
+		Views: map[View]string{
+			TagsView:      "Tags",
+			OnThisDayView: "on-this-day",
+		},
+		QueriesAtRoot:                true,
+		EqualRatingName:              "{rating}-stars",
+		GreaterThanOrEqualRatingName: "{rating}-stars-and-up",
+		Templates:                    defaultTemplates(),
+		TagTemplate:                  DefaultTagTemplate,
+		CountsInNames:                true,
+		HideEmptyTags:                true,
+	}, l)
+
+	_, err = ConfigToLayout(&LayoutConfig{Views: map[string]string{"people": ""}}, nil)
+	assert.Error(err)
+
+	_, err = ConfigToLayout(&LayoutConfig{Views: map[string]string{"tags": "photos", "albums": "photos"}}, nil)
+	assert.Error(err)
+
+	_, err = ConfigToLayout(&LayoutConfig{Views: map[string]string{"tags": "a/b"}}, nil)
+	assert.Error(err)
+
+	_, err = ConfigToLayout(&LayoutConfig{EqualRatingName: "stars"}, nil)
+	assert.Error(err)
+
+	_, err = ConfigToLayout(&LayoutConfig{EqualRatingName: ">={rating}"}, nil)
+	assert.Error(err)
+}
+
+func TestValidateQueryConfigs(t *testing.T) {
+	assert := assert.New(t)
+
+	queries := func(names ...string) []QueryConfig {
+		configs := make([]QueryConfig, 0, len(names))
+		for _, n := range names {
+			configs = append(configs, QueryConfig{Name: n})
+		}
+		return configs
+	}
+
+	assert.NoError(ValidateQueryConfigs(queries("Flat", "Trips/Iceland", "Trips/Norway", "Trips/Europe/Italy")))
+
+	assert.Error(ValidateQueryConfigs(queries("Trips", "Trips")))
+	assert.Error(ValidateQueryConfigs(queries("Trips/Iceland", "Trips/Iceland")))
+	assert.Error(ValidateQueryConfigs(queries("")))
+	assert.Error(ValidateQueryConfigs(queries("Trips/")))
+	assert.Error(ValidateQueryConfigs(queries("/Trips")))
+	assert.Error(ValidateQueryConfigs(queries("Trips//Iceland")))
+	assert.Error(ValidateQueryConfigs(queries("Trips/../Iceland")))
+	assert.Error(ValidateQueryConfigs(queries("Trips", "Trips/Iceland")))
+	assert.Error(ValidateQueryConfigs(queries("Trips/Europe/Italy", "Trips/Europe")))
+}
+
+func TestLayout_ValidateQueriesAtRoot(t *testing.T) {
+	assert := assert.New(t)
+
+	queries := func(names ...string) []NamedQuery {
+		named := make([]NamedQuery, 0, len(names))
+		for _, n := range names {
+			named = append(named, NamedQuery{Name: n})
+		}
+		return named
+	}
+
+	// Queries can have the same name as a view when they aren't at the root.
+	l := DefaultLayout()
+	assert.NoError(l.ValidateQueriesAtRoot(queries("tags")))
+
+	l.QueriesAtRoot = true
+	assert.NoError(l.ValidateQueriesAtRoot(queries("Trips", "People/Alice")))
+	assert.Error(l.ValidateQueriesAtRoot(queries("tags")))
+	assert.Error(l.ValidateQueriesAtRoot(queries("on-this-day")))
+	assert.Error(l.ValidateQueriesAtRoot(queries("ratings/Best")))
+
+	// Only the views that are in the root matter, with the names that they are
+	// given.
+	l, err := ConfigToLayout(&LayoutConfig{Views: map[string]string{"tags": "People"}, QueriesAtRoot: true}, nil)
+	assert.NoError(err)
+	assert.NoError(l.ValidateQueriesAtRoot(queries("tags", "albums")))
+	assert.Error(l.ValidateQueriesAtRoot(queries("People")))
+
+	l, err = ConfigToLayout(&LayoutConfig{RootTemplate: "root", QueriesAtRoot: true}, map[string]TemplateConfig{
+		"root": {{View: "ratings"}},
+	})
+	assert.NoError(err)
+	assert.NoError(l.ValidateQueriesAtRoot(queries("tags")))
+	assert.Error(l.ValidateQueriesAtRoot(queries("ratings")))
+}
+
+func TestConfigToLayout_Templates(t *testing.T) {
+	assert := assert.New(t)
+
+	templates := map[string]TemplateConfig{
+		"root": {
+			{View: "tags", Template: "simpleTag"},
+			{View: "Ratings", Template: "ratingWithTags"},
+		},
+		"simpleTag": {
+			{View: "tags", Template: "simpleTag"},
+			{View: "photos"},
+		},
+		"ratingWithTags": {
+			{View: "photos"},
+			{View: "tags", Template: "simpleTag"},
+		},
+	}
+	l, err := ConfigToLayout(&LayoutConfig{RootTemplate: "root"}, templates)
+	assert.NoError(err)
+	assert.Equal("root", l.RootTemplate)
+	assert.Equal(DefaultTagTemplate, l.TagTemplate)
+	assert.Equal(ViewTemplate{{View: TagsView, Template: "simpleTag"}, {View: RatingsView, Template: "ratingWithTags"}}, l.Templates["root"])
+
+	root, ok := l.Template("", "root")
+	assert.True(ok)
+	assert.Equal(l.Templates["root"], root)
+	_, ok = l.Template("doesNotExist", "root")
+	assert.False(ok)
+
+	// The default templates can be replaced.
+	l, err = ConfigToLayout(nil, map[string]TemplateConfig{DefaultTagTemplate: {{View: "photos"}}})
+	assert.NoError(err)
+	tag, ok := l.Template("", DefaultTagTemplate)
+	assert.True(ok)
+	assert.Equal(ViewTemplate{{View: PhotosView}}, tag)
+
+	assert.NoError(l.ValidateQueryTemplates([]NamedQuery{{Name: "q", Template: DefaultQueryTemplate}}))
+	assert.Error(l.ValidateQueryTemplates([]NamedQuery{{Name: "q", Template: "doesNotExist"}}))
+
+	// The "and" and "not" views can only be used for tags.
+	l, err = ConfigToLayout(nil, map[string]TemplateConfig{DefaultTagTemplate: {{View: "photos"}, {View: "and"}, {View: "not"}}})
+	assert.NoError(err)
+	assert.NoError(DefaultLayout().ValidateQueryTemplates([]NamedQuery{{Name: "q", Template: DefaultTagTemplate}}))
+	assert.Error(l.ValidateQueryTemplates([]NamedQuery{{Name: "q", Template: DefaultTagTemplate}}))
+
+	invalid := []struct {
+		name      string
+		layout    *LayoutConfig
+		templates map[string]TemplateConfig
+	}{
+		{
+			name:      "unknown view kind",
+			templates: map[string]TemplateConfig{"t": {{View: "people"}}},
+		},
+		{
+			name:      "duplicate view",
+			templates: map[string]TemplateConfig{"t": {{View: "photos"}, {View: "photos"}}},
+		},
+		{
+			name:      "unknown template",
+			templates: map[string]TemplateConfig{"t": {{View: "tags", Template: "doesNotExist"}}},
+		},
+		{
+			name:      "template for photos",
+			templates: map[string]TemplateConfig{"t": {{View: "photos", Template: "t"}}},
+		},
+		{
+			name:   "unknown root template",
+			layout: &LayoutConfig{RootTemplate: "doesNotExist"},
+		},
+		{
+			name:      "photos at root",
+			layout:    &LayoutConfig{RootTemplate: "t"},
+			templates: map[string]TemplateConfig{"t": {{View: "photos"}}},
+		},
+		{
+			name:      "albums in tag",
+			layout:    &LayoutConfig{TagTemplate: "t"},
+			templates: map[string]TemplateConfig{"t": {{View: "albums"}}},
+		},
+		{
+			name:   "and in rating",
+			layout: &LayoutConfig{RootTemplate: "root"},
+			templates: map[string]TemplateConfig{
+				"root":   {{View: "ratings", Template: "rating"}},
+				"rating": {{View: "and"}},
+			},
+		},
+		{
+			name:      "ratings in ratings",
+			layout:    &LayoutConfig{TagTemplate: "t"},
+			templates: map[string]TemplateConfig{"t": {{View: "ratings", Template: "t"}}},
+		},
+		{
+			name:   "tags in ratings in tags",
+			layout: &LayoutConfig{TagTemplate: "t"},
+			templates: map[string]TemplateConfig{
+				"t":      {{View: "ratings", Template: "rating"}},
+				"rating": {{View: "tags", Template: "t"}},
+			},
+		},
+	}
+	for _, c := range invalid {
+		_, err := ConfigToLayout(c.layout, c.templates)
+		assert.Error(err, c.name)
+	}
+}
+
+func TestConfigToQuery_Template(t *testing.T) {
+	assert := assert.New(t)
+
+	selector := SelectorConfig{
+		Type: "hasTag",
+		Properties: SelectorPropertyMap{
+			"tag": SelectorProperty{Strings: []string{"a"}},
+		},
+	}
+
+	q, err := ConfigToQuery(QueryConfig{Name: "q", Selector: selector, Template: "t"})
+	assert.NoError(err)
+	assert.Equal("t", q.Template)
+
+	q, err = ConfigToQuery(QueryConfig{Name: "q", Selector: selector, Subtrees: true})
+	assert.NoError(err)
+	assert.Equal(DefaultQueryTemplate, q.Template)
+
+	_, err = ConfigToQuery(QueryConfig{Name: "q", Selector: selector, Subtrees: true, Template: "t"})
+	assert.Error(err)
+}
+
+func TestConfigToQuery_PageSize(t *testing.T) {
+	assert := assert.New(t)
+
+	selector := SelectorConfig{
+		Type: "hasTag",
+		Properties: SelectorPropertyMap{
+			"tag": SelectorProperty{Strings: []string{"a"}},
+		},
+	}
+
+	q, err := ConfigToQuery(QueryConfig{Name: "q", Selector: selector})
+	assert.NoError(err)
+	assert.Nil(q.PageSize)
+
+	pageSize := 0
+	q, err = ConfigToQuery(QueryConfig{Name: "q", Selector: selector, PageSize: &pageSize})
+	assert.NoError(err)
+	assert.Equal(&pageSize, q.PageSize)
+
+	pageSize = -1
+	_, err = ConfigToQuery(QueryConfig{Name: "q", Selector: selector, PageSize: &pageSize})
+	assert.Error(err)
+}
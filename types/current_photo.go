@@ -0,0 +1,55 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// CurrentPhotoOrder is the order in which the current photo of a directory
+// rotates through the photos in that directory.
+type CurrentPhotoOrder string
+
+const (
+	// RandomOrder shuffles the photos and then steps through them so every
+	// photo is shown once before any photo is shown again.
+	RandomOrder CurrentPhotoOrder = "random"
+
+	// SequentialOrder steps through the photos in the order of their file
+	// names.
+	SequentialOrder CurrentPhotoOrder = "sequential"
+)
+
+func (o CurrentPhotoOrder) Validate() error {
+	switch o {
+	case RandomOrder, SequentialOrder:
+		return nil
+	default:
+		return fmt.Errorf("%q is not a valid CurrentPhotoOrder", string(o))
+	}
+}
+
+// DefaultCurrentPhotoInterval is how often the current photo rotates if no
+// interval is specified.
+const DefaultCurrentPhotoInterval = 10 * time.Minute
+
+// CurrentPhoto configures the current.<ext> symlink that points to a single
+// photo of a directory and periodically rotates to a different photo.
+type CurrentPhoto struct {
+	// Disabled turns off the current.<ext> symlink, this is useful for
+	// turning it off for a single query when it is turned on globally.
+	Disabled bool
+
+	Interval time.Duration
+	Order    CurrentPhotoOrder
+}
+
+func (c CurrentPhoto) Validate() error {
+	if c.Disabled {
+		return nil
+	}
+	if c.Interval <= 0 {
+		return errors.New("current photo interval must be greater than zero")
+	}
+	return c.Order.Validate()
+}
@@ -0,0 +1,210 @@
+package types
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// View is a kind of directory. AllViews are the views that can be at the top
+// level of the file system, the rest can only be used within a ViewTemplate.
+type View string
+
+const (
+	TagsView      View = "tags"
+	AlbumsView    View = "albums"
+	CamerasView   View = "cameras"
+	LensesView    View = "lenses"
+	OnThisDayView View = "onThisDay"
+	QueriesView   View = "queries"
+	RatingsView   View = "ratings"
+)
+
+// AllViews are all of the views in the order they are enabled by default.
+var AllViews = []View{TagsView, AlbumsView, CamerasView, LensesView, OnThisDayView, QueriesView, RatingsView}
+
+func (v View) Validate() error {
+	for _, valid := range AllViews {
+		if v == valid {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q is not a valid view", string(v))
+}
+
+// RatingPlaceholder is replaced with the rating in the templates used to name
+// rating directories.
+const RatingPlaceholder = "{rating}"
+
+const (
+	DefaultEqualRatingName              = string(Equal) + RatingPlaceholder
+	DefaultGreaterThanOrEqualRatingName = string(GreaterThanOrEqual) + RatingPlaceholder
+)
+
+// Layout controls which directories are in the file system and what they are
+// called.
+type Layout struct {
+	// Views maps each of the enabled top level views to the name of its
+	// directory.
+	Views map[View]string
+
+	// QueriesAtRoot puts the directories of custom queries directly in the
+	// root of the file system, in addition to the queries view if it is
+	// enabled.
+	QueriesAtRoot bool
+
+	// EqualRatingName and GreaterThanOrEqualRatingName are the templates used
+	// to name the "==" and ">=" rating directories, RatingPlaceholder is
+	// replaced with the rating.
+	EqualRatingName              string
+	GreaterThanOrEqualRatingName string
+
+	// Templates are the named view templates that can be used for the
+	// children of directories, in addition to the default templates.
+	Templates map[string]ViewTemplate
+
+	// RootTemplate is the name of the template used for the root of the file
+	// system. If it is empty then the root contains the Views instead, and the
+	// Views are only used to name directories.
+	RootTemplate string
+
+	// TagTemplate is the name of the template used for tags. If it is empty
+	// then DefaultTagTemplate is used.
+	TagTemplate string
+
+	// CountsInNames adds the number of photos to the names of tag and rating
+	// directories, for example "Alice (12)". The directories can still be
+	// entered by the name without the count.
+	CountsInNames bool
+
+	// HideEmptyTags hides the directories of tags that aren't applied to any
+	// photos, unless one of their descendants is. They can still be entered by
+	// name.
+	HideEmptyTags bool
+}
+
+// DefaultLayout is the layout used when none is configured.
+func DefaultLayout() Layout {
+	views := make(map[View]string, len(AllViews))
+	for _, v := range AllViews {
+		views[v] = defaultViewName(v)
+	}
+	return Layout{
+		Views:                        views,
+		EqualRatingName:              DefaultEqualRatingName,
+		GreaterThanOrEqualRatingName: DefaultGreaterThanOrEqualRatingName,
+		Templates:                    defaultTemplates(),
+		TagTemplate:                  DefaultTagTemplate,
+	}
+}
+
+func defaultViewName(v View) string {
+	if v == OnThisDayView {
+		return "on-this-day"
+	}
+	return string(v)
+}
+
+// RatingName gets the name of the directory for a rating.
+func (l Layout) RatingName(operator RelationalOperator, rating float64) string {
+	template := l.EqualRatingName
+	if operator == GreaterThanOrEqual {
+		template = l.GreaterThanOrEqualRatingName
+	}
+	return strings.ReplaceAll(template, RatingPlaceholder, strconv.Itoa(int(rating)))
+}
+
+func (l Layout) Validate() error {
+	names := make(map[string]View, len(l.Views))
+	for v, name := range l.Views {
+		if err := v.Validate(); err != nil {
+			return err
+		}
+		if err := validateDirName(name); err != nil {
+			return fmt.Errorf("invalid name for view %q: %w", string(v), err)
+		}
+		if other, ok := names[name]; ok {
+			return fmt.Errorf("views %q and %q can't both be named %q", string(other), string(v), name)
+		}
+		names[name] = v
+	}
+
+	for _, template := range []string{l.EqualRatingName, l.GreaterThanOrEqualRatingName} {
+		if !strings.Contains(template, RatingPlaceholder) {
+			return fmt.Errorf("rating name %q must contain %q", template, RatingPlaceholder)
+		}
+		if err := validateDirName(template); err != nil {
+			return fmt.Errorf("invalid rating name: %w", err)
+		}
+	}
+	if l.EqualRatingName == l.GreaterThanOrEqualRatingName {
+		return fmt.Errorf("rating names must be different, both are %q", l.EqualRatingName)
+	}
+
+	if err := l.validateTemplates(); err != nil {
+		return fmt.Errorf("invalid template: %w", err)
+	}
+	return nil
+}
+
+// ViewName gets the name of the directory for a view. Views that aren't
+// enabled, which can still be used in templates, use their default name.
+func (l Layout) ViewName(v View) string {
+	if name, ok := l.Views[v]; ok {
+		return name
+	}
+	return defaultViewName(v)
+}
+
+// rootViews gets the views that have a directory in the root of the file
+// system.
+func (l Layout) rootViews() []View {
+	if l.RootTemplate == "" {
+		views := make([]View, 0, len(l.Views))
+		for _, v := range AllViews {
+			if _, ok := l.Views[v]; ok {
+				views = append(views, v)
+			}
+		}
+		return views
+	}
+
+	t, _ := l.Template(l.RootTemplate, "")
+	var views []View
+	for _, tv := range t {
+		if tv.View.Validate() == nil {
+			views = append(views, tv.View)
+		}
+	}
+	return views
+}
+
+// ValidateQueriesAtRoot checks that the queries can be put in the root of the
+// file system if QueriesAtRoot is set, which they can't be if a query or folder
+// of queries has the same name as one of the views in the root.
+func (l Layout) ValidateQueriesAtRoot(queries []NamedQuery) error {
+	if !l.QueriesAtRoot {
+		return nil
+	}
+	views := make(map[string]View)
+	for _, v := range l.rootViews() {
+		views[l.ViewName(v)] = v
+	}
+	for _, q := range queries {
+		name := strings.SplitN(q.Name, "/", 2)[0]
+		if v, ok := views[name]; ok {
+			return fmt.Errorf("query %q can't be put in the root of the file system since %q is the name of the %q view", q.Name, name, string(v))
+		}
+	}
+	return nil
+}
+
+func validateDirName(name string) error {
+	if name == "" || name == "." || name == ".." {
+		return fmt.Errorf("%q is not a valid directory name", name)
+	}
+	if strings.ContainsAny(name, "/\x00") {
+		return fmt.Errorf("directory name %q must not contain '/'", name)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package types
+
+import (
+	"reflect"
+	"sort"
+)
+
+// Optimize normalizes a selector into a simpler selector that selects the same
+// photos, so that DBs can turn it into simpler and faster queries.
+//
+// Nested And and Or selectors are flattened into their parent, duplicate
+// operands are dropped, set operations with a single operand are replaced by
+// the operand and a Difference that excludes what it starts with selects
+// nothing. The operands of And selectors are then ordered so that the ones we
+// expect to select the fewest photos come first, and the operands of Or
+// selectors so the ones we expect to select the most photos come first, which
+// lets DBs that evaluate the operands in order stop as early as possible.
+func Optimize(s Selector) Selector {
+	switch t := s.(type) {
+	case And:
+		return optimizeAnd(t)
+	case Or:
+		return optimizeOr(t)
+	case Difference:
+		return optimizeDifference(t)
+	default:
+		return s
+	}
+}
+
+// SelectsNothing checks if a selector is known to select no photos, which is
+// the case for an Or with no operands. Optimize uses this to represent set
+// operations that it can tell will always be empty.
+func SelectsNothing(s Selector) bool {
+	or, ok := s.(Or)
+	return ok && len(or.Operands) == 0
+}
+
+// nothing is a selector that selects no photos.
+var nothing = Or{}
+
+func optimizeAnd(s And) Selector {
+	var operands []Selector
+	for _, op := range s.Operands {
+		op = Optimize(op)
+		if SelectsNothing(op) {
+			return nothing
+		}
+		if and, ok := op.(And); ok {
+			for _, nested := range and.Operands {
+				operands = appendUnique(operands, nested)
+			}
+			continue
+		}
+		operands = appendUnique(operands, op)
+	}
+
+	if len(operands) == 1 {
+		return operands[0]
+	}
+	sort.SliceStable(operands, func(i, j int) bool {
+		return estimateSelectivity(operands[i]) < estimateSelectivity(operands[j])
+	})
+	return And{Operands: operands}
+}
+
+func optimizeOr(s Or) Selector {
+	var operands []Selector
+	for _, op := range s.Operands {
+		op = Optimize(op)
+		if or, ok := op.(Or); ok {
+			// This also drops operands that select nothing, since they are an
+			// Or with no operands.
+			for _, nested := range or.Operands {
+				operands = appendUnique(operands, nested)
+			}
+			continue
+		}
+		operands = appendUnique(operands, op)
+	}
+
+	if len(operands) == 0 {
+		return nothing
+	}
+	if len(operands) == 1 {
+		return operands[0]
+	}
+	sort.SliceStable(operands, func(i, j int) bool {
+		return estimateSelectivity(operands[i]) > estimateSelectivity(operands[j])
+	})
+	return Or{Operands: operands}
+}
+
+func optimizeDifference(s Difference) Selector {
+	starting := Optimize(s.Starting)
+	excluding := Optimize(s.Excluding)
+	if SelectsNothing(starting) || reflect.DeepEqual(starting, excluding) {
+		return nothing
+	}
+	if SelectsNothing(excluding) {
+		return starting
+	}
+	return Difference{Starting: starting, Excluding: excluding}
+}
+
+func appendUnique(operands []Selector, op Selector) []Selector {
+	for _, existing := range operands {
+		if reflect.DeepEqual(existing, op) {
+			return operands
+		}
+	}
+	return append(operands, op)
+}
+
+// estimateSelectivity makes a rough guess at the fraction of the photos in a
+// library that a selector selects. It doesn't need to be accurate, only good
+// enough to tell which operands are likely to select fewer photos than others.
+func estimateSelectivity(s Selector) float64 {
+	switch t := s.(type) {
+	case HasTag, InAlbum:
+		return 0.05
+	case HasCamera, HasLens:
+		return 0.2
+	case HasRating:
+		switch t.Operator {
+		case Equal:
+			return 0.2
+		case NotEqual:
+			return 0.8
+		default:
+			return 0.5
+		}
+	case TakenOn:
+		selectivity := 1.0
+		if t.Year != 0 {
+			selectivity /= 10
+		}
+		if t.Month != 0 {
+			selectivity /= 12
+		}
+		if t.Day != 0 {
+			selectivity /= 31
+		}
+		return selectivity
+	case And:
+		selectivity := 1.0
+		for _, op := range t.Operands {
+			selectivity *= estimateSelectivity(op)
+		}
+		return selectivity
+	case Or:
+		selectivity := 0.0
+		for _, op := range t.Operands {
+			selectivity += estimateSelectivity(op)
+		}
+		if selectivity > 1 {
+			return 1
+		}
+		return selectivity
+	case Difference:
+		return estimateSelectivity(t.Starting) * (1 - estimateSelectivity(t.Excluding))
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,118 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptimize(t *testing.T) {
+	a := HasTag{Tag: Tag{Path: []string{"a"}}}
+	b := HasTag{Tag: Tag{Path: []string{"b"}}}
+	c := HasTag{Tag: Tag{Path: []string{"c"}}}
+	fourStars := HasRating{Operator: GreaterThanOrEqual, Rating: 4}
+	year := TakenOn{Year: 2022}
+	day := TakenOn{Month: 7, Day: 10}
+
+	tests := []struct {
+		name     string
+		selector Selector
+		expected Selector
+	}{
+		{
+			name:     "Nil",
+			selector: nil,
+			expected: nil,
+		},
+		{
+			name:     "Leaf",
+			selector: a,
+			expected: a,
+		},
+		{
+			name:     "FlattenAnd",
+			selector: And{Operands: []Selector{And{Operands: []Selector{a, b}}, c}},
+			expected: And{Operands: []Selector{a, b, c}},
+		},
+		{
+			name:     "FlattenOr",
+			selector: Or{Operands: []Selector{a, Or{Operands: []Selector{b, Or{Operands: []Selector{c}}}}}},
+			expected: Or{Operands: []Selector{a, b, c}},
+		},
+		{
+			name:     "DontFlattenMixed",
+			selector: And{Operands: []Selector{a, Or{Operands: []Selector{b, c}}}},
+			expected: And{Operands: []Selector{a, Or{Operands: []Selector{b, c}}}},
+		},
+		{
+			name:     "SingleOperand",
+			selector: Or{Operands: []Selector{And{Operands: []Selector{a}}}},
+			expected: a,
+		},
+		{
+			name:     "Duplicates",
+			selector: And{Operands: []Selector{a, b, And{Operands: []Selector{b, a}}}},
+			expected: And{Operands: []Selector{a, b}},
+		},
+		{
+			name:     "DuplicatesDownToOne",
+			selector: Or{Operands: []Selector{a, a}},
+			expected: a,
+		},
+		{
+			name:     "DifferenceOfIdenticalSides",
+			selector: Difference{Starting: a, Excluding: Or{Operands: []Selector{a}}},
+			expected: Or{},
+		},
+		{
+			name:     "AndWithNothing",
+			selector: And{Operands: []Selector{a, Difference{Starting: b, Excluding: b}}},
+			expected: Or{},
+		},
+		{
+			name:     "OrWithNothing",
+			selector: Or{Operands: []Selector{a, Difference{Starting: b, Excluding: b}}},
+			expected: a,
+		},
+		{
+			name:     "DifferenceExcludingNothing",
+			selector: Difference{Starting: a, Excluding: Difference{Starting: b, Excluding: b}},
+			expected: a,
+		},
+		{
+			name:     "DifferenceStartingWithNothing",
+			selector: Difference{Starting: Difference{Starting: b, Excluding: b}, Excluding: a},
+			expected: Or{},
+		},
+		{
+			name:     "AndMostSelectiveFirst",
+			selector: And{Operands: []Selector{fourStars, year, a, day}},
+			expected: And{Operands: []Selector{day, a, year, fourStars}},
+		},
+		{
+			name:     "OrLeastSelectiveFirst",
+			selector: Or{Operands: []Selector{a, day, fourStars}},
+			expected: Or{Operands: []Selector{fourStars, a, day}},
+		},
+		{
+			name:     "NestedOperands",
+			selector: Difference{Starting: And{Operands: []Selector{fourStars, And{Operands: []Selector{a}}}}, Excluding: Or{Operands: []Selector{b}}},
+			expected: Difference{Starting: And{Operands: []Selector{a, fourStars}}, Excluding: b},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, Optimize(tt.selector))
+		})
+	}
+}
+
+func TestSelectsNothing(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(SelectsNothing(Or{}))
+	assert.False(SelectsNothing(Or{Operands: []Selector{HasTag{}}}))
+	assert.False(SelectsNothing(And{}))
+	assert.False(SelectsNothing(nil))
+}
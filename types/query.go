@@ -1,6 +1,9 @@
 package types
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 type RelationalOperator string
 
@@ -30,6 +33,23 @@ type Query struct {
 type NamedQuery struct {
 	Name string
 	Query
+
+	// CurrentPhoto overrides the default configuration of the current.<ext>
+	// symlink for this query, if nil the default is used.
+	CurrentPhoto *CurrentPhoto
+
+	// IncludeExcluded means photos that are excluded from the rest of the
+	// file system still show up in this query.
+	IncludeExcluded bool
+
+	// PageSize overrides the default page size of the photos of this query, if
+	// nil the default is used.
+	PageSize *int
+
+	// Template is the name of the view template used for the directory of the
+	// query. If it is empty then the photos of the query are directly in the
+	// directory of the query.
+	Template string
 }
 
 // Selector represents a method of selecting specific photos within our
@@ -42,9 +62,69 @@ type Selector interface {
 	Accept(v SelectorVisitor) (interface{}, error)
 }
 
+// SelectorKind identifies a type of selector. Each kind is named the same as
+// the type of the selector in the config.
+type SelectorKind string
+
+const (
+	HasTagKind     SelectorKind = "hasTag"
+	HasRatingKind  SelectorKind = "hasRating"
+	InAlbumKind    SelectorKind = "inAlbum"
+	HasCameraKind  SelectorKind = "hasCamera"
+	HasLensKind    SelectorKind = "hasLens"
+	TakenOnKind    SelectorKind = "takenOn"
+	AndKind        SelectorKind = "and"
+	OrKind         SelectorKind = "or"
+	DifferenceKind SelectorKind = "difference"
+)
+
+// SelectorKinds are all of the kinds of selectors.
+var SelectorKinds = []SelectorKind{
+	HasTagKind,
+	HasRatingKind,
+	InAlbumKind,
+	HasCameraKind,
+	HasLensKind,
+	TakenOnKind,
+	AndKind,
+	OrKind,
+	DifferenceKind,
+}
+
+// KindOf gets the kind of the selector, or an empty kind if it isn't one of
+// the SelectorKinds.
+func KindOf(s Selector) SelectorKind {
+	switch s.(type) {
+	case HasTag:
+		return HasTagKind
+	case HasRating:
+		return HasRatingKind
+	case InAlbum:
+		return InAlbumKind
+	case HasCamera:
+		return HasCameraKind
+	case HasLens:
+		return HasLensKind
+	case TakenOn:
+		return TakenOnKind
+	case And:
+		return AndKind
+	case Or:
+		return OrKind
+	case Difference:
+		return DifferenceKind
+	default:
+		return ""
+	}
+}
+
 type SelectorVisitor interface {
 	VisitHasTag(s HasTag) (interface{}, error)
 	VisitHasRating(s HasRating) (interface{}, error)
+	VisitInAlbum(s InAlbum) (interface{}, error)
+	VisitHasCamera(s HasCamera) (interface{}, error)
+	VisitHasLens(s HasLens) (interface{}, error)
+	VisitTakenOn(s TakenOn) (interface{}, error)
 	VisitAnd(s And) (interface{}, error)
 	VisitOr(s Or) (interface{}, error)
 	VisitDifference(s Difference) (interface{}, error)
@@ -76,6 +156,73 @@ func (s HasRating) Accept(v SelectorVisitor) (interface{}, error) {
 	return v.VisitHasRating(s)
 }
 
+// InAlbum is a selector for selecting photos that are directly within a specific
+// album. Photos in albums nested under the album are not selected.
+type InAlbum struct {
+	Album Album
+}
+
+var _ = (Selector)(InAlbum{})
+
+func (s InAlbum) Accept(v SelectorVisitor) (interface{}, error) {
+	return v.VisitInAlbum(s)
+}
+
+// HasCamera is a selector for selecting photos that were taken with a specific
+// camera.
+type HasCamera struct {
+	Camera Camera
+}
+
+var _ = (Selector)(HasCamera{})
+
+func (s HasCamera) Accept(v SelectorVisitor) (interface{}, error) {
+	return v.VisitHasCamera(s)
+}
+
+// HasLens is a selector for selecting photos that were taken with a specific
+// lens.
+type HasLens struct {
+	Lens string
+}
+
+var _ = (Selector)(HasLens{})
+
+func (s HasLens) Accept(v SelectorVisitor) (interface{}, error) {
+	return v.VisitHasLens(s)
+}
+
+// TakenOn is a selector for selecting photos that were taken on a specific date.
+//
+// Any of the parts of the date may be left as zero in order to match any value
+// for that part of the date. For example a TakenOn with a zero Year but a Month
+// and Day set will select photos that were taken on that day of the year in any
+// year.
+type TakenOn struct {
+	Year  int
+	Month time.Month
+	Day   int
+}
+
+var _ = (Selector)(TakenOn{})
+
+func (s TakenOn) Accept(v SelectorVisitor) (interface{}, error) {
+	return v.VisitTakenOn(s)
+}
+
+func (s TakenOn) Validate() error {
+	if s.Year < 0 {
+		return fmt.Errorf("%d is not a valid year", s.Year)
+	}
+	if s.Month < 0 || s.Month > time.December {
+		return fmt.Errorf("%d is not a valid month", s.Month)
+	}
+	if s.Day < 0 || s.Day > 31 {
+		return fmt.Errorf("%d is not a valid day", s.Day)
+	}
+	return nil
+}
+
 // And is a selector for selecting photos that meet ALL of the specified sub
 // selectors.
 type And struct {
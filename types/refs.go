@@ -0,0 +1,98 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SelectorRefs resolves the names used by "ref" selectors to the selectors of
+// the definitions and queries they refer to. References are replaced by the
+// selector they refer to when the config is parsed, so the DB never sees them.
+type SelectorRefs struct {
+	definitions map[string]SelectorConfig
+	queries     map[string]SelectorConfig
+
+	// resolved caches the selectors of names that have already been resolved
+	// so that a name referenced from many places is only parsed once.
+	resolved map[string]Selector
+
+	// chain is the names that are currently being resolved, in order, so we
+	// can tell when a name ends up referring to itself.
+	chain []string
+}
+
+// NewSelectorRefs creates the SelectorRefs for the definitions and queries of a
+// config. A name can't be used for both a definition and a query since "ref"
+// selectors wouldn't know which one to refer to.
+func NewSelectorRefs(definitions map[string]SelectorConfig, queries []QueryConfig) (*SelectorRefs, error) {
+	r := &SelectorRefs{
+		definitions: make(map[string]SelectorConfig, len(definitions)),
+		queries:     make(map[string]SelectorConfig, len(queries)),
+		resolved:    make(map[string]Selector),
+	}
+	for name, d := range definitions {
+		if name == "" {
+			return nil, errors.New("definitions must have a name")
+		}
+		r.definitions[name] = d
+	}
+	for _, q := range queries {
+		if _, ok := r.definitions[q.Name]; ok {
+			return nil, fmt.Errorf("the name %q is used for both a definition and a query", q.Name)
+		}
+		r.queries[q.Name] = q.Selector
+	}
+	return r, nil
+}
+
+// describe gets a description of a name for error messages.
+func (r *SelectorRefs) describe(name string) string {
+	if _, ok := r.definitions[name]; ok {
+		return fmt.Sprintf("definition %q", name)
+	}
+	return fmt.Sprintf("query %q", name)
+}
+
+// refError is an error resolving a reference. It is wrapped with the name of
+// each of the definitions and queries it was resolved from, so it only needs to
+// describe the reference itself.
+type refError struct {
+	msg string
+}
+
+func (e *refError) Error() string {
+	return e.msg
+}
+
+// resolve gets the selector of the definition or query with the given name.
+func (r *SelectorRefs) resolve(name string) (Selector, error) {
+	if r == nil {
+		return nil, &refError{msg: fmt.Sprintf("unknown reference %q", name)}
+	}
+	if s, ok := r.resolved[name]; ok {
+		return s, nil
+	}
+
+	for _, n := range r.chain {
+		if n == name {
+			return nil, &refError{msg: fmt.Sprintf("reference cycle back to %s", r.describe(name))}
+		}
+	}
+
+	config, ok := r.definitions[name]
+	if !ok {
+		config, ok = r.queries[name]
+	}
+	if !ok {
+		return nil, &refError{msg: fmt.Sprintf("unknown reference %q", name)}
+	}
+
+	r.chain = append(r.chain, name)
+	s, err := configToSelector(config, r)
+	r.chain = r.chain[:len(r.chain)-1]
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", r.describe(name), err)
+	}
+	r.resolved[name] = s
+	return s, nil
+}
@@ -0,0 +1,156 @@
+package types
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func hasTagConfig(tag ...string) SelectorConfig {
+	return SelectorConfig{
+		Type: "hasTag",
+		Properties: SelectorPropertyMap{
+			"tag": {Strings: tag},
+		},
+	}
+}
+
+func refConfig(name string) SelectorConfig {
+	return SelectorConfig{
+		Type: "ref",
+		Properties: SelectorPropertyMap{
+			"name": {String: name},
+		},
+	}
+}
+
+func andConfig(operands ...SelectorConfig) SelectorConfig {
+	return SelectorConfig{
+		Type: "and",
+		Properties: SelectorPropertyMap{
+			"operands": {Selectors: operands},
+		},
+	}
+}
+
+func TestSelectorRefs(t *testing.T) {
+	assert := assert.New(t)
+
+	definitions := map[string]SelectorConfig{
+		"family": {
+			Type: "or",
+			Properties: SelectorPropertyMap{
+				"operands": {Selectors: []SelectorConfig{
+					hasTagConfig("People", "Alice"),
+					hasTagConfig("People", "Bob"),
+				}},
+			},
+		},
+		"public": {
+			Type: "hasRating",
+			Properties: SelectorPropertyMap{
+				"operator": {String: ">="},
+				"rating":   {Number: 4},
+			},
+		},
+		"publicFamily": andConfig(refConfig("family"), refConfig("public")),
+	}
+	queries := []QueryConfig{
+		{Name: "Family", Selector: refConfig("publicFamily")},
+		{Name: "FamilyInIceland", Selector: andConfig(refConfig("Family"), hasTagConfig("Iceland"))},
+		{
+			Name: "InQuery",
+			Selector: SelectorConfig{
+				Type:       "inQuery",
+				Properties: SelectorPropertyMap{"name": {String: "family"}},
+			},
+		},
+	}
+	refs, err := NewSelectorRefs(definitions, queries)
+	assert.NoError(err)
+
+	family := Or{Operands: []Selector{
+		HasTag{Tag: Tag{Path: []string{"People", "Alice"}}},
+		HasTag{Tag: Tag{Path: []string{"People", "Bob"}}},
+	}}
+	public := HasRating{Operator: GreaterThanOrEqual, Rating: 4}
+	publicFamily := And{Operands: []Selector{family, public}}
+
+	namedQueries, err := ConfigsToQueries(queries, refs)
+	assert.NoError(err)
+	assert.Equal([]NamedQuery{
+		{Name: "Family", Query: Query{Selector: publicFamily}},
+		{Name: "FamilyInIceland", Query: Query{Selector: And{Operands: []Selector{
+			publicFamily,
+			HasTag{Tag: Tag{Path: []string{"Iceland"}}},
+		}}}},
+		{Name: "InQuery", Query: Query{Selector: family}},
+	}, namedQueries)
+
+	excluding := refConfig("family")
+	exclude, err := ConfigToExclude(&SelectorConfig{
+		Type: "difference",
+		Properties: SelectorPropertyMap{
+			"starting":  {Selector: &SelectorConfig{Type: "hasTag", Properties: SelectorPropertyMap{"tag": {Strings: []string{"Private"}}}}},
+			"excluding": {Selector: &excluding},
+		},
+	}, refs)
+	assert.NoError(err)
+	assert.Equal(Difference{Starting: HasTag{Tag: Tag{Path: []string{"Private"}}}, Excluding: family}, exclude)
+}
+
+func TestSelectorRefs_Errors(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := NewSelectorRefs(map[string]SelectorConfig{"a": hasTagConfig("a")}, []QueryConfig{{Name: "a", Selector: hasTagConfig("a")}})
+	assert.EqualError(err, `the name "a" is used for both a definition and a query`)
+
+	definitions := map[string]SelectorConfig{
+		"a":       andConfig(refConfig("b"), hasTagConfig("a")),
+		"b":       refConfig("Q"),
+		"self":    refConfig("self"),
+		"missing": refConfig("doesNotExist"),
+		"invalid": {Type: "notASelector"},
+	}
+
+	for _, c := range []struct {
+		query QueryConfig
+		err   string
+	}{
+		{
+			query: QueryConfig{Name: "Q", Selector: refConfig("a")},
+			err:   `error parsing query "Q": error parsing definition "a": error parsing definition "b": reference cycle back to query "Q"`,
+		},
+		{
+			query: QueryConfig{Name: "Q", Selector: refConfig("self")},
+			err:   `error parsing query "Q": error parsing definition "self": reference cycle back to definition "self"`,
+		},
+		{
+			query: QueryConfig{Name: "Q", Selector: refConfig("missing")},
+			err:   `error parsing query "Q": error parsing definition "missing": unknown reference "doesNotExist"`,
+		},
+		{
+			query: QueryConfig{Name: "Q", Selector: andConfig(hasTagConfig("a"), refConfig("invalid"))},
+			err:   `error parsing query "Q": error parsing definition "invalid": invalid selector type "notASelector"`,
+		},
+		{
+			query: QueryConfig{Name: "Q", Selector: SelectorConfig{Type: "ref"}},
+			err:   `error parsing query "Q": unspecified name of ref`,
+		},
+	} {
+		refs, err := NewSelectorRefs(definitions, []QueryConfig{c.query})
+		assert.NoError(err)
+		_, err = ConfigsToQueries([]QueryConfig{c.query}, refs)
+		assert.EqualError(err, c.err)
+
+		// The references can still be told apart from any other error.
+		var refErr *refError
+		assert.Equal(strings.Contains(c.err, "reference "), errors.As(err, &refErr))
+	}
+
+	// Without any refs a ref can't be resolved.
+	_, err = ConfigToQuery(QueryConfig{Name: "Q", Selector: refConfig("a")})
+	assert.EqualError(err, `error parsing config "Q": unknown reference "a"`)
+}
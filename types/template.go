@@ -0,0 +1,281 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Views that can only be used within a ViewTemplate, the directories for them
+// are always nested within a tag, query or rating.
+const (
+	PhotosView View = "photos"
+	AndView    View = "and"
+	NotView    View = "not"
+)
+
+// templateViews are all of the views that can be used in a ViewTemplate.
+var templateViews = append([]View{PhotosView, AndView, NotView}, AllViews...)
+
+// The names of the templates that are used when no other template is
+// specified. They are always available but can be replaced by defining a
+// template with the same name.
+const (
+	DefaultTagTemplate    = "tag"
+	DefaultRatingTemplate = "rating"
+	DefaultQueryTemplate  = "query"
+)
+
+// ViewTemplate describes the children of a directory as a tree of views so that
+// the same structure can be reused for tags, queries, ratings and the root.
+type ViewTemplate []TemplateView
+
+// TemplateView is one of the views in a ViewTemplate.
+type TemplateView struct {
+	View View
+
+	// Template is the name of the template used for each of the directories
+	// within the view, such as each tag of the tags view or each rating of the
+	// ratings view. If it is empty then the default template for the view is
+	// used.
+	Template string
+}
+
+func defaultTemplates() map[string]ViewTemplate {
+	return map[string]ViewTemplate{
+		DefaultTagTemplate: {
+			{View: TagsView},
+			{View: RatingsView},
+			{View: PhotosView},
+		},
+		DefaultRatingTemplate: {
+			{View: PhotosView},
+		},
+		DefaultQueryTemplate: {
+			{View: TagsView},
+			{View: RatingsView},
+			{View: PhotosView},
+		},
+	}
+}
+
+// templateScope is the kind of directory a template is used for, which
+// decides which views are allowed in the template.
+type templateScope int
+
+const (
+	// rootScope is the root of the file system.
+	rootScope templateScope = iota
+
+	// tagScope is a tag.
+	tagScope
+
+	// selectionScope is any other directory of photos, such as a query or a
+	// rating.
+	selectionScope
+)
+
+func (s templateScope) String() string {
+	switch s {
+	case rootScope:
+		return "the root"
+	case tagScope:
+		return "tags"
+	default:
+		return "queries and ratings"
+	}
+}
+
+func (s templateScope) allows(v View) bool {
+	switch v {
+	case TagsView, RatingsView:
+		return true
+	case PhotosView:
+		return s != rootScope
+	case AndView, NotView:
+		return s == tagScope
+	default:
+		return s == rootScope
+	}
+}
+
+// templateUse is a template being used for a kind of directory.
+type templateUse struct {
+	name  string
+	scope templateScope
+}
+
+// templateEdge is a view of a template whose directories use another template.
+type templateEdge struct {
+	from templateUse
+	to   templateUse
+	view View
+
+	// bounded is true if following the edge is guaranteed to make progress
+	// towards the end of the tree, for example tags nested in tags go deeper in
+	// the tag hierarchy, so a cycle of templates made only of these edges
+	// still results in a tree that ends.
+	bounded bool
+}
+
+// Template gets the template with the given name, or the template named
+// fallback if name is empty. The second result is false if there is no such
+// template.
+func (l Layout) Template(name, fallback string) (ViewTemplate, bool) {
+	if name == "" {
+		name = fallback
+	}
+	if t, ok := l.Templates[name]; ok {
+		return t, true
+	}
+	t, ok := defaultTemplates()[name]
+	return t, ok
+}
+
+// tagTemplate gets the name of the template used for tags.
+func (l Layout) tagTemplate() string {
+	if l.TagTemplate == "" {
+		return DefaultTagTemplate
+	}
+	return l.TagTemplate
+}
+
+// ValidateQueryTemplates checks that the templates used by the queries exist
+// and can be used for queries.
+func (l Layout) ValidateQueryTemplates(queries []NamedQuery) error {
+	for _, q := range queries {
+		if q.Template == "" {
+			continue
+		}
+		if err := l.validateTemplateUse(templateUse{name: q.Template, scope: selectionScope}); err != nil {
+			return fmt.Errorf("invalid template for query %q: %w", q.Name, err)
+		}
+	}
+	return nil
+}
+
+func (l Layout) validateTemplates() error {
+	for name, t := range l.Templates {
+		if name == "" {
+			return errors.New("templates must have a name")
+		}
+		views := make(map[View]struct{}, len(t))
+		for _, tv := range t {
+			if !isTemplateView(tv.View) {
+				return fmt.Errorf("template %q has unknown view kind %q", name, string(tv.View))
+			}
+			if _, ok := views[tv.View]; ok {
+				return fmt.Errorf("template %q has more than one %q view", name, string(tv.View))
+			}
+			views[tv.View] = struct{}{}
+
+			if tv.Template == "" {
+				continue
+			}
+			switch tv.View {
+			case TagsView, RatingsView, AndView, NotView:
+			default:
+				return fmt.Errorf("the %q view of template %q can't have a template", string(tv.View), name)
+			}
+			if _, ok := l.Template(tv.Template, ""); !ok {
+				return fmt.Errorf("template %q uses unknown template %q", name, tv.Template)
+			}
+		}
+	}
+
+	if l.RootTemplate != "" {
+		if err := l.validateTemplateUse(templateUse{name: l.RootTemplate, scope: rootScope}); err != nil {
+			return fmt.Errorf("invalid root template: %w", err)
+		}
+	}
+	if err := l.validateTemplateUse(templateUse{name: l.tagTemplate(), scope: tagScope}); err != nil {
+		return fmt.Errorf("invalid tag template: %w", err)
+	}
+	return nil
+}
+
+// validateTemplateUse checks that the template, and all of the templates used
+// within it, only contain views that are allowed where they are used, and that
+// they don't nest within each other forever.
+func (l Layout) validateTemplateUse(start templateUse) error {
+	edges := make(map[templateUse][]templateEdge)
+	toVisit := []templateUse{start}
+	for len(toVisit) > 0 {
+		use := toVisit[len(toVisit)-1]
+		toVisit = toVisit[:len(toVisit)-1]
+		if _, ok := edges[use]; ok {
+			continue
+		}
+
+		t, ok := l.Template(use.name, "")
+		if !ok {
+			return fmt.Errorf("unknown template %q", use.name)
+		}
+		edges[use] = []templateEdge{}
+		for _, tv := range t {
+			if !use.scope.allows(tv.View) {
+				return fmt.Errorf("template %q is used for %s which can't have a %q view", use.name, use.scope, string(tv.View))
+			}
+			var e templateEdge
+			switch tv.View {
+			case TagsView, AndView, NotView:
+				to := tv.Template
+				if to == "" {
+					to = l.tagTemplate()
+				}
+				e = templateEdge{to: templateUse{name: to, scope: tagScope}, bounded: use.scope == tagScope}
+			case RatingsView:
+				to := tv.Template
+				if to == "" {
+					to = DefaultRatingTemplate
+				}
+				e = templateEdge{to: templateUse{name: to, scope: selectionScope}}
+			default:
+				continue
+			}
+			e.from = use
+			e.view = tv.View
+			edges[use] = append(edges[use], e)
+			toVisit = append(toVisit, e.to)
+		}
+	}
+
+	for _, es := range edges {
+		for _, e := range es {
+			if !e.bounded && templateReaches(edges, e.to, e.from) {
+				return fmt.Errorf("template %q nests within itself forever through its %q view", e.from.name, string(e.view))
+			}
+		}
+	}
+	return nil
+}
+
+// templateReaches checks if the template use to can be reached by following
+// the edges starting at from.
+func templateReaches(edges map[templateUse][]templateEdge, from, to templateUse) bool {
+	visited := map[templateUse]struct{}{}
+	toVisit := []templateUse{from}
+	for len(toVisit) > 0 {
+		use := toVisit[len(toVisit)-1]
+		toVisit = toVisit[:len(toVisit)-1]
+		if use == to {
+			return true
+		}
+		if _, ok := visited[use]; ok {
+			continue
+		}
+		visited[use] = struct{}{}
+		for _, e := range edges[use] {
+			toVisit = append(toVisit, e.to)
+		}
+	}
+	return false
+}
+
+func isTemplateView(v View) bool {
+	for _, valid := range templateViews {
+		if v == valid {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,10 @@
 package types
 
-import "path/filepath"
+import (
+	"path/filepath"
+	"strings"
+	"time"
+)
 
 type Photo struct {
 
@@ -41,6 +45,29 @@ func (p Photo) UniqueStableName() string {
 	return p.ID + filepath.Ext(p.Path)
 }
 
+// PhotoMetadata is the information a photo database keeps about a photo beyond
+// where it is stored.
+type PhotoMetadata struct {
+	Tags []Tag
+
+	// Rating is nil if the photo hasn't been rated.
+	Rating *float64
+
+	Caption string
+
+	// Taken is the zero time if the database doesn't know when the photo was
+	// taken.
+	Taken time.Time
+
+	// Album is the album the photo is directly within.
+	Album Album
+
+	// Camera and Lens are empty if the database doesn't know what the photo
+	// was taken with.
+	Camera Camera
+	Lens   string
+}
+
 type Tag struct {
 	Path []string
 }
@@ -51,3 +78,113 @@ func (t Tag) Name() string {
 	}
 	return t.Path[len(t.Path)-1]
 }
+
+// Album is a folder within the photo library as it is organized by the photo
+// database. The first element of the path is the name of the top level album
+// (for digiKam this is the album root / collection) and the remaining elements
+// are the folders nested under it.
+type Album struct {
+	Path []string
+}
+
+func (a Album) Name() string {
+	if len(a.Path) == 0 {
+		return ""
+	}
+	return a.Path[len(a.Path)-1]
+}
+
+// Camera is the camera body that was used to take a photo.
+type Camera struct {
+	Make  string
+	Model string
+}
+
+// Name gets the name of the camera. Many cameras already start their model
+// with the make, such as "Canon EOS R5" made by "Canon" or "NIKON D5500" made
+// by "NIKON CORPORATION", in which case the make is left out so that it isn't
+// repeated.
+func (c Camera) Name() string {
+	makeWords := strings.Fields(c.Make)
+	model := strings.TrimSpace(c.Model)
+	if len(makeWords) == 0 || startsWithWord(model, makeWords[0]) {
+		return model
+	}
+	return strings.TrimSpace(c.Make + " " + model)
+}
+
+// startsWithWord checks if s starts with the word, ignoring case.
+func startsWithWord(s, word string) bool {
+	if len(s) < len(word) || !strings.EqualFold(s[:len(word)], word) {
+		return false
+	}
+	return len(s) == len(word) || s[len(word)] == ' '
+}
+
+// TagCount is the number of photos that a tag is directly applied to.
+type TagCount struct {
+	Tag    Tag
+	Photos int
+}
+
+// RatingCount is the number of photos that have a rating.
+type RatingCount struct {
+	Rating float64
+	Photos int
+}
+
+// PhotoGroups are the distinct albums, cameras and lenses of a set of photos.
+type PhotoGroups struct {
+	// Albums are the albums the photos are directly within.
+	Albums []Album
+
+	// Cameras and Lenses leave out the photos the database doesn't know the
+	// camera or lens of.
+	Cameras []Camera
+	Lenses  []string
+}
+
+// PhotoGroupsBuilder collects the distinct albums, cameras and lenses of
+// photos one photo at a time. The zero value is ready to use.
+type PhotoGroupsBuilder struct {
+	groups  PhotoGroups
+	albums  map[string]struct{}
+	cameras map[Camera]struct{}
+	lenses  map[string]struct{}
+}
+
+// Add adds the album, camera and lens of a photo. Empty cameras and lenses are
+// left out since they mean the camera or lens isn't known.
+func (b *PhotoGroupsBuilder) Add(album Album, camera Camera, lens string) {
+	if b.albums == nil {
+		b.albums = make(map[string]struct{})
+		b.cameras = make(map[Camera]struct{})
+		b.lenses = make(map[string]struct{})
+	}
+	if len(album.Path) > 0 {
+		// Album names can contain just about any character so we use a null
+		// character as a separator since it can't be part of a name.
+		key := strings.Join(album.Path, "\x00")
+		if _, ok := b.albums[key]; !ok {
+			b.albums[key] = struct{}{}
+			b.groups.Albums = append(b.groups.Albums, album)
+		}
+	}
+	if camera != (Camera{}) {
+		if _, ok := b.cameras[camera]; !ok {
+			b.cameras[camera] = struct{}{}
+			b.groups.Cameras = append(b.groups.Cameras, camera)
+		}
+	}
+	if lens != "" {
+		if _, ok := b.lenses[lens]; !ok {
+			b.lenses[lens] = struct{}{}
+			b.groups.Lenses = append(b.groups.Lenses, lens)
+		}
+	}
+}
+
+// Groups gets the albums, cameras and lenses of the photos added so far.
+func (b *PhotoGroupsBuilder) Groups() PhotoGroups {
+	return b.groups
+}
@@ -48,3 +48,59 @@ func TestTagName(t *testing.T) {
 		assert.Equal(t, tag.Name(), "tag")
 	}
 }
+
+func TestAlbumName(t *testing.T) {
+	{
+		album := Album{Path: []string{}}
+		assert.Equal(t, album.Name(), "")
+	}
+
+	{
+		album := Album{Path: []string{"Pictures"}}
+		assert.Equal(t, album.Name(), "Pictures")
+	}
+
+	{
+		album := Album{Path: []string{"Pictures", "2022", "Iceland"}}
+		assert.Equal(t, album.Name(), "Iceland")
+	}
+}
+
+func TestCameraName(t *testing.T) {
+	{
+		camera := Camera{Make: "NIKON CORPORATION", Model: "NIKON D5500"}
+		assert.Equal(t, camera.Name(), "NIKON D5500")
+	}
+
+	{
+		camera := Camera{Make: "Canon", Model: "Canon EOS R5"}
+		assert.Equal(t, camera.Name(), "Canon EOS R5")
+	}
+
+	{
+		camera := Camera{Make: "Canon", Model: "EOS R5"}
+		assert.Equal(t, camera.Name(), "Canon EOS R5")
+	}
+
+	{
+		// Only a whole word of the make is left out.
+		camera := Camera{Make: "Leica", Model: "Leicaflex"}
+		assert.Equal(t, camera.Name(), "Leica Leicaflex")
+	}
+
+	{
+		camera := Camera{Model: "Pixel 7"}
+		assert.Equal(t, camera.Name(), "Pixel 7")
+	}
+}
+
+func TestLayout_RatingName(t *testing.T) {
+	l := DefaultLayout()
+	assert.Equal(t, "==4", l.RatingName(Equal, 4))
+	assert.Equal(t, ">=4", l.RatingName(GreaterThanOrEqual, 4))
+
+	l.EqualRatingName = "{rating}-stars"
+	l.GreaterThanOrEqualRatingName = "{rating}-stars-and-up"
+	assert.Equal(t, "4-stars", l.RatingName(Equal, 4))
+	assert.Equal(t, "0-stars-and-up", l.RatingName(GreaterThanOrEqual, 0))
+}